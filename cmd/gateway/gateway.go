@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/rooms"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+	"github.com/coder/websocket"
+)
+
+// How long a single write to a socket can take before the connection is dropped
+const writeTimeout = 5 * time.Second
+
+// Connects WebSocket clients to a SandboxStore, and implements rooms.Sender against the live sockets
+type gateway struct {
+	sandbox *store.SandboxStore
+	rooms   *rooms.Registry
+
+	// Passed to websocket.Accept, cross-origin requests are rejected unless they match
+	originPatterns []string
+
+	mu    sync.RWMutex
+	conns map[string]*websocket.Conn
+
+	// Running connection handlers
+	wg sync.WaitGroup
+}
+
+func newGateway(originPatterns []string) *gateway {
+	return &gateway{
+		originPatterns: originPatterns,
+		conns:          make(map[string]*websocket.Conn),
+	}
+}
+
+// Random ID for a new connection
+func newConnectionId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Handles /ws/{instanceId}/{roomId}.
+//
+// The connection joins the room when it opens, every text or binary message is delivered as ON_MESSAGE,
+// and it leaves the room once it closes
+func (g *gateway) handleWS(w http.ResponseWriter, r *http.Request) {
+	instanceId := r.PathValue("instanceId")
+	roomId := r.PathValue("roomId")
+	if !validModuleId(instanceId) || roomId == "" {
+		http.Error(w, "Invalid module or room", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: g.originPatterns})
+	if err != nil {
+		// Accept has already written the response
+		slog.Warn("Failed to accept WebSocket", "err", err)
+		return
+	}
+
+	g.wg.Add(1)
+	defer g.wg.Done()
+
+	connectionId := newConnectionId()
+	g.mu.Lock()
+	g.conns[connectionId] = conn
+	g.mu.Unlock()
+
+	log := slog.With("instanceId", instanceId, "roomId", roomId, "connectionId", connectionId)
+	log.Info("Connection opened")
+
+	// the request's context is cancelled once the handler returns, ON_LEAVE needs to outlive it
+	ctx := context.WithoutCancel(r.Context())
+	g.execute(ctx, log, instanceId, roomId, connectionId, wsevents.ON_JOIN, "")
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			if status := websocket.CloseStatus(err); status != websocket.StatusNormalClosure && status != websocket.StatusGoingAway {
+				log.Info("Connection dropped", "err", err)
+			}
+			break
+		}
+
+		// messages from one connection are run one at a time, so modules see them in order
+		g.execute(ctx, log, instanceId, roomId, connectionId, wsevents.ON_MESSAGE, string(data))
+	}
+
+	g.mu.Lock()
+	delete(g.conns, connectionId)
+	g.mu.Unlock()
+	conn.CloseNow()
+
+	g.execute(ctx, log, instanceId, roomId, connectionId, wsevents.ON_LEAVE, "")
+	log.Info("Connection closed")
+}
+
+func (g *gateway) execute(ctx context.Context, log *slog.Logger, instanceId string, roomId string, connectionId string, eventType wsevents.WSEventType, payload string) {
+	err := g.sandbox.ExecuteOnModule(ctx, &wsevents.WSEventInfo{
+		ConnectionId: connectionId,
+		RoomId:       roomId,
+		InstanceId:   instanceId,
+		EventType:    eventType,
+		Payload:      payload,
+		Timestamp:    time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Error("Failed to run event", "event", eventType.String(), "err", err)
+	}
+}
+
+// Deliver a room lifecycle event. Modules don't have to export these, so failures are only debug logs
+func (g *gateway) roomEvent(instanceId string, roomId string, eventType wsevents.WSEventType) {
+	if err := g.sandbox.ExecuteRoomEvent(context.Background(), instanceId, roomId, eventType, ""); err != nil {
+		slog.Debug("Failed to run room event", "instanceId", instanceId, "roomId", roomId, "event", eventType.String(), "err", err)
+	}
+}
+
+func (g *gateway) conn(connectionId string) (*websocket.Conn, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	conn, ok := g.conns[connectionId]
+	if !ok {
+		return nil, errors.New("Connection is closed")
+	}
+	return conn, nil
+}
+
+// Implements rooms.Sender. Messages are sent as text frames holding only the message data
+func (g *gateway) Send(msg rooms.Message) error {
+	conn, err := g.conn(msg.To)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	return conn.Write(ctx, websocket.MessageText, []byte(msg.Data))
+}
+
+// Implements rooms.Sender. The connection's read loop sees the close and delivers ON_LEAVE
+func (g *gateway) Close(connectionId string) error {
+	conn, err := g.conn(connectionId)
+	if err != nil {
+		return err
+	}
+
+	// closing waits for the client to reply, which shouldn't hold up the module
+	go conn.Close(websocket.StatusNormalClosure, "Closed by the server")
+	return nil
+}
+
+// Wait for every connection handler to return, or for ctx to be done
+func (g *gateway) wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Close every connection, used when shutting down
+func (g *gateway) closeAll() {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for _, conn := range g.conns {
+		go conn.Close(websocket.StatusGoingAway, "Server is shutting down")
+	}
+}
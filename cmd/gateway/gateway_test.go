@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmtest"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/sandboxtest"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+	"github.com/coder/websocket"
+)
+
+// Broadcasts every message it gets to the room. The event is an array of the connection, room,
+// timestamp and payload, each a length and then the bytes, after 6 bytes of header
+const echoModule = `(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(memory (export "memory") 1)
+	(global $heap (mut i32) (i32.const 1024))
+
+	(func (export "__new") (param $size i32) (param $id i32) (result i32)
+		global.get $heap
+		(global.set $heap (i32.add (global.get $heap) (local.get $size))))
+
+	;; the address of the field after the one at $p
+	(func $skip (param $p i32) (result i32)
+		(i32.add (i32.add (local.get $p) (i32.const 4)) (i32.load (local.get $p))))
+
+	(func (export "__onMessage") (param $ptr i32) (param $len i32) (local $p i32)
+		(global.set $heap (i32.const 1024))
+		(local.set $p (call $skip (call $skip (call $skip (i32.add (local.get $ptr) (i32.const 6))))))
+		(drop (call $broadcast (i32.add (local.get $p) (i32.const 4)) (i32.load (local.get $p))))))`
+
+// Sets a timer from __onLeave, and exports every room event so none of them fail
+const lifecycleModule = `(module
+	(import "env" "setTimeout" (func $setTimeout (param i32 i32 i32) (result i32)))
+	(memory (export "memory") 1)
+	(func (export "__new") (param i32 i32) (result i32) (i32.const 1024))
+	(func (export "__onJoin") (param i32 i32))
+	(func (export "__onRoomCreated") (param i32 i32))
+	(func (export "__onRoomEmpty") (param i32 i32))
+	(func (export "__onLeave") (param i32 i32)
+		(drop (call $setTimeout (i32.const 60000) (i32.const 0) (i32.const 0)))))`
+
+// Gateway serving echoModule as "echo" and lifecycleModule as "lifecycle" from a module directory.
+// cfg only needs the options the test cares about
+func newTestServer(t *testing.T, cfg store.SandboxStoreCfg) (*server, string) {
+	dir := t.TempDir()
+	for name, wat := range map[string]string{"echo": echoModule, "lifecycle": lifecycleModule} {
+		if err := os.WriteFile(filepath.Join(dir, name+".wasm"), wasmtest.Must(t, wat), 0o644); err != nil {
+			t.Fatalf("Failed to write module: %v", err)
+		}
+	}
+	modules, err := loader.NewFSLoader(loader.FSConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create loader: %v", err)
+	}
+	t.Cleanup(func() { modules.Close() })
+
+	cfg.LoaderFunction = modules.Load
+	cfg.SettingsFunction = modules.Settings
+	srv, err := newServer(cfg, t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	httpServer := httptest.NewServer(srv.handler)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.close(ctx)
+		httpServer.Close()
+	})
+	return srv, "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	return string(data)
+}
+
+func write(t *testing.T, conn *websocket.Conn, message string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := conn.Write(ctx, websocket.MessageText, []byte(message)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+}
+
+// Joins are delivered after the socket opens, so wait for the room to have the expected size
+func waitForUsers(t *testing.T, srv *server, roomId string, users int) {
+	t.Helper()
+	waitFor(t, func() bool { return len(srv.gw.rooms.Users("echo", roomId)) == users },
+		"%d users in %s", users, roomId)
+}
+
+func waitFor(t *testing.T, done func() bool, format string, args ...any) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected "+format, args...)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBroadcastRoundTrip(t *testing.T) {
+	srv, url := newTestServer(t, store.SandboxStoreCfg{})
+
+	alice := dial(t, url+"/ws/echo/lobby")
+	bob := dial(t, url+"/ws/echo/lobby")
+	other := dial(t, url+"/ws/echo/other")
+	waitForUsers(t, srv, "lobby", 2)
+	waitForUsers(t, srv, "other", 1)
+
+	write(t, alice, "hello")
+	for name, conn := range map[string]*websocket.Conn{"alice": alice, "bob": bob} {
+		if got := read(t, conn); got != "hello" {
+			t.Errorf("Expected %s to get %q, got %q", name, "hello", got)
+		}
+	}
+
+	// bob leaves, and the other room only sees its own messages
+	bob.Close(websocket.StatusNormalClosure, "")
+	waitForUsers(t, srv, "lobby", 1)
+	write(t, other, "elsewhere")
+	write(t, alice, "again")
+	if got := read(t, alice); got != "again" {
+		t.Errorf("Expected alice to get %q, got %q", "again", got)
+	}
+	if got := read(t, other); got != "elsewhere" {
+		t.Errorf("Expected the other room to get %q, got %q", "elsewhere", got)
+	}
+}
+
+func TestInvalidModuleIsRejected(t *testing.T) {
+	_, url := newTestServer(t, store.SandboxStoreCfg{})
+	httpURL := "http" + strings.TrimPrefix(url, "ws")
+
+	resp, err := http.Get(httpURL + "/ws/.hidden/lobby")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+// The recording is written by executions while the test reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// When the last socket leaves, the module's __onLeave runs before __onRoomEmpty,
+// and the room is only closed after both, so the timer __onLeave sets is cancelled with it
+func TestLastDisconnectOrder(t *testing.T) {
+	var recording lockedBuffer
+	recorder, err := record.NewRecorder(&recording)
+	if err != nil {
+		t.Fatalf("Failed to start recording: %v", err)
+	}
+	clock := sandboxtest.NewClock(time.Now())
+	srv, url := newTestServer(t, store.SandboxStoreCfg{Recorder: recorder, Clock: clock})
+
+	conn := dial(t, url+"/ws/lifecycle/lobby")
+	waitFor(t, func() bool { return len(srv.gw.rooms.Users("lifecycle", "lobby")) == 1 }, "the connection to join")
+	conn.Close(websocket.StatusNormalClosure, "")
+
+	events := func() []string {
+		if err := recorder.Flush(); err != nil {
+			t.Fatalf("Failed to flush recording: %v", err)
+		}
+		rec, err := record.Read(bytes.NewReader(recording.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to read recording: %v", err)
+		}
+		var events []string
+		for _, execution := range rec.Executions() {
+			events = append(events, execution.Input.EventType.String())
+		}
+		return events
+	}
+	waitFor(t, func() bool { return len(events()) == 4 }, "4 events to run")
+	expected := []string{"__onRoomCreated", "__onJoin", "__onLeave", "__onRoomEmpty"}
+	if got := events(); !slices.Equal(got, expected) {
+		t.Errorf("Expected the events %v, got %v", expected, got)
+	}
+	waitFor(t, func() bool { return clock.Pending() == 0 }, "the timer set by __onLeave to be cancelled")
+}
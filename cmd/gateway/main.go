@@ -0,0 +1,248 @@
+// Reference WebSocket gateway, which connects clients to modules in a SandboxStore.
+//
+// Clients connect to /ws/{instanceId}/{roomId}, where instanceId is the name of a .wasm file in the modules
+// directory (without the extension), with its settings in an optional manifest next to it (see loader.FSLoader).
+// Modules are reloaded when either file changes.
+//
+// Opening, messaging and closing the socket deliver ON_JOIN, ON_MESSAGE and ON_LEAVE, and modules can reply with
+// broadcast, sendMessage, serverMessage and closeConnection.
+//
+//	go run ./cmd/gateway -modules example/build
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/handlers/db"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/handlers/kv"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/rooms"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/signing"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Module IDs are file names, so they can't contain separators or start with a dot
+var moduleIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+func validModuleId(id string) bool {
+	return moduleIdPattern.MatchString(id)
+}
+
+func logHandler(event *wasmevents.WASMEventInfo) (string, error) {
+	slog.Info("Module log", "instanceId", event.InstanceId, "roomId", event.RoomId, "message", strings.Join(event.Payload, " "))
+	return "", nil
+}
+
+func debugHandler(event *wasmevents.WASMEventInfo) (string, error) {
+	slog.Debug("Module debug", "instanceId", event.InstanceId, "roomId", event.RoomId, "message", strings.Join(event.Payload, " "))
+	return "", nil
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	modulesDir := flag.String("modules", ".", "directory holding the .wasm modules")
+	dbDir := flag.String("db", "", "directory for the persistent DB, DB events fail if not set")
+	origins := flag.String("origins", "", "comma separated origin patterns allowed to connect from other sites")
+	memoryPages := flag.Uint("memory-pages", 100, "memory limit of each instance, in 64KB pages")
+	wasi := flag.Bool("wasi", false, "let modules without a manifest import WASI, which modules built with Go or Rust need")
+	watch := flag.Bool("watch", true, "reload modules when their .wasm file or manifest changes")
+	trust := flag.String("trust", "", "comma separated PEM encoded public keys, modules have to be signed by one of them when set")
+	debug := flag.Bool("debug", false, "log debug messages, including the debug() calls of modules")
+	flag.Parse()
+
+	if *debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	modules, err := loader.NewFSLoader(loader.FSConfig{
+		Dir:      *modulesDir,
+		Defaults: loader.ModuleSettings{WASI: *wasi},
+	})
+	if err != nil {
+		slog.Error("Gateway failed", "err", err)
+		os.Exit(1)
+	}
+	defer modules.Close()
+
+	cfg := store.SandboxStoreCfg{
+		MemoryLimitPages: uint32(*memoryPages),
+		CleanupInterval:  time.Minute,
+		MaxIdleTime:      10 * time.Minute,
+		LoaderFunction:   modules.Load,
+		SettingsFunction: modules.Settings,
+	}
+
+	if *trust != "" {
+		trusted, err := readPublicKeys(*trust)
+		if err != nil {
+			slog.Error("Gateway failed", "err", err)
+			os.Exit(1)
+		}
+		cfg.LoaderFunction = signing.VerifyingLoader(modules.Load, signing.Config{
+			TrustedKeys: trusted,
+			Signature:   modules.Signature,
+		})
+	}
+
+	var watcher *loader.FSLoader
+	if *watch {
+		watcher = modules
+	}
+
+	if err := run(cfg, watcher, *addr, *dbDir, *origins); err != nil {
+		slog.Error("Gateway failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+func readPublicKeys(paths string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, path := range strings.Split(paths, ",") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := signing.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Everything the gateway serves, apart from the listener
+type server struct {
+	gw      *gateway
+	sandbox *store.SandboxStore
+	handler http.Handler
+
+	kvStore *kv.Store
+	dbStore *db.Store
+}
+
+// Set up the stores, the room registry and the routes. DB events are only handled if dbDir is set
+func newServer(cfg store.SandboxStoreCfg, dbDir string, origins string) (*server, error) {
+	var originPatterns []string
+	if origins != "" {
+		originPatterns = strings.Split(origins, ",")
+	}
+	gw := newGateway(originPatterns)
+
+	gw.rooms = rooms.New(rooms.Config{
+		Sender: gw,
+		OnRoomCreated: func(instanceId string, roomId string) {
+			gw.roomEvent(instanceId, roomId, wsevents.ON_ROOM_CREATED)
+		},
+		// only called once the last __onLeave has run, so whatever it left behind is closed with the room
+		OnRoomEmpty: func(instanceId string, roomId string) {
+			gw.roomEvent(instanceId, roomId, wsevents.ON_ROOM_EMPTY)
+			gw.sandbox.CloseRoom(instanceId, roomId)
+		},
+	})
+
+	srv := &server{gw: gw, kvStore: kv.New(kv.Config{})}
+
+	handlerMap := gw.rooms.Register(srv.kvStore.Register(wasmevents.NewHandlerMap())).
+		AddHandler(wasmevents.LOG, logHandler).
+		AddHandler(wasmevents.DEBUG, debugHandler)
+
+	cfg.HandlerMap = handlerMap
+	cfg.EventObserver = gw.rooms
+
+	if dbDir != "" {
+		dbStore, err := db.Open(db.Config{Dir: dbDir})
+		if err != nil {
+			srv.kvStore.Close()
+			return nil, err
+		}
+		srv.dbStore = dbStore
+
+		dbStore.Register(handlerMap)
+		cfg.TransactionHandler = dbStore
+	}
+
+	sandbox, err := store.NewSandboxStore(context.Background(), cfg)
+	if err != nil {
+		srv.closeStores()
+		return nil, err
+	}
+	gw.sandbox = sandbox
+	srv.sandbox = sandbox
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws/{instanceId}/{roomId}", gw.handleWS)
+	srv.handler = mux
+
+	return srv, nil
+}
+
+// Close every connection, wait for their ON_LEAVE, then close the sandbox and the stores
+func (srv *server) close(ctx context.Context) error {
+	// hijacked WebSocket connections aren't closed by http.Server.Shutdown
+	srv.gw.closeAll()
+	srv.gw.wait(ctx)
+
+	err := srv.sandbox.Close(ctx)
+	srv.closeStores()
+	return err
+}
+
+func (srv *server) closeStores() {
+	srv.kvStore.Close()
+	if srv.dbStore != nil {
+		srv.dbStore.Close()
+	}
+}
+
+// Modules are reloaded when they change on disk if watcher is set
+func run(cfg store.SandboxStoreCfg, watcher *loader.FSLoader, addr string, dbDir string, origins string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv, err := newServer(cfg, dbDir, origins)
+	if err != nil {
+		return err
+	}
+
+	if watcher != nil {
+		go watcher.Watch(ctx, srv.sandbox)
+	}
+
+	httpServer := &http.Server{Addr: addr, Handler: srv.handler}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Gateway listening", "addr", addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		srv.close(context.Background())
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Failed to shut down server", "err", err)
+	}
+	return srv.close(shutdownCtx)
+}
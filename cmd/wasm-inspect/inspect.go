@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	builder "github.com/Cloud-RAMP/wasm-sandbox/internal/host-builder"
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmbin"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+type report struct {
+	Path   string `json:"path"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	// False if the store would refuse to load the module, Problems says why
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems,omitempty"`
+
+	// Things that don't stop the module from loading but are worth knowing
+	Warnings []string `json:"warnings,omitempty"`
+
+	Runtime string `json:"runtime"`
+
+	// From the producers custom section, if the toolchain wrote one
+	Producers map[string][]string `json:"producers,omitempty"`
+
+	// Only set if the module declares it
+	ABIVersion     *int32 `json:"abi_version,omitempty"`
+	HostABIVersion int32  `json:"host_abi_version"`
+
+	Memory         *memory         `json:"memory,omitempty"`
+	Imports        []function      `json:"imports"`
+	Exports        []function      `json:"exports"`
+	CustomSections []customSection `json:"custom_sections"`
+}
+
+type memory struct {
+	Imported bool `json:"imported"`
+
+	// In 64KB pages
+	Min uint32  `json:"min_pages"`
+	Max *uint32 `json:"max_pages,omitempty"`
+}
+
+type function struct {
+	Module  string   `json:"module,omitempty"`
+	Name    string   `json:"name"`
+	Params  []string `json:"params"`
+	Results []string `json:"results"`
+
+	// What the host uses an export for, or where an import comes from
+	Role string `json:"role,omitempty"`
+
+	// Imports only, false if nothing in the host provides it with this signature
+	Provided *bool `json:"provided,omitempty"`
+}
+
+type customSection struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// Exports that the host calls, other than the WS events
+var knownExports = map[string]string{
+	"__new":                  "allocator, required",
+	"__onMessageBatch":       "batched messages",
+	"__onHttpRequest":        "HTTP requests",
+	"__warmup":               "snapshot warmup",
+	"_start":                 "WASI command entry point",
+	"_initialize":            "WASI reactor initializer",
+	"__pin":                  "AssemblyScript runtime",
+	"__unpin":                "AssemblyScript runtime",
+	"__collect":              "AssemblyScript runtime",
+	"__rtti_base":            "AssemblyScript runtime",
+	builder.ABIVersionExport: "ABI version",
+}
+
+type inspectOptions struct {
+	memoryPages uint32
+	wasi        bool
+}
+
+func inspect(ctx context.Context, path string, wasm []byte, opts inspectOptions) *report {
+	sum := sha256.Sum256(wasm)
+	r := &report{
+		Path:           path,
+		Size:           len(wasm),
+		SHA256:         hex.EncodeToString(sum[:]),
+		HostABIVersion: builder.ABIVersion,
+		Runtime:        "unknown",
+		Imports:        []function{},
+		Exports:        []function{},
+		CustomSections: []customSection{},
+	}
+
+	// the memory limit is checked separately, so that the rest can still be reported
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCustomSections(true))
+	defer runtime.Close(ctx)
+
+	compiled, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("Failed to compile: %v", err))
+		return r
+	}
+	defer compiled.Close(ctx)
+
+	host, err := hostFunctions(ctx, runtime)
+	if err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("Failed to build the host module: %v", err))
+		return r
+	}
+
+	r.inspectImports(compiled, host, opts)
+	r.inspectExports(compiled)
+	r.inspectMemory(compiled, opts)
+
+	for _, section := range compiled.CustomSections() {
+		r.CustomSections = append(r.CustomSections, customSection{Name: section.Name(), Size: len(section.Data())})
+	}
+
+	if version, ok, err := wasmbin.ExportedConstI32(wasm, builder.ABIVersionExport); err != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("Failed to read the ABI version: %v", err))
+	} else if ok {
+		r.ABIVersion = &version
+		if version != builder.ABIVersion {
+			r.Problems = append(r.Problems, fmt.Sprintf("Built for ABI version %d, the host implements version %d", version, builder.ABIVersion))
+		}
+	}
+
+	r.Producers = readProducers(compiled)
+	r.Runtime = detectRuntime(wasm, compiled, r.Producers)
+	r.Valid = len(r.Problems) == 0
+	return r
+}
+
+// Functions available to modules, by module name and function name
+func hostFunctions(ctx context.Context, runtime wazero.Runtime) (map[string]map[string]api.FunctionDefinition, error) {
+	env, err := builder.BuildHostModule(ctx, runtime, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := builder.BuildWASIModule(ctx, runtime); err != nil {
+		return nil, err
+	}
+
+	return map[string]map[string]api.FunctionDefinition{
+		"env":                             env.ExportedFunctionDefinitions(),
+		wasi_snapshot_preview1.ModuleName: runtime.Module(wasi_snapshot_preview1.ModuleName).ExportedFunctionDefinitions(),
+	}, nil
+}
+
+func typeNames(types []api.ValueType) []string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = api.ValueTypeName(t)
+	}
+	return names
+}
+
+func (r *report) inspectImports(compiled wazero.CompiledModule, host map[string]map[string]api.FunctionDefinition, opts inspectOptions) {
+	usesWASI := false
+	for _, def := range compiled.ImportedFunctions() {
+		moduleName, name, _ := def.Import()
+		fn := function{
+			Module:  moduleName,
+			Name:    name,
+			Params:  typeNames(def.ParamTypes()),
+			Results: typeNames(def.ResultTypes()),
+		}
+
+		hostDef, found := host[moduleName][name]
+		provided := found &&
+			slices.Equal(hostDef.ParamTypes(), def.ParamTypes()) &&
+			slices.Equal(hostDef.ResultTypes(), def.ResultTypes())
+		fn.Provided = &provided
+
+		switch {
+		case !found:
+			r.Problems = append(r.Problems, fmt.Sprintf("Imports %s.%s, which the host doesn't provide", moduleName, name))
+		case !provided:
+			r.Problems = append(r.Problems, fmt.Sprintf("Imports %s.%s as (%s) -> (%s), the host provides (%s) -> (%s)",
+				moduleName, name, joinTypes(fn.Params), joinTypes(fn.Results),
+				joinTypes(typeNames(hostDef.ParamTypes())), joinTypes(typeNames(hostDef.ResultTypes()))))
+		}
+		if moduleName == wasi_snapshot_preview1.ModuleName {
+			usesWASI = true
+			fn.Role = "WASI"
+		} else if moduleName == "env" {
+			fn.Role = "host function"
+		}
+
+		r.Imports = append(r.Imports, fn)
+	}
+
+	if usesWASI && !opts.wasi {
+		r.Problems = append(r.Problems, "Imports WASI, so WASI has to be enabled in its settings (check again with -wasi)")
+	}
+}
+
+func joinTypes(types []string) string {
+	return strings.Join(types, ", ")
+}
+
+func (r *report) inspectExports(compiled wazero.CompiledModule) {
+	roles := maps.Clone(knownExports)
+	for eventType := wsevents.WSEventType(0); eventType.Valid(); eventType++ {
+		roles[eventType.String()] = "WS event"
+	}
+
+	exports := compiled.ExportedFunctions()
+	names := slices.Sorted(maps.Keys(exports))
+	events := 0
+	for _, name := range names {
+		def := exports[name]
+		fn := function{
+			Name:    name,
+			Params:  typeNames(def.ParamTypes()),
+			Results: typeNames(def.ResultTypes()),
+			Role:    roles[name],
+		}
+		if fn.Role == "WS event" || name == "__onMessageBatch" || name == "__onHttpRequest" {
+			events++
+		}
+		r.Exports = append(r.Exports, fn)
+	}
+
+	if _, ok := exports["__new"]; !ok {
+		r.Problems = append(r.Problems, "Doesn't export __new, so the host can't pass it events")
+	}
+	if events == 0 {
+		r.Warnings = append(r.Warnings, "Doesn't export any event handlers, so the host will never call it")
+	}
+}
+
+func (r *report) inspectMemory(compiled wazero.CompiledModule, opts inspectOptions) {
+	var def api.MemoryDefinition
+	for _, mem := range compiled.ExportedMemories() {
+		def = mem
+	}
+	imported := compiled.ImportedMemories()
+	if len(imported) > 0 {
+		def = imported[0]
+		r.Problems = append(r.Problems, "Imports its memory, the host doesn't provide one")
+	}
+
+	if def == nil {
+		r.Problems = append(r.Problems, "Doesn't export its memory, so the host can't pass it events")
+		return
+	}
+
+	r.Memory = &memory{Imported: len(imported) > 0, Min: def.Min()}
+	if max, ok := def.Max(); ok {
+		r.Memory.Max = &max
+	}
+	if def.Min() > opts.memoryPages {
+		r.Problems = append(r.Problems, fmt.Sprintf("Needs %d pages of memory, more than the limit of %d", def.Min(), opts.memoryPages))
+	}
+}
+
+// Best guess at what produced the module. AssemblyScript modules are told apart by the runtime files
+// they reference, either in their name section or the file names of their assertions.
+// Other languages are taken from the producers section, which most toolchains write
+func detectRuntime(wasm []byte, compiled wazero.CompiledModule, producers map[string][]string) string {
+	exports := compiled.ExportedFunctions()
+
+	for _, variant := range []struct{ file, name string }{
+		{"~lib/rt/itcms", "incremental"},
+		{"~lib/rt/tcms", "minimal"},
+		{"~lib/rt/stub", "stub"},
+	} {
+		if bytes.Contains(wasm, []byte(variant.file)) || bytes.Contains(wasm, utf16Bytes(variant.file)) {
+			return "AssemblyScript (" + variant.name + " runtime)"
+		}
+	}
+
+	name := strings.Join(producers["language"], ", ")
+	if name == "" {
+		name = strings.Join(producers["processed-by"], ", ")
+	}
+	if builder.ImportsWASI(compiled) {
+		kind := "WASI command"
+		if _, ok := exports["_initialize"]; ok {
+			kind = "WASI reactor"
+		}
+		if name == "" {
+			return kind
+		}
+		return name + " (" + kind + ")"
+	}
+
+	if name != "" {
+		return name
+	}
+	if _, ok := exports["__new"]; ok {
+		return "AssemblyScript (unknown runtime)"
+	}
+	return "unknown"
+}
+
+// Fields of the producers custom section, such as language and processed-by, as "name version" values
+func readProducers(compiled wazero.CompiledModule) map[string][]string {
+	for _, section := range compiled.CustomSections() {
+		if section.Name() == "producers" {
+			return parseProducers(section.Data())
+		}
+	}
+	return nil
+}
+
+func parseProducers(data []byte) map[string][]string {
+	producers := make(map[string][]string)
+
+	pos := 0
+	u32 := func() (uint32, bool) {
+		n, size := binary.Uvarint(data[pos:])
+		if size <= 0 || n > uint64(len(data)) {
+			return 0, false
+		}
+		pos += size
+		return uint32(n), true
+	}
+	name := func() (string, bool) {
+		n, ok := u32()
+		if !ok || int(n) > len(data)-pos {
+			return "", false
+		}
+		pos += int(n)
+		return string(data[pos-int(n) : pos]), true
+	}
+
+	fields, ok := u32()
+	for i := uint32(0); ok && i < fields; i++ {
+		var field string
+		var values uint32
+		if field, ok = name(); !ok {
+			break
+		}
+		if values, ok = u32(); !ok {
+			break
+		}
+		for j := uint32(0); ok && j < values; j++ {
+			var value, version string
+			if value, ok = name(); ok {
+				version, ok = name()
+			}
+			if ok {
+				producers[field] = append(producers[field], strings.TrimSpace(value+" "+version))
+			}
+		}
+	}
+
+	return producers
+}
+
+func utf16Bytes(s string) []byte {
+	var out []byte
+	for _, unit := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(unit), byte(unit>>8))
+	}
+	return out
+}
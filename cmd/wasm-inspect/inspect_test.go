@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmtest"
+)
+
+// A module the store would load, laid out like an AssemblyScript build
+const validModule = `(module
+	(import "env" "log" (func $log (param i32 i32) (result i32)))
+	(memory (export "memory") 1 2)
+	(global (export "__abiVersion") i32 (i32.const 1))
+	(func (export "__new") (param i32 i32) (result i32)
+		i32.const 0)
+	(func (export "__onMessage") (param i32 i32)
+		(drop (call $log (local.get 0) (local.get 1))))
+	(func (export "helper")))`
+
+// A module with every problem the report checks for
+const brokenModule = `(module
+	(import "env" "log" (func (param i32) (result i32)))
+	(import "env" "missing" (func))
+	(import "wasi_snapshot_preview1" "fd_write" (func (param i32 i32 i32 i32) (result i32)))
+	(memory (export "memory") 20)
+	(global (export "__abiVersion") i32 (i32.const 99))
+	(func (export "_start")))`
+
+func expectJSON(t *testing.T, name string, got any, want string) {
+	t.Helper()
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("Expected %s %s, got %s", name, want, data)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	r := inspect(context.Background(), "valid.wasm", wasmtest.Must(t, validModule), inspectOptions{memoryPages: 10})
+
+	if !r.Valid || len(r.Problems) > 0 || len(r.Warnings) > 0 {
+		t.Errorf("Expected the module to be valid, got problems %q and warnings %q", r.Problems, r.Warnings)
+	}
+	if r.Runtime != "AssemblyScript (unknown runtime)" {
+		t.Errorf("Expected an AssemblyScript runtime, got %q", r.Runtime)
+	}
+	if r.ABIVersion == nil || *r.ABIVersion != 1 {
+		t.Errorf("Expected ABI version 1, got %v", r.ABIVersion)
+	}
+
+	expectJSON(t, "memory", r.Memory, `{"imported":false,"min_pages":1,"max_pages":2}`)
+	expectJSON(t, "imports", r.Imports,
+		`[{"module":"env","name":"log","params":["i32","i32"],"results":["i32"],"role":"host function","provided":true}]`)
+	expectJSON(t, "exports", r.Exports, `[`+
+		`{"name":"__new","params":["i32","i32"],"results":["i32"],"role":"allocator, required"},`+
+		`{"name":"__onMessage","params":["i32","i32"],"results":[],"role":"WS event"},`+
+		`{"name":"helper","params":[],"results":[]}]`)
+
+	var out bytes.Buffer
+	printReport(&out, r)
+	for _, want := range []string{
+		"  valid:    yes\n",
+		"  memory:   1 pages, maximum 2 pages\n",
+		"    env.log(i32, i32) -> i32\n",
+		"    __onMessage(i32, i32)  // WS event\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestInspectProblems(t *testing.T) {
+	wasm := wasmtest.Must(t, brokenModule)
+	r := inspect(context.Background(), "broken.wasm", wasm, inspectOptions{memoryPages: 10})
+
+	want := []string{
+		"Imports env.log as (i32) -> (i32), the host provides (i32, i32) -> (i32)",
+		"Imports env.missing, which the host doesn't provide",
+		"Imports WASI, so WASI has to be enabled in its settings (check again with -wasi)",
+		"Doesn't export __new, so the host can't pass it events",
+		"Needs 20 pages of memory, more than the limit of 10",
+		"Built for ABI version 99, the host implements version 1",
+	}
+	if r.Valid || !slices.Equal(r.Problems, want) {
+		t.Errorf("Expected problems %q, got %q", want, r.Problems)
+	}
+	if want := []string{"Doesn't export any event handlers, so the host will never call it"}; !slices.Equal(r.Warnings, want) {
+		t.Errorf("Expected warnings %q, got %q", want, r.Warnings)
+	}
+	if r.Runtime != "WASI command" {
+		t.Errorf("Expected a WASI command, got %q", r.Runtime)
+	}
+
+	var provided []bool
+	for _, fn := range r.Imports {
+		provided = append(provided, *fn.Provided)
+	}
+	if want := []bool{false, false, true}; !slices.Equal(provided, want) {
+		t.Errorf("Expected imports to be provided %v, got %v", want, provided)
+	}
+
+	var out bytes.Buffer
+	printReport(&out, r)
+	if want := "  ! env.missing()\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("Expected the report to flag %q, got:\n%s", want, out.String())
+	}
+
+	// -wasi only removes the WASI problem
+	r = inspect(context.Background(), "broken.wasm", wasm, inspectOptions{memoryPages: 10, wasi: true})
+	if !slices.Equal(r.Problems, slices.Delete(slices.Clone(want), 2, 3)) {
+		t.Errorf("Expected the WASI problem to be gone with -wasi, got %q", r.Problems)
+	}
+}
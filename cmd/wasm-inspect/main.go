@@ -0,0 +1,148 @@
+// Prints what's inside a module: its imports, exports, memory, custom sections, the runtime it was built with,
+// and whether the store would load it.
+//
+//	go run ./cmd/wasm-inspect example/build/release.wasm
+//	go run ./cmd/wasm-inspect -json -wasi module.wasm
+//
+// Exits with status 1 if any module has problems that would stop it from loading
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+func main() {
+	opts := inspectOptions{}
+	asJSON := false
+	memoryPages := uint(10)
+
+	flag.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	flag.BoolVar(&opts.wasi, "wasi", false, "check the module as if WASI was enabled in its settings")
+	flag.UintVar(&memoryPages, "memory-pages", memoryPages, "memory limit to check against, in 64KB pages")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] module.wasm...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.memoryPages = uint32(memoryPages)
+
+	ctx := context.Background()
+	reports := make([]*report, 0, flag.NArg())
+	for _, path := range flag.Args() {
+		wasm, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		reports = append(reports, inspect(ctx, path, wasm, opts))
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		var err error
+		if len(reports) == 1 {
+			err = enc.Encode(reports[0])
+		} else {
+			err = enc.Encode(reports)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	} else {
+		for i, r := range reports {
+			if i > 0 {
+				fmt.Println()
+			}
+			printReport(os.Stdout, r)
+		}
+	}
+
+	for _, r := range reports {
+		if !r.Valid {
+			os.Exit(1)
+		}
+	}
+}
+
+func printReport(out io.Writer, r *report) {
+	fmt.Fprintf(out, "%s\n", r.Path)
+	fmt.Fprintf(out, "  size:     %d bytes\n", r.Size)
+	fmt.Fprintf(out, "  sha256:   %s\n", r.SHA256)
+	fmt.Fprintf(out, "  runtime:  %s\n", r.Runtime)
+	for _, field := range slices.Sorted(maps.Keys(r.Producers)) {
+		fmt.Fprintf(out, "    %s: %s\n", field, strings.Join(r.Producers[field], ", "))
+	}
+
+	abi := "not declared"
+	if r.ABIVersion != nil {
+		abi = fmt.Sprint(*r.ABIVersion)
+	}
+	fmt.Fprintf(out, "  abi:      %s (host implements %d)\n", abi, r.HostABIVersion)
+
+	if r.Memory != nil {
+		limit := "no maximum"
+		if r.Memory.Max != nil {
+			limit = fmt.Sprintf("maximum %d pages", *r.Memory.Max)
+		}
+		fmt.Fprintf(out, "  memory:   %d pages, %s\n", r.Memory.Min, limit)
+	}
+
+	if r.Valid {
+		fmt.Fprintf(out, "  valid:    yes\n")
+	} else {
+		fmt.Fprintf(out, "  valid:    no\n")
+	}
+	for _, problem := range r.Problems {
+		fmt.Fprintf(out, "    ! %s\n", problem)
+	}
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(out, "    ? %s\n", warning)
+	}
+
+	fmt.Fprintf(out, "\n  imports (%d):\n", len(r.Imports))
+	for _, fn := range r.Imports {
+		flag := " "
+		if fn.Provided != nil && !*fn.Provided {
+			flag = "!"
+		}
+		fmt.Fprintf(out, "  %s %s.%s(%s)%s\n", flag, fn.Module, fn.Name, strings.Join(fn.Params, ", "), results(fn.Results))
+	}
+
+	fmt.Fprintf(out, "\n  exports (%d):\n", len(r.Exports))
+	for _, fn := range r.Exports {
+		role := ""
+		if fn.Role != "" {
+			role = "  // " + fn.Role
+		}
+		fmt.Fprintf(out, "    %s(%s)%s%s\n", fn.Name, strings.Join(fn.Params, ", "), results(fn.Results), role)
+	}
+
+	if len(r.CustomSections) > 0 {
+		fmt.Fprintf(out, "\n  custom sections (%d):\n", len(r.CustomSections))
+		for _, section := range r.CustomSections {
+			fmt.Fprintf(out, "    %s (%d bytes)\n", section.Name, section.Size)
+		}
+	}
+}
+
+func results(types []string) string {
+	if len(types) == 0 {
+		return ""
+	}
+	return " -> " + strings.Join(types, ", ")
+}
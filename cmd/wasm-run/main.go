@@ -0,0 +1,286 @@
+// Runs a module against a script of events, printing every host call it makes.
+//
+//	go run ./cmd/wasm-run -script events.jsonl example/build/release.wasm
+//
+// Scripts are a JSON array of events, or one event per line, using the json tags of WSEventInfo:
+//
+//	{"event": "join", "connection_id": "alice", "room_id": "lobby"}
+//	{"connection_id": "alice", "room_id": "lobby", "payload": "hello"}
+//
+// KV and DB events go to real in-memory and temporary stores, GET_USERS returns a fixed list, FETCH returns
+// canned responses and messages are recorded instead of sent. Exits with status 1 if any event fails or traps,
+// including timers that fire during -wait.
+//
+// With -record, every event and host call is written to a recording. -replay runs one execution of a recording
+// again, feeding the module the recorded responses instead of calling any handlers:
+//
+//	go run ./cmd/wasm-run -replay events.rec module.wasm                # list executions
+//	go run ./cmd/wasm-run -replay events.rec -execution 3 module.wasm
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/handlers/db"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/handlers/kv"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+type options struct {
+	modulePath  string
+	scriptPath  string
+	instanceId  string
+	concurrent  bool
+	users       []string
+	kvSeed      map[string]string
+	fetch       map[string]string
+	wasi        bool
+	memoryPages uint
+	timeout     time.Duration
+	wait        time.Duration
+	recordPath  string
+	replayPath  string
+	executionId string
+}
+
+func main() {
+	opts := options{kvSeed: make(pairsFlag), fetch: make(pairsFlag)}
+	users := ""
+
+	flag.StringVar(&opts.scriptPath, "script", "-", "script of events, - reads from stdin")
+	flag.StringVar(&opts.instanceId, "instance", "", "instance ID for events that don't set one (defaults to the file name)")
+	flag.BoolVar(&opts.concurrent, "concurrent", false, "run every event at once instead of in order")
+	flag.StringVar(&users, "users", "", "comma separated connections returned by getUsers")
+	flag.Var(pairsFlag(opts.kvSeed), "kv", "key=value to set in the KV store before running, can be repeated")
+	flag.Var(pairsFlag(opts.fetch), "fetch", "url=response to return for fetch calls, can be repeated")
+	flag.BoolVar(&opts.wasi, "wasi", false, "let the module import WASI, which modules built with Go or Rust need")
+	flag.UintVar(&opts.memoryPages, "memory-pages", 100, "memory limit of each instance, in 64KB pages")
+	flag.DurationVar(&opts.timeout, "timeout", 5*time.Second, "execution time limit of each event")
+	flag.DurationVar(&opts.wait, "wait", 0, "how long to keep running after the script, so timers can fire")
+	flag.StringVar(&opts.recordPath, "record", "", "record the events and host calls to this file")
+	flag.StringVar(&opts.replayPath, "replay", "", "replay an execution from a recording instead of running a script, lists the executions without -execution")
+	flag.StringVar(&opts.executionId, "execution", "", "ID of the execution to replay")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] module.wasm\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.modulePath = flag.Arg(0)
+	if users != "" {
+		opts.users = strings.Split(users, ",")
+	}
+	if opts.instanceId == "" {
+		opts.instanceId = strings.TrimSuffix(filepath.Base(opts.modulePath), filepath.Ext(opts.modulePath))
+	}
+
+	runFunc := run
+	if opts.replayPath != "" {
+		runFunc = replay
+	}
+
+	failed, err := runFunc(opts, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func readScriptFile(path string) ([]scriptEvent, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+	return readScript(r)
+}
+
+// Run the script and print the transcript, returns the number of events that failed
+func run(opts options, out io.Writer) (int, error) {
+	ctx := context.Background()
+
+	events, err := readScriptFile(opts.scriptPath)
+	if err != nil {
+		return 0, err
+	}
+
+	wasm, err := os.ReadFile(opts.modulePath)
+	if err != nil {
+		return 0, err
+	}
+
+	dbDir, err := os.MkdirTemp("", "wasm-run-db")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dbDir)
+
+	dbStore, err := db.Open(db.Config{Dir: dbDir})
+	if err != nil {
+		return 0, err
+	}
+	defer dbStore.Close()
+
+	kvStore := kv.New(kv.Config{})
+	defer kvStore.Close()
+	for key, value := range opts.kvSeed {
+		if err := kvStore.Set(opts.instanceId, key, value, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	t := &transcript{out: out, showConnection: opts.concurrent}
+	m := &mocks{transcript: t, users: opts.users, fetch: opts.fetch}
+	cfg := sandboxCfg(opts, wasm)
+	cfg.HandlerMap = m.register(dbStore.Register(kvStore.Register(wasmevents.NewHandlerMap())))
+	cfg.TransactionHandler = &transcriptTransactions{transcript: t, handler: dbStore}
+	// wrap the store's own handlers too, so timers, state and transactions show up
+	cfg.WrapHandlers = t.wrap
+
+	var timerFailures atomic.Int64
+	cfg.OnTimerError = func(event *wsevents.WSEventInfo, err error) {
+		timerFailures.Add(1)
+		t.printf("timer %s connection=%q room=%q failed: %v", event.EventType.String(), event.ConnectionId, event.RoomId, err)
+	}
+
+	if opts.recordPath != "" {
+		file, err := os.Create(opts.recordPath)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+
+		recorder, err := record.NewRecorder(file)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			if err := recorder.Flush(); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to write recording:", err)
+			}
+		}()
+		cfg.Recorder = recorder
+	}
+
+	sandbox, err := store.NewSandboxStore(ctx, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer sandbox.Close(ctx)
+
+	execute := func(i int, event *wsevents.WSEventInfo) bool {
+		if event.InstanceId == "" {
+			event.InstanceId = opts.instanceId
+		}
+		if event.Timestamp == 0 {
+			event.Timestamp = time.Now().UnixMilli()
+		}
+
+		if !opts.concurrent {
+			t.printf("#%d %s connection=%q room=%q payload=%q", i+1, event.EventType.String(), event.ConnectionId, event.RoomId, event.Payload)
+		}
+
+		executionId := fmt.Sprintf("%d", i+1)
+		start := time.Now()
+		err := sandbox.ExecuteOnModule(store.WithExecutionId(ctx, executionId), event)
+		elapsed := time.Since(start).Round(time.Microsecond)
+
+		prefix := "   "
+		if opts.concurrent {
+			prefix = fmt.Sprintf("#%s %s connection=%q:", executionId, event.EventType.String(), event.ConnectionId)
+		}
+		if err != nil {
+			t.printf("%s failed after %s: %v", prefix, elapsed, err)
+			return false
+		}
+		t.printf("%s ok in %s", prefix, elapsed)
+		return true
+	}
+
+	failed := 0
+	if opts.concurrent {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for i := range events {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !execute(i, &events[i].WSEventInfo) {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i := range events {
+			if !execute(i, &events[i].WSEventInfo) {
+				failed++
+			}
+		}
+	}
+
+	if opts.wait > 0 {
+		t.printf("waiting %s for timers", opts.wait)
+		time.Sleep(opts.wait)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(out, "\n%d events, %d failed\n", len(events), failed)
+	if n := int(timerFailures.Load()); n > 0 {
+		fmt.Fprintf(out, "%d timers failed\n", n)
+		failed += n
+	}
+	if len(t.sent) > 0 {
+		fmt.Fprintf(out, "%d messages sent:\n", len(t.sent))
+		for _, msg := range t.sent {
+			fmt.Fprintf(out, "  %s\n", msg)
+		}
+	}
+	return failed, nil
+}
+
+// Settings shared by running and replaying
+func sandboxCfg(opts options, wasm []byte) store.SandboxStoreCfg {
+	cfg := store.SandboxStoreCfg{
+		MemoryLimitPages:   uint32(opts.memoryPages),
+		MaxExecutionTime:   opts.timeout,
+		CloseOnContextDone: true,
+		// every instance ID loads the same file
+		LoaderFunction: func(ctx context.Context, moduleId string) ([]byte, error) {
+			return wasm, nil
+		},
+	}
+	if opts.wasi {
+		cfg.SettingsFunction = func(ctx context.Context, moduleId string) (*loader.ModuleSettings, error) {
+			return &loader.ModuleSettings{WASI: true}, nil
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmtest"
+)
+
+// A tiny module, so the tests don't need an AssemblyScript build:
+//   - __onJoin sets a 10ms timeout with payload "ping" and writes "k" = "v" to the DB
+//   - __onTimer traps
+const scriptModule = `(module
+	(import "env" "setTimeout" (func $setTimeout (param i32 i32 i32) (result i32)))
+	(import "env" "dbSet" (func $dbSet (param i32 i32 i32 i32) (result i32)))
+
+	(memory (export "memory") 1)
+	(global $heap (mut i32) (i32.const 1024))
+
+	(data (i32.const 0) "ping")
+	(data (i32.const 16) "k")
+	(data (i32.const 32) "v")
+
+	;; bumps the heap pointer
+	(func (export "__new") (param $size i32) (param $id i32) (result i32)
+		global.get $heap
+		(global.set $heap (i32.add (global.get $heap) (local.get $size))))
+
+	(func (export "__onJoin") (param i32 i32)
+		(drop (call $setTimeout (i32.const 10) (i32.const 0) (i32.const 4)))
+		(drop (call $dbSet (i32.const 16) (i32.const 1) (i32.const 32) (i32.const 1))))
+
+	(func (export "__onTimer") (param i32 i32)
+		unreachable))`
+
+func writeScriptFiles(t *testing.T, script string) options {
+	t.Helper()
+
+	dir := t.TempDir()
+	modulePath := filepath.Join(dir, "room.wasm")
+	scriptPath := filepath.Join(dir, "script.jsonl")
+	if err := os.WriteFile(modulePath, wasmtest.Must(t, scriptModule), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return options{
+		modulePath:  modulePath,
+		scriptPath:  scriptPath,
+		instanceId:  "room",
+		memoryPages: 10,
+		timeout:     time.Second,
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	opts := writeScriptFiles(t, `{"event": "join", "connection_id": "alice", "room_id": "lobby"}`)
+
+	var out bytes.Buffer
+	failed, err := run(opts, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 0 {
+		t.Errorf("Expected no failures, got %d:\n%s", failed, out.String())
+	}
+
+	transcript := out.String()
+	for _, want := range []string{
+		`#1 __onJoin connection="alice" room="lobby"`,
+		// handled by the store itself, not the handlers wasm-run passes in
+		`setTimeout("10", "ping") = `,
+		`dbSet("k", "v")`,
+		`commit 1 writes`,
+		`1 events, 0 failed`,
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("Expected transcript to contain %q, got:\n%s", want, transcript)
+		}
+	}
+}
+
+func TestRunCountsTimerFailures(t *testing.T) {
+	opts := writeScriptFiles(t, `{"event": "join", "connection_id": "alice", "room_id": "lobby"}`)
+	opts.wait = 200 * time.Millisecond
+
+	var out bytes.Buffer
+	failed, err := run(opts, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 1 {
+		t.Errorf("Expected the failed timer to be counted, got %d failures:\n%s", failed, out.String())
+	}
+
+	transcript := out.String()
+	for _, want := range []string{
+		`timer __onTimer connection="alice" room="lobby" failed: `,
+		`1 timers failed`,
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("Expected transcript to contain %q, got:\n%s", want, transcript)
+		}
+	}
+}
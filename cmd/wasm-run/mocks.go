@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// Prints host calls and event results, one line at a time
+type transcript struct {
+	mu  sync.Mutex
+	out io.Writer
+
+	// Prefix host calls with their connection, for when events run concurrently
+	showConnection bool
+
+	// Messages sent through BROADCAST, SEND_MESSAGE and SERVER_MESSAGE, in order
+	sent []string
+}
+
+func (t *transcript) printf(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.out, format+"\n", args...)
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Wrap every handler of a map so that its calls are printed
+func (t *transcript) wrap(handlerMap *wasmevents.HandlerMap) {
+	for eventType, handler := range *handlerMap {
+		(*handlerMap)[eventType] = func(event *wasmevents.WASMEventInfo) (string, error) {
+			res, err := handler(event)
+
+			line := fmt.Sprintf("    %s(%s)", eventType.String(), quoteAll(event.Payload))
+			if t.showConnection {
+				line = fmt.Sprintf("    [%s] %s(%s)", event.ConnectionId, eventType.String(), quoteAll(event.Payload))
+			}
+			switch {
+			case err != nil:
+				line += " failed: " + err.Error()
+			case res != "":
+				line += fmt.Sprintf(" = %q", res)
+			}
+			t.printf("%s", line)
+			return res, err
+		}
+	}
+}
+
+// Prints the writes of guest transactions as they are applied
+type transcriptTransactions struct {
+	transcript *transcript
+	handler    wasmevents.TransactionHandler
+}
+
+func (t *transcriptTransactions) CommitTransaction(instanceId string, writes []wasmevents.DBWrite) error {
+	err := t.handler.CommitTransaction(instanceId, writes)
+	t.transcript.printCommit(writes, err)
+	return err
+}
+
+// Print the writes a transaction applied, and the error it failed with
+func (t *transcript) printCommit(writes []wasmevents.DBWrite, err error) {
+	lines := []string{fmt.Sprintf("    commit %d writes", len(writes))}
+	if err != nil {
+		lines[0] += " failed: " + err.Error()
+	}
+	for _, write := range writes {
+		if write.Delete {
+			lines = append(lines, fmt.Sprintf("      %s(%q)", wasmevents.DB_DEL.String(), write.Key))
+		} else {
+			lines = append(lines, fmt.Sprintf("      %s(%q, %q)", wasmevents.DB_SET.String(), write.Key, write.Value))
+		}
+	}
+	t.printf("%s", strings.Join(lines, "\n"))
+}
+
+// Handlers for the events that talk to other connections or the outside world.
+// Nothing is actually sent, the transcript shows what would have been
+type mocks struct {
+	transcript *transcript
+
+	// Returned by GET_USERS
+	users []string
+
+	// Responses for FETCH by URL, other URLs fail
+	fetch map[string]string
+}
+
+func (m *mocks) register(handlerMap *wasmevents.HandlerMap) *wasmevents.HandlerMap {
+	return handlerMap.
+		AddHandler(wasmevents.GET_USERS, m.handleGetUsers).
+		AddHandler(wasmevents.BROADCAST, m.recordMessage).
+		AddHandler(wasmevents.SEND_MESSAGE, m.recordMessage).
+		AddHandler(wasmevents.SERVER_MESSAGE, m.recordMessage).
+		AddHandler(wasmevents.CLOSE_CONNECTION, m.ignore).
+		AddHandler(wasmevents.FETCH, m.handleFetch).
+		AddHandler(wasmevents.LOG, m.ignore).
+		AddHandler(wasmevents.DEBUG, m.ignore).
+		AddHandler(wasmevents.ABORT, m.ignore)
+}
+
+func (m *mocks) handleGetUsers(event *wasmevents.WASMEventInfo) (string, error) {
+	return strings.Join(m.users, ","), nil
+}
+
+func (m *mocks) recordMessage(event *wasmevents.WASMEventInfo) (string, error) {
+	m.transcript.mu.Lock()
+	defer m.transcript.mu.Unlock()
+
+	m.transcript.sent = append(m.transcript.sent, fmt.Sprintf("%s from %q: %s", event.EventType.String(), event.ConnectionId, quoteAll(event.Payload)))
+	return "", nil
+}
+
+// Payload is [url, method, body]
+func (m *mocks) handleFetch(event *wasmevents.WASMEventInfo) (string, error) {
+	if len(event.Payload) == 0 {
+		return "", fmt.Errorf("Invalid payload for %s event", event.EventType.String())
+	}
+	res, ok := m.fetch[event.Payload[0]]
+	if !ok {
+		return "", fmt.Errorf("No mock response for %s", event.Payload[0])
+	}
+	return res, nil
+}
+
+// The transcript already shows the call
+func (m *mocks) ignore(event *wasmevents.WASMEventInfo) (string, error) {
+	return "", nil
+}
+
+// Flag that can be repeated, collecting key=value pairs
+type pairsFlag map[string]string
+
+func (f pairsFlag) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f pairsFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("Expected key=value, got %q", value)
+	}
+	f[key] = val
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Replay a recorded execution, or list the executions of the recording if none was picked.
+// Returns 1 if the execution failed or diverged from the recording
+func replay(opts options, out io.Writer) (int, error) {
+	ctx := context.Background()
+
+	file, err := os.Open(opts.replayPath)
+	if err != nil {
+		return 0, err
+	}
+	recording, err := record.Read(file)
+	file.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.executionId == "" {
+		for _, execution := range recording.Executions() {
+			if execution.Batch != nil {
+				first := execution.Batch[0]
+				fmt.Fprintf(out, "%s: batch of %d messages instance=%q room=%q, %d host calls\n",
+					execution.Id, len(execution.Batch), first.InstanceId, first.RoomId, len(execution.Calls))
+				continue
+			}
+			if req := execution.HTTP; req != nil {
+				fmt.Fprintf(out, "%s: HTTP %s %s instance=%q, %d host calls\n",
+					execution.Id, req.Method, req.Path, req.InstanceId, len(execution.Calls))
+				continue
+			}
+			if execution.Input == nil {
+				fmt.Fprintf(out, "%s: %d host calls\n", execution.Id, len(execution.Calls))
+				continue
+			}
+			input := execution.Input
+			fmt.Fprintf(out, "%s: %s instance=%q connection=%q room=%q, %d host calls\n",
+				execution.Id, input.EventType.String(), input.InstanceId, input.ConnectionId, input.RoomId, len(execution.Calls))
+		}
+		return 0, nil
+	}
+
+	execution, ok := recording.Execution(opts.executionId)
+	if !ok {
+		return 0, fmt.Errorf("No execution %q in %s", opts.executionId, opts.replayPath)
+	}
+	if execution.Input == nil && execution.Batch == nil && execution.HTTP == nil {
+		return 0, fmt.Errorf("Execution %q has no recorded input and can't be replayed", opts.executionId)
+	}
+
+	wasm, err := os.ReadFile(opts.modulePath)
+	if err != nil {
+		return 0, err
+	}
+
+	t := &transcript{out: out}
+	r := execution.Replay()
+	handlerMap := r.Handlers()
+	t.wrap(handlerMap)
+
+	cfg := sandboxCfg(opts, wasm)
+	cfg.HandlerMap = wasmevents.NewHandlerMap()
+	cfg.ReplayHandlers = handlerMap
+
+	sandbox, err := store.NewSandboxStore(ctx, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer sandbox.Close(ctx)
+
+	start := time.Now()
+	switch {
+	case execution.Batch != nil:
+		err = replayBatch(ctx, sandbox, execution, t)
+	case execution.HTTP != nil:
+		err = replayHTTP(ctx, sandbox, execution, t)
+	default:
+		// copy so the recording isn't changed by the run
+		event := *execution.Input
+		t.printf("%s %s connection=%q room=%q payload=%q", execution.Id, event.EventType.String(), event.ConnectionId, event.RoomId, event.Payload)
+		err = sandbox.ExecuteOnModule(store.WithExecutionId(ctx, execution.Id), &event)
+	}
+	elapsed := time.Since(start).Round(time.Microsecond)
+
+	if err != nil {
+		t.printf("    failed after %s: %v", elapsed, err)
+	} else {
+		t.printf("    ok in %s", elapsed)
+	}
+	// writes aren't applied again, the recording has what was
+	if commit := execution.Commit; commit != nil {
+		var commitErr error
+		if commit.HasError {
+			commitErr = errors.New(commit.Error)
+		}
+		t.printf("    recorded:")
+		t.printCommit(commit.Writes, commitErr)
+	}
+	if replayErr := r.Err(); replayErr != nil {
+		t.printf("    %v", replayErr)
+		return 1, nil
+	}
+	if err != nil {
+		return 1, nil
+	}
+	t.printf("    replayed %d host calls", len(execution.Calls))
+	return 0, nil
+}
+
+// Run the messages of a batch execution through ExecuteBatch. Returns the first error of any message
+func replayBatch(ctx context.Context, sandbox *store.SandboxStore, execution *record.Execution, t *transcript) error {
+	events := make([]*wsevents.WSEventInfo, len(execution.Batch))
+	for i, recorded := range execution.Batch {
+		event := *recorded
+		events[i] = &event
+	}
+	t.printf("%s batch of %d messages room=%q", execution.Id, len(events), events[0].RoomId)
+
+	for _, err := range sandbox.ExecuteBatch(store.WithExecutionId(ctx, execution.Id), events[0].InstanceId, events) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send the request of an HTTP execution through ExecuteHTTP
+func replayHTTP(ctx context.Context, sandbox *store.SandboxStore, execution *record.Execution, t *transcript) error {
+	recorded := execution.HTTP
+	req, err := http.NewRequestWithContext(ctx, recorded.Method, (&url.URL{Path: recorded.Path, RawQuery: recorded.Query}).String(), strings.NewReader(recorded.Body))
+	if err != nil {
+		return err
+	}
+	req.Header = recorded.Header.Clone()
+	t.printf("%s HTTP %s %s query=%q body=%q", execution.Id, recorded.Method, recorded.Path, recorded.Query, recorded.Body)
+
+	resp, err := sandbox.ExecuteHTTP(store.WithExecutionId(ctx, execution.Id), recorded.InstanceId, req)
+	if err != nil {
+		return err
+	}
+	t.printf("    response %d body=%q", resp.StatusCode, resp.Body)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// A line of a script. Uses the json tags of WSEventInfo, plus the type of event by name
+type scriptEvent struct {
+	wsevents.WSEventInfo
+
+	// Export name ("__onJoin") or short name ("join"). Without it, EventType is used, which defaults to ON_MESSAGE
+	Event string `json:"event"`
+}
+
+// Names that can be used for "event", besides the export names
+var shortEventNames = map[string]wsevents.WSEventType{
+	"message":     wsevents.ON_MESSAGE,
+	"join":        wsevents.ON_JOIN,
+	"leave":       wsevents.ON_LEAVE,
+	"error":       wsevents.ON_ERROR,
+	"timer":       wsevents.ON_TIMER,
+	"roomCreated": wsevents.ON_ROOM_CREATED,
+	"roomEmpty":   wsevents.ON_ROOM_EMPTY,
+	"tick":        wsevents.ON_TICK,
+}
+
+func parseEventType(name string) (wsevents.WSEventType, error) {
+	if eventType, ok := shortEventNames[name]; ok {
+		return eventType, nil
+	}
+	for eventType := wsevents.WSEventType(0); eventType.Valid(); eventType++ {
+		if eventType.String() == name {
+			return eventType, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown event %q", name)
+}
+
+// Read a script, which is either a JSON array of events or one event per line (JSONL).
+// Blank lines and lines starting with # or // are skipped in JSONL scripts
+func readScript(r io.Reader) ([]scriptEvent, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []scriptEvent
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &events); err != nil {
+			return nil, fmt.Errorf("Invalid script: %w", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "//") {
+				continue
+			}
+
+			var event scriptEvent
+			if err := json.Unmarshal([]byte(text), &event); err != nil {
+				return nil, fmt.Errorf("Invalid script line %d: %w", line, err)
+			}
+			events = append(events, event)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range events {
+		if events[i].Event != "" {
+			eventType, err := parseEventType(events[i].Event)
+			if err != nil {
+				return nil, fmt.Errorf("Event %d: %w", i+1, err)
+			}
+			events[i].EventType = eventType
+		}
+		if !events[i].EventType.Valid() {
+			return nil, fmt.Errorf("Event %d: Invalid event type %d", i+1, events[i].EventType)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,217 @@
+// Signs modules with ed25519 so that signing.VerifyingLoader accepts them, and checks signed modules.
+//
+//	go run ./cmd/wasm-sign -generate -key signing.key
+//	go run ./cmd/wasm-sign -key signing.key module.wasm
+//	go run ./cmd/wasm-sign -key signing.key -detached module.wasm
+//	go run ./cmd/wasm-sign -verify -trust signing.key.pub module.wasm
+//
+// Signatures are embedded in the module unless -detached is passed, which writes them to module.wasm.sig instead
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/signing"
+)
+
+func main() {
+	generate := flag.Bool("generate", false, "generate a key pair, written to -key and -key with .pub added")
+	keyPath := flag.String("key", "", "PEM encoded ed25519 private key to sign with")
+	detached := flag.Bool("detached", false, "write the signature to a .sig file next to the module instead of embedding it")
+	output := flag.String("o", "", "where to write the signed module (defaults to replacing it), only for a single module")
+	verify := flag.Bool("verify", false, "check the signatures of the modules instead of signing them")
+	trust := flag.String("trust", "", "comma separated PEM encoded public keys to trust with -verify")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] module.wasm...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var err error
+	switch {
+	case *generate:
+		err = generateKey(*keyPath)
+	case *verify:
+		var ok bool
+		ok, err = verifyModules(*trust, flag.Args())
+		if err == nil && !ok {
+			os.Exit(1)
+		}
+	default:
+		err = signModules(*keyPath, *detached, *output, flag.Args())
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func generateKey(path string) error {
+	if path == "" {
+		return errors.New("Pass the file to write the private key to with -key")
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privatePEM, err := signing.MarshalPrivateKey(private)
+	if err != nil {
+		return err
+	}
+	publicPEM, err := signing.MarshalPublicKey(public)
+	if err != nil {
+		return err
+	}
+
+	// never overwrite a key
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(privatePEM); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".pub", publicPEM, 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated key %s, public key written to %s.pub\n", signing.KeyId(public), path)
+	return nil
+}
+
+func signModules(keyPath string, detached bool, output string, paths []string) error {
+	if keyPath == "" || len(paths) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if output != "" && (len(paths) > 1 || detached) {
+		return errors.New("-o can only be used when embedding the signature of a single module")
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	key, err := signing.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return fmt.Errorf("%s: %w", keyPath, err)
+	}
+	keyId := signing.KeyId(key.Public().(ed25519.PublicKey))
+
+	for _, path := range paths {
+		wasm, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if detached {
+			if err := os.WriteFile(path+signing.SidecarExtension, signing.SignDetached(wasm, key), 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("%s: signed with key %s, signature written to %s%s\n", path, keyId, path, signing.SidecarExtension)
+			continue
+		}
+
+		signed, err := signing.Sign(wasm, key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		target := path
+		if output != "" {
+			target = output
+		}
+		if err := replaceFile(target, signed); err != nil {
+			return err
+		}
+		fmt.Printf("%s: signed with key %s\n", target, keyId)
+	}
+
+	return nil
+}
+
+// Write through a temporary file, so a failed write doesn't leave a truncated module behind
+func replaceFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Returns false if any module isn't signed by one of the trusted keys
+func verifyModules(trust string, paths []string) (bool, error) {
+	if trust == "" || len(paths) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var trusted []ed25519.PublicKey
+	for _, keyPath := range strings.Split(trust, ",") {
+		keyPEM, err := os.ReadFile(keyPath)
+		if err != nil {
+			return false, err
+		}
+		key, err := signing.ParsePublicKey(keyPEM)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", keyPath, err)
+		}
+		trusted = append(trusted, key)
+	}
+
+	allValid := true
+	for _, path := range paths {
+		wasm, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+
+		unsigned, signature, err := signing.Embedded(wasm)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", path, err)
+		}
+		message, kind := unsigned, "embedded"
+		if signature == nil {
+			signature, err = os.ReadFile(path + signing.SidecarExtension)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return false, err
+			}
+			message, kind = wasm, "detached"
+		}
+
+		signer, err := signing.Verify(message, signature, trusted)
+		if err != nil {
+			allValid = false
+			fmt.Printf("%s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("%s: valid %s signature from key %s\n", path, kind, signing.KeyId(signer))
+	}
+
+	return allValid, nil
+}
@@ -52,4 +52,92 @@ export declare function _closeConnection(targetPtr: usize, targetLen: usize): us
 
 //@ts-ignore
 @external("env", "fetch")
-export declare function _fetch(urlPtr: usize, urlLen: usize, methodPtr: usize, methodLen: usize, bodyPtr: usize, bodyLen: usize): usize;
\ No newline at end of file
+export declare function _fetch(urlPtr: usize, urlLen: usize, methodPtr: usize, methodLen: usize, bodyPtr: usize, bodyLen: usize): usize;
+
+//@ts-ignore
+@external("env", "setTimeout")
+export declare function _setTimeout(delayMs: u32, payloadPtr: usize, payloadLen: usize): usize;
+
+//@ts-ignore
+@external("env", "setInterval")
+export declare function _setInterval(intervalMs: u32, payloadPtr: usize, payloadLen: usize): usize;
+
+//@ts-ignore
+@external("env", "clearTimer")
+export declare function _clearTimer(idPtr: usize, idLen: usize): usize;
+
+//@ts-ignore
+@external("env", "getState")
+export declare function _getState(): usize;
+
+//@ts-ignore
+@external("env", "setState")
+export declare function _setState(statePtr: usize, stateLen: usize): usize;
+
+//@ts-ignore
+@external("env", "setEx")
+export declare function _setEx(keyPtr: usize, keyLen: usize, valPtr: usize, valLen: usize, ttlMs: u32): usize;
+
+//@ts-ignore
+@external("env", "expire")
+export declare function _expire(keyPtr: usize, keyLen: usize, ttlMs: u32): usize;
+
+//@ts-ignore
+@external("env", "incrBy")
+export declare function _incrBy(keyPtr: usize, keyLen: usize, delta: i64): usize;
+
+//@ts-ignore
+@external("env", "compareAndSwap")
+export declare function _compareAndSwap(keyPtr: usize, keyLen: usize, expectedPtr: usize, expectedLen: usize, valPtr: usize, valLen: usize): usize;
+
+//@ts-ignore
+@external("env", "setIfAbsent")
+export declare function _setIfAbsent(keyPtr: usize, keyLen: usize, valPtr: usize, valLen: usize): usize;
+
+//@ts-ignore
+@external("env", "listPush")
+export declare function _listPush(keyPtr: usize, keyLen: usize, valPtr: usize, valLen: usize): usize;
+
+//@ts-ignore
+@external("env", "listPop")
+export declare function _listPop(keyPtr: usize, keyLen: usize): usize;
+
+//@ts-ignore
+@external("env", "listRange")
+export declare function _listRange(keyPtr: usize, keyLen: usize, start: i32, stop: i32): usize;
+
+//@ts-ignore
+@external("env", "hashGet")
+export declare function _hashGet(keyPtr: usize, keyLen: usize, fieldPtr: usize, fieldLen: usize): usize;
+
+//@ts-ignore
+@external("env", "hashSet")
+export declare function _hashSet(keyPtr: usize, keyLen: usize, fieldPtr: usize, fieldLen: usize, valPtr: usize, valLen: usize): usize;
+
+//@ts-ignore
+@external("env", "hashGetAll")
+export declare function _hashGetAll(keyPtr: usize, keyLen: usize): usize;
+
+//@ts-ignore
+@external("env", "dbList")
+export declare function _dbList(prefixPtr: usize, prefixLen: usize): usize;
+
+//@ts-ignore
+@external("env", "scan")
+export declare function _scan(prefixPtr: usize, prefixLen: usize, cursorPtr: usize, cursorLen: usize, limit: u32): usize;
+
+//@ts-ignore
+@external("env", "dbScan")
+export declare function _dbScan(prefixPtr: usize, prefixLen: usize, cursorPtr: usize, cursorLen: usize, limit: u32): usize;
+
+//@ts-ignore
+@external("env", "txBegin")
+export declare function _txBegin(): usize;
+
+//@ts-ignore
+@external("env", "txCommit")
+export declare function _txCommit(): usize;
+
+//@ts-ignore
+@external("env", "txRollback")
+export declare function _txRollback(): usize;
@@ -1,5 +1,8 @@
-import { decodeWSEvent } from "./sdk";
-import { onMessage, onJoin, onLeave, onError } from "./user";
+import { decodeWSEvent, decodeWSEventBatch, decodeRoomEvent, decodeHttpRequest } from "./sdk";
+import { onMessage, onJoin, onLeave, onError, onTimer, onRoomCreated, onRoomEmpty, onTick, onHttpRequest } from "./user";
+
+// Version of the host interface this SDK was written against, read by tools like wasm-inspect
+export const __abiVersion: i32 = 1;
 
 // Internal function to be called by the WebAssembly
 //
@@ -11,6 +14,20 @@ export function __onMessage(ptr: usize, len: usize): void {
   onMessage(event);
 }
 
+// Called by the host with many messages at once. Each one is passed to onMessage in order.
+//
+// Returning 0 tells the host that every message succeeded
+export function __onMessageBatch(ptr: usize, len: usize): usize {
+  const buf = changetype<ArrayBuffer>(ptr);
+  const events = decodeWSEventBatch(buf);
+
+  for (let i = 0; i < events.length; i++) {
+    onMessage(events[i]);
+  }
+
+  return 0;
+}
+
 export function __onJoin(ptr: usize, len: usize): void {
   const buf = changetype<ArrayBuffer>(ptr);
   const event = decodeWSEvent(buf);
@@ -30,4 +47,41 @@ export function __onError(ptr: usize, len: usize): void {
   const event = decodeWSEvent(buf);
 
   onError(event);
+}
+
+export function __onTimer(ptr: usize, len: usize): void {
+  const buf = changetype<ArrayBuffer>(ptr);
+  const event = decodeWSEvent(buf);
+
+  onTimer(event);
+}
+
+export function __onRoomCreated(ptr: usize, len: usize): void {
+  const buf = changetype<ArrayBuffer>(ptr);
+  const event = decodeRoomEvent(buf);
+
+  onRoomCreated(event);
+}
+
+export function __onRoomEmpty(ptr: usize, len: usize): void {
+  const buf = changetype<ArrayBuffer>(ptr);
+  const event = decodeRoomEvent(buf);
+
+  onRoomEmpty(event);
+}
+
+export function __onTick(ptr: usize, len: usize): void {
+  const buf = changetype<ArrayBuffer>(ptr);
+  const event = decodeRoomEvent(buf);
+
+  onTick(event);
+}
+
+// Returns a pointer to the encoded response, which the host reads after the call
+export function __onHttpRequest(ptr: usize, len: usize): usize {
+  const buf = changetype<ArrayBuffer>(ptr);
+  const request = decodeHttpRequest(buf);
+
+  const response = onHttpRequest(request);
+  return changetype<usize>(response.encode());
 }
\ No newline at end of file
@@ -12,6 +12,7 @@ export function debug(msg: string): void {
  * * store: contains methods for redis operations
  * * db: contains methods for persistent data operations
  * * room: contains methods for room operations
+ * * timers: contains methods for scheduling calls to onTimer
  * 
  * Example usage:
  * ```TypeScript
@@ -24,11 +25,15 @@ export class Context {
   store: Store;
   db: DB;
   room: Room;
+  timers: Timers;
+  state: State;
 
   constructor(){
     this.store = new Store();
     this.room = new Room();
     this.db = new DB();
+    this.timers = new Timers();
+    this.state = new State();
   }
   
   /**
@@ -105,6 +110,170 @@ class Store {
     const valPtr = env._del(to_usize(key), key.length);
     return get_status(valPtr);
   }
+
+  /**
+   * Set a key to the corresponding value, and delete it after a while
+   * 
+   * @param key the key to set
+   * @param value the value to set it to
+   * @param ttlMs how long the key lives for, in milliseconds
+   * @returns A status representing the success of the operation
+   */
+  setEx(key: string, value: string, ttlMs: u32): Status {
+    const errPtr = env._setEx(to_usize(key), key.length, to_usize(value), value.length, ttlMs);
+    return get_status(errPtr);
+  }
+
+  /**
+   * Delete an existing key after a while
+   * 
+   * @param key the key to expire
+   * @param ttlMs how long the key lives for from now, in milliseconds
+   * @returns A status representing the success of the operation
+   */
+  expire(key: string, ttlMs: u32): Status {
+    const errPtr = env._expire(to_usize(key), key.length, ttlMs);
+    return get_status(errPtr);
+  }
+
+  /**
+   * Atomically add to the integer stored at a key. Missing keys start at 0
+   * 
+   * @param key the key to increment
+   * @param delta the amount to add, which can be negative
+   * @returns A result containing the new value or an error
+   */
+  incrBy(key: string, delta: i64): Result<i64> {
+    const valPtr = env._incrBy(to_usize(key), key.length, delta);
+    const res = get_result(valPtr);
+    if (res.isError()) {
+      return new Result<i64>(0, res.error);
+    }
+    return new Result<i64>(I64.parseInt(res.data));
+  }
+
+  /**
+   * Atomically replace the value of a key, but only if it still holds the expected value
+   * 
+   * @param key the key to swap
+   * @param expected the value the key must currently hold
+   * @param value the new value
+   * @returns A result containing whether the value was swapped, or an error
+   */
+  compareAndSwap(key: string, expected: string, value: string): Result<bool> {
+    const valPtr = env._compareAndSwap(to_usize(key), key.length, to_usize(expected), expected.length, to_usize(value), value.length);
+    return get_bool_result(valPtr);
+  }
+
+  /**
+   * Set a key only if it doesn't exist yet
+   * 
+   * @param key the key to set
+   * @param value the value to set it to
+   * @returns A result containing whether the value was set, or an error
+   */
+  setIfAbsent(key: string, value: string): Result<bool> {
+    const valPtr = env._setIfAbsent(to_usize(key), key.length, to_usize(value), value.length);
+    return get_bool_result(valPtr);
+  }
+
+  /**
+   * Append a value to the end of a list, creating the list if needed
+   * 
+   * @param key the key of the list
+   * @param value the value to append
+   * @returns A result containing the new length of the list, or an error
+   */
+  listPush(key: string, value: string): Result<i32> {
+    const valPtr = env._listPush(to_usize(key), key.length, to_usize(value), value.length);
+    const res = get_result(valPtr);
+    if (res.isError()) {
+      return new Result<i32>(0, res.error);
+    }
+    return new Result<i32>(I32.parseInt(res.data));
+  }
+
+  /**
+   * Remove and return the first value of a list
+   * 
+   * @param key the key of the list
+   * @returns A result containing the value, or an error if the list is empty
+   */
+  listPop(key: string): Result<string> {
+    const valPtr = env._listPop(to_usize(key), key.length);
+    return get_result(valPtr);
+  }
+
+  /**
+   * Get the values of a list between two indexes (both inclusive).
+   * Negative indexes count from the end, so listRange(key, 0, -1) returns the whole list
+   * 
+   * @param key the key of the list
+   * @param start the index of the first value
+   * @param stop the index of the last value
+   * @returns A result containing the values or an error
+   */
+  listRange(key: string, start: i32, stop: i32): Result<string[]> {
+    const ptr = env._listRange(to_usize(key), key.length, start, stop);
+    return decodeStringArray(changetype<ArrayBuffer>(ptr));
+  }
+
+  /**
+   * Get a field of a hash
+   * 
+   * @param key the key of the hash
+   * @param field the field to get
+   * @returns A result containing the value ("" if the field doesn't exist) or an error
+   */
+  hashGet(key: string, field: string): Result<string> {
+    const valPtr = env._hashGet(to_usize(key), key.length, to_usize(field), field.length);
+    return get_result(valPtr);
+  }
+
+  /**
+   * Set a field of a hash, creating the hash if needed
+   * 
+   * @param key the key of the hash
+   * @param field the field to set
+   * @param value the value to set it to
+   * @returns A status representing the success of the operation
+   */
+  hashSet(key: string, field: string, value: string): Status {
+    const errPtr = env._hashSet(to_usize(key), key.length, to_usize(field), field.length, to_usize(value), value.length);
+    return get_status(errPtr);
+  }
+
+  /**
+   * Get every field of a hash
+   * 
+   * @param key the key of the hash
+   * @returns A result containing a map of fields to values, or an error
+   */
+  hashGetAll(key: string): Result<Map<string, string>> {
+    const ptr = env._hashGetAll(to_usize(key), key.length);
+    const res = decodeStringArray(changetype<ArrayBuffer>(ptr));
+
+    const fields = new Map<string, string>();
+    if (res.isError()) {
+      return new Result(fields, res.error);
+    }
+    for (let i = 0; i + 1 < res.data.length; i += 2) {
+      fields.set(res.data[i], res.data[i + 1]);
+    }
+    return new Result(fields);
+  }
+
+  /**
+   * Iterate over the keys and values in the store that start with a prefix, in sorted order.
+   * Pages of keys are fetched from the host as needed. Lists and hashes are returned as JSON
+   * 
+   * @param prefix the prefix to match, "" matches every key
+   * @param pageSize how many keys to fetch at once (0 uses the host's default)
+   * @returns An iterator, see ScanIterator
+   */
+  scan(prefix: string, pageSize: u32 = 0): ScanIterator {
+    return new ScanIterator(false, prefix, pageSize);
+  }
 }
 
 class DB {
@@ -141,6 +310,129 @@ class DB {
     const valPtr = env._dbDel(to_usize(key), key.length);
     return get_status(valPtr);
   }
+
+  /**
+   * List the keys in the database that start with a prefix
+   * 
+   * @param prefix the prefix to match, "" lists every key
+   * @returns A result containing the keys in sorted order, or an error
+   */
+  list(prefix: string): Result<string[]> {
+    const ptr = env._dbList(to_usize(prefix), prefix.length);
+    return decodeStringArray(changetype<ArrayBuffer>(ptr));
+  }
+
+  /**
+   * Iterate over the keys and values in the database that start with a prefix, in sorted order.
+   * Pages of keys are fetched from the host as needed
+   * 
+   * @param prefix the prefix to match, "" matches every key
+   * @param pageSize how many keys to fetch at once (0 uses the host's default)
+   * @returns An iterator, see ScanIterator
+   */
+  scan(prefix: string, pageSize: u32 = 0): ScanIterator {
+    return new ScanIterator(true, prefix, pageSize);
+  }
+
+  /**
+   * Start a transaction. Until commit or rollback is called, set and del are buffered
+   * and only applied once the handler returns successfully
+   * 
+   * @returns A status, which fails if a transaction is already open
+   */
+  begin(): Status {
+    const errPtr = env._txBegin();
+    return get_status(errPtr);
+  }
+
+  /**
+   * Commit the open transaction. Its writes are applied together once the handler returns,
+   * and discarded if it fails
+   * 
+   * @returns A status, which fails if no transaction is open
+   */
+  commit(): Status {
+    const errPtr = env._txCommit();
+    return get_status(errPtr);
+  }
+
+  /**
+   * Discard the writes of the open transaction
+   * 
+   * @returns A status, which fails if no transaction is open
+   */
+  rollback(): Status {
+    const errPtr = env._txRollback();
+    return get_status(errPtr);
+  }
+}
+
+/**
+ * Iterates over the results of Store.scan or DB.scan.
+ * 
+ * Example usage:
+ * ```Typescript
+ * const it = ctx.db.scan("room:123:");
+ * while (it.next()) {
+ *     debug(it.key + " = " + it.value);
+ * }
+ * if (it.error != "") {
+ *     debug("Scan failed: " + it.error);
+ * }
+ * ```
+ */
+export class ScanIterator {
+  key: string = "";
+  value: string = "";
+  error: string = "";
+
+  private db: bool;
+  private prefix: string;
+  private pageSize: u32;
+  private cursor: string = "";
+  private page: string[] = [];
+  private index: i32 = 0;
+  private done: bool = false;
+
+  constructor(db: bool, prefix: string, pageSize: u32) {
+    this.db = db;
+    this.prefix = prefix;
+    this.pageSize = pageSize;
+  }
+
+  /**
+   * Move to the next key
+   * 
+   * @returns false once there are no more keys, or the scan failed
+   */
+  next(): bool {
+    // pages are [next cursor, key1, value1, key2, value2, ...]
+    while (this.index + 1 >= this.page.length) {
+      if (this.done) {
+        return false;
+      }
+
+      const ptr = this.db
+        ? env._dbScan(to_usize(this.prefix), this.prefix.length, to_usize(this.cursor), this.cursor.length, this.pageSize)
+        : env._scan(to_usize(this.prefix), this.prefix.length, to_usize(this.cursor), this.cursor.length, this.pageSize);
+      const res = decodeStringArray(changetype<ArrayBuffer>(ptr));
+      if (res.isError() || res.data.length == 0) {
+        this.error = res.isError() ? res.error : "Invalid scan response";
+        this.done = true;
+        return false;
+      }
+
+      this.page = res.data;
+      this.index = -1;
+      this.cursor = res.data[0];
+      this.done = this.cursor == "";
+    }
+
+    this.index += 2;
+    this.key = this.page[this.index];
+    this.value = this.page[this.index + 1];
+    return true;
+  }
 }
 
 class Room {
@@ -193,6 +485,77 @@ class Room {
   }
 }
 
+/**
+ * Defines methods that schedule calls to onTimer.
+ * 
+ * Timers belong to the room they were created in, and are cancelled when the room
+ * closes or the module is unloaded
+ */
+class Timers {
+  /**
+   * Call onTimer once after a delay
+   * 
+   * @param delayMs the delay in milliseconds
+   * @param payload passed back as the payload of the onTimer event
+   * @returns A result containing the timer's ID or an error
+   */
+  setTimeout(delayMs: u32, payload: string): Result<string> {
+    const valPtr = env._setTimeout(delayMs, to_usize(payload), payload.length);
+    return get_result(valPtr);
+  }
+
+  /**
+   * Call onTimer repeatedly until the timer is cleared
+   * 
+   * @param intervalMs the interval in milliseconds
+   * @param payload passed back as the payload of each onTimer event
+   * @returns A result containing the timer's ID or an error
+   */
+  setInterval(intervalMs: u32, payload: string): Result<string> {
+    const valPtr = env._setInterval(intervalMs, to_usize(payload), payload.length);
+    return get_result(valPtr);
+  }
+
+  /**
+   * Cancel a timer
+   * 
+   * @param id the ID returned by setTimeout or setInterval
+   * @returns A status representing the success of the operation
+   */
+  clear(id: string): Status {
+    const errPtr = env._clearTimer(to_usize(id), id.length);
+    return get_status(errPtr);
+  }
+}
+
+/**
+ * State that is kept by the host for the current room, and shared by every instance of the module.
+ * 
+ * Changes are saved once the current event returns successfully, and thrown away if it fails.
+ * Only available if the host has module state enabled
+ */
+class State {
+  /**
+   * Get the state of the current room
+   * 
+   * @returns A result containing the state, or "" if it was never set
+   */
+  get(): Result<string> {
+    const valPtr = env._getState();
+    return get_result(valPtr);
+  }
+
+  /**
+   * Replace the state of the current room
+   * 
+   * @param value the new state
+   * @returns A status representing the success of the operation
+   */
+  set(value: string): Status {
+    const errPtr = env._setState(to_usize(value), value.length);
+    return get_status(errPtr);
+  }
+}
 
 // AssemblyScript doesn't seem to allow for object interfaces so we need to use a class
 
@@ -215,6 +578,79 @@ export class WSEvent {
   timestamp: number = 0;
 }
 
+/**
+ * This class defines the information passed in with room lifecycle events
+ * (onRoomCreated, onRoomEmpty and onTick). These are not sent by a connection.
+ * 
+ * Fields:
+ * * roomId: the unique identifier of the room
+ * * payload: extra information about the event.
+ *     * For onTick, this is the tick's sequence number, starting at 1
+ * * timestamp
+ *     * the unixmilli timestamp of when the event was created
+ */
+export class RoomEvent {
+  roomId: string = "";
+  payload: string = "";
+  timestamp: number = 0;
+}
+
+/**
+ * This class defines an HTTP request sent to the module's onHttpRequest function.
+ * 
+ * Fields:
+ * * method: the HTTP method (GET, POST, PUT, etc.)
+ * * path: the request path, without the module prefix
+ * * query: the raw query string, without the leading "?"
+ * * body: the request body
+ * * headers: the request headers
+ */
+export class HttpRequest {
+  method: string = "";
+  path: string = "";
+  query: string = "";
+  body: string = "";
+  headers: Map<string, string> = new Map<string, string>();
+}
+
+/**
+ * This class defines the response returned from onHttpRequest.
+ * 
+ * Example usage:
+ * ```TypeScript
+ * const resp = new HttpResponse(200, "{\"ok\": true}");
+ * resp.headers.set("Content-Type", "application/json");
+ * return resp;
+ * ```
+ */
+export class HttpResponse {
+  status: i32;
+  body: string;
+  headers: Map<string, string> = new Map<string, string>();
+
+  constructor(status: i32 = 200, body: string = "") {
+    this.status = status;
+    this.body = body;
+  }
+
+  /**
+   * Encode the response in the array format that the host reads
+   */
+  encode(): ArrayBuffer {
+    const fields = new Array<string>();
+    fields.push(this.status.toString());
+    fields.push(this.body);
+
+    const names = this.headers.keys();
+    for (let i = 0; i < names.length; i++) {
+      fields.push(names[i]);
+      fields.push(this.headers.get(names[i]));
+    }
+
+    return encodeStringArray(fields);
+  }
+}
+
 /**
  * The "Result" class is used when a method has a return value,
  * but may also error. It is inspired by the similarly named type in Rust.
@@ -318,6 +754,53 @@ function decodeStringArray(buf: ArrayBuffer): Result<string[]> {
     return new Result(result);
 }
 
+// Inverse of decodeStringArray, lays out the strings in the same format as the host
+function encodeStringArray(arr: string[]): ArrayBuffer {
+    let size = 6;
+    for (let i = 0; i < arr.length; i++) {
+        size += 4 + String.UTF8.byteLength(arr[i]);
+    }
+
+    const buf = new ArrayBuffer(size);
+    const data = Uint8Array.wrap(buf);
+
+    data[0] = 43; // + is 43 in ascii
+    data[1] = 0;
+    store<u32>(changetype<usize>(buf) + 2, arr.length);
+
+    let offset = 6;
+    for (let i = 0; i < arr.length; i++) {
+        const strBytes = Uint8Array.wrap(String.UTF8.encode(arr[i]));
+        store<u32>(changetype<usize>(buf) + offset, strBytes.length);
+        offset += 4;
+
+        data.set(strBytes, offset);
+        offset += strBytes.length;
+    }
+
+    return buf;
+}
+
+export function decodeHttpRequest(buf: ArrayBuffer): HttpRequest {
+    const data = decodeStringArray(buf);
+    if (data.isError()) {
+        return new HttpRequest();
+    }
+
+    const strArray = data.data;
+    const ret: HttpRequest = new HttpRequest();
+    ret.method = strArray[0];
+    ret.path = strArray[1];
+    ret.query = strArray[2];
+    ret.body = strArray[3];
+
+    for (let i = 4; i + 1 < strArray.length; i += 2) {
+        ret.headers.set(strArray[i], strArray[i + 1]);
+    }
+
+    return ret;
+}
+
 export function decodeWSEvent(buf: ArrayBuffer): WSEvent {
     const data = decodeStringArray(buf);
     if (data.isError()) {
@@ -334,6 +817,42 @@ export function decodeWSEvent(buf: ArrayBuffer): WSEvent {
     return ret;
 }
 
+// A batch is a flat array with the same 4 fields per event as decodeWSEvent
+export function decodeWSEventBatch(buf: ArrayBuffer): WSEvent[] {
+    const data = decodeStringArray(buf);
+    if (data.isError()) {
+        return [];
+    }
+
+    const strArray = data.data;
+    const events = new Array<WSEvent>();
+    for (let i = 0; i + 3 < strArray.length; i += 4) {
+        const event = new WSEvent();
+        event.connectionId = strArray[i];
+        event.roomId = strArray[i + 1];
+        event.timestamp = parseInt(strArray[i + 2]);
+        event.payload = strArray[i + 3];
+        events.push(event);
+    }
+
+    return events;
+}
+
+export function decodeRoomEvent(buf: ArrayBuffer): RoomEvent {
+    const data = decodeStringArray(buf);
+    if (data.isError()) {
+        return new RoomEvent();
+    }
+
+    const strArray = data.data;
+    const ret: RoomEvent = new RoomEvent();
+    ret.roomId = strArray[0];
+    ret.timestamp = parseInt(strArray[1]);
+    ret.payload = strArray[2];
+
+    return ret;
+}
+
 function to_usize(str: string): usize {
     const ptr = String.UTF8.encode(str);
     return changetype<usize>(ptr);
@@ -349,6 +868,14 @@ function get_status(ptr: u32): Status {
     return new Status(val);
 }
 
+function get_bool_result(ptr: u32): Result<bool> {
+    const res = get_result(ptr);
+    if (res.isError()) {
+        return new Result<bool>(false, res.error);
+    }
+    return new Result<bool>(res.data == "true");
+}
+
 function get_result(ptr: u32): Result<string> {
     // assembly script strings have the length stored 4 bytes before the string itself
     const len = load<i32>(ptr - 4);
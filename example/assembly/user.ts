@@ -1,4 +1,4 @@
-import { WSEvent, Context, debug } from "./sdk";
+import { WSEvent, RoomEvent, HttpRequest, HttpResponse, Context, debug } from "./sdk";
 
 export function onMessage(event: WSEvent): void {
   const ctx = new Context();
@@ -16,4 +16,24 @@ export function onLeave(event: WSEvent): void {
 }
 
 export function onError(event: WSEvent): void {
+}
+
+export function onTimer(event: WSEvent): void {
+}
+
+export function onRoomCreated(event: RoomEvent): void {
+}
+
+export function onRoomEmpty(event: RoomEvent): void {
+}
+
+export function onTick(event: RoomEvent): void {
+}
+
+export function onHttpRequest(request: HttpRequest): HttpResponse {
+  if (request.path == "/health") {
+    return new HttpResponse(200, "ok");
+  }
+
+  return new HttpResponse(404, "not found");
 }
\ No newline at end of file
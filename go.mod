@@ -2,6 +2,9 @@ module github.com/Cloud-RAMP/wasm-sandbox
 
 go 1.24.1
 
-require github.com/tetratelabs/wazero v1.11.0
+require (
+	github.com/coder/websocket v1.8.14
+	github.com/tetratelabs/wazero v1.11.0
+)
 
 require golang.org/x/sys v0.38.0 // indirect
@@ -0,0 +1,177 @@
+package asmscript
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Module with nothing but a single page of exported memory
+var memoryModule = []byte{
+	0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00,
+	0x05, 0x03, 0x01, 0x00, 0x01, // memory section: 1 page
+	0x07, 0x0a, 0x01, 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00, // export section: "memory"
+}
+
+// Memory of a fresh instance, which the fuzz targets fill with guest-controlled bytes
+func fuzzMemory(f *testing.F) api.Memory {
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	f.Cleanup(func() { runtime.Close(ctx) })
+
+	mod, err := runtime.Instantiate(ctx, memoryModule)
+	if err != nil {
+		f.Fatalf("Failed to instantiate memory module: %v", err)
+	}
+	return mod.Memory()
+}
+
+func fillMemory(mem api.Memory, data []byte) {
+	size := mem.Size()
+	if uint32(len(data)) > size {
+		data = data[:size]
+	}
+	clear := make([]byte, size)
+	copy(clear, data)
+	mem.Write(0, clear)
+}
+
+func FuzzDecodeArray(f *testing.F) {
+	f.Add(encodeArray(nil))
+	f.Add(encodeArray([]string{"a", "", "hello"}))
+	f.Add([]byte{'+', 0, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{'+', 0, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		arr, err := decodeArray(buf)
+		if err != nil {
+			return
+		}
+
+		// whatever decodes must encode back to the bytes it was read from
+		encoded := encodeArray(arr)
+		if len(encoded) > len(buf) || !slices.Equal(encoded[2:], buf[2:len(encoded)]) {
+			t.Errorf("Decoded %q from %x, which encodes to %x", arr, buf, encoded)
+		}
+	})
+}
+
+func FuzzArrayRoundTrip(f *testing.F) {
+	f.Add("")
+	f.Add("a\x00b\x00")
+	f.Add("\xff\xfe\x00")
+
+	f.Fuzz(func(t *testing.T, joined string) {
+		arr := strings.Split(joined, "\x00")
+
+		decoded, err := decodeArray(encodeArray(arr))
+		if err != nil {
+			t.Fatalf("Failed to decode %q: %v", arr, err)
+		}
+		if !slices.Equal(arr, decoded) {
+			t.Errorf("Encoded %q, decoded %q", arr, decoded)
+		}
+	})
+}
+
+func FuzzWSEventRoundTrip(f *testing.F) {
+	f.Add("connection", "room", int64(0), "payload", false)
+	f.Add("", "", int64(-1), "\x00\xff", true)
+
+	f.Fuzz(func(t *testing.T, connectionId string, roomId string, timestamp int64, payload string, roomEvent bool) {
+		event := &wsevents.WSEventInfo{
+			ConnectionId: connectionId,
+			RoomId:       roomId,
+			Timestamp:    timestamp,
+			Payload:      payload,
+			EventType:    wsevents.ON_MESSAGE,
+		}
+		if roomEvent {
+			event.EventType = wsevents.ON_TICK
+		}
+
+		decoded, err := decodeArray(encodeWSEvent(event))
+		if err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		if decoded[len(decoded)-1] != payload || decoded[len(decoded)-3] != roomId {
+			t.Errorf("Event fields were changed: %q", decoded)
+		}
+	})
+}
+
+func FuzzUTF16(f *testing.F) {
+	f.Add("hello")
+	f.Add("héllo wörld")
+	f.Add("emoji 🎉")
+	f.Add("\xff")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		encoded := encodeUTF16LE(s)
+		if len(encoded)%2 != 0 {
+			t.Fatalf("Odd number of bytes for %q", s)
+		}
+
+		decoded := decodeUTF16LE(encoded)
+		if utf8.ValidString(s) && decoded != s {
+			t.Errorf("Encoded %q, decoded %q", s, decoded)
+		}
+	})
+}
+
+func FuzzReadASString(f *testing.F) {
+	mem := fuzzMemory(f)
+
+	valid := binary.LittleEndian.AppendUint32(make([]byte, 4), 2)
+	valid = append(valid, encodeUTF16LE("hi")...)
+
+	f.Add(valid, uint32(0))
+	f.Add(valid, uint32(0xfffffffc))
+	f.Add([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f}, uint32(0))
+	f.Add([]byte{0, 0, 0, 0, 0x01, 0x00, 0x00, 0x80}, uint32(0))
+
+	f.Fuzz(func(t *testing.T, data []byte, ptr uint32) {
+		fillMemory(mem, data)
+
+		// can't be longer than the memory it was read from
+		if s := ReadASString(mem, ptr); uint32(len(s)) > mem.Size()*2 {
+			t.Errorf("Read %d bytes from a %d byte memory", len(s), mem.Size())
+		}
+	})
+}
+
+func FuzzReadArray(f *testing.F) {
+	mem := fuzzMemory(f)
+
+	valid := binary.LittleEndian.AppendUint32(nil, 0)
+	valid = append(valid, encodeArray([]string{"a", "b"})...)
+	binary.LittleEndian.PutUint32(valid, uint32(len(valid)-4))
+
+	f.Add(valid, uint32(4))
+	f.Add(valid, uint32(0))
+	f.Add(valid, uint32(0xffffffff))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff}, uint32(4))
+
+	f.Fuzz(func(t *testing.T, data []byte, ptr uint32) {
+		fillMemory(mem, data)
+
+		arr, err := ReadArray(mem, ptr)
+		if err != nil {
+			return
+		}
+		total := 0
+		for _, s := range arr {
+			total += len(s)
+		}
+		if uint32(total) > mem.Size() {
+			t.Errorf("Read %d bytes from a %d byte memory", total, mem.Size())
+		}
+	})
+}
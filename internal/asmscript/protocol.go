@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+	"github.com/tetratelabs/wazero/api"
 )
 
 /*
@@ -34,9 +35,48 @@ func encodeArray(arr []string) []byte {
 	return buf
 }
 
+// Inverse of encodeArray, used for arrays that the module writes
+func decodeArray(buf []byte) ([]string, error) {
+	if len(buf) < 6 {
+		return nil, fmt.Errorf("Array is too short")
+	}
+	if buf[0] != '+' {
+		return nil, fmt.Errorf("Array has an error indicator")
+	}
+
+	count := binary.LittleEndian.Uint32(buf[2:])
+	offset := uint32(6)
+
+	// every string takes at least 4 bytes, so don't trust counts that can't fit
+	if count > (uint32(len(buf))-offset)/4 {
+		return nil, fmt.Errorf("Array count %d is larger than the buffer", count)
+	}
+
+	arr := make([]string, 0, count)
+	for range count {
+		if uint32(len(buf))-offset < 4 {
+			return nil, fmt.Errorf("Array is truncated")
+		}
+		strLen := binary.LittleEndian.Uint32(buf[offset:])
+		offset += 4
+
+		if strLen > uint32(len(buf))-offset {
+			return nil, fmt.Errorf("String length %d is larger than the buffer", strLen)
+		}
+		arr = append(arr, string(buf[offset:offset+strLen]))
+		offset += strLen
+	}
+
+	return arr, nil
+}
+
 // A WS event will just be encoded as an array of fields,
 // it will be assumed that they are in the same order every time
 func encodeWSEvent(event *wsevents.WSEventInfo) []byte {
+	if event.EventType.IsRoomEvent() {
+		return encodeRoomEvent(event)
+	}
+
 	feilds := []string{
 		event.ConnectionId,
 		event.RoomId,
@@ -47,6 +87,37 @@ func encodeWSEvent(event *wsevents.WSEventInfo) []byte {
 	return encodeArray(feilds)
 }
 
+// Room events don't come from a connection, so they only carry the room, timestamp and payload
+func encodeRoomEvent(event *wsevents.WSEventInfo) []byte {
+	fields := []string{
+		event.RoomId,
+		fmt.Sprint(event.Timestamp),
+		event.Payload,
+	}
+
+	return encodeArray(fields)
+}
+
+// A batch of WS events is a flat array with the same 4 fields per event
+func encodeWSEventBatch(events []*wsevents.WSEventInfo) []byte {
+	fields := make([]string, 0, len(events)*4)
+	for _, event := range events {
+		fields = append(fields,
+			event.ConnectionId,
+			event.RoomId,
+			fmt.Sprint(event.Timestamp),
+			event.Payload,
+		)
+	}
+
+	return encodeArray(fields)
+}
+
+func WriteWSEventBatch(mod *ModuleContext, events []*wsevents.WSEventInfo) (uint64, uint64, error) {
+	bytes := encodeWSEventBatch(events)
+	return writeHelper(mod, bytes)
+}
+
 func WriteWSEvent(mod *ModuleContext, event *wsevents.WSEventInfo) (uint64, uint64, error) {
 	bytes := encodeWSEvent(event)
 	return writeHelper(mod, bytes)
@@ -56,3 +127,25 @@ func WriteArray(mod *ModuleContext, array []string) (uint64, uint64, error) {
 	bytes := encodeArray(array)
 	return writeHelper(mod, bytes)
 }
+
+// Read an array that the module encoded with the same protocol as WriteArray.
+//
+// ptr points to an AssemblyScript ArrayBuffer, which has its byte length stored in the 4 bytes before it
+func ReadArray(mem api.Memory, ptr uint32) ([]string, error) {
+	if ptr < 4 {
+		return nil, fmt.Errorf("Invalid array pointer %d", ptr)
+	}
+
+	lenBytes, ok := mem.Read(ptr-4, 4)
+	if !ok {
+		return nil, fmt.Errorf("Failed to read array length")
+	}
+	size := binary.LittleEndian.Uint32(lenBytes)
+
+	data, ok := mem.Read(ptr, size)
+	if !ok {
+		return nil, fmt.Errorf("Failed to read array data")
+	}
+
+	return decodeArray(data)
+}
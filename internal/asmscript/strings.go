@@ -2,6 +2,7 @@ package asmscript
 
 import (
 	"encoding/binary"
+	"math"
 
 	"github.com/tetratelabs/wazero/api"
 )
@@ -11,12 +12,20 @@ const STRING_TYPE_ID = 2
 
 // Read AssemblyScript string from memory
 func ReadASString(mem api.Memory, ptr uint32) string {
+	// ptr is guest controlled, don't let the offsets below wrap around
+	if ptr > math.MaxUint32-8 {
+		return "<failed to read string>"
+	}
+
 	// Read length prefix (bytes 4-7)
 	lenBytes, ok := mem.Read(ptr+4, 4)
 	if !ok {
 		return "<failed to read string>"
 	}
 	strLen := binary.LittleEndian.Uint32(lenBytes)
+	if strLen > math.MaxUint32/2 {
+		return "<failed to read string data>"
+	}
 
 	// Read UTF-16 data (starts at offset 8)
 	data, ok := mem.Read(ptr+8, strLen*2)
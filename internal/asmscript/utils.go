@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"unicode/utf16"
 
 	"github.com/tetratelabs/wazero/api"
 )
@@ -13,24 +14,23 @@ type ModuleContext struct {
 	Ctx    context.Context
 }
 
-// Encode string to UTF-16 Little Endian bytes
+// Encode string to UTF-16 Little Endian bytes. Characters outside the BMP become surrogate pairs
 func encodeUTF16LE(s string) []byte {
-	bytes := make([]byte, len(s)*2)
-	for i, r := range s {
-		binary.LittleEndian.PutUint16(bytes[i*2:], uint16(r))
+	units := utf16.Encode([]rune(s))
+	bytes := make([]byte, len(units)*2)
+	for i, unit := range units {
+		binary.LittleEndian.PutUint16(bytes[i*2:], unit)
 	}
 	return bytes
 }
 
-// Decode UTF-16 Little Endian bytes to string
+// Decode UTF-16 Little Endian bytes to string, an odd trailing byte is ignored
 func decodeUTF16LE(b []byte) string {
-	runes := make([]rune, len(b)/2)
-	for i := 0; i < len(b); i += 2 {
-		if i+1 < len(b) {
-			runes[i/2] = rune(binary.LittleEndian.Uint16(b[i:]))
-		}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[i*2:])
 	}
-	return string(runes)
+	return string(utf16.Decode(units))
 }
 
 // Write a string to module memory
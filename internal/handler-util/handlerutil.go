@@ -0,0 +1,64 @@
+// Helpers shared by the built-in handler packages (kv, db and rooms)
+package handlerutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// Scans return at most this many keys per page
+const MaxScanLimit = 1000
+
+// Page size used when a scan asks for a limit of 0
+const DefaultScanLimit = 100
+
+// A key and its value, returned by scans
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// Gets up to limit pairs for a tenant whose keys start with prefix and sort after cursor,
+// along with the cursor for the next page
+type ScanFunc func(tenant string, prefix string, cursor string, limit int) ([]Pair, string, error)
+
+// Make sure an event has the number of payload fields its handler expects
+func CheckPayload(event *wasmevents.WASMEventInfo, length int) error {
+	if len(event.Payload) != length {
+		return fmt.Errorf("Invalid payload for %s event", event.EventType.String())
+	}
+	return nil
+}
+
+// Handle a scan event with the given scan function, for the event's InstanceId.
+//
+// Payload is [prefix, cursor, limit], returns a JSON array of [next cursor, key1, value1, key2, value2, ...].
+// A limit of 0 uses DefaultScanLimit, and limits are capped at MaxScanLimit
+func HandleScan(event *wasmevents.WASMEventInfo, scan ScanFunc) (string, error) {
+	if err := CheckPayload(event, 3); err != nil {
+		return "", err
+	}
+	limit, err := strconv.Atoi(event.Payload[2])
+	if err != nil || limit < 0 {
+		return "", fmt.Errorf("Invalid limit %q", event.Payload[2])
+	}
+	if limit == 0 {
+		limit = DefaultScanLimit
+	}
+
+	pairs, next, err := scan(event.InstanceId, event.Payload[0], event.Payload[1], min(limit, MaxScanLimit))
+	if err != nil {
+		return "", err
+	}
+
+	fields := make([]string, 0, 1+len(pairs)*2)
+	fields = append(fields, next)
+	for _, pair := range pairs {
+		fields = append(fields, pair.Key, pair.Value)
+	}
+	encoded, err := json.Marshal(fields)
+	return string(encoded), err
+}
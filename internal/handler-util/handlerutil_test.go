@@ -0,0 +1,46 @@
+package handlerutil
+
+import (
+	"testing"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+func TestHandleScan(t *testing.T) {
+	var gotLimit int
+	scan := func(tenant string, prefix string, cursor string, limit int) ([]Pair, string, error) {
+		gotLimit = limit
+		if tenant != "tenant" || prefix != "p" || cursor != "c" {
+			t.Errorf("Unexpected scan of %q, %q, %q", tenant, prefix, cursor)
+		}
+		return []Pair{{Key: "p1", Value: "a"}, {Key: "p2", Value: `"b"`}}, "p2", nil
+	}
+
+	for _, tc := range []struct {
+		limit    string
+		expected int
+	}{
+		{"0", DefaultScanLimit},
+		{"5", 5},
+		{"5000", MaxScanLimit},
+	} {
+		event := &wasmevents.WASMEventInfo{InstanceId: "tenant", EventType: wasmevents.SCAN, Payload: []string{"p", "c", tc.limit}}
+		result, err := HandleScan(event, scan)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if gotLimit != tc.expected {
+			t.Errorf("Expected a limit of %s to scan %d keys, got %d", tc.limit, tc.expected, gotLimit)
+		}
+		if expected := `["p2","p1","a","p2","\"b\""]`; result != expected {
+			t.Errorf("Expected %s, got %s", expected, result)
+		}
+	}
+
+	for _, payload := range [][]string{{"p", "c"}, {"p", "c", "-1"}, {"p", "c", "x"}} {
+		event := &wasmevents.WASMEventInfo{EventType: wasmevents.SCAN, Payload: payload}
+		if _, err := HandleScan(event, scan); err == nil {
+			t.Errorf("Expected an error for payload %q", payload)
+		}
+	}
+}
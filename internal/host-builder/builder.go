@@ -9,6 +9,12 @@ import (
 	"github.com/tetratelabs/wazero/api"
 )
 
+// Version of the host interface, meaning the env functions and the memory protocol.
+// Modules can declare the version they were built against by exporting an immutable i32 global named ABIVersionExport
+const ABIVersion = 1
+
+const ABIVersionExport = "__abiVersion"
+
 func BuildHostModule(ctx context.Context, runtime wazero.Runtime, handlerMap *wasmevents.HandlerMap) (api.Module, error) {
 	hostModuleBuilder := runtime.NewHostModuleBuilder("env")
 
@@ -86,5 +92,127 @@ func BuildHostModule(ctx context.Context, runtime wazero.Runtime, handlerMap *wa
 		WithFunc(fetchHandler(handlerMap)).
 		Export(wasmevents.FETCH.String())
 
+	// SET_TIMEOUT
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(timerHandler(handlerMap, wasmevents.SET_TIMEOUT)).
+		Export(wasmevents.SET_TIMEOUT.String())
+
+	// SET_INTERVAL
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(timerHandler(handlerMap, wasmevents.SET_INTERVAL)).
+		Export(wasmevents.SET_INTERVAL.String())
+
+	// CLEAR_TIMER
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(clearTimerHandler(handlerMap)).
+		Export(wasmevents.CLEAR_TIMER.String())
+
+	// GET_STATE
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(getStateHandler(handlerMap)).
+		Export(wasmevents.GET_STATE.String())
+
+	// SET_STATE
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(setStateHandler(handlerMap)).
+		Export(wasmevents.SET_STATE.String())
+
+	// SET_EX
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(setExHandler(handlerMap)).
+		Export(wasmevents.SET_EX.String())
+
+	// EXPIRE
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(expireHandler(handlerMap)).
+		Export(wasmevents.EXPIRE.String())
+
+	// INCR_BY
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(incrByHandler(handlerMap)).
+		Export(wasmevents.INCR_BY.String())
+
+	// COMPARE_AND_SWAP
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(compareAndSwapHandler(handlerMap)).
+		Export(wasmevents.COMPARE_AND_SWAP.String())
+
+	// SET_IF_ABSENT
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(keyValueHandler(handlerMap, wasmevents.SET_IF_ABSENT)).
+		Export(wasmevents.SET_IF_ABSENT.String())
+
+	// LIST_PUSH
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(keyValueHandler(handlerMap, wasmevents.LIST_PUSH)).
+		Export(wasmevents.LIST_PUSH.String())
+
+	// LIST_POP
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(getHandler(handlerMap, wasmevents.LIST_POP)).
+		Export(wasmevents.LIST_POP.String())
+
+	// LIST_RANGE
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(listRangeHandler(handlerMap)).
+		Export(wasmevents.LIST_RANGE.String())
+
+	// HASH_GET
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(keyValueHandler(handlerMap, wasmevents.HASH_GET)).
+		Export(wasmevents.HASH_GET.String())
+
+	// HASH_SET
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(hashSetHandler(handlerMap)).
+		Export(wasmevents.HASH_SET.String())
+
+	// HASH_GET_ALL
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(hashGetAllHandler(handlerMap)).
+		Export(wasmevents.HASH_GET_ALL.String())
+
+	// DB_LIST
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(dbListHandler(handlerMap)).
+		Export(wasmevents.DB_LIST.String())
+
+	// SCAN
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(scanHandler(handlerMap, wasmevents.SCAN)).
+		Export(wasmevents.SCAN.String())
+
+	// DB_SCAN
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(scanHandler(handlerMap, wasmevents.DB_SCAN)).
+		Export(wasmevents.DB_SCAN.String())
+
+	// TX_BEGIN
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(transactionHandler(handlerMap, wasmevents.TX_BEGIN)).
+		Export(wasmevents.TX_BEGIN.String())
+
+	// TX_COMMIT
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(transactionHandler(handlerMap, wasmevents.TX_COMMIT)).
+		Export(wasmevents.TX_COMMIT.String())
+
+	// TX_ROLLBACK
+	hostModuleBuilder.NewFunctionBuilder().
+		WithFunc(transactionHandler(handlerMap, wasmevents.TX_ROLLBACK)).
+		Export(wasmevents.TX_ROLLBACK.String())
+
 	return hostModuleBuilder.Instantiate(ctx)
 }
+
+// Names of the host functions a module imports from env
+func ImportedHostFunctions(compiled wazero.CompiledModule) []string {
+	var names []string
+	for _, fn := range compiled.ImportedFunctions() {
+		moduleName, name, _ := fn.Import()
+		if moduleName == "env" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
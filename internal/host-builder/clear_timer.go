@@ -0,0 +1,35 @@
+package hostbuilder
+
+import (
+	"context"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func clearTimerHandler(handlerMap *wasmevents.HandlerMap) any {
+	return func(ctx context.Context, mod api.Module, idPtr uint32, idLen uint32) uint32 {
+		mem := mod.Memory()
+		if mem == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), MOD_MEMORY_ERR)
+		}
+
+		bytes, ok := mem.Read(idPtr, idLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		timerId := string(bytes)
+
+		event, err := getWASMEvent(ctx, wasmevents.CLEAR_TIMER, timerId)
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		_, err = handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), EXTERNAL_HANDLER_ERR)
+		}
+
+		return 0
+	}
+}
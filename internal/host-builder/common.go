@@ -30,10 +30,14 @@ func getWASMEvent(ctx context.Context, eventType wasmevents.WASMEventType, paylo
 		return nil, err
 	}
 
+	// not every execution has one, such as snapshot warmups
+	executionId, _ := ctx.Value("executionId").(string)
+
 	return &wasmevents.WASMEventInfo{
 		ConnectionId: connectionId,
 		InstanceId:   instanceId,
 		RoomId:       roomId,
+		ExecutionId:  executionId,
 		Timestamp:    time.Now().UnixMilli(),
 		EventType:    eventType,
 		Payload:      payload,
@@ -56,6 +60,7 @@ const (
 	GET_WASM_EVENT_ERR
 	CREATE_AS_STRING_ERR
 	EXTERNAL_HANDLER_ERR
+	INVALID_RESPONSE_ERR
 )
 
 var errorMessages = [...]error{
@@ -64,6 +69,7 @@ var errorMessages = [...]error{
 	fmt.Errorf("Failed to parse event information"),
 	fmt.Errorf("Failed to create string in WASM memory"),
 	fmt.Errorf("Failed external call"),
+	fmt.Errorf("Invalid response from external call"),
 }
 
 func writeErrorMessage(mod *asmscript.ModuleContext, err errorMessagesType) uint32 {
@@ -0,0 +1,54 @@
+package hostbuilder
+
+import (
+	"context"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func compareAndSwapHandler(handlerMap *wasmevents.HandlerMap) any {
+	return func(ctx context.Context, mod api.Module, keyPtr uint32, keyLen uint32, expectedPtr uint32, expectedLen uint32, nextPtr uint32, nextLen uint32) uint32 {
+		mem := mod.Memory()
+		if mem == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), MOD_MEMORY_ERR)
+		}
+
+		bytes, ok := mem.Read(keyPtr, keyLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		key := string(bytes)
+
+		bytes, ok = mem.Read(expectedPtr, expectedLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		expected := string(bytes)
+
+		bytes, ok = mem.Read(nextPtr, nextLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		next := string(bytes)
+
+		event, err := getWASMEvent(ctx, wasmevents.COMPARE_AND_SWAP, key, expected, next)
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		modCtx := getModuleContext(ctx, mod)
+		swapped, err := handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(modCtx, EXTERNAL_HANDLER_ERR)
+		}
+
+		ptr, _, err := asmscript.CreateASString(modCtx, swapped)
+		if err != nil {
+			return writeErrorMessage(modCtx, CREATE_AS_STRING_ERR)
+		}
+
+		return uint32(ptr)
+	}
+}
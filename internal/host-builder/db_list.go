@@ -0,0 +1,49 @@
+package hostbuilder
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func dbListHandler(handlerMap *wasmevents.HandlerMap) any {
+	return func(ctx context.Context, mod api.Module, prefixPtr uint32, prefixLen uint32) uint32 {
+		mem := mod.Memory()
+		if mem == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), MOD_MEMORY_ERR)
+		}
+
+		bytes, ok := mem.Read(prefixPtr, prefixLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		prefix := string(bytes)
+
+		event, err := getWASMEvent(ctx, wasmevents.DB_LIST, prefix)
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		modCtx := getModuleContext(ctx, mod)
+		resp, err := handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(modCtx, EXTERNAL_HANDLER_ERR)
+		}
+
+		// handlers return the keys as a JSON array
+		var keys []string
+		if err := json.Unmarshal([]byte(resp), &keys); err != nil {
+			return writeErrorMessage(modCtx, INVALID_RESPONSE_ERR)
+		}
+
+		ptr, _, err := asmscript.WriteArray(modCtx, keys)
+		if err != nil {
+			return writeErrorMessage(modCtx, CREATE_AS_STRING_ERR)
+		}
+
+		return uint32(ptr)
+	}
+}
@@ -0,0 +1,31 @@
+package hostbuilder
+
+import (
+	"context"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func getStateHandler(handlerMap *wasmevents.HandlerMap) any {
+	return func(ctx context.Context, mod api.Module) uint32 {
+		event, err := getWASMEvent(ctx, wasmevents.GET_STATE)
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		modCtx := getModuleContext(ctx, mod)
+		state, err := handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(modCtx, EXTERNAL_HANDLER_ERR)
+		}
+
+		ptr, _, err := asmscript.CreateASString(modCtx, state)
+		if err != nil {
+			return writeErrorMessage(modCtx, CREATE_AS_STRING_ERR)
+		}
+
+		return uint32(ptr)
+	}
+}
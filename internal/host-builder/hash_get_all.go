@@ -0,0 +1,57 @@
+package hostbuilder
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func hashGetAllHandler(handlerMap *wasmevents.HandlerMap) any {
+	return func(ctx context.Context, mod api.Module, keyPtr uint32, keyLen uint32) uint32 {
+		mem := mod.Memory()
+		if mem == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), MOD_MEMORY_ERR)
+		}
+
+		bytes, ok := mem.Read(keyPtr, keyLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		key := string(bytes)
+
+		event, err := getWASMEvent(ctx, wasmevents.HASH_GET_ALL, key)
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		modCtx := getModuleContext(ctx, mod)
+		resp, err := handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(modCtx, EXTERNAL_HANDLER_ERR)
+		}
+
+		// handlers return the hash as a JSON object, which is passed on as
+		// [field1, value1, field2, value2, ...] sorted by field
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(resp), &fields); err != nil {
+			return writeErrorMessage(modCtx, INVALID_RESPONSE_ERR)
+		}
+
+		pairs := make([]string, 0, len(fields)*2)
+		for _, field := range slices.Sorted(maps.Keys(fields)) {
+			pairs = append(pairs, field, fields[field])
+		}
+
+		ptr, _, err := asmscript.WriteArray(modCtx, pairs)
+		if err != nil {
+			return writeErrorMessage(modCtx, CREATE_AS_STRING_ERR)
+		}
+
+		return uint32(ptr)
+	}
+}
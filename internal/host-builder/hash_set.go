@@ -0,0 +1,47 @@
+package hostbuilder
+
+import (
+	"context"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func hashSetHandler(handlerMap *wasmevents.HandlerMap) any {
+	return func(ctx context.Context, mod api.Module, keyPtr uint32, keyLen uint32, fieldPtr uint32, fieldLen uint32, valPtr uint32, valLen uint32) uint32 {
+		mem := mod.Memory()
+		if mem == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), MOD_MEMORY_ERR)
+		}
+
+		bytes, ok := mem.Read(keyPtr, keyLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		key := string(bytes)
+
+		bytes, ok = mem.Read(fieldPtr, fieldLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		field := string(bytes)
+
+		bytes, ok = mem.Read(valPtr, valLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		val := string(bytes)
+
+		event, err := getWASMEvent(ctx, wasmevents.HASH_SET, key, field, val)
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		_, err = handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), EXTERNAL_HANDLER_ERR)
+		}
+
+		return 0
+	}
+}
@@ -0,0 +1,50 @@
+package hostbuilder
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func listRangeHandler(handlerMap *wasmevents.HandlerMap) any {
+	return func(ctx context.Context, mod api.Module, keyPtr uint32, keyLen uint32, start int32, stop int32) uint32 {
+		mem := mod.Memory()
+		if mem == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), MOD_MEMORY_ERR)
+		}
+
+		bytes, ok := mem.Read(keyPtr, keyLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		key := string(bytes)
+
+		event, err := getWASMEvent(ctx, wasmevents.LIST_RANGE, key, strconv.Itoa(int(start)), strconv.Itoa(int(stop)))
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		modCtx := getModuleContext(ctx, mod)
+		resp, err := handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(modCtx, EXTERNAL_HANDLER_ERR)
+		}
+
+		// handlers return the values as a JSON array
+		var values []string
+		if err := json.Unmarshal([]byte(resp), &values); err != nil {
+			return writeErrorMessage(modCtx, INVALID_RESPONSE_ERR)
+		}
+
+		ptr, _, err := asmscript.WriteArray(modCtx, values)
+		if err != nil {
+			return writeErrorMessage(modCtx, CREATE_AS_STRING_ERR)
+		}
+
+		return uint32(ptr)
+	}
+}
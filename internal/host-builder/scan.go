@@ -0,0 +1,56 @@
+package hostbuilder
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func scanHandler(handlerMap *wasmevents.HandlerMap, scanType wasmevents.WASMEventType) any {
+	return func(ctx context.Context, mod api.Module, prefixPtr uint32, prefixLen uint32, cursorPtr uint32, cursorLen uint32, limit uint32) uint32 {
+		mem := mod.Memory()
+		if mem == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), MOD_MEMORY_ERR)
+		}
+
+		bytes, ok := mem.Read(prefixPtr, prefixLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		prefix := string(bytes)
+
+		bytes, ok = mem.Read(cursorPtr, cursorLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		cursor := string(bytes)
+
+		event, err := getWASMEvent(ctx, scanType, prefix, cursor, strconv.FormatUint(uint64(limit), 10))
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		modCtx := getModuleContext(ctx, mod)
+		resp, err := handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(modCtx, EXTERNAL_HANDLER_ERR)
+		}
+
+		// handlers return [next cursor, key1, value1, ...] as a JSON array
+		var fields []string
+		if err := json.Unmarshal([]byte(resp), &fields); err != nil || len(fields)%2 != 1 {
+			return writeErrorMessage(modCtx, INVALID_RESPONSE_ERR)
+		}
+
+		ptr, _, err := asmscript.WriteArray(modCtx, fields)
+		if err != nil {
+			return writeErrorMessage(modCtx, CREATE_AS_STRING_ERR)
+		}
+
+		return uint32(ptr)
+	}
+}
@@ -0,0 +1,46 @@
+package hostbuilder
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+func timerHandler(handlerMap *wasmevents.HandlerMap, timerType wasmevents.WASMEventType) any {
+	return func(ctx context.Context, mod api.Module, delayMs uint32, payloadPtr uint32, payloadLen uint32) uint32 {
+		mem := mod.Memory()
+		if mem == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), MOD_MEMORY_ERR)
+		}
+
+		bytes, ok := mem.Read(payloadPtr, payloadLen)
+		if !ok {
+			return writeErrorMessage(getModuleContext(ctx, mod), MEM_READ_ERR)
+		}
+		payload := string(bytes)
+
+		event, err := getWASMEvent(ctx, timerType, strconv.FormatUint(uint64(delayMs), 10), payload)
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		modCtx := getModuleContext(ctx, mod)
+		timerId, err := handlerMap.CallHandler(event)
+		if err != nil {
+			return writeErrorMessage(modCtx, EXTERNAL_HANDLER_ERR)
+		}
+
+		ptr, _, err := asmscript.CreateASString(
+			modCtx,
+			timerId,
+		)
+		if err != nil {
+			return writeErrorMessage(modCtx, CREATE_AS_STRING_ERR)
+		}
+
+		return uint32(ptr)
+	}
+}
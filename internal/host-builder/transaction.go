@@ -0,0 +1,28 @@
+package hostbuilder
+
+import (
+	"context"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// TX_BEGIN / TX_COMMIT / TX_ROLLBACK, which are handled by the store.
+// The guest gets the handler's error, so it can tell why the transaction couldn't be opened or closed
+func transactionHandler(handlerMap *wasmevents.HandlerMap, txType wasmevents.WASMEventType) any {
+	return func(ctx context.Context, mod api.Module) uint32 {
+		event, err := getWASMEvent(ctx, txType)
+		if event == nil {
+			return writeErrorMessage(getModuleContext(ctx, mod), GET_WASM_EVENT_ERR)
+		}
+
+		_, err = handlerMap.CallHandler(event)
+		if err != nil {
+			ptr, _, _ := asmscript.CreateASError(getModuleContext(ctx, mod), err)
+			return uint32(ptr)
+		}
+
+		return 0
+	}
+}
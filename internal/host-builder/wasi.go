@@ -0,0 +1,103 @@
+package hostbuilder
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Lines longer than this are split into multiple events
+const maxWriterLine = 4096
+
+// Instantiate the wasi_snapshot_preview1 host module in the given runtime.
+//
+// All WASI state (args, clocks, stdout, ...) comes from the ModuleConfig of each guest,
+// so this only needs to happen once per runtime
+func BuildWASIModule(ctx context.Context, runtime wazero.Runtime) (api.Closer, error) {
+	return wasi_snapshot_preview1.Instantiate(ctx, runtime)
+}
+
+// Returns true if the compiled module imports any WASI function
+func ImportsWASI(compiled wazero.CompiledModule) bool {
+	for _, fn := range compiled.ImportedFunctions() {
+		moduleName, _, _ := fn.Import()
+		if moduleName == wasi_snapshot_preview1.ModuleName {
+			return true
+		}
+	}
+	return false
+}
+
+// Writer that forwards guest output (stdout / stderr) to a handler
+type HandlerWriter struct {
+	handlerMap *wasmevents.HandlerMap
+	eventType  wasmevents.WASMEventType
+	instanceId string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// Create a writer which calls the handler for eventType once per line written.
+//
+// Events are tagged with the instance ID, but not a connection or room, since
+// WASI output is not tied to a specific WS event.
+// Output that doesn't end with a newline is held until Flush is called
+func NewHandlerWriter(handlerMap *wasmevents.HandlerMap, eventType wasmevents.WASMEventType, instanceId string) *HandlerWriter {
+	return &HandlerWriter{
+		handlerMap: handlerMap,
+		eventType:  eventType,
+		instanceId: instanceId,
+	}
+}
+
+func (w *HandlerWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			if len(w.buf) < maxWriterLine {
+				break
+			}
+			i = maxWriterLine
+			w.emit(w.buf[:i])
+			w.buf = w.buf[i:]
+			continue
+		}
+
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+
+	// the guest should never see an error from logging
+	return len(p), nil
+}
+
+// Send the partial line held by the writer, if there is one
+func (w *HandlerWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *HandlerWriter) emit(line []byte) {
+	w.handlerMap.CallHandler(&wasmevents.WASMEventInfo{
+		InstanceId: w.instanceId,
+		Timestamp:  time.Now().UnixMilli(),
+		EventType:  w.eventType,
+		Payload:    []string{string(line)},
+	})
+}
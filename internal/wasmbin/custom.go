@@ -0,0 +1,51 @@
+package wasmbin
+
+// Content of the last section of a module if it's a custom section with the given name,
+// and the module bytes that come before it
+func TrailingCustomSection(wasm []byte, name string) (before []byte, content []byte, ok bool, err error) {
+	// readSections checks the header and that every section is complete
+	if _, err := readSections(wasm); err != nil {
+		return nil, nil, false, err
+	}
+
+	// find where the last section starts
+	r := &reader{buf: wasm, pos: len(magic)}
+	last := -1
+	for r.pos < len(r.buf) {
+		last = r.pos
+		r.pos++
+		if _, err := r.vec(); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	if last < 0 || wasm[last] != sectionCustom {
+		return wasm, nil, false, nil
+	}
+
+	r = &reader{buf: wasm, pos: last + 1}
+	section, err := r.vec()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	sr := &reader{buf: section}
+	sectionName, err := sr.vec()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if string(sectionName) != name {
+		return wasm, nil, false, nil
+	}
+
+	return wasm[:last], section[sr.pos:], true, nil
+}
+
+// Add a custom section to the end of a module
+func AppendCustomSection(wasm []byte, name string, content []byte) []byte {
+	section := appendName(nil, name)
+	section = append(section, content...)
+
+	out := append([]byte{}, wasm...)
+	out = append(out, sectionCustom)
+	out = appendU32(out, uint32(len(section)))
+	return append(out, section...)
+}
@@ -0,0 +1,138 @@
+package wasmbin
+
+import (
+	"fmt"
+)
+
+// Value of an exported immutable i32 global that is initialized with a constant, such as the ABI version that
+// modules declare. ok is false if the module has no such export
+func ExportedConstI32(wasm []byte, name string) (value int32, ok bool, err error) {
+	sections, err := readSections(wasm)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var importedGlobals uint32
+	constants := make(map[uint32]int32)
+	var exportIndex *uint32
+
+	for _, s := range sections {
+		switch s.id {
+		case sectionImport:
+			if importedGlobals, err = countImportedGlobals(s.content); err != nil {
+				return 0, false, err
+			}
+		case sectionGlobal:
+			if constants, err = findConstI32Globals(s.content, importedGlobals); err != nil {
+				return 0, false, err
+			}
+		case sectionExport:
+			index, found, err := findExport(s.content, name, kindGlobal)
+			if err != nil {
+				return 0, false, err
+			}
+			if found {
+				exportIndex = &index
+			}
+		}
+	}
+
+	if exportIndex == nil {
+		return 0, false, nil
+	}
+	value, ok = constants[*exportIndex]
+	return value, ok, nil
+}
+
+// Immutable i32 globals initialized with a single i32.const, by index
+func findConstI32Globals(content []byte, firstIndex uint32) (map[uint32]int32, error) {
+	r := &reader{buf: content}
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	constants := make(map[uint32]int32)
+	for i := range count {
+		valType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		// peek at the init expression, anything more than i32.const is skipped
+		start := r.pos
+		if valType == 0x7f && mut == 0 && r.pos < len(r.buf) && r.buf[r.pos] == 0x41 {
+			r.pos++
+			value, err := r.slebValue(32)
+			if err != nil {
+				return nil, err
+			}
+			if end, err := r.byte(); err == nil && end == 0x0b {
+				constants[firstIndex+i] = int32(value)
+				continue
+			}
+			r.pos = start
+		}
+		if err := r.constExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	return constants, nil
+}
+
+// Index of the export with the given name and kind
+func findExport(content []byte, name string, kind byte) (uint32, bool, error) {
+	r := &reader{buf: content}
+	count, err := r.u32()
+	if err != nil {
+		return 0, false, err
+	}
+
+	for range count {
+		exportName, err := r.vec()
+		if err != nil {
+			return 0, false, err
+		}
+		exportKind, err := r.byte()
+		if err != nil {
+			return 0, false, err
+		}
+		index, err := r.u32()
+		if err != nil {
+			return 0, false, err
+		}
+		if string(exportName) == name && exportKind == kind {
+			return index, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// Signed LEB128
+func (r *reader) slebValue(maxBits int) (int64, error) {
+	var result int64
+	shift := 0
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			return result, nil
+		}
+		if shift >= maxBits+7 {
+			return 0, fmt.Errorf("LEB128 value is too long at offset %d", r.pos)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package wasmbin
+
+import "fmt"
+
+// Lower the maximum size of the memories a module defines to at most pages, so memory.grow fails past it.
+//
+// The runtime's limit applies to every module, this is used to give a single module a smaller one.
+// Modules that need more than pages to start with are refused
+func LimitMemory(wasm []byte, pages uint32) ([]byte, error) {
+	sections, err := readSections(wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range sections {
+		if s.id != sectionMemory {
+			continue
+		}
+
+		r := &reader{buf: s.content}
+		count, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+
+		content := appendU32(nil, count)
+		for range count {
+			flags, err := r.byte()
+			if err != nil {
+				return nil, err
+			}
+			// shared and 64-bit memories aren't supported by the runtime anyway
+			if flags > 0x01 {
+				return nil, fmt.Errorf("Unsupported memory limits flags %#x", flags)
+			}
+
+			minPages, err := r.u32()
+			if err != nil {
+				return nil, err
+			}
+			maxPages := pages
+			if flags == 0x01 {
+				if maxPages, err = r.u32(); err != nil {
+					return nil, err
+				}
+				maxPages = min(maxPages, pages)
+			}
+			if minPages > pages {
+				return nil, fmt.Errorf("Module needs %d pages of memory, more than its limit of %d", minPages, pages)
+			}
+
+			content = append(content, 0x01)
+			content = appendU32(content, minPages)
+			content = appendU32(content, maxPages)
+		}
+
+		sections[i].content = content
+	}
+
+	return writeSections(sections), nil
+}
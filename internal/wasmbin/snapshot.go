@@ -0,0 +1,215 @@
+package wasmbin
+
+import "fmt"
+
+// Prefix of the exports added for mutable globals, followed by the global's index
+const GlobalExportPrefix = "__snapshot_global_"
+
+// Name the start function is exported under once the start section is removed
+const StartExport = "__snapshot_start"
+
+// A module rewritten by PrepareSnapshot
+type Prepared struct {
+	Wasm []byte
+
+	// Export names of every mutable global that the module defines
+	Globals []string
+
+	// Whether the module had a start section, which is now exported as StartExport
+	HasStart bool
+}
+
+// Rewrite a module so that its state can be captured and restored from the host.
+//
+// wazero can only read and write globals that are exported, so every mutable global
+// defined by the module gets an extra export. The start section is also turned into the
+// StartExport export, since a start section would run again on every instantiation and
+// overwrite the restored state. Function and global indexes are unchanged
+func PrepareSnapshot(wasm []byte) (*Prepared, error) {
+	sections, err := readSections(wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	importedGlobals := uint32(0)
+	var mutableGlobals []uint32
+	startFunc := int64(-1)
+	exportIndex := -1
+
+	for i, s := range sections {
+		switch s.id {
+		case sectionImport:
+			importedGlobals, err = countImportedGlobals(s.content)
+		case sectionGlobal:
+			mutableGlobals, err = findMutableGlobals(s.content, importedGlobals)
+		case sectionStart:
+			var idx uint32
+			idx, err = (&reader{buf: s.content}).u32()
+			startFunc = int64(idx)
+		case sectionExport:
+			exportIndex = i
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// build the export section, keeping the module's own exports
+	var existing []byte
+	count := uint32(0)
+	names := make(map[string]bool)
+	if exportIndex >= 0 {
+		r := &reader{buf: sections[exportIndex].content}
+		if count, err = r.u32(); err != nil {
+			return nil, err
+		}
+		start := r.pos
+		for range count {
+			name, err := r.vec()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := r.byte(); err != nil {
+				return nil, err
+			}
+			if _, err := r.u32(); err != nil {
+				return nil, err
+			}
+			names[string(name)] = true
+		}
+		existing = r.buf[start:r.pos]
+	}
+
+	prepared := &Prepared{HasStart: startFunc >= 0}
+	var added []byte
+	for _, idx := range mutableGlobals {
+		name := fmt.Sprintf("%s%d", GlobalExportPrefix, idx)
+		if names[name] {
+			return nil, fmt.Errorf("Module already exports %s", name)
+		}
+		added = appendName(added, name)
+		added = append(added, kindGlobal)
+		added = appendU32(added, idx)
+		prepared.Globals = append(prepared.Globals, name)
+		count++
+	}
+	if prepared.HasStart {
+		if names[StartExport] {
+			return nil, fmt.Errorf("Module already exports %s", StartExport)
+		}
+		added = appendName(added, StartExport)
+		added = append(added, kindFunc)
+		added = appendU32(added, uint32(startFunc))
+		count++
+	}
+
+	exports := section{id: sectionExport}
+	exports.content = appendU32(nil, count)
+	exports.content = append(exports.content, existing...)
+	exports.content = append(exports.content, added...)
+
+	// put the export section back in the right place, and drop the start section
+	out := make([]section, 0, len(sections)+1)
+	written := false
+	for _, s := range sections {
+		if !written && (s.id == sectionExport || afterExports(s.id)) {
+			out = append(out, exports)
+			written = true
+		}
+		if s.id == sectionExport || s.id == sectionStart {
+			continue
+		}
+		out = append(out, s)
+	}
+	if !written {
+		out = append(out, exports)
+	}
+
+	prepared.Wasm = writeSections(out)
+	return prepared, nil
+}
+
+func countImportedGlobals(content []byte) (uint32, error) {
+	r := &reader{buf: content}
+	count, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+
+	globals := uint32(0)
+	for range count {
+		// module and field names
+		if _, err := r.vec(); err != nil {
+			return 0, err
+		}
+		if _, err := r.vec(); err != nil {
+			return 0, err
+		}
+
+		kind, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		switch kind {
+		case kindFunc:
+			_, err = r.u32()
+		case kindTable:
+			if _, err = r.byte(); err == nil {
+				err = r.limits()
+			}
+		case kindMemory:
+			err = r.limits()
+		case kindGlobal:
+			_, err = r.bytes(2) // type and mutability
+			globals++
+		case kindTag:
+			if _, err = r.byte(); err == nil {
+				_, err = r.u32()
+			}
+		default:
+			return 0, fmt.Errorf("Unknown import kind %#x", kind)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return globals, nil
+}
+
+// Indexes of the mutable globals in the global section
+func findMutableGlobals(content []byte, firstIndex uint32) ([]uint32, error) {
+	r := &reader{buf: content}
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	var mutable []uint32
+	for i := range count {
+		valType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.constExpr(); err != nil {
+			return nil, err
+		}
+		if mut == 0 {
+			continue
+		}
+
+		// references point into one instance, and wazero can't read v128 globals as a single value
+		switch valType {
+		case 0x7f, 0x7e, 0x7d, 0x7c: // i32, i64, f32, f64
+		default:
+			return nil, fmt.Errorf("Mutable global %d has type %#x, which can't be snapshotted", firstIndex+i, valType)
+		}
+		mutable = append(mutable, firstIndex+i)
+	}
+
+	return mutable, nil
+}
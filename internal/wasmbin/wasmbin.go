@@ -0,0 +1,216 @@
+// Minimal reader / writer for the WASM binary format.
+//
+// Only the sections needed to rewrite a module's exports are decoded, everything else is copied as is
+package wasmbin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var magic = []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+
+var errTruncated = errors.New("WASM binary is truncated")
+
+const (
+	sectionCustom  = 0
+	sectionImport  = 2
+	sectionMemory  = 5
+	sectionGlobal  = 6
+	sectionExport  = 7
+	sectionStart   = 8
+	sectionElement = 9
+	sectionCode    = 10
+	sectionData    = 11
+	sectionDataCnt = 12
+)
+
+const (
+	kindFunc   = 0x00
+	kindTable  = 0x01
+	kindMemory = 0x02
+	kindGlobal = 0x03
+	kindTag    = 0x04
+)
+
+type section struct {
+	id      byte
+	content []byte
+}
+
+// Split a binary into its sections
+func readSections(wasm []byte) ([]section, error) {
+	if !bytes.HasPrefix(wasm, magic) {
+		return nil, fmt.Errorf("Not a WASM binary")
+	}
+
+	r := &reader{buf: wasm, pos: len(magic)}
+	var sections []section
+	for r.pos < len(r.buf) {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.vec()
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, section{id: id, content: content})
+	}
+
+	return sections, nil
+}
+
+func writeSections(sections []section) []byte {
+	out := append([]byte{}, magic...)
+	for _, s := range sections {
+		out = append(out, s.id)
+		out = appendU32(out, uint32(len(s.content)))
+		out = append(out, s.content...)
+	}
+	return out
+}
+
+// Sections that have to come after the export section
+func afterExports(id byte) bool {
+	switch id {
+	case sectionStart, sectionElement, sectionDataCnt, sectionCode, sectionData:
+		return true
+	}
+	return false
+}
+
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errTruncated
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || len(r.buf)-r.pos < n {
+		return nil, errTruncated
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// Unsigned LEB128
+func (r *reader) uleb(maxBits int) (uint64, error) {
+	var result uint64
+	for shift := 0; shift < maxBits+7; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+	}
+	return 0, fmt.Errorf("LEB128 value is too long at offset %d", r.pos)
+}
+
+// Signed LEB128, only the length matters here
+func (r *reader) sleb(maxBits int) error {
+	_, err := r.uleb(maxBits)
+	return err
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+// A byte vector, prefixed with its length
+func (r *reader) vec() ([]byte, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	return r.bytes(int(n))
+}
+
+func (r *reader) limits() error {
+	flags, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if _, err := r.uleb(64); err != nil {
+		return err
+	}
+	if flags&0x01 != 0 {
+		if _, err := r.uleb(64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Skip a constant expression, up to and including its end opcode
+func (r *reader) constExpr() error {
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case 0x0b: // end
+			return nil
+		case 0x41: // i32.const
+			err = r.sleb(32)
+		case 0x42: // i64.const
+			err = r.sleb(64)
+		case 0x43: // f32.const
+			_, err = r.bytes(4)
+		case 0x44: // f64.const
+			_, err = r.bytes(8)
+		case 0x23, 0xd2: // global.get, ref.func
+			_, err = r.u32()
+		case 0xd0: // ref.null
+			_, err = r.byte()
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e: // extended const arithmetic
+		case 0xfd: // v128.const
+			var sub uint32
+			if sub, err = r.u32(); err == nil {
+				if sub != 0x0c {
+					return fmt.Errorf("Unsupported SIMD opcode %#x in constant expression", sub)
+				}
+				_, err = r.bytes(16)
+			}
+		default:
+			return fmt.Errorf("Unsupported opcode %#x in constant expression", op)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func appendU32(buf []byte, v uint32) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			buf = append(buf, b|0x80)
+		} else {
+			return append(buf, b)
+		}
+	}
+}
+
+func appendName(buf []byte, name string) []byte {
+	buf = appendU32(buf, uint32(len(name)))
+	return append(buf, name...)
+}
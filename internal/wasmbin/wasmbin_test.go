@@ -0,0 +1,290 @@
+package wasmbin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmtest"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Imports a global so the module's own globals don't start at index 0. The start function
+// changes memory and the mutable globals, and "sum" adds everything up
+const snapshotWAT = `(module
+	(import "env" "base" (global $base i32))
+	(memory (export "memory") 1)
+	(global $a (mut i32) (i32.const 1))
+	(global $fixed i32 (i32.const 100))
+	(global $b (mut i64) (i64.const 2))
+	(data (i32.const 16) "\05")
+
+	(func $init
+		(global.set $a (i32.const 10))
+		(global.set $b (i64.const 20))
+		(i32.store8 (i32.const 16) (i32.const 30)))
+	(start $init)
+
+	(func (export "sum") (result i32)
+		(i32.add (global.get $base) (global.get $a))
+		(i32.wrap_i64 (global.get $b))
+		i32.add
+		(i32.load8_u (i32.const 16))
+		i32.add
+		global.get $fixed
+		i32.add))`
+
+// Runtime with an "env" module exporting the global that snapshotWAT imports
+func testRuntime(t *testing.T) wazero.Runtime {
+	t.Helper()
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { runtime.Close(ctx) })
+
+	env := wasmtest.Must(t, `(module (global (export "base") i32 (i32.const 1000)))`)
+	if _, err := runtime.InstantiateWithConfig(ctx, env, wazero.NewModuleConfig().WithName("env")); err != nil {
+		t.Fatalf("Failed to instantiate env: %v", err)
+	}
+	return runtime
+}
+
+func instantiate(t *testing.T, runtime wazero.Runtime, wasm []byte, config wazero.ModuleConfig) api.Module {
+	t.Helper()
+	mod, err := runtime.InstantiateWithConfig(context.Background(), wasm, config.WithName(""))
+	if err != nil {
+		t.Fatalf("Failed to instantiate: %v", err)
+	}
+	return mod
+}
+
+func call(t *testing.T, mod api.Module, name string) uint64 {
+	t.Helper()
+	results, err := mod.ExportedFunction(name).Call(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to call %s: %v", name, err)
+	}
+	if len(results) == 0 {
+		return 0
+	}
+	return results[0]
+}
+
+func TestPrepareSnapshotRoundTrip(t *testing.T) {
+	wasm := wasmtest.Must(t, snapshotWAT)
+	runtime := testRuntime(t)
+
+	original := instantiate(t, runtime, wasm, wazero.NewModuleConfig())
+	expected := call(t, original, "sum")
+	if expected != 1000+10+20+30+100 {
+		t.Fatalf("Unexpected sum %d from the original module", expected)
+	}
+
+	prepared, err := PrepareSnapshot(wasm)
+	if err != nil {
+		t.Fatalf("Failed to prepare module: %v", err)
+	}
+	if !prepared.HasStart {
+		t.Errorf("Expected the start section to be found")
+	}
+	// the imported global is index 0, the immutable one isn't exported
+	if len(prepared.Globals) != 2 || prepared.Globals[0] != GlobalExportPrefix+"1" || prepared.Globals[1] != GlobalExportPrefix+"3" {
+		t.Errorf("Expected the two mutable globals to be exported, got %v", prepared.Globals)
+	}
+
+	// the start section is gone, so nothing runs until the start export is called
+	mod := instantiate(t, runtime, prepared.Wasm, wazero.NewModuleConfig())
+	if sum := call(t, mod, "sum"); sum != 1000+1+2+5+100 {
+		t.Errorf("Expected the start function not to run on instantiation, got sum %d", sum)
+	}
+	call(t, mod, StartExport)
+	if sum := call(t, mod, "sum"); sum != expected {
+		t.Errorf("Expected sum %d after the start export, got %d", expected, sum)
+	}
+
+	if a := mod.ExportedGlobal(prepared.Globals[0]).Get(); a != 10 {
+		t.Errorf("Expected the exported global $a to be 10, got %d", a)
+	}
+	if b := mod.ExportedGlobal(prepared.Globals[1]).Get(); b != 20 {
+		t.Errorf("Expected the exported global $b to be 20, got %d", b)
+	}
+	if _, ok := mod.ExportedGlobal(prepared.Globals[0]).(api.MutableGlobal); !ok {
+		t.Errorf("Expected the exported global to be mutable")
+	}
+}
+
+func TestPrepareSnapshotWithoutExports(t *testing.T) {
+	// no export section at all, and a section that comes after exports
+	wasm := wasmtest.Must(t, `(module
+		(memory 1)
+		(global $g (mut i32) (i32.const 7))
+		(data (i32.const 0) "x"))`)
+
+	prepared, err := PrepareSnapshot(wasm)
+	if err != nil {
+		t.Fatalf("Failed to prepare module: %v", err)
+	}
+	if prepared.HasStart || len(prepared.Globals) != 1 {
+		t.Errorf("Unexpected result %+v", prepared)
+	}
+
+	mod := instantiate(t, testRuntime(t), prepared.Wasm, wazero.NewModuleConfig())
+	if g := mod.ExportedGlobal(prepared.Globals[0]).Get(); g != 7 {
+		t.Errorf("Expected the global to be 7, got %d", g)
+	}
+}
+
+func TestPrepareSnapshotRejectsTakenNames(t *testing.T) {
+	for _, wat := range []string{
+		`(module (global (export "__snapshot_global_0") (mut i32) (i32.const 0)))`,
+		`(module (func $f) (start $f) (export "__snapshot_start" (func $f)))`,
+	} {
+		if _, err := PrepareSnapshot(wasmtest.Must(t, wat)); err == nil {
+			t.Errorf("Expected an error for %s", wat)
+		}
+	}
+}
+
+func TestLimitMemory(t *testing.T) {
+	grow := `
+		(func (export "grow") (param $pages i32) (result i32)
+			(memory.grow (local.get $pages)))`
+
+	for _, tc := range []struct {
+		memory string
+		pages  uint32
+
+		// Pages grow can add, -1 if the module is refused
+		growable int
+	}{
+		{"(memory 1)", 3, 2},
+		{"(memory 1 8)", 3, 2},
+		{"(memory 1 2)", 3, 1},
+		{"(memory 2)", 2, 0},
+		{"(memory 4)", 3, -1},
+	} {
+		wasm := wasmtest.Must(t, "(module "+tc.memory+grow+")")
+		limited, err := LimitMemory(wasm, tc.pages)
+		if tc.growable < 0 {
+			if err == nil {
+				t.Errorf("%s: expected a module needing more than %d pages to be refused", tc.memory, tc.pages)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to limit memory: %v", tc.memory, err)
+		}
+
+		mod := instantiate(t, testRuntime(t), limited, wazero.NewModuleConfig())
+		results, err := mod.ExportedFunction("grow").Call(context.Background(), uint64(tc.growable+1))
+		if err != nil {
+			t.Fatalf("%s: grow failed: %v", tc.memory, err)
+		}
+		if int32(results[0]) != -1 {
+			t.Errorf("%s: expected growing past %d pages to fail", tc.memory, tc.pages)
+		}
+		if tc.growable > 0 {
+			results, _ = mod.ExportedFunction("grow").Call(context.Background(), uint64(tc.growable))
+			if int32(results[0]) == -1 {
+				t.Errorf("%s: expected growing to %d pages to work", tc.memory, tc.pages)
+			}
+		}
+	}
+}
+
+func TestExportedConstI32(t *testing.T) {
+	wasm := wasmtest.Must(t, `(module
+		(import "env" "base" (global i32))
+		(global (export "version") i32 (i32.const 3))
+		(global (export "mutable") (mut i32) (i32.const 4)))`)
+
+	if value, ok, err := ExportedConstI32(wasm, "version"); err != nil || !ok || value != 3 {
+		t.Errorf("Expected version 3, got %d, %v, %v", value, ok, err)
+	}
+	for _, name := range []string{"mutable", "missing"} {
+		if _, ok, err := ExportedConstI32(wasm, name); err != nil || ok {
+			t.Errorf("Expected %s not to be found, got %v, %v", name, ok, err)
+		}
+	}
+}
+
+func TestCustomSections(t *testing.T) {
+	wasm := wasmtest.Must(t, `(module (memory 1))`)
+	signed := AppendCustomSection(wasm, "sig", []byte("content"))
+
+	if _, err := wazero.NewRuntime(context.Background()).CompileModule(context.Background(), signed); err != nil {
+		t.Fatalf("Module with the custom section doesn't compile: %v", err)
+	}
+
+	before, content, ok, err := TrailingCustomSection(signed, "sig")
+	if err != nil || !ok || string(content) != "content" || !bytes.Equal(before, wasm) {
+		t.Errorf("Expected to find the section after the original module, got %q, %v, %v", content, ok, err)
+	}
+	if _, _, ok, err := TrailingCustomSection(signed, "other"); err != nil || ok {
+		t.Errorf("Expected no section with another name, got %v, %v", ok, err)
+	}
+
+	// only the last section counts
+	twice := AppendCustomSection(signed, "other", nil)
+	if _, _, ok, err := TrailingCustomSection(twice, "sig"); err != nil || ok {
+		t.Errorf("Expected a custom section that isn't last to be ignored, got %v, %v", ok, err)
+	}
+}
+
+func TestMalformedModules(t *testing.T) {
+	wasm := wasmtest.Must(t, snapshotWAT)
+
+	malformed := [][]byte{
+		nil,
+		[]byte("\x00asm"),
+		[]byte("\x00asm\x02\x00\x00\x00"),
+		// section longer than the module
+		append(bytes.Clone(wasm[:8]), 0x01, 0x10, 0x00),
+		// section length that overflows
+		append(bytes.Clone(wasm[:8]), 0x01, 0xff, 0xff, 0xff, 0xff, 0x7f),
+		wasm[:len(wasm)-1],
+	}
+	for i, bad := range malformed {
+		if _, err := PrepareSnapshot(bad); err == nil {
+			t.Errorf("%d: expected PrepareSnapshot to fail", i)
+		}
+		if _, err := LimitMemory(bad, 1); err == nil {
+			t.Errorf("%d: expected LimitMemory to fail", i)
+		}
+		if _, _, err := ExportedConstI32(bad, "x"); err == nil {
+			t.Errorf("%d: expected ExportedConstI32 to fail", i)
+		}
+		if _, _, _, err := TrailingCustomSection(bad, "x"); err == nil {
+			t.Errorf("%d: expected TrailingCustomSection to fail", i)
+		}
+	}
+}
+
+// Nothing may panic on bad input, and whatever is rewritten can be read again
+// (compiling arbitrary input is left out, the runtime can run out of memory on it)
+func FuzzRewrite(f *testing.F) {
+	f.Add(wasmtest.Must(f, snapshotWAT))
+	f.Add(wasmtest.Must(f, `(module (memory 1 4) (global (export "v") i32 (i32.const 1)))`))
+
+	f.Fuzz(func(t *testing.T, wasm []byte) {
+		ExportedConstI32(wasm, "v")
+
+		if prepared, err := PrepareSnapshot(wasm); err == nil {
+			if _, err := readSections(prepared.Wasm); err != nil {
+				t.Errorf("Prepared module can't be read: %v", err)
+			}
+		}
+		if limited, err := LimitMemory(wasm, 1); err == nil {
+			if _, err := readSections(limited); err != nil {
+				t.Errorf("Limited module can't be read: %v", err)
+			}
+		}
+
+		if _, _, _, err := TrailingCustomSection(wasm, "sig"); err == nil {
+			before, content, ok, err := TrailingCustomSection(AppendCustomSection(wasm, "sig", wasm), "sig")
+			if err != nil || !ok || !bytes.Equal(before, wasm) || !bytes.Equal(content, wasm) {
+				t.Errorf("Appended section didn't round trip: %v, %v", ok, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,1028 @@
+// Assembles small modules from the WebAssembly text format, so tests can describe their guests in WAT
+// instead of hand-encoding binaries or depending on an AssemblyScript build.
+//
+// Only the subset of WAT that the tests need is supported:
+//   - module fields: func, import (of functions and globals), memory, global, export, data and start
+//   - inline (export "name") on func, memory and global, and (import "module" "name") on func
+//   - instructions in flat or folded form, except folded block, loop and if
+//   - identifiers ($name) for functions, globals, params and locals, but not for labels or types
+package wasmtest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Assemble a module, failing the test if the WAT is invalid
+func Must(tb testing.TB, wat string) []byte {
+	tb.Helper()
+	wasm, err := Assemble(wat)
+	if err != nil {
+		tb.Fatalf("Failed to assemble module: %v", err)
+	}
+	return wasm
+}
+
+// Assemble a module written in WAT to its binary encoding
+func Assemble(wat string) ([]byte, error) {
+	tokens, err := tokenize(wat)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.node()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(tokens) {
+		return nil, fmt.Errorf("Unexpected %q after the module", tokens[p.pos].text)
+	}
+	if !root.isList || root.head() != "module" {
+		return nil, fmt.Errorf("Expected (module ...)")
+	}
+
+	a := &assembler{
+		funcNames:   make(map[string]uint32),
+		globalNames: make(map[string]uint32),
+	}
+	if err := a.module(root.list[1:]); err != nil {
+		return nil, err
+	}
+	return a.encode()
+}
+
+type token struct {
+	text  string
+	str   []byte
+	isStr bool
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], ";;"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+			i += end
+		case strings.HasPrefix(src[i:], "(;"):
+			end := strings.Index(src[i:], ";)")
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated block comment")
+			}
+			i += end + 2
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case c == '"':
+			str, n, err := unquote(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{text: src[i : i+n], str: str, isStr: true})
+			i += n
+		default:
+			start := i
+			for i < len(src) && !strings.ContainsRune(" \t\n\r()\"", rune(src[i])) && !strings.HasPrefix(src[i:], ";;") {
+				i++
+			}
+			tokens = append(tokens, token{text: src[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+// Decode a string starting at src[0], returns its bytes and the length of the literal
+func unquote(src string) ([]byte, int, error) {
+	var out []byte
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"':
+			return out, i + 1, nil
+		case '\\':
+			if i+1 >= len(src) {
+				return nil, 0, fmt.Errorf("Unterminated string")
+			}
+			i++
+			switch e := src[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 't':
+				out = append(out, '\t')
+			case '\\', '"', '\'':
+				out = append(out, e)
+			default:
+				if i+1 >= len(src) {
+					return nil, 0, fmt.Errorf("Unterminated string")
+				}
+				b, err := strconv.ParseUint(src[i:i+2], 16, 8)
+				if err != nil {
+					return nil, 0, fmt.Errorf("Invalid escape \\%s", src[i:i+2])
+				}
+				out = append(out, byte(b))
+				i++
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return nil, 0, fmt.Errorf("Unterminated string")
+}
+
+// An atom, a string or a list
+type node struct {
+	atom   string
+	str    []byte
+	isStr  bool
+	isList bool
+	list   []node
+}
+
+func (n node) head() string {
+	if !n.isList || len(n.list) == 0 || n.list[0].isList || n.list[0].isStr {
+		return ""
+	}
+	return n.list[0].atom
+}
+
+func (n node) String() string {
+	switch {
+	case n.isStr:
+		return strconv.Quote(string(n.str))
+	case n.isList:
+		return "(" + n.head() + " ...)"
+	}
+	return n.atom
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) node() (node, error) {
+	if p.pos >= len(p.tokens) {
+		return node{}, fmt.Errorf("Unexpected end of input")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+
+	switch {
+	case t.isStr:
+		return node{str: t.str, isStr: true}, nil
+	case t.text == ")":
+		return node{}, fmt.Errorf("Unexpected )")
+	case t.text != "(":
+		return node{atom: t.text}, nil
+	}
+
+	n := node{isList: true}
+	for {
+		if p.pos >= len(p.tokens) {
+			return node{}, fmt.Errorf("Missing )")
+		}
+		if p.tokens[p.pos].text == ")" && !p.tokens[p.pos].isStr {
+			p.pos++
+			return n, nil
+		}
+		child, err := p.node()
+		if err != nil {
+			return node{}, err
+		}
+		n.list = append(n.list, child)
+	}
+}
+
+const (
+	sectionType     = 1
+	sectionImport   = 2
+	sectionFunction = 3
+	sectionMemory   = 5
+	sectionGlobal   = 6
+	sectionExport   = 7
+	sectionStart    = 8
+	sectionCode     = 10
+	sectionData     = 11
+
+	kindFunc   = 0x00
+	kindMemory = 0x02
+	kindGlobal = 0x03
+)
+
+var valueTypes = map[string]byte{"i32": 0x7f, "i64": 0x7e, "f32": 0x7d, "f64": 0x7c}
+
+type signature struct {
+	params  []byte
+	results []byte
+}
+
+func (s signature) key() string {
+	return string(s.params) + "|" + string(s.results)
+}
+
+type function struct {
+	typeIndex uint32
+	signature signature
+	locals    []byte
+	names     map[string]uint32
+	body      []node
+}
+
+type export struct {
+	name  string
+	kind  byte
+	index uint32
+}
+
+type assembler struct {
+	types   []signature
+	imports []byte
+	funcs   []*function
+
+	// Imported functions come first in the function index space, and imported globals in the global one
+	importCount     uint32
+	funcImportCount uint32
+	funcNames       map[string]uint32
+
+	memories [][]byte
+
+	globals     []byte
+	globalCount uint32
+
+	// Imported globals come first in the global index space, but aren't in the global section
+	globalImportCount uint32
+	globalNames       map[string]uint32
+
+	exports []export
+
+	// Exports that reference functions or globals by name, resolved once every field has been read
+	pendingExports []node
+
+	start *node
+	data  [][]byte
+}
+
+func (a *assembler) module(fields []node) error {
+	// imports have to be numbered before the functions, whatever order they are written in
+	for _, field := range fields {
+		var err error
+		switch {
+		case field.head() == "import":
+			err = a.importField(field)
+		case isInlineImport(field):
+			err = a.inlineImport(field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, field := range fields {
+		var err error
+		switch field.head() {
+		case "import":
+		case "func":
+			if !isInlineImport(field) {
+				err = a.funcField(field)
+			}
+		case "memory":
+			err = a.memoryField(field)
+		case "global":
+			err = a.globalField(field)
+		case "export":
+			a.pendingExports = append(a.pendingExports, field)
+		case "data":
+			err = a.dataField(field)
+		case "start":
+			a.start = &field
+		default:
+			err = fmt.Errorf("Unsupported module field %s", field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, field := range a.pendingExports {
+		if err := a.exportField(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *assembler) typeIndex(sig signature) uint32 {
+	for i, t := range a.types {
+		if t.key() == sig.key() {
+			return uint32(i)
+		}
+	}
+	a.types = append(a.types, sig)
+	return uint32(len(a.types) - 1)
+}
+
+// Read an optional $name at the start of a field's items
+func takeName(items []node) (string, []node) {
+	if len(items) > 0 && !items[0].isList && !items[0].isStr && strings.HasPrefix(items[0].atom, "$") {
+		return items[0].atom, items[1:]
+	}
+	return "", items
+}
+
+// Read the params, results and locals of a function, returns the items after them
+func (a *assembler) signature(items []node, f *function) ([]node, error) {
+	for len(items) > 0 && items[0].isList {
+		item := items[0]
+		kind := item.head()
+		if kind != "param" && kind != "result" && kind != "local" {
+			break
+		}
+		items = items[1:]
+
+		name, types := takeName(item.list[1:])
+		if name != "" && (kind == "result" || len(types) != 1) {
+			return nil, fmt.Errorf("Invalid %s", item)
+		}
+		for _, t := range types {
+			valueType, ok := valueTypes[t.atom]
+			if !ok || t.isList || t.isStr {
+				return nil, fmt.Errorf("Unknown value type %s", t)
+			}
+			index := uint32(len(f.signature.params) + len(f.locals))
+			switch kind {
+			case "param":
+				if len(f.signature.results) > 0 || len(f.locals) > 0 {
+					return nil, fmt.Errorf("Params have to come first")
+				}
+				f.signature.params = append(f.signature.params, valueType)
+			case "result":
+				if len(f.locals) > 0 {
+					return nil, fmt.Errorf("Results have to come before locals")
+				}
+				f.signature.results = append(f.signature.results, valueType)
+			case "local":
+				f.locals = append(f.locals, valueType)
+			}
+			if name != "" {
+				f.names[name] = index
+			}
+		}
+	}
+	return items, nil
+}
+
+// (import "module" "name" (func $name (param ...) (result ...)))
+func (a *assembler) importField(field node) error {
+	items := field.list[1:]
+	if len(items) == 3 && items[0].isStr && items[1].isStr && items[2].head() == "global" {
+		return a.importGlobal(items)
+	}
+	if len(items) != 3 || !items[0].isStr || !items[1].isStr || items[2].head() != "func" {
+		return fmt.Errorf("Only function and global imports are supported: %s", field)
+	}
+
+	f := &function{names: make(map[string]uint32)}
+	name, rest := takeName(items[2].list[1:])
+	rest, err := a.signature(rest, f)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("Unexpected %s in import", rest[0])
+	}
+
+	if name != "" {
+		a.funcNames[name] = a.funcImportCount
+	}
+	a.imports = appendName(a.imports, string(items[0].str))
+	a.imports = appendName(a.imports, string(items[1].str))
+	a.imports = append(a.imports, kindFunc)
+	a.imports = binary.AppendUvarint(a.imports, uint64(a.typeIndex(f.signature)))
+	a.importCount++
+	a.funcImportCount++
+	return nil
+}
+
+// (import "module" "name" (global $name i32)), or (mut i32)
+func (a *assembler) importGlobal(items []node) error {
+	name, rest := takeName(items[2].list[1:])
+	if len(rest) != 1 {
+		return fmt.Errorf("Invalid global import %s", items[2])
+	}
+	valueType, mutable, err := globalType(rest[0])
+	if err != nil {
+		return err
+	}
+
+	if name != "" {
+		a.globalNames[name] = a.globalCount
+	}
+	a.imports = appendName(a.imports, string(items[0].str))
+	a.imports = appendName(a.imports, string(items[1].str))
+	a.imports = append(a.imports, kindGlobal, valueType, mutable)
+	a.importCount++
+	a.globalCount++
+	a.globalImportCount++
+	return nil
+}
+
+// i32 or (mut i32)
+func globalType(n node) (valueType byte, mutable byte, err error) {
+	if n.head() == "mut" && len(n.list) == 2 {
+		mutable = 1
+		n = n.list[1]
+	}
+	valueType, ok := valueTypes[n.atom]
+	if !ok || n.isList {
+		return 0, 0, fmt.Errorf("Unknown value type %s", n)
+	}
+	return valueType, mutable, nil
+}
+
+// (func $name (import "module" "name") (param ...) (result ...))
+func isInlineImport(field node) bool {
+	if field.head() != "func" {
+		return false
+	}
+	_, items := takeName(field.list[1:])
+	return len(items) > 0 && items[0].head() == "import"
+}
+
+func (a *assembler) inlineImport(field node) error {
+	name, items := takeName(field.list[1:])
+	imp := items[0]
+	if len(imp.list) != 3 {
+		return fmt.Errorf("Invalid import %s", imp)
+	}
+
+	fn := []node{{atom: "func"}}
+	if name != "" {
+		fn = append(fn, node{atom: name})
+	}
+	fn = append(fn, items[1:]...)
+	return a.importField(node{isList: true, list: []node{{atom: "import"}, imp.list[1], imp.list[2], {isList: true, list: fn}}})
+}
+
+// (func $name (export "name") (param ...) (result ...) (local ...) instructions...)
+func (a *assembler) funcField(field node) error {
+	name, items := takeName(field.list[1:])
+
+	var exportNames []string
+	for len(items) > 0 && items[0].head() == "export" {
+		exportNames = append(exportNames, string(items[0].list[1].str))
+		items = items[1:]
+	}
+
+	f := &function{names: make(map[string]uint32)}
+	body, err := a.signature(items, f)
+	if err != nil {
+		return err
+	}
+	f.typeIndex = a.typeIndex(f.signature)
+	f.body = body
+
+	index := a.funcImportCount + uint32(len(a.funcs))
+	if name != "" {
+		a.funcNames[name] = index
+	}
+	for _, exportName := range exportNames {
+		a.exports = append(a.exports, export{name: exportName, kind: kindFunc, index: index})
+	}
+	a.funcs = append(a.funcs, f)
+	return nil
+}
+
+// (memory $name (export "name") min max)
+func (a *assembler) memoryField(field node) error {
+	_, items := takeName(field.list[1:])
+	for len(items) > 0 && items[0].head() == "export" {
+		a.exports = append(a.exports, export{name: string(items[0].list[1].str), kind: kindMemory, index: uint32(len(a.memories))})
+		items = items[1:]
+	}
+	if len(items) < 1 || len(items) > 2 {
+		return fmt.Errorf("Invalid memory %s", field)
+	}
+
+	var limits []byte
+	min, err := parseUint32(items[0])
+	if err != nil {
+		return err
+	}
+	if len(items) == 2 {
+		max, err := parseUint32(items[1])
+		if err != nil {
+			return err
+		}
+		limits = binary.AppendUvarint(binary.AppendUvarint([]byte{0x01}, uint64(min)), uint64(max))
+	} else {
+		limits = binary.AppendUvarint([]byte{0x00}, uint64(min))
+	}
+	a.memories = append(a.memories, limits)
+	return nil
+}
+
+// (global $name (export "name") (mut i32) (i32.const 0))
+func (a *assembler) globalField(field node) error {
+	name, items := takeName(field.list[1:])
+	for len(items) > 0 && items[0].head() == "export" {
+		a.exports = append(a.exports, export{name: string(items[0].list[1].str), kind: kindGlobal, index: a.globalCount})
+		items = items[1:]
+	}
+	if len(items) != 2 {
+		return fmt.Errorf("Invalid global %s", field)
+	}
+
+	valueType, mutable, err := globalType(items[0])
+	if err != nil {
+		return err
+	}
+
+	init, err := a.constExpr(items[1])
+	if err != nil {
+		return err
+	}
+
+	if name != "" {
+		a.globalNames[name] = a.globalCount
+	}
+	a.globals = append(a.globals, valueType, mutable)
+	a.globals = append(a.globals, init...)
+	a.globalCount++
+	return nil
+}
+
+// (export "name" (func $f)), also memory and global
+func (a *assembler) exportField(field node) error {
+	items := field.list[1:]
+	if len(items) != 2 || !items[0].isStr || !items[1].isList || len(items[1].list) != 2 {
+		return fmt.Errorf("Invalid export %s", field)
+	}
+
+	ref := items[1].list[1]
+	var kind byte
+	var index uint32
+	var err error
+	switch items[1].head() {
+	case "func":
+		kind = kindFunc
+		index, err = resolve(ref, a.funcNames)
+	case "memory":
+		kind = kindMemory
+		index, err = parseUint32(ref)
+	case "global":
+		kind = kindGlobal
+		index, err = resolve(ref, a.globalNames)
+	default:
+		err = fmt.Errorf("Unsupported export %s", field)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.exports = append(a.exports, export{name: string(items[0].str), kind: kind, index: index})
+	return nil
+}
+
+// (data (i32.const 16) "bytes" "more bytes")
+func (a *assembler) dataField(field node) error {
+	items := field.list[1:]
+	if len(items) == 0 || !items[0].isList {
+		return fmt.Errorf("Only active data segments with an offset are supported: %s", field)
+	}
+
+	offset, err := a.constExpr(items[0])
+	if err != nil {
+		return err
+	}
+
+	var bytes []byte
+	for _, item := range items[1:] {
+		if !item.isStr {
+			return fmt.Errorf("Expected a string in data, got %s", item)
+		}
+		bytes = append(bytes, item.str...)
+	}
+
+	segment := append([]byte{0x00}, offset...)
+	segment = binary.AppendUvarint(segment, uint64(len(bytes)))
+	a.data = append(a.data, append(segment, bytes...))
+	return nil
+}
+
+// A folded constant instruction, followed by end
+func (a *assembler) constExpr(n node) ([]byte, error) {
+	switch n.head() {
+	case "i32.const", "i64.const", "global.get":
+	default:
+		return nil, fmt.Errorf("Expected a constant expression, got %s", n)
+	}
+	code, err := a.instructions([]node{n}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(code, 0x0b), nil
+}
+
+type immediate int
+
+const (
+	noImmediate immediate = iota
+	localIndex
+	globalIndex
+	funcIndex
+	i32Value
+	i64Value
+	memArg
+	blockType
+	labelIndex
+	memoryIndex
+)
+
+type instruction struct {
+	opcode    byte
+	immediate immediate
+
+	// Natural alignment (as a power of 2) of loads and stores
+	align uint32
+}
+
+var instructions = map[string]instruction{
+	"unreachable":      {0x00, noImmediate, 0},
+	"nop":              {0x01, noImmediate, 0},
+	"block":            {0x02, blockType, 0},
+	"loop":             {0x03, blockType, 0},
+	"if":               {0x04, blockType, 0},
+	"else":             {0x05, noImmediate, 0},
+	"end":              {0x0b, noImmediate, 0},
+	"br":               {0x0c, labelIndex, 0},
+	"br_if":            {0x0d, labelIndex, 0},
+	"return":           {0x0f, noImmediate, 0},
+	"call":             {0x10, funcIndex, 0},
+	"drop":             {0x1a, noImmediate, 0},
+	"select":           {0x1b, noImmediate, 0},
+	"local.get":        {0x20, localIndex, 0},
+	"local.set":        {0x21, localIndex, 0},
+	"local.tee":        {0x22, localIndex, 0},
+	"global.get":       {0x23, globalIndex, 0},
+	"global.set":       {0x24, globalIndex, 0},
+	"i32.load":         {0x28, memArg, 2},
+	"i64.load":         {0x29, memArg, 3},
+	"i32.load8_u":      {0x2d, memArg, 0},
+	"i32.load16_u":     {0x2f, memArg, 1},
+	"i32.store":        {0x36, memArg, 2},
+	"i64.store":        {0x37, memArg, 3},
+	"i32.store8":       {0x3a, memArg, 0},
+	"i32.store16":      {0x3b, memArg, 1},
+	"memory.size":      {0x3f, memoryIndex, 0},
+	"memory.grow":      {0x40, memoryIndex, 0},
+	"i32.const":        {0x41, i32Value, 0},
+	"i64.const":        {0x42, i64Value, 0},
+	"i32.eqz":          {0x45, noImmediate, 0},
+	"i32.eq":           {0x46, noImmediate, 0},
+	"i32.ne":           {0x47, noImmediate, 0},
+	"i32.lt_u":         {0x49, noImmediate, 0},
+	"i32.gt_u":         {0x4b, noImmediate, 0},
+	"i32.le_u":         {0x4d, noImmediate, 0},
+	"i32.ge_u":         {0x4f, noImmediate, 0},
+	"i32.add":          {0x6a, noImmediate, 0},
+	"i32.sub":          {0x6b, noImmediate, 0},
+	"i32.mul":          {0x6c, noImmediate, 0},
+	"i32.and":          {0x71, noImmediate, 0},
+	"i32.or":           {0x72, noImmediate, 0},
+	"i32.shl":          {0x74, noImmediate, 0},
+	"i32.shr_u":        {0x76, noImmediate, 0},
+	"i64.add":          {0x7c, noImmediate, 0},
+	"i32.wrap_i64":     {0xa7, noImmediate, 0},
+	"i64.extend_i32_u": {0xad, noImmediate, 0},
+}
+
+// Encode a sequence of instructions, in flat or folded form. f is nil in constant expressions
+func (a *assembler) instructions(items []node, f *function) ([]byte, error) {
+	var code []byte
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		if item.isStr {
+			return nil, fmt.Errorf("Unexpected string %s", item)
+		}
+
+		// folded: (op immediates... operands...), the operands run first
+		if item.isList {
+			name := item.head()
+			if name == "block" || name == "loop" || name == "if" {
+				return nil, fmt.Errorf("Folded %s is not supported", name)
+			}
+			var immediates, operands []node
+			for _, child := range item.list[1:] {
+				if child.isList {
+					operands = append(operands, child)
+				} else {
+					immediates = append(immediates, child)
+				}
+			}
+			operandCode, err := a.instructions(operands, f)
+			if err != nil {
+				return nil, err
+			}
+			code = append(code, operandCode...)
+
+			instrCode, used, err := a.instruction(name, immediates, f)
+			if err != nil {
+				return nil, err
+			}
+			if used != len(immediates) {
+				return nil, fmt.Errorf("Unexpected %s after %s", immediates[used], name)
+			}
+			code = append(code, instrCode...)
+			continue
+		}
+
+		// flat: op immediates...
+		instrCode, used, err := a.instruction(item.atom, items[i+1:], f)
+		if err != nil {
+			return nil, err
+		}
+		code = append(code, instrCode...)
+		i += used
+	}
+	return code, nil
+}
+
+// Encode a single instruction, returns how many of the following items were its immediates
+func (a *assembler) instruction(name string, following []node, f *function) ([]byte, int, error) {
+	instr, ok := instructions[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("Unknown instruction %q", name)
+	}
+	code := []byte{instr.opcode}
+
+	next := func() (node, error) {
+		if len(following) == 0 || following[0].isList || following[0].isStr {
+			return node{}, fmt.Errorf("%s is missing its immediate", name)
+		}
+		return following[0], nil
+	}
+
+	switch instr.immediate {
+	case noImmediate:
+		return code, 0, nil
+
+	case localIndex:
+		n, err := next()
+		if err != nil {
+			return nil, 0, err
+		}
+		if f == nil {
+			return nil, 0, fmt.Errorf("%s outside of a function", name)
+		}
+		index, err := resolve(n, f.names)
+		if err != nil {
+			return nil, 0, err
+		}
+		return binary.AppendUvarint(code, uint64(index)), 1, nil
+
+	case globalIndex, funcIndex:
+		n, err := next()
+		if err != nil {
+			return nil, 0, err
+		}
+		names := a.globalNames
+		if instr.immediate == funcIndex {
+			names = a.funcNames
+		}
+		index, err := resolve(n, names)
+		if err != nil {
+			return nil, 0, err
+		}
+		return binary.AppendUvarint(code, uint64(index)), 1, nil
+
+	case labelIndex:
+		n, err := next()
+		if err != nil {
+			return nil, 0, err
+		}
+		depth, err := parseUint32(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		return binary.AppendUvarint(code, uint64(depth)), 1, nil
+
+	case i32Value, i64Value:
+		n, err := next()
+		if err != nil {
+			return nil, 0, err
+		}
+		bits := 32
+		if instr.immediate == i64Value {
+			bits = 64
+		}
+		value, err := parseInt(n.atom, bits)
+		if err != nil {
+			return nil, 0, err
+		}
+		return appendSigned(code, value), 1, nil
+
+	case memArg:
+		offset, align := uint64(0), uint64(instr.align)
+		used := 0
+		for _, n := range following {
+			if n.isList || n.isStr {
+				break
+			}
+			if value, ok := strings.CutPrefix(n.atom, "offset="); ok {
+				v, err := parseInt(value, 33)
+				if err != nil || v < 0 {
+					return nil, 0, fmt.Errorf("Invalid offset %s", n)
+				}
+				offset = uint64(v)
+			} else if value, ok := strings.CutPrefix(n.atom, "align="); ok {
+				v, err := strconv.ParseUint(value, 10, 32)
+				if err != nil || v == 0 || v&(v-1) != 0 {
+					return nil, 0, fmt.Errorf("Invalid alignment %s", n)
+				}
+				align = 0
+				for v > 1 {
+					v >>= 1
+					align++
+				}
+			} else {
+				break
+			}
+			used++
+		}
+		code = binary.AppendUvarint(code, align)
+		return binary.AppendUvarint(code, offset), used, nil
+
+	case blockType:
+		if len(following) > 0 && following[0].head() == "result" {
+			result := following[0]
+			if len(result.list) != 2 {
+				return nil, 0, fmt.Errorf("Blocks can only have a single result")
+			}
+			valueType, ok := valueTypes[result.list[1].atom]
+			if !ok {
+				return nil, 0, fmt.Errorf("Unknown value type %s", result.list[1])
+			}
+			return append(code, valueType), 1, nil
+		}
+		return append(code, 0x40), 0, nil
+
+	case memoryIndex:
+		return append(code, 0x00), 0, nil
+	}
+
+	return nil, 0, fmt.Errorf("Unknown immediate for %s", name)
+}
+
+// Index of a $name or a plain number
+func resolve(n node, names map[string]uint32) (uint32, error) {
+	if strings.HasPrefix(n.atom, "$") {
+		index, ok := names[n.atom]
+		if !ok {
+			return 0, fmt.Errorf("Unknown identifier %s", n.atom)
+		}
+		return index, nil
+	}
+	return parseUint32(n)
+}
+
+func parseUint32(n node) (uint32, error) {
+	v, err := strconv.ParseUint(strings.ReplaceAll(n.atom, "_", ""), 0, 32)
+	if err != nil || n.isList || n.isStr {
+		return 0, fmt.Errorf("Expected a number, got %s", n)
+	}
+	return uint32(v), nil
+}
+
+// Parse a signed or unsigned integer of the given width, unsigned values above the signed range wrap around
+func parseInt(s string, bits int) (int64, error) {
+	s = strings.ReplaceAll(s, "_", "")
+	if v, err := strconv.ParseInt(s, 0, bits); err == nil {
+		return v, nil
+	}
+	u, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid i%d %q", bits, s)
+	}
+	if bits == 32 {
+		return int64(int32(uint32(u))), nil
+	}
+	return int64(u), nil
+}
+
+func appendName(b []byte, name string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(name))), name...)
+}
+
+func appendSection(out []byte, id byte, count int, content []byte) []byte {
+	content = append(binary.AppendUvarint(nil, uint64(count)), content...)
+	out = append(out, id)
+	out = binary.AppendUvarint(out, uint64(len(content)))
+	return append(out, content...)
+}
+
+func (a *assembler) encode() ([]byte, error) {
+	// bodies first, since calls can reference functions defined after them
+	var code []byte
+	for _, f := range a.funcs {
+		var body []byte
+		body = binary.AppendUvarint(body, uint64(len(f.locals)))
+		for _, local := range f.locals {
+			body = append(body, 0x01, local)
+		}
+		instrs, err := a.instructions(f.body, f)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, instrs...)
+		body = append(body, 0x0b)
+		code = binary.AppendUvarint(code, uint64(len(body)))
+		code = append(code, body...)
+	}
+
+	out := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+
+	if len(a.types) > 0 {
+		var types []byte
+		for _, t := range a.types {
+			types = append(types, 0x60)
+			types = binary.AppendUvarint(types, uint64(len(t.params)))
+			types = append(types, t.params...)
+			types = binary.AppendUvarint(types, uint64(len(t.results)))
+			types = append(types, t.results...)
+		}
+		out = appendSection(out, sectionType, len(a.types), types)
+	}
+	if a.importCount > 0 {
+		out = appendSection(out, sectionImport, int(a.importCount), a.imports)
+	}
+	if len(a.funcs) > 0 {
+		var funcs []byte
+		for _, f := range a.funcs {
+			funcs = binary.AppendUvarint(funcs, uint64(f.typeIndex))
+		}
+		out = appendSection(out, sectionFunction, len(a.funcs), funcs)
+	}
+	if len(a.memories) > 0 {
+		var memories []byte
+		for _, m := range a.memories {
+			memories = append(memories, m...)
+		}
+		out = appendSection(out, sectionMemory, len(a.memories), memories)
+	}
+	if a.globalCount > a.globalImportCount {
+		out = appendSection(out, sectionGlobal, int(a.globalCount-a.globalImportCount), a.globals)
+	}
+	if len(a.exports) > 0 {
+		var exports []byte
+		for _, e := range a.exports {
+			exports = appendName(exports, e.name)
+			exports = append(exports, e.kind)
+			exports = binary.AppendUvarint(exports, uint64(e.index))
+		}
+		out = appendSection(out, sectionExport, len(a.exports), exports)
+	}
+	if a.start != nil {
+		if len(a.start.list) != 2 {
+			return nil, fmt.Errorf("Invalid start %s", *a.start)
+		}
+		index, err := resolve(a.start.list[1], a.funcNames)
+		if err != nil {
+			return nil, err
+		}
+		start := binary.AppendUvarint([]byte{sectionStart}, uint64(len(binary.AppendUvarint(nil, uint64(index)))))
+		out = binary.AppendUvarint(append(out, start...), uint64(index))
+	}
+	if len(a.funcs) > 0 {
+		out = appendSection(out, sectionCode, len(a.funcs), code)
+	}
+	if len(a.data) > 0 {
+		var data []byte
+		for _, d := range a.data {
+			data = append(data, d...)
+		}
+		out = appendSection(out, sectionData, len(a.data), data)
+	}
+
+	return out, nil
+}
+
+// Signed LEB128, which isn't the zigzag encoding of binary.AppendVarint
+func appendSigned(out []byte, value int64) []byte {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if (value == 0 && b&0x40 == 0) || (value == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
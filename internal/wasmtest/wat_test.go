@@ -0,0 +1,62 @@
+package wasmtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+)
+
+func TestAssemble(t *testing.T) {
+	wasm := Must(t, `(module
+		(memory (export "memory") 1)
+		(global $base (mut i32) (i32.const -200))
+		(data (i32.const 300) "\01\02\03")
+
+		;; base + the sum of 1..n, then the data bytes
+		(func $sum (export "sum") (param $n i32) (result i32) (local $total i32)
+			block
+				loop
+					(br_if 1 (i32.eqz (local.get $n)))
+					(local.set $total (i32.add (local.get $total) (local.get $n)))
+					(local.set $n (i32.sub (local.get $n) (i32.const 1)))
+					br 0
+				end
+			end
+			(i32.add (local.get $total) (global.get $base))
+			(i32.load8_u offset=300 (i32.const 0))
+			i32.add
+			(i32.load16_u (i32.const 301))
+			i32.add))`)
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	mod, err := runtime.Instantiate(ctx, wasm)
+	if err != nil {
+		t.Fatalf("Failed to instantiate module: %v", err)
+	}
+	results, err := mod.ExportedFunction("sum").Call(ctx, 100)
+	if err != nil {
+		t.Fatalf("Failed to call sum: %v", err)
+	}
+	// 5050 - 200 + 1 + 0x0302
+	if got := int32(results[0]); got != 5050-200+1+0x0302 {
+		t.Errorf("Expected %d, got %d", 5050-200+1+0x0302, got)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, wat := range []string{
+		`(module`,
+		`(func)`,
+		`(module (func (call $missing)))`,
+		`(module (func bogus.op))`,
+		`(module (data (i32.const 0) "\zz"))`,
+	} {
+		if _, err := Assemble(wat); err == nil {
+			t.Errorf("Expected an error for %s", wat)
+		}
+	}
+}
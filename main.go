@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"sync"
+	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/handlers/db"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/handlers/kv"
 	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
 	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
 	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
@@ -28,18 +31,24 @@ func debugHandler(event *wasmevents.WASMEventInfo) (string, error) {
 func main() {
 	ctx := context.Background()
 
-	store, err := store.NewSandboxStore(context.Background(), store.SandboxStoreCfg{
+	// in-memory KV store for the SET / GET / DEL family of events
+	kvStore := kv.New(kv.Config{})
+	defer kvStore.Close()
+
+	// on-disk store for the DB_SET / DB_GET / DB_DEL / DB_LIST events
+	dbStore, err := db.Open(db.Config{Dir: filepath.Join(os.TempDir(), "wasm-sandbox-db")})
+	if err != nil {
+		fmt.Println("Failed to open DB", err)
+		return
+	}
+	defer dbStore.Close()
+
+	sandbox, err := store.NewSandboxStore(context.Background(), store.SandboxStoreCfg{
 		CleanupInterval:    5 * time.Second,
 		MaxIdleTime:        6 * time.Second,
 		MemoryLimitPages:   10,
 		CloseOnContextDone: true,
-		HandlerMap: wasmevents.NewHandlerMap().
-			AddHandler(wasmevents.GET, dummyHandler).
-			AddHandler(wasmevents.SET, dummyHandler).
-			AddHandler(wasmevents.DEL, dummyHandler).
-			AddHandler(wasmevents.DB_GET, dummyHandler).
-			AddHandler(wasmevents.DB_SET, dummyHandler).
-			AddHandler(wasmevents.DB_DEL, dummyHandler).
+		HandlerMap: dbStore.Register(kvStore.Register(wasmevents.NewHandlerMap())).
 			AddHandler(wasmevents.BROADCAST, dummyHandler).
 			AddHandler(wasmevents.FETCH, dummyHandler).
 			AddHandler(wasmevents.LOG, dummyHandler).
@@ -47,7 +56,8 @@ func main() {
 			AddHandler(wasmevents.GET_USERS, dummyHandler).
 			AddHandler(wasmevents.SEND_MESSAGE, dummyHandler).
 			AddHandler(wasmevents.CLOSE_CONNECTION, dummyHandler),
-		LoaderFunction: loader.MockLoaderFunction,
+		TransactionHandler: dbStore,
+		LoaderFunction:     loader.MockLoaderFunction,
 	})
 
 	if err != nil {
@@ -55,7 +65,7 @@ func main() {
 		return
 	}
 
-	var wg sync.WaitGroup
+	results := make([]<-chan store.Result, 0, 10)
 
 	for i := range 10 {
 		// sample event
@@ -68,14 +78,14 @@ func main() {
 			Timestamp:    time.Now().UnixMilli(),
 		}
 
-		wg.Add(1)
-
-		go func() {
-			store.ExecuteOnModule(ctx, event)
-			wg.Done()
-		}()
+		// Submit runs the event on the store's workers, so we don't need our own goroutines
+		results = append(results, sandbox.Submit(ctx, event))
 	}
 
 	fmt.Println("Finished sending requests")
-	wg.Wait()
+	for _, result := range results {
+		if res := <-result; res.Err != nil {
+			fmt.Println("Failed to execute event", res.Err)
+		}
+	}
 }
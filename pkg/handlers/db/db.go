@@ -0,0 +1,418 @@
+// Persistent key-value store that implements the DB_SET, DB_GET, DB_DEL and DB_LIST events.
+//
+// Every tenant (InstanceId) gets its own append-only log file in a directory, which is replayed
+// the first time the tenant is used, so data survives process restarts. Logs are compacted
+// once they are mostly overwritten or deleted records
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// Returned when a write would take a tenant past MaxBytes
+var ErrQuotaExceeded = errors.New("Tenant is out of storage")
+
+// Returned for operations on a store that was closed
+var ErrClosed = errors.New("DB is closed")
+
+type Config struct {
+	// Directory that holds the log files, created if it doesn't exist
+	Dir string
+
+	// Maximum bytes of live data per tenant, counting keys and values (defaults to 64MB)
+	MaxBytes int64
+
+	// Call fsync after every write. Without it, writes survive the process crashing but not the machine
+	SyncWrites bool
+
+	// Logs smaller than this are never compacted (defaults to 1MB)
+	CompactMinBytes int64
+
+	// Maximum number of tenants with an open log (defaults to 256). The least recently used
+	// tenant is closed to make room, and its log is replayed again the next time it is used
+	MaxOpenTenants int
+}
+
+type Store struct {
+	dir             string
+	maxBytes        int64
+	syncWrites      bool
+	compactMinBytes int64
+	maxOpenTenants  int
+
+	mu      sync.Mutex
+	tenants map[string]*tenant
+	closed  bool
+
+	// Incremented every time a tenant is used, to find the least recently used one
+	uses uint64
+}
+
+type tenant struct {
+	mu   sync.RWMutex
+	path string
+	file *os.File
+
+	data map[string]string
+
+	// Size of the keys and values in data
+	liveBytes int64
+
+	// Size of the log file
+	logBytes int64
+
+	// Set once the file is closed, after which the tenant is opened again on its next use
+	closed atomic.Bool
+
+	// Value of uses when the tenant was last used, guarded by the store's lock
+	lastUsed uint64
+}
+
+// Open a store in the configured directory
+func Open(cfg Config) (*Store, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("No DB directory specified")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:             cfg.Dir,
+		maxBytes:        cfg.MaxBytes,
+		syncWrites:      cfg.SyncWrites,
+		compactMinBytes: cfg.CompactMinBytes,
+		maxOpenTenants:  cfg.MaxOpenTenants,
+		tenants:         make(map[string]*tenant),
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = 64 << 20
+	}
+	if s.compactMinBytes <= 0 {
+		s.compactMinBytes = 1 << 20
+	}
+	if s.maxOpenTenants <= 0 {
+		s.maxOpenTenants = 256
+	}
+
+	return s, nil
+}
+
+// Close every open log file
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var errs []error
+	for id, t := range s.tenants {
+		errs = append(errs, t.close())
+		delete(s.tenants, id)
+	}
+	return errors.Join(errs...)
+}
+
+// Close the tenant's file, waiting for operations on it to finish
+func (t *tenant) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed.Store(true)
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// Tenant IDs can contain any character, so files are named after their hash
+func (s *Store) logPath(tenantId string) string {
+	sum := sha256.Sum256([]byte(tenantId))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".log")
+}
+
+// Get a tenant, replaying its log if it isn't open
+func (s *Store) tenant(tenantId string) (*tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+	s.uses++
+	if t, ok := s.tenants[tenantId]; ok && !t.closed.Load() {
+		t.lastUsed = s.uses
+		return t, nil
+	}
+
+	delete(s.tenants, tenantId)
+	if len(s.tenants) >= s.maxOpenTenants {
+		s.evictLRU()
+	}
+
+	t := &tenant{path: s.logPath(tenantId), data: make(map[string]string), lastUsed: s.uses}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	s.tenants[tenantId] = t
+	return t, nil
+}
+
+// Close the least recently used tenant. s.mu must be held
+func (s *Store) evictLRU() {
+	var lru string
+	for id, t := range s.tenants {
+		if lru == "" || t.lastUsed < s.tenants[lru].lastUsed {
+			lru = id
+		}
+	}
+
+	// closed synchronously, so that the log is never opened twice
+	s.tenants[lru].close()
+	delete(s.tenants, lru)
+}
+
+// Run fn with a tenant locked, for writing or for reading.
+// A tenant that is closed while fn waits for the lock is opened again
+func (s *Store) withTenant(tenantId string, write bool, fn func(t *tenant) error) error {
+	for {
+		t, err := s.tenant(tenantId)
+		if err != nil {
+			return err
+		}
+
+		lock, unlock := t.mu.RLock, t.mu.RUnlock
+		if write {
+			lock, unlock = t.mu.Lock, t.mu.Unlock
+		}
+		lock()
+		if t.closed.Load() {
+			unlock()
+			continue
+		}
+		err = fn(t)
+		unlock()
+		return err
+	}
+}
+
+func (t *tenant) open() error {
+	file, err := os.OpenFile(t.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	end, err := replayLog(file, t.apply)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// drop anything after the last good record, so new records aren't written after garbage
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(end, 0); err != nil {
+		file.Close()
+		return err
+	}
+
+	t.file = file
+	t.logBytes = end
+	return nil
+}
+
+// Apply operations to the in-memory data
+func (t *tenant) apply(ops []Op) {
+	for _, op := range ops {
+		if prev, ok := t.data[op.Key]; ok {
+			t.liveBytes -= int64(len(op.Key) + len(prev))
+			delete(t.data, op.Key)
+		}
+		if !op.Delete {
+			t.data[op.Key] = op.Value
+			t.liveBytes += int64(len(op.Key) + len(op.Value))
+		}
+	}
+}
+
+// Size of the live data once ops are applied
+func (t *tenant) sizeAfter(ops []Op) int64 {
+	size := t.liveBytes
+	pending := make(map[string]*Op, len(ops))
+	for i := range ops {
+		pending[ops[i].Key] = &ops[i]
+	}
+	for key, op := range pending {
+		if prev, ok := t.data[key]; ok {
+			size -= int64(len(key) + len(prev))
+		}
+		if !op.Delete {
+			size += int64(len(key) + len(op.Value))
+		}
+	}
+	return size
+}
+
+// Write operations to the log as a single record, then apply them.
+// Either all of them are applied or none are
+func (s *Store) Apply(tenantId string, ops []Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	return s.withTenant(tenantId, true, func(t *tenant) error {
+		if size := t.sizeAfter(ops); size > t.liveBytes && size > s.maxBytes {
+			return ErrQuotaExceeded
+		}
+
+		record := encodeRecord(ops)
+		_, err := t.file.Write(record)
+		if err == nil && s.syncWrites {
+			err = t.file.Sync()
+		}
+		if err != nil {
+			t.fail()
+			return err
+		}
+
+		t.logBytes += int64(len(record))
+		t.apply(ops)
+
+		// the write is already applied, so a failed compaction is only logged and tried again on the next write
+		if t.logBytes > s.compactMinBytes && t.logBytes > 2*t.liveBytes {
+			if err := t.compact(); err != nil {
+				slog.Warn("Failed to compact DB log", "path", t.path, "err", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Stop using a file after a failed write. The record may be partly written, or written but not
+// synced, so it is cut off and the tenant is closed. The next call opens it again.
+// The tenant's lock must be held
+func (t *tenant) fail() {
+	t.file.Truncate(t.logBytes)
+	t.file.Close()
+	t.file = nil
+	t.closed.Store(true)
+}
+
+// Rewrite the log with only the live data, then swap it in. The tenant's lock must be held
+func (t *tenant) compact() error {
+	tmpPath := t.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	size := int64(0)
+	for _, key := range slices.Sorted(maps.Keys(t.data)) {
+		record := encodeRecord([]Op{{Key: key, Value: t.data[key]}})
+		if _, err := tmp.Write(record); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		size += int64(len(record))
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, t.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	t.file.Close()
+	t.file = tmp
+	t.logBytes = size
+
+	// the rename only survives a crash once the directory is synced
+	return syncDir(filepath.Dir(t.path))
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Get the value of a key
+func (s *Store) Get(tenantId string, key string) (string, bool, error) {
+	var value string
+	var ok bool
+	err := s.withTenant(tenantId, false, func(t *tenant) error {
+		value, ok = t.data[key]
+		return nil
+	})
+	return value, ok, err
+}
+
+// Implements wasmevents.TransactionHandler, so that the store can apply the writes of guest transactions
+func (s *Store) CommitTransaction(tenantId string, writes []wasmevents.DBWrite) error {
+	ops := make([]Op, len(writes))
+	for i, write := range writes {
+		ops[i] = Op{Delete: write.Delete, Key: write.Key, Value: write.Value}
+	}
+	return s.Apply(tenantId, ops)
+}
+
+func (s *Store) Set(tenantId string, key string, value string) error {
+	return s.Apply(tenantId, []Op{{Key: key, Value: value}})
+}
+
+func (s *Store) Delete(tenantId string, key string) error {
+	return s.Apply(tenantId, []Op{{Delete: true, Key: key}})
+}
+
+// Get every key that starts with prefix, in sorted order
+func (s *Store) List(tenantId string, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := s.withTenant(tenantId, false, func(t *tenant) error {
+		for key := range t.data {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// Bytes of live data that a tenant is using
+func (s *Store) Usage(tenantId string) (int64, error) {
+	var usage int64
+	err := s.withTenant(tenantId, false, func(t *tenant) error {
+		usage = t.liveBytes
+		return nil
+	})
+	return usage, err
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func openStore(t *testing.T, cfg Config) *Store {
+	t.Helper()
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func set(t *testing.T, s *Store, tenantId string, key string, value string) {
+	t.Helper()
+	if err := s.Set(tenantId, key, value); err != nil {
+		t.Fatalf("Failed to set %s: %v", key, err)
+	}
+}
+
+func expectValue(t *testing.T, s *Store, tenantId string, key string, expected string) {
+	t.Helper()
+	value, ok, err := s.Get(tenantId, key)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %v", key, err)
+	}
+	if expected == "" && ok {
+		t.Errorf("Expected %s not to exist, got %q", key, value)
+	} else if expected != "" && value != expected {
+		t.Errorf("Expected %s to be %q, got %q", key, expected, value)
+	}
+}
+
+func appendToLog(t *testing.T, path string, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+}
+
+func TestDataSurvivesReopening(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir})
+	set(t, s, "t", "a", "1")
+	set(t, s, "t", "b", "2")
+	s.Apply("t", []Op{{Delete: true, Key: "a"}, {Key: "c", Value: "3"}})
+	set(t, s, "other", "a", "other")
+	s.Close()
+
+	s = openStore(t, Config{Dir: dir})
+	expectValue(t, s, "t", "a", "")
+	expectValue(t, s, "t", "b", "2")
+	expectValue(t, s, "t", "c", "3")
+	expectValue(t, s, "other", "a", "other")
+	if usage, _ := s.Usage("t"); usage != 4 {
+		t.Errorf("Expected a usage of 4 bytes, got %d", usage)
+	}
+}
+
+func TestTornTailIsCutOff(t *testing.T) {
+	record := encodeRecord([]Op{{Key: "torn", Value: "value"}})
+
+	for name, tail := range map[string][]byte{
+		"header":  record[:5],
+		"payload": record[:len(record)-2],
+		// the file was extended, but the payload never made it
+		"zeroes": append(record[:8:8], make([]byte, len(record)-8)...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, Config{Dir: dir})
+			set(t, s, "t", "a", "1")
+			s.Close()
+			appendToLog(t, s.logPath("t"), tail)
+
+			s = openStore(t, Config{Dir: dir})
+			expectValue(t, s, "t", "a", "1")
+			expectValue(t, s, "t", "torn", "")
+
+			// new records go where the torn one was
+			set(t, s, "t", "b", "2")
+			s.Close()
+			s = openStore(t, Config{Dir: dir})
+			expectValue(t, s, "t", "a", "1")
+			expectValue(t, s, "t", "b", "2")
+		})
+	}
+}
+
+func TestCorruptionBeforeTheEndFails(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir})
+	set(t, s, "t", "a", "1")
+	set(t, s, "t", "b", "2")
+	s.Close()
+
+	path := s.logPath("t")
+	log, _ := os.ReadFile(path)
+	// a byte of the first record's value
+	log[len(encodeRecord([]Op{{Key: "a", Value: "1"}}))-1] ^= 0xff
+	os.WriteFile(path, log, 0o644)
+
+	s = openStore(t, Config{Dir: dir})
+	if _, _, err := s.Get("t", "b"); !errors.Is(err, ErrCorruptLog) {
+		t.Errorf("Expected ErrCorruptLog, got %v", err)
+	}
+	if kept, _ := os.ReadFile(path); !slices.Equal(kept, log) {
+		t.Errorf("Expected the corrupt log to be left alone")
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir, CompactMinBytes: 100})
+	for i := range 50 {
+		set(t, s, "t", "key", fmt.Sprint(i))
+	}
+	set(t, s, "t", "other", "value")
+
+	info, _ := os.Stat(s.logPath("t"))
+	if info.Size() > 200 {
+		t.Errorf("Expected the log to be compacted, it has %d bytes", info.Size())
+	}
+	if _, err := os.Stat(s.logPath("t") + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file to be left behind")
+	}
+	s.Close()
+
+	s = openStore(t, Config{Dir: dir})
+	expectValue(t, s, "t", "key", "49")
+	expectValue(t, s, "t", "other", "value")
+}
+
+// Compaction is only maintenance, so a write that was applied still succeeds when it fails
+func TestFailedCompactionKeepsTheWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir, CompactMinBytes: 100})
+	set(t, s, "t", "key", "first")
+
+	// the temporary file can't be created where a directory is
+	if err := os.Mkdir(s.logPath("t")+".compact", 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for i := range 50 {
+		set(t, s, "t", "key", fmt.Sprint(i))
+	}
+	s.Close()
+
+	s = openStore(t, Config{Dir: dir})
+	expectValue(t, s, "t", "key", "49")
+}
+
+func TestQuota(t *testing.T) {
+	s := openStore(t, Config{Dir: t.TempDir(), MaxBytes: 10})
+
+	set(t, s, "t", "key", "12345")
+	if err := s.Set("t", "key", "123456789"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	expectValue(t, s, "t", "key", "12345")
+
+	// writes that don't grow the data are always allowed
+	if err := s.Apply("t", []Op{{Delete: true, Key: "key"}, {Key: "k", Value: "123456789"}}); err != nil {
+		t.Errorf("Expected a write that frees space to be allowed, got %v", err)
+	}
+}
+
+func TestLeastRecentlyUsedTenantIsClosed(t *testing.T) {
+	s := openStore(t, Config{Dir: t.TempDir(), MaxOpenTenants: 2})
+
+	set(t, s, "a", "key", "a")
+	set(t, s, "b", "key", "b")
+	expectValue(t, s, "a", "key", "a")
+	set(t, s, "c", "key", "c")
+
+	s.mu.Lock()
+	open := slices.Sorted(maps.Keys(s.tenants))
+	s.mu.Unlock()
+	if !slices.Equal(open, []string{"a", "c"}) {
+		t.Errorf("Expected tenants a and c to be open, got %v", open)
+	}
+
+	// b is replayed from its log
+	expectValue(t, s, "b", "key", "b")
+	set(t, s, "b", "other", "b")
+	expectValue(t, s, "b", "other", "b")
+}
+
+// Tenants are closed and opened again while they are in use, without losing writes
+func TestEvictionDuringWrites(t *testing.T) {
+	s := openStore(t, Config{Dir: t.TempDir(), MaxOpenTenants: 2})
+
+	const tenants = 4
+	const writes = 50
+	var wg sync.WaitGroup
+	for i := range tenants * 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tenantId := fmt.Sprint(i % tenants)
+			for n := range writes {
+				if err := s.Set(tenantId, fmt.Sprintf("%d-%d", i, n), "v"); err != nil {
+					t.Errorf("Set failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range tenants {
+		keys, err := s.List(fmt.Sprint(i), "")
+		if err != nil || len(keys) != 2*writes {
+			t.Errorf("Expected tenant %d to have %d keys, got %d (%v)", i, 2*writes, len(keys), err)
+		}
+	}
+}
+
+func TestFailedWriteIsNotApplied(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir, SyncWrites: true})
+	set(t, s, "t", "a", "1")
+
+	// the next write fails, as if the disk went away
+	s.mu.Lock()
+	s.tenants["t"].file.Close()
+	s.mu.Unlock()
+
+	if err := s.Set("t", "b", "2"); err == nil {
+		t.Fatalf("Expected the write to fail")
+	}
+	expectValue(t, s, "t", "b", "")
+
+	// the tenant is opened again from its log
+	set(t, s, "t", "c", "3")
+	s.Close()
+	s = openStore(t, Config{Dir: dir})
+	expectValue(t, s, "t", "a", "1")
+	expectValue(t, s, "t", "b", "")
+	expectValue(t, s, "t", "c", "3")
+}
+
+func TestClosedStore(t *testing.T) {
+	s := openStore(t, Config{Dir: t.TempDir()})
+	set(t, s, "t", "a", "1")
+	s.Close()
+
+	if err := s.Set("t", "a", "2"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+	if _, _, err := s.Get("t", "a"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
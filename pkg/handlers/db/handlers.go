@@ -0,0 +1,63 @@
+package db
+
+import (
+	"encoding/json"
+
+	handlerutil "github.com/Cloud-RAMP/wasm-sandbox/internal/handler-util"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// Add handlers for every event the store implements.
+//
+// Returns the map so that it can be chained
+func (s *Store) Register(handlerMap *wasmevents.HandlerMap) *wasmevents.HandlerMap {
+	return handlerMap.
+		AddHandler(wasmevents.DB_SET, s.handleSet).
+		AddHandler(wasmevents.DB_GET, s.handleGet).
+		AddHandler(wasmevents.DB_DEL, s.handleDel).
+		AddHandler(wasmevents.DB_LIST, s.handleList).
+		AddHandler(wasmevents.DB_SCAN, s.handleScan)
+}
+
+// Payload is [key, value]
+func (s *Store) handleSet(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 2); err != nil {
+		return "", err
+	}
+	return "", s.Set(event.InstanceId, event.Payload[0], event.Payload[1])
+}
+
+// Payload is [key]. Missing keys return an empty string
+func (s *Store) handleGet(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	value, _, err := s.Get(event.InstanceId, event.Payload[0])
+	return value, err
+}
+
+// Payload is [key]
+func (s *Store) handleDel(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	return "", s.Delete(event.InstanceId, event.Payload[0])
+}
+
+// Payload is [prefix], returns a JSON array of keys
+func (s *Store) handleList(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	keys, err := s.List(event.InstanceId, event.Payload[0])
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(keys)
+	return string(encoded), err
+}
+
+// Payload is [prefix, cursor, limit], see handlerutil.HandleScan
+func (s *Store) handleScan(event *wasmevents.WASMEventInfo) (string, error) {
+	return handlerutil.HandleScan(event, s.Scan)
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+/*
+Each tenant's data is kept in its own append-only log file. Every record is laid out as:
+  - 4 bytes for the length of the payload
+  - 4 bytes for the CRC32 of the payload
+  - The payload, which is one or more operations
+
+Each operation is:
+  - 1 byte for the op (set or delete)
+  - uvarint key length, then the key
+  - uvarint value length, then the value (only for set)
+
+All operations of a record are applied together, so a record is the unit of atomicity.
+A torn or corrupt record at the end of the file (from a crash mid-write) is cut off when the log is opened.
+A bad record anywhere else can't come from a crash, so the log fails to open with ErrCorruptLog
+instead of losing the records after it
+*/
+
+const (
+	opSet byte = 1
+	opDel byte = 2
+)
+
+// Largest record that will be read back, anything bigger is treated as corruption
+const maxRecordBytes = 1 << 30
+
+// Returned when a log has a bad record before its end
+var ErrCorruptLog = errors.New("DB log is corrupt")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// A single write to a tenant's data
+type Op struct {
+	Delete bool
+	Key    string
+	Value  string
+}
+
+func encodeRecord(ops []Op) []byte {
+	payload := make([]byte, 0, 64)
+	for _, op := range ops {
+		if op.Delete {
+			payload = append(payload, opDel)
+		} else {
+			payload = append(payload, opSet)
+		}
+		payload = binary.AppendUvarint(payload, uint64(len(op.Key)))
+		payload = append(payload, op.Key...)
+		if !op.Delete {
+			payload = binary.AppendUvarint(payload, uint64(len(op.Value)))
+			payload = append(payload, op.Value...)
+		}
+	}
+
+	record := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(record[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.Checksum(payload, crcTable))
+	return append(record, payload...)
+}
+
+func decodePayload(payload []byte) ([]Op, error) {
+	var ops []Op
+	for len(payload) > 0 {
+		kind := payload[0]
+		payload = payload[1:]
+		if kind != opSet && kind != opDel {
+			return nil, fmt.Errorf("Unknown op %d", kind)
+		}
+
+		key, rest, err := readBytes(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = rest
+
+		op := Op{Delete: kind == opDel, Key: string(key)}
+		if kind == opSet {
+			value, rest, err := readBytes(payload)
+			if err != nil {
+				return nil, err
+			}
+			payload = rest
+			op.Value = string(value)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func readBytes(buf []byte) ([]byte, []byte, error) {
+	n, read := binary.Uvarint(buf)
+	if read <= 0 || n > uint64(len(buf)-read) {
+		return nil, nil, errors.New("Op is truncated")
+	}
+	buf = buf[read:]
+	return buf[:n], buf[n:], nil
+}
+
+// Read every record in a log, and call apply for each.
+//
+// Returns the offset just after the last valid record, which is before a torn record at the end
+func replayLog(file *os.File, apply func([]Op)) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(file)
+	offset := int64(0)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a clean EOF, or a torn header
+			return offset, nil
+		} else if err != nil {
+			return 0, err
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:])
+		sum := binary.LittleEndian.Uint32(header[4:])
+		end := offset + 8 + int64(length)
+		if end > size {
+			// the write of the last record never finished
+			return offset, nil
+		}
+		if length > maxRecordBytes {
+			return 0, fmt.Errorf("%w: record at offset %d is too large", ErrCorruptLog, offset)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, err
+		}
+
+		var ops []Op
+		if crc32.Checksum(payload, crcTable) != sum {
+			err = errors.New("Checksum mismatch")
+		} else {
+			ops, err = decodePayload(payload)
+		}
+		if err != nil {
+			// the last record can be complete in size, but not in content
+			if end == size {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("%w: record at offset %d: %v", ErrCorruptLog, offset, err)
+		}
+
+		apply(ops)
+		offset = end
+	}
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	handlerutil "github.com/Cloud-RAMP/wasm-sandbox/internal/handler-util"
+)
+
+// A key and its value, returned by Scan
+type Pair = handlerutil.Pair
+
+// Get up to limit keys that start with prefix and sort after cursor, along with their values.
+//
+// Returns the cursor for the next page, which is "" once there are no more keys
+func (s *Store) Scan(tenantId string, prefix string, cursor string, limit int) ([]Pair, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("Scan limit must be positive")
+	}
+
+	var pairs []Pair
+	next := ""
+	err := s.withTenant(tenantId, false, func(t *tenant) error {
+		var keys []string
+		for key := range t.data {
+			if strings.HasPrefix(key, prefix) && key > cursor {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+
+		if len(keys) > limit {
+			keys = keys[:limit]
+			next = keys[limit-1]
+		}
+
+		pairs = make([]Pair, len(keys))
+		for i, key := range keys {
+			pairs[i] = Pair{Key: key, Value: t.data[key]}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return pairs, next, nil
+}
@@ -0,0 +1,224 @@
+package kv
+
+import (
+	"maps"
+	"strconv"
+)
+
+// Run fn while holding the lock of the key's shard.
+//
+// fn gets the live entry (nil if there is none) and can replace it with put
+func (s *Store) withKey(tenant string, key string, fn func(sh *shard, k entryKey, e *entry) error) error {
+	k := entryKey{tenant, key}
+	sh := s.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, _ := s.lookup(sh, k)
+	return fn(sh, k, e)
+}
+
+// Add delta to the integer stored at a key, and return the result.
+// Missing keys start at 0. Returns ErrOverflow instead of wrapping around
+func (s *Store) IncrBy(tenant string, key string, delta int64) (int64, error) {
+	var result int64
+	err := s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		current := int64(0)
+		expiresAt := int64(0)
+		if e != nil {
+			if e.kind != kindString {
+				return ErrWrongType
+			}
+
+			var err error
+			current, err = strconv.ParseInt(e.value, 10, 64)
+			if err != nil {
+				return ErrWrongType
+			}
+			expiresAt = e.expiresAt
+		}
+
+		result = current + delta
+		if (delta > 0 && result < current) || (delta < 0 && result > current) {
+			return ErrOverflow
+		}
+		return s.put(sh, k, &entry{value: strconv.FormatInt(result, 10), expiresAt: expiresAt})
+	})
+	return result, err
+}
+
+// Set a key to next only if it currently holds expected.
+// Returns false if the key holds something else or doesn't exist
+func (s *Store) CompareAndSwap(tenant string, key string, expected string, next string) (bool, error) {
+	swapped := false
+	err := s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		if e == nil {
+			return nil
+		}
+		if e.kind != kindString {
+			return ErrWrongType
+		}
+		if e.value != expected {
+			return nil
+		}
+
+		if err := s.put(sh, k, &entry{value: next, expiresAt: e.expiresAt}); err != nil {
+			return err
+		}
+		swapped = true
+		return nil
+	})
+	return swapped, err
+}
+
+// Set a key only if it doesn't exist. Returns false if it already did
+func (s *Store) SetIfAbsent(tenant string, key string, value string) (bool, error) {
+	set := false
+	err := s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		if e != nil {
+			return nil
+		}
+		if err := s.put(sh, k, &entry{value: value}); err != nil {
+			return err
+		}
+		set = true
+		return nil
+	})
+	return set, err
+}
+
+// Append a value to the end of a list, creating it if needed. Returns the new length
+func (s *Store) ListPush(tenant string, key string, value string) (int, error) {
+	length := 0
+	err := s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		if e == nil {
+			length = 1
+			return s.put(sh, k, &entry{kind: kindList, list: []string{value}})
+		}
+		if e.kind != kindList {
+			return ErrWrongType
+		}
+
+		if err := s.reserve(k, 0, int64(len(value))); err != nil {
+			return err
+		}
+		e.list = append(e.list, value)
+		length = len(e.list)
+		return nil
+	})
+	return length, err
+}
+
+// Remove and return the first value of a list, so that lists can be used as queues.
+// Returns ErrNotFound if the list is empty. Empty lists are deleted
+func (s *Store) ListPop(tenant string, key string) (string, error) {
+	var value string
+	err := s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		if e == nil {
+			return ErrNotFound
+		}
+		if e.kind != kindList {
+			return ErrWrongType
+		}
+
+		value = e.list[0]
+		if len(e.list) == 1 {
+			s.remove(sh, k, e)
+			return nil
+		}
+
+		e.list[0] = ""
+		e.list = e.list[1:]
+		s.reserve(k, 0, -int64(len(value)))
+		return nil
+	})
+	return value, err
+}
+
+// Get the values of a list from start to stop, inclusive.
+// Negative indexes count from the end, so 0, -1 is the whole list
+func (s *Store) ListRange(tenant string, key string, start int, stop int) ([]string, error) {
+	var values []string
+	err := s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		if e == nil {
+			return nil
+		}
+		if e.kind != kindList {
+			return ErrWrongType
+		}
+
+		length := len(e.list)
+		if start < 0 {
+			start = max(length+start, 0)
+		}
+		if stop < 0 {
+			stop = length + stop
+		}
+		stop = min(stop, length-1)
+		if start > stop {
+			return nil
+		}
+
+		values = append([]string(nil), e.list[start:stop+1]...)
+		return nil
+	})
+	if values == nil {
+		values = []string{}
+	}
+	return values, err
+}
+
+// Get a field of a hash. Returns false if the hash or field doesn't exist
+func (s *Store) HashGet(tenant string, key string, field string) (string, bool, error) {
+	var value string
+	found := false
+	err := s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		if e == nil {
+			return nil
+		}
+		if e.kind != kindHash {
+			return ErrWrongType
+		}
+		value, found = e.hash[field]
+		return nil
+	})
+	return value, found, err
+}
+
+// Set a field of a hash, creating it if needed
+func (s *Store) HashSet(tenant string, key string, field string, value string) error {
+	return s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		if e == nil {
+			return s.put(sh, k, &entry{kind: kindHash, hash: map[string]string{field: value}})
+		}
+		if e.kind != kindHash {
+			return ErrWrongType
+		}
+
+		delta := int64(len(field) + len(value))
+		if prev, ok := e.hash[field]; ok {
+			delta -= int64(len(field) + len(prev))
+		}
+		if err := s.reserve(k, 0, delta); err != nil {
+			return err
+		}
+		e.hash[field] = value
+		return nil
+	})
+}
+
+// Get every field of a hash
+func (s *Store) HashGetAll(tenant string, key string) (map[string]string, error) {
+	fields := make(map[string]string)
+	err := s.withKey(tenant, key, func(sh *shard, k entryKey, e *entry) error {
+		if e == nil {
+			return nil
+		}
+		if e.kind != kindHash {
+			return ErrWrongType
+		}
+		maps.Copy(fields, e.hash)
+		return nil
+	})
+	return fields, err
+}
@@ -0,0 +1,173 @@
+package kv
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIncrBy(t *testing.T) {
+	s, _ := newTestStore(t, Config{})
+
+	for _, step := range []struct {
+		delta int64
+		want  int64
+	}{{5, 5}, {-7, -2}, {0, -2}} {
+		if value, err := s.IncrBy("t", "counter", step.delta); err != nil || value != step.want {
+			t.Errorf("Expected IncrBy %d to return %d, got %d, %v", step.delta, step.want, value, err)
+		}
+	}
+	if value, _, _ := s.Get("t", "counter"); value != "-2" {
+		t.Errorf("Expected the counter to be stored as a string, got %q", value)
+	}
+
+	s.Set("t", "word", "abc", 0)
+	if _, err := s.IncrBy("t", "word", 1); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType for a value that isn't an integer, got %v", err)
+	}
+	s.ListPush("t", "list", "1")
+	if _, err := s.IncrBy("t", "list", 1); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType for a list, got %v", err)
+	}
+}
+
+func TestIncrByOverflow(t *testing.T) {
+	s, _ := newTestStore(t, Config{})
+
+	s.IncrBy("t", "max", math.MaxInt64-1)
+	if value, err := s.IncrBy("t", "max", 1); err != nil || value != math.MaxInt64 {
+		t.Errorf("Expected to reach MaxInt64, got %d, %v", value, err)
+	}
+	if _, err := s.IncrBy("t", "max", 1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow past MaxInt64, got %v", err)
+	}
+
+	s.IncrBy("t", "min", math.MinInt64)
+	if _, err := s.IncrBy("t", "min", -1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow past MinInt64, got %v", err)
+	}
+	if _, err := s.IncrBy("t", "min", math.MinInt64); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow when adding MinInt64 to MinInt64, got %v", err)
+	}
+
+	// the counters are left alone
+	if value, _, _ := s.Get("t", "max"); value != "9223372036854775807" {
+		t.Errorf("Expected the counter to stay at MaxInt64, got %q", value)
+	}
+	if value, _, _ := s.Get("t", "min"); value != "-9223372036854775808" {
+		t.Errorf("Expected the counter to stay at MinInt64, got %q", value)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	s, clock := newTestStore(t, Config{})
+
+	if swapped, err := s.CompareAndSwap("t", "key", "", "v"); err != nil || swapped {
+		t.Errorf("Expected a missing key not to be swapped, got %v, %v", swapped, err)
+	}
+	if _, ok, _ := s.Get("t", "key"); ok {
+		t.Errorf("Expected CompareAndSwap not to create the key")
+	}
+
+	s.Set("t", "key", "a", time.Second)
+	if swapped, err := s.CompareAndSwap("t", "key", "b", "c"); err != nil || swapped {
+		t.Errorf("Expected a different value not to be swapped, got %v, %v", swapped, err)
+	}
+	if swapped, err := s.CompareAndSwap("t", "key", "a", "b"); err != nil || !swapped {
+		t.Errorf("Expected the value to be swapped, got %v, %v", swapped, err)
+	}
+	if value, _, _ := s.Get("t", "key"); value != "b" {
+		t.Errorf("Expected the swapped value, got %q", value)
+	}
+
+	// the swap keeps the key's TTL
+	clock.Advance(time.Second)
+	if _, ok, _ := s.Get("t", "key"); ok {
+		t.Errorf("Expected CompareAndSwap to keep the key's TTL")
+	}
+
+	s.HashSet("t", "hash", "f", "v")
+	if _, err := s.CompareAndSwap("t", "hash", "", "v"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType for a hash, got %v", err)
+	}
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	s, clock := newTestStore(t, Config{MaxKeys: 1})
+
+	if set, err := s.SetIfAbsent("t", "key", "a"); err != nil || !set {
+		t.Errorf("Expected a missing key to be set, got %v, %v", set, err)
+	}
+	if set, err := s.SetIfAbsent("t", "key", "b"); err != nil || set {
+		t.Errorf("Expected an existing key not to be set, got %v, %v", set, err)
+	}
+	if value, _, _ := s.Get("t", "key"); value != "a" {
+		t.Errorf("Expected the first value to be kept, got %q", value)
+	}
+
+	// quotas apply to new keys
+	if set, err := s.SetIfAbsent("t", "other", "v"); !errors.Is(err, ErrKeyQuota) || set {
+		t.Errorf("Expected ErrKeyQuota, got %v, %v", set, err)
+	}
+
+	// expired keys count as absent
+	s.Expire("t", "key", time.Second)
+	clock.Advance(time.Second)
+	if set, err := s.SetIfAbsent("t", "key", "c"); err != nil || !set {
+		t.Errorf("Expected an expired key to be set, got %v, %v", set, err)
+	}
+	expectUsage(t, s, "t", 1, 4)
+}
+
+func TestHash(t *testing.T) {
+	s, _ := newTestStore(t, Config{MaxBytes: 20})
+
+	if _, found, err := s.HashGet("t", "hash", "f"); err != nil || found {
+		t.Errorf("Expected a missing hash to have no fields, got %v, %v", found, err)
+	}
+	if fields, err := s.HashGetAll("t", "hash"); err != nil || len(fields) != 0 {
+		t.Errorf("Expected a missing hash to be empty, got %v, %v", fields, err)
+	}
+
+	if err := s.HashSet("t", "hash", "a", "1"); err != nil {
+		t.Fatalf("HashSet failed: %v", err)
+	}
+	s.HashSet("t", "hash", "b", "2")
+	s.HashSet("t", "hash", "a", "3")
+
+	if value, found, err := s.HashGet("t", "hash", "a"); err != nil || !found || value != "3" {
+		t.Errorf("Expected field a to be 3, got %q, %v, %v", value, found, err)
+	}
+	if _, found, _ := s.HashGet("t", "hash", "c"); found {
+		t.Errorf("Expected field c not to be found")
+	}
+	fields, err := s.HashGetAll("t", "hash")
+	if want := map[string]string{"a": "3", "b": "2"}; err != nil || !maps.Equal(fields, want) {
+		t.Errorf("Expected fields %v, got %v, %v", want, fields, err)
+	}
+
+	// the returned map is a copy
+	fields["a"] = "changed"
+	if value, _, _ := s.HashGet("t", "hash", "a"); value != "3" {
+		t.Errorf("Expected HashGetAll to return a copy, got %q", value)
+	}
+
+	// replacing a field only counts the difference in size
+	expectUsage(t, s, "t", 1, 8)
+	if err := s.HashSet("t", "hash", "long", "0123456789"); !errors.Is(err, ErrByteQuota) {
+		t.Errorf("Expected ErrByteQuota, got %v", err)
+	}
+
+	s.Set("t", "plain", "v", 0)
+	if _, _, err := s.HashGet("t", "plain", "f"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType from HashGet, got %v", err)
+	}
+	if _, err := s.HashGetAll("t", "plain"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType from HashGetAll, got %v", err)
+	}
+	if err := s.HashSet("t", "plain", "f", "v"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType from HashSet, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	handlerutil "github.com/Cloud-RAMP/wasm-sandbox/internal/handler-util"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// Add handlers for every event the store implements.
+//
+// Returns the map so that it can be chained
+func (s *Store) Register(handlerMap *wasmevents.HandlerMap) *wasmevents.HandlerMap {
+	return handlerMap.
+		AddHandler(wasmevents.SET, s.handleSet).
+		AddHandler(wasmevents.GET, s.handleGet).
+		AddHandler(wasmevents.DEL, s.handleDel).
+		AddHandler(wasmevents.SET_EX, s.handleSetEx).
+		AddHandler(wasmevents.EXPIRE, s.handleExpire).
+		AddHandler(wasmevents.INCR_BY, s.handleIncrBy).
+		AddHandler(wasmevents.COMPARE_AND_SWAP, s.handleCompareAndSwap).
+		AddHandler(wasmevents.SET_IF_ABSENT, s.handleSetIfAbsent).
+		AddHandler(wasmevents.LIST_PUSH, s.handleListPush).
+		AddHandler(wasmevents.LIST_POP, s.handleListPop).
+		AddHandler(wasmevents.LIST_RANGE, s.handleListRange).
+		AddHandler(wasmevents.HASH_GET, s.handleHashGet).
+		AddHandler(wasmevents.HASH_SET, s.handleHashSet).
+		AddHandler(wasmevents.HASH_GET_ALL, s.handleHashGetAll).
+		AddHandler(wasmevents.SCAN, s.handleScan)
+}
+
+// TTLs are sent by the host functions in milliseconds, and must be positive
+func parseTTL(ttl string) (time.Duration, error) {
+	ms, err := strconv.ParseUint(ttl, 10, 32)
+	if err != nil || ms == 0 {
+		return 0, fmt.Errorf("Invalid TTL %q", ttl)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Payload is [key, value]
+func (s *Store) handleSet(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 2); err != nil {
+		return "", err
+	}
+	return "", s.Set(event.InstanceId, event.Payload[0], event.Payload[1], 0)
+}
+
+// Payload is [key]. Missing keys return an empty string
+func (s *Store) handleGet(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	value, _, err := s.Get(event.InstanceId, event.Payload[0])
+	return value, err
+}
+
+// Payload is [key]
+func (s *Store) handleDel(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	s.Delete(event.InstanceId, event.Payload[0])
+	return "", nil
+}
+
+// Payload is [key, value, ttl in milliseconds]
+func (s *Store) handleSetEx(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 3); err != nil {
+		return "", err
+	}
+	ttl, err := parseTTL(event.Payload[2])
+	if err != nil {
+		return "", err
+	}
+	return "", s.Set(event.InstanceId, event.Payload[0], event.Payload[1], ttl)
+}
+
+// Payload is [key, ttl in milliseconds]
+func (s *Store) handleExpire(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 2); err != nil {
+		return "", err
+	}
+	ttl, err := parseTTL(event.Payload[1])
+	if err != nil {
+		return "", err
+	}
+	return "", s.Expire(event.InstanceId, event.Payload[0], ttl)
+}
+
+// Payload is [key, delta]
+func (s *Store) handleIncrBy(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 2); err != nil {
+		return "", err
+	}
+	delta, err := strconv.ParseInt(event.Payload[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Invalid delta %q", event.Payload[1])
+	}
+	value, err := s.IncrBy(event.InstanceId, event.Payload[0], delta)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(value, 10), nil
+}
+
+// Payload is [key, expected, new]
+func (s *Store) handleCompareAndSwap(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 3); err != nil {
+		return "", err
+	}
+	swapped, err := s.CompareAndSwap(event.InstanceId, event.Payload[0], event.Payload[1], event.Payload[2])
+	return strconv.FormatBool(swapped), err
+}
+
+// Payload is [key, value]
+func (s *Store) handleSetIfAbsent(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 2); err != nil {
+		return "", err
+	}
+	set, err := s.SetIfAbsent(event.InstanceId, event.Payload[0], event.Payload[1])
+	return strconv.FormatBool(set), err
+}
+
+// Payload is [key, value]
+func (s *Store) handleListPush(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 2); err != nil {
+		return "", err
+	}
+	length, err := s.ListPush(event.InstanceId, event.Payload[0], event.Payload[1])
+	return strconv.Itoa(length), err
+}
+
+// Payload is [key]
+func (s *Store) handleListPop(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	return s.ListPop(event.InstanceId, event.Payload[0])
+}
+
+// Payload is [key, start, stop]
+func (s *Store) handleListRange(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 3); err != nil {
+		return "", err
+	}
+	start, err := strconv.Atoi(event.Payload[1])
+	if err != nil {
+		return "", fmt.Errorf("Invalid start %q", event.Payload[1])
+	}
+	stop, err := strconv.Atoi(event.Payload[2])
+	if err != nil {
+		return "", fmt.Errorf("Invalid stop %q", event.Payload[2])
+	}
+
+	values, err := s.ListRange(event.InstanceId, event.Payload[0], start, stop)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(values)
+	return string(encoded), err
+}
+
+// Payload is [key, field]
+func (s *Store) handleHashGet(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 2); err != nil {
+		return "", err
+	}
+	value, _, err := s.HashGet(event.InstanceId, event.Payload[0], event.Payload[1])
+	return value, err
+}
+
+// Payload is [key, field, value]
+func (s *Store) handleHashSet(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 3); err != nil {
+		return "", err
+	}
+	return "", s.HashSet(event.InstanceId, event.Payload[0], event.Payload[1], event.Payload[2])
+}
+
+// Payload is [key]
+func (s *Store) handleHashGetAll(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	fields, err := s.HashGetAll(event.InstanceId, event.Payload[0])
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(fields)
+	return string(encoded), err
+}
+
+// Payload is [prefix, cursor, limit], see handlerutil.HandleScan
+func (s *Store) handleScan(event *wasmevents.WASMEventInfo) (string, error) {
+	return handlerutil.HandleScan(event, s.Scan)
+}
@@ -0,0 +1,353 @@
+// In-memory KV store that implements the SET, GET, DEL, SET_EX and EXPIRE events,
+// along with the atomic counter, list and hash events.
+//
+// Keys are namespaced by the InstanceId of the module that sets them, so tenants never see each other's keys
+package kv
+
+import (
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// Returned when a tenant already has MaxKeys keys
+var ErrKeyQuota = errors.New("Tenant has too many keys")
+
+// Returned when a write would take a tenant past MaxBytes
+var ErrByteQuota = errors.New("Tenant is out of storage")
+
+// Returned when a key does not exist, or has expired
+var ErrNotFound = errors.New("Key not found")
+
+// Returned when an operation is used on a key that holds another type of value,
+// for example a list operation on a string
+var ErrWrongType = errors.New("Key holds a different type of value")
+
+// Returned when IncrBy would take a counter past the range of an int64. The counter is left as it was
+var ErrOverflow = errors.New("Increment or decrement would overflow")
+
+type Config struct {
+	// Number of independently locked shards (defaults to 64)
+	Shards int
+
+	// Maximum number of keys per tenant (defaults to 10,000)
+	MaxKeys int64
+
+	// Maximum bytes per tenant, counting both keys and values (defaults to 16MB)
+	MaxBytes int64
+
+	// How often expired keys are removed in the background (defaults to 1 second).
+	// Expired keys are never returned, even before they are removed
+	ExpiryInterval time.Duration
+
+	// Clock used for TTLs (defaults to time.Now)
+	Now func() time.Time
+}
+
+type Store struct {
+	shards []*shard
+	seed   maphash.Seed
+
+	maxKeys  int64
+	maxBytes int64
+	now      func() time.Time
+
+	// Tenant to *usage, so that tenants never wait on each other's quota
+	usage sync.Map
+
+	closeOnce sync.Once
+	quit      chan struct{}
+}
+
+type shard struct {
+	mu      sync.Mutex
+	entries map[entryKey]*entry
+}
+
+type entryKey struct {
+	tenant string
+	key    string
+}
+
+type entryKind int
+
+const (
+	kindString entryKind = iota
+	kindList
+	kindHash
+)
+
+type entry struct {
+	kind entryKind
+
+	// Only the field matching kind is used
+	value string
+	list  []string
+	hash  map[string]string
+
+	// Unix nanoseconds, 0 if the key never expires
+	expiresAt int64
+}
+
+type usage struct {
+	mu sync.Mutex
+
+	// Set once the usage is removed from the store, reserve must then start over with a new one
+	removed bool
+
+	// Every key the tenant has, so that scans don't need to visit every shard
+	keys  map[string]struct{}
+	bytes int64
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now
+}
+
+func (k entryKey) size(e *entry) int64 {
+	size := len(k.key) + len(e.value)
+	for _, item := range e.list {
+		size += len(item)
+	}
+	for field, value := range e.hash {
+		size += len(field) + len(value)
+	}
+	return int64(size)
+}
+
+// Create a store and start its background expiry. Call Close to stop it
+func New(cfg Config) *Store {
+	shards := cfg.Shards
+	if shards <= 0 {
+		shards = 64
+	}
+
+	s := &Store{
+		shards:   make([]*shard, shards),
+		seed:     maphash.MakeSeed(),
+		maxKeys:  cfg.MaxKeys,
+		maxBytes: cfg.MaxBytes,
+		now:      cfg.Now,
+		quit:     make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{entries: make(map[entryKey]*entry)}
+	}
+
+	if s.maxKeys <= 0 {
+		s.maxKeys = 10_000
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = 16 << 20
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+
+	interval := cfg.ExpiryInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go s.expireLoop(interval)
+
+	return s
+}
+
+// Stop the background expiry
+func (s *Store) Close() {
+	s.closeOnce.Do(func() { close(s.quit) })
+}
+
+func (s *Store) shardFor(k entryKey) *shard {
+	return s.shards[maphash.Comparable(s.seed, k)%uint64(len(s.shards))]
+}
+
+// Reserve (or give back, with negative deltas) a tenant's quota.
+// keys is 1 when k is a new key, and -1 when it is being removed
+func (s *Store) reserve(k entryKey, keys int64, bytes int64) error {
+	for {
+		value, _ := s.usage.LoadOrStore(k.tenant, &usage{keys: make(map[string]struct{})})
+		u := value.(*usage)
+		u.mu.Lock()
+		if u.removed {
+			u.mu.Unlock()
+			continue
+		}
+
+		err := s.reserveLocked(k, u, keys, bytes)
+		u.mu.Unlock()
+		return err
+	}
+}
+
+func (s *Store) reserveLocked(k entryKey, u *usage, keys int64, bytes int64) error {
+	if keys > 0 && int64(len(u.keys))+keys > s.maxKeys {
+		return ErrKeyQuota
+	}
+	if bytes > 0 && u.bytes+bytes > s.maxBytes {
+		return ErrByteQuota
+	}
+
+	if keys > 0 {
+		u.keys[k.key] = struct{}{}
+	} else if keys < 0 {
+		delete(u.keys, k.key)
+	}
+	u.bytes += bytes
+	if len(u.keys) == 0 && u.bytes == 0 {
+		u.removed = true
+		s.usage.CompareAndDelete(k.tenant, u)
+	}
+	return nil
+}
+
+// Run fn with the tenant's usage locked, if it has any
+func (s *Store) withUsage(tenant string, fn func(u *usage)) {
+	value, ok := s.usage.Load(tenant)
+	if !ok {
+		return
+	}
+	u := value.(*usage)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.removed {
+		fn(u)
+	}
+}
+
+// Remove an entry, the shard's lock must be held
+func (s *Store) remove(sh *shard, k entryKey, e *entry) {
+	delete(sh.entries, k)
+	s.reserve(k, -1, -k.size(e))
+}
+
+// Look up a live entry, removing it if it has expired. The shard's lock must be held
+func (s *Store) lookup(sh *shard, k entryKey) (*entry, bool) {
+	e, ok := sh.entries[k]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(s.now().UnixNano()) {
+		s.remove(sh, k, e)
+		return nil, false
+	}
+	return e, true
+}
+
+// Store a new value for a key, the shard's lock must be held
+func (s *Store) put(sh *shard, k entryKey, next *entry) error {
+	prev, exists := s.lookup(sh, k)
+
+	keys := int64(1)
+	bytes := k.size(next)
+	if exists {
+		keys = 0
+		bytes -= k.size(prev)
+	}
+
+	if err := s.reserve(k, keys, bytes); err != nil {
+		return err
+	}
+	sh.entries[k] = next
+	return nil
+}
+
+func (s *Store) expiresAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return s.now().Add(ttl).UnixNano()
+}
+
+// Get the value of a key. Returns false if it doesn't exist
+func (s *Store) Get(tenant string, key string) (string, bool, error) {
+	k := entryKey{tenant, key}
+	sh := s.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := s.lookup(sh, k)
+	if !ok {
+		return "", false, nil
+	}
+	if e.kind != kindString {
+		return "", false, ErrWrongType
+	}
+	return e.value, true, nil
+}
+
+// Set the value of a key, replacing any type of value it held. A ttl of 0 means the key never expires
+func (s *Store) Set(tenant string, key string, value string, ttl time.Duration) error {
+	k := entryKey{tenant, key}
+	sh := s.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return s.put(sh, k, &entry{value: value, expiresAt: s.expiresAt(ttl)})
+}
+
+// Delete a key. Returns false if it didn't exist
+func (s *Store) Delete(tenant string, key string) bool {
+	k := entryKey{tenant, key}
+	sh := s.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := s.lookup(sh, k)
+	if ok {
+		s.remove(sh, k, e)
+	}
+	return ok
+}
+
+// Change the TTL of an existing key. A ttl of 0 removes the expiry
+func (s *Store) Expire(tenant string, key string, ttl time.Duration) error {
+	k := entryKey{tenant, key}
+	sh := s.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := s.lookup(sh, k)
+	if !ok {
+		return ErrNotFound
+	}
+	e.expiresAt = s.expiresAt(ttl)
+	return nil
+}
+
+// Number of keys and bytes that a tenant is using, including expired keys that haven't been removed yet
+func (s *Store) Usage(tenant string) (keys int64, bytes int64) {
+	s.withUsage(tenant, func(u *usage) {
+		keys, bytes = int64(len(u.keys)), u.bytes
+	})
+	return keys, bytes
+}
+
+func (s *Store) expireLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.removeExpired()
+		}
+	}
+}
+
+// Sweep every shard for expired keys, one shard at a time
+func (s *Store) removeExpired() {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		now := s.now().UnixNano()
+		for k, e := range sh.entries {
+			if e.expired(now) {
+				s.remove(sh, k, e)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
@@ -0,0 +1,192 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Clock that only moves when the test says so
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Store with a test clock, whose background expiry never runs on its own
+func newTestStore(t *testing.T, cfg Config) (*Store, *testClock) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	cfg.Now = clock.Now
+	cfg.ExpiryInterval = time.Hour
+	s := New(cfg)
+	t.Cleanup(s.Close)
+	return s, clock
+}
+
+func expectUsage(t *testing.T, s *Store, tenant string, keys int64, bytes int64) {
+	t.Helper()
+	if k, b := s.Usage(tenant); k != keys || b != bytes {
+		t.Errorf("Expected %s to use %d keys and %d bytes, got %d and %d", tenant, keys, bytes, k, b)
+	}
+}
+
+func TestTTLExpiry(t *testing.T) {
+	s, clock := newTestStore(t, Config{})
+
+	s.Set("t", "short", "v", time.Second)
+	s.Set("t", "long", "v", time.Minute)
+	s.Set("t", "forever", "v", 0)
+
+	clock.Advance(time.Second - time.Nanosecond)
+	if _, ok, _ := s.Get("t", "short"); !ok {
+		t.Errorf("Expected the key to live until its TTL")
+	}
+
+	clock.Advance(time.Nanosecond)
+	if _, ok, _ := s.Get("t", "short"); ok {
+		t.Errorf("Expected the key to be gone once its TTL passed")
+	}
+	if err := s.Expire("t", "short", time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an expired key, got %v", err)
+	}
+	expectUsage(t, s, "t", 2, 13)
+
+	// a TTL of 0 removes the expiry
+	if err := s.Expire("t", "long", 0); err != nil {
+		t.Fatalf("Failed to remove the expiry: %v", err)
+	}
+	if err := s.Expire("t", "forever", time.Second); err != nil {
+		t.Fatalf("Failed to add an expiry: %v", err)
+	}
+	clock.Advance(time.Hour)
+
+	// expired keys keep their quota until they are looked up or swept
+	expectUsage(t, s, "t", 2, 13)
+	s.removeExpired()
+	expectUsage(t, s, "t", 1, 5)
+	if _, ok, _ := s.Get("t", "long"); !ok {
+		t.Errorf("Expected the key whose expiry was removed to live on")
+	}
+}
+
+func TestTTLIsKeptByUpdates(t *testing.T) {
+	s, clock := newTestStore(t, Config{})
+
+	s.Set("t", "counter", "1", time.Second)
+	if _, err := s.IncrBy("t", "counter", 1); err != nil {
+		t.Fatalf("IncrBy failed: %v", err)
+	}
+	// SET replaces the TTL along with the value
+	s.Set("t", "plain", "v", time.Second)
+	s.Set("t", "plain", "v", 0)
+
+	clock.Advance(time.Second)
+	if _, ok, _ := s.Get("t", "counter"); ok {
+		t.Errorf("Expected IncrBy to keep the key's TTL")
+	}
+	if _, ok, _ := s.Get("t", "plain"); !ok {
+		t.Errorf("Expected SET to clear the key's TTL")
+	}
+}
+
+func TestKeyQuota(t *testing.T) {
+	s, _ := newTestStore(t, Config{MaxKeys: 2})
+
+	s.Set("t", "a", "1", 0)
+	s.Set("t", "b", "1", 0)
+	if err := s.Set("t", "c", "1", 0); !errors.Is(err, ErrKeyQuota) {
+		t.Errorf("Expected ErrKeyQuota, got %v", err)
+	}
+	if _, err := s.ListPush("t", "c", "1"); !errors.Is(err, ErrKeyQuota) {
+		t.Errorf("Expected ErrKeyQuota for a new list, got %v", err)
+	}
+
+	// existing keys can still be written, and other tenants have their own quota
+	if err := s.Set("t", "a", "2", 0); err != nil {
+		t.Errorf("Expected an existing key to be replaced, got %v", err)
+	}
+	if err := s.Set("other", "c", "1", 0); err != nil {
+		t.Errorf("Expected another tenant not to be limited, got %v", err)
+	}
+
+	s.Delete("t", "b")
+	if err := s.Set("t", "c", "1", 0); err != nil {
+		t.Errorf("Expected a deleted key to free its quota, got %v", err)
+	}
+	expectUsage(t, s, "t", 2, 4)
+}
+
+func TestByteQuota(t *testing.T) {
+	s, _ := newTestStore(t, Config{MaxBytes: 10})
+
+	if err := s.Set("t", "key", "12345", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := s.Set("t", "key", "123456789", 0); !errors.Is(err, ErrByteQuota) {
+		t.Errorf("Expected ErrByteQuota when growing a value, got %v", err)
+	}
+	if value, _, _ := s.Get("t", "key"); value != "12345" {
+		t.Errorf("Expected a refused write to leave the value alone, got %q", value)
+	}
+	// shrinking is always allowed
+	if err := s.Set("t", "key", "1", 0); err != nil {
+		t.Errorf("Expected a smaller value to fit, got %v", err)
+	}
+	expectUsage(t, s, "t", 1, 4)
+
+	s.ListPush("t", "list", "12")
+	if _, err := s.ListPush("t", "list", "1"); !errors.Is(err, ErrByteQuota) {
+		t.Errorf("Expected ErrByteQuota when growing a list, got %v", err)
+	}
+	if err := s.HashSet("t", "list", "f", "v"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	s.ListPop("t", "list")
+	expectUsage(t, s, "t", 1, 4)
+}
+
+// Quotas stay exact while tenants write and delete at the same time
+func TestUsageIsConsistent(t *testing.T) {
+	s, _ := newTestStore(t, Config{MaxKeys: 5})
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// pairs of workers share a tenant
+			tenant := fmt.Sprintf("t%d", i/2)
+			for round := range 200 {
+				key := fmt.Sprintf("%d-%d", i, round%3)
+				if err := s.Set(tenant, key, "v", 0); err != nil && !errors.Is(err, ErrKeyQuota) {
+					t.Errorf("Set failed: %v", err)
+					return
+				}
+				if keys, _ := s.Usage(tenant); keys > 5 {
+					t.Errorf("Tenant %s is over its quota with %d keys", tenant, keys)
+					return
+				}
+				s.Delete(tenant, key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range workers / 2 {
+		expectUsage(t, s, fmt.Sprintf("t%d", i), 0, 0)
+	}
+}
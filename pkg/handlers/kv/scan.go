@@ -0,0 +1,71 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	handlerutil "github.com/Cloud-RAMP/wasm-sandbox/internal/handler-util"
+)
+
+// A key and its value, returned by Scan
+type Pair = handlerutil.Pair
+
+// Get up to limit keys that start with prefix and sort after cursor, along with their values.
+//
+// Returns the cursor for the next page, which is "" once there are no more keys.
+// Lists and hashes are returned as JSON arrays and objects
+func (s *Store) Scan(tenant string, prefix string, cursor string, limit int) ([]Pair, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("Scan limit must be positive")
+	}
+
+	var keys []string
+	s.withUsage(tenant, func(u *usage) {
+		for key := range u.keys {
+			if strings.HasPrefix(key, prefix) && key > cursor {
+				keys = append(keys, key)
+			}
+		}
+	})
+	slices.Sort(keys)
+
+	pairs := make([]Pair, 0, min(limit, len(keys)))
+	for i, key := range keys {
+		if len(pairs) == limit {
+			return pairs, keys[i-1], nil
+		}
+
+		k := entryKey{tenant, key}
+		sh := s.shardFor(k)
+		sh.mu.Lock()
+		e, ok := s.lookup(sh, k)
+		var value string
+		if ok {
+			value = e.encode()
+		}
+		sh.mu.Unlock()
+
+		// the key was removed since the keys were collected
+		if !ok {
+			continue
+		}
+		pairs = append(pairs, Pair{Key: key, Value: value})
+	}
+
+	return pairs, "", nil
+}
+
+// The value of an entry as a single string
+func (e *entry) encode() string {
+	switch e.kind {
+	case kindList:
+		encoded, _ := json.Marshal(e.list)
+		return string(encoded)
+	case kindHash:
+		encoded, _ := json.Marshal(e.hash)
+		return string(encoded)
+	}
+	return e.value
+}
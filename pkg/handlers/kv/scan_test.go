@@ -0,0 +1,56 @@
+package kv
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	s, clock := newTestStore(t, Config{})
+
+	s.Set("t", "user:c", "3", 0)
+	s.Set("t", "user:a", "1", 0)
+	s.Set("t", "user:b", "2", time.Second)
+	s.ListPush("t", "user:d", "x")
+	s.HashSet("t", "user:e", "f", "v")
+	s.Set("t", "other", "v", 0)
+	s.Set("other-tenant", "user:z", "v", 0)
+
+	var pages [][]Pair
+	cursor := ""
+	for {
+		pairs, next, err := s.Scan("t", "user:", cursor, 2)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		pages = append(pages, pairs)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	want := [][]Pair{
+		{{Key: "user:a", Value: "1"}, {Key: "user:b", Value: "2"}},
+		{{Key: "user:c", Value: "3"}, {Key: "user:d", Value: `["x"]`}},
+		{{Key: "user:e", Value: `{"f":"v"}`}},
+	}
+	if !slices.EqualFunc(pages, want, slices.Equal) {
+		t.Errorf("Expected pages %v, got %v", want, pages)
+	}
+
+	// expired keys are skipped
+	clock.Advance(time.Second)
+	pairs, next, err := s.Scan("t", "user:", "", 3)
+	if want := []Pair{{Key: "user:a", Value: "1"}, {Key: "user:c", Value: "3"}, {Key: "user:d", Value: `["x"]`}}; err != nil || !slices.Equal(pairs, want) {
+		t.Errorf("Expected %v without the expired key, got %v, %v", want, pairs, err)
+	}
+	if next != "user:d" {
+		t.Errorf("Expected the cursor to be the last key returned, got %q", next)
+	}
+
+	if _, _, err := s.Scan("t", "", "", 0); err == nil {
+		t.Errorf("Expected a limit of 0 to fail")
+	}
+}
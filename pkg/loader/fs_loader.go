@@ -0,0 +1,239 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+type FSConfig struct {
+	// Directory holding the modules
+	Dir string
+
+	// Settings of modules without a manifest. Fields that a manifest leaves out keep these values
+	Defaults ModuleSettings
+
+	// How often Watch checks the files of loaded modules (defaults to 1 second)
+	PollInterval time.Duration
+}
+
+// Loads modules from a directory.
+//
+// Module IDs are slash separated paths inside it, without the extension: "chat" is read from chat.wasm
+// and "tenant/chat" from tenant/chat.wasm. Settings are read from an optional manifest next to the module
+// (chat.json), holding a JSON encoded ModuleSettings. IDs that would reach outside the directory are
+// refused, including through symlinks
+type FSLoader struct {
+	root         *os.Root
+	defaults     ModuleSettings
+	pollInterval time.Duration
+
+	// Files of every module read so far, as they were when they were read.
+	// IDs that don't match any file are left out, so unknown IDs can't grow it
+	watched map[string]*watchedModule
+	mu      sync.Mutex
+}
+
+type watchedModule struct {
+	wasm     fileVersion
+	manifest fileVersion
+}
+
+// Enough to tell that a file changed without reading it. The zero value is a missing file
+type fileVersion struct {
+	exists  bool
+	size    int64
+	modTime int64
+}
+
+// Implemented by SandboxStore, see FSLoader.Watch
+type Invalidator interface {
+	Invalidate(moduleId string) bool
+}
+
+func NewFSLoader(cfg FSConfig) (*FSLoader, error) {
+	root, err := os.OpenRoot(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open module directory: %w", err)
+	}
+
+	pollInterval := cfg.PollInterval
+	if pollInterval == 0 {
+		pollInterval = time.Second
+	}
+
+	return &FSLoader{
+		root:         root,
+		defaults:     cfg.Defaults,
+		pollInterval: pollInterval,
+		watched:      make(map[string]*watchedModule),
+	}, nil
+}
+
+// LoaderFunction for SandboxStoreCfg
+func (l *FSLoader) Load(ctx context.Context, moduleId string) ([]byte, error) {
+	name, err := modulePath(moduleId)
+	if err != nil {
+		return nil, err
+	}
+
+	version := l.stat(name + ".wasm")
+	wasm, err := l.readFile(name + ".wasm")
+	if err == nil {
+		l.watch(moduleId, func(w *watchedModule) { w.wasm = version })
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrModuleNotFound, moduleId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read module %s: %w", moduleId, err)
+	}
+
+	return wasm, nil
+}
+
+// SettingsFunction for SandboxStoreCfg
+func (l *FSLoader) Settings(ctx context.Context, moduleId string) (*ModuleSettings, error) {
+	name, err := modulePath(moduleId)
+	if err != nil {
+		return nil, err
+	}
+
+	version := l.stat(name + ".json")
+	manifest, err := l.readFile(name + ".json")
+	// settings are looked up before the module is loaded, so only IDs with a module or manifest are watched
+	if err == nil || l.stat(name+".wasm").exists {
+		l.watch(moduleId, func(w *watchedModule) { w.manifest = version })
+	}
+
+	// copy the slices as well, decoding into them would overwrite the defaults
+	settings := l.defaults
+	settings.Capabilities = slices.Clone(settings.Capabilities)
+	settings.WASIConfig.Args = slices.Clone(settings.WASIConfig.Args)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return &settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read manifest of module %s: %w", moduleId, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(manifest))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&settings); err != nil {
+		return nil, fmt.Errorf("Invalid manifest for module %s: %w", moduleId, err)
+	}
+
+	return &settings, nil
+}
+
+// Sidecar signature of a module (chat.wasm.sig), see the signing package
+func (l *FSLoader) Signature(ctx context.Context, moduleId string) ([]byte, error) {
+	name, err := modulePath(moduleId)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := l.readFile(name + ".wasm.sig")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSignature, moduleId)
+	}
+	return signature, err
+}
+
+// Check the files of every module that was read on the poll interval, and invalidate the modules whose
+// .wasm file or manifest was modified, created or deleted since, so that the store reloads them on their next event.
+//
+// Blocks until ctx is done
+func (l *FSLoader) Watch(ctx context.Context, store Invalidator) {
+	ticker := time.NewTicker(l.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, moduleId := range l.poll() {
+			if store.Invalidate(moduleId) {
+				slog.Info("Module changed, reloading it on its next event", "moduleId", moduleId)
+			}
+		}
+	}
+}
+
+func (l *FSLoader) Close() error {
+	return l.root.Close()
+}
+
+// IDs of the modules whose files changed since the last time they were read or polled
+func (l *FSLoader) poll() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var changed []string
+	for moduleId, w := range l.watched {
+		name, _ := modulePath(moduleId)
+		wasm := l.stat(name + ".wasm")
+		manifest := l.stat(name + ".json")
+		if wasm != w.wasm || manifest != w.manifest {
+			w.wasm = wasm
+			w.manifest = manifest
+			changed = append(changed, moduleId)
+		}
+	}
+
+	return changed
+}
+
+func (l *FSLoader) watch(moduleId string, update func(w *watchedModule)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// both files are recorded straight away, or a poll between Settings and Load would see the other one change
+	w, ok := l.watched[moduleId]
+	if !ok {
+		name, _ := modulePath(moduleId)
+		w = &watchedModule{wasm: l.stat(name + ".wasm"), manifest: l.stat(name + ".json")}
+		l.watched[moduleId] = w
+	}
+	update(w)
+}
+
+func (l *FSLoader) stat(name string) fileVersion {
+	info, err := l.root.Stat(name)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{exists: true, size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+func (l *FSLoader) readFile(name string) ([]byte, error) {
+	f, err := l.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// Path of a module inside the directory, without the extension
+func modulePath(moduleId string) (string, error) {
+	if !validModuleId(moduleId) {
+		return "", fmt.Errorf("%w %q", ErrInvalidModuleId, moduleId)
+	}
+	return filepath.FromSlash(moduleId), nil
+}
@@ -0,0 +1,191 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func newTestFSLoader(t *testing.T, cfg FSConfig) *FSLoader {
+	t.Helper()
+	l, err := NewFSLoader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create loader: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestFSLoaderLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "chat.wasm"), "chat")
+	writeFile(t, filepath.Join(dir, "tenant", "chat.wasm"), "tenant chat")
+	l := newTestFSLoader(t, FSConfig{Dir: dir})
+
+	for moduleId, expected := range map[string]string{"chat": "chat", "tenant/chat": "tenant chat"} {
+		wasm, err := l.Load(context.Background(), moduleId)
+		if err != nil || string(wasm) != expected {
+			t.Errorf("Expected %s to load %q, got %q, %v", moduleId, expected, wasm, err)
+		}
+	}
+	if _, err := l.Load(context.Background(), "missing"); !errors.Is(err, ErrModuleNotFound) {
+		t.Errorf("Expected ErrModuleNotFound, got %v", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "chat.wasm.sig"), "signature")
+	if sig, err := l.Signature(context.Background(), "chat"); err != nil || string(sig) != "signature" {
+		t.Errorf("Expected the sidecar signature, got %q, %v", sig, err)
+	}
+	if _, err := l.Signature(context.Background(), "tenant/chat"); !errors.Is(err, ErrNoSignature) {
+		t.Errorf("Expected ErrNoSignature, got %v", err)
+	}
+}
+
+func TestFSLoaderRefusesEscapes(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "modules")
+	writeFile(t, filepath.Join(base, "x.wasm"), "outside")
+	writeFile(t, filepath.Join(base, "outside", "x.wasm"), "outside")
+	writeFile(t, filepath.Join(dir, "ok.wasm"), "inside")
+
+	if err := os.Symlink(filepath.Join(base, "x.wasm"), filepath.Join(dir, "link.wasm")); err != nil {
+		t.Skipf("Symlinks aren't supported: %v", err)
+	}
+	os.Symlink(filepath.Join(base, "outside"), filepath.Join(dir, "linked"))
+	l := newTestFSLoader(t, FSConfig{Dir: dir})
+
+	for _, moduleId := range []string{"../x", "tenant/../../x", "/x", `..\x`, "."} {
+		if _, err := l.Load(context.Background(), moduleId); !errors.Is(err, ErrInvalidModuleId) {
+			t.Errorf("Expected ErrInvalidModuleId for %q, got %v", moduleId, err)
+		}
+		if _, err := l.Settings(context.Background(), moduleId); !errors.Is(err, ErrInvalidModuleId) {
+			t.Errorf("Expected ErrInvalidModuleId for the settings of %q, got %v", moduleId, err)
+		}
+	}
+
+	for _, moduleId := range []string{"link", "linked/x"} {
+		if wasm, err := l.Load(context.Background(), moduleId); err == nil {
+			t.Errorf("Expected %s to be refused, got %q", moduleId, wasm)
+		}
+	}
+}
+
+func TestFSLoaderManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "plain.wasm"), "")
+	writeFile(t, filepath.Join(dir, "custom.wasm"), "")
+	writeFile(t, filepath.Join(dir, "custom.json"), `{"snapshot": true, "capabilities": ["log"], "wasi_config": {"args": ["a"]}}`)
+	writeFile(t, filepath.Join(dir, "unknown.wasm"), "")
+	writeFile(t, filepath.Join(dir, "unknown.json"), `{"snapshots": true}`)
+
+	defaults := ModuleSettings{
+		PoolSize:     3,
+		Capabilities: []string{"broadcast", "get"},
+		WASIConfig:   WASIConfig{Args: []string{"default"}, RandSeed: 7},
+	}
+	l := newTestFSLoader(t, FSConfig{Dir: dir, Defaults: defaults})
+
+	plain, err := l.Settings(context.Background(), "plain")
+	if err != nil || plain.PoolSize != 3 || !slices.Equal(plain.Capabilities, defaults.Capabilities) {
+		t.Errorf("Expected the defaults without a manifest, got %+v, %v", plain, err)
+	}
+
+	// fields the manifest sets replace the defaults, the rest keep them
+	custom, err := l.Settings(context.Background(), "custom")
+	if err != nil {
+		t.Fatalf("Failed to read settings: %v", err)
+	}
+	if !custom.Snapshot || custom.PoolSize != 3 || custom.WASIConfig.RandSeed != 7 {
+		t.Errorf("Expected the manifest to be merged with the defaults, got %+v", custom)
+	}
+	if !slices.Equal(custom.Capabilities, []string{"log"}) || !slices.Equal(custom.WASIConfig.Args, []string{"a"}) {
+		t.Errorf("Expected the manifest's lists to replace the defaults, got %v and %v", custom.Capabilities, custom.WASIConfig.Args)
+	}
+	if !slices.Equal(l.defaults.Capabilities, []string{"broadcast", "get"}) || !slices.Equal(l.defaults.WASIConfig.Args, []string{"default"}) {
+		t.Errorf("Expected the defaults to be left alone, got %+v", l.defaults)
+	}
+
+	if _, err := l.Settings(context.Background(), "unknown"); err == nil {
+		t.Errorf("Expected a manifest with an unknown field to be refused")
+	}
+}
+
+// Invalidator that sends the modules it is asked to invalidate
+type invalidations chan string
+
+func (i invalidations) Invalidate(moduleId string) bool {
+	i <- moduleId
+	return true
+}
+
+func TestFSLoaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "chat.wasm"), "v1")
+	writeFile(t, filepath.Join(dir, "other.wasm"), "v1")
+	l := newTestFSLoader(t, FSConfig{Dir: dir, PollInterval: time.Millisecond})
+
+	// only modules that were read are watched
+	for _, moduleId := range []string{"chat", "missing", "tenant/missing"} {
+		l.Settings(context.Background(), moduleId)
+		l.Load(context.Background(), moduleId)
+	}
+	l.mu.Lock()
+	if len(l.watched) != 1 || l.watched["chat"] == nil {
+		t.Errorf("Expected only chat to be watched, got %v", l.watched)
+	}
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(invalidations, 8)
+	done := make(chan struct{})
+	go func() {
+		l.Watch(ctx, changed)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	expect := func(action string) {
+		t.Helper()
+		select {
+		case moduleId := <-changed:
+			if moduleId != "chat" {
+				t.Errorf("Expected chat to be invalidated, got %s", moduleId)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected chat to be invalidated after %s", action)
+		}
+	}
+
+	// sizes change too, so this doesn't depend on the resolution of modification times
+	writeFile(t, filepath.Join(dir, "chat.wasm"), "version 2")
+	expect("changing its module")
+	writeFile(t, filepath.Join(dir, "chat.json"), `{"snapshot": true}`)
+	expect("creating its manifest")
+	os.Remove(filepath.Join(dir, "chat.json"))
+	expect("deleting its manifest")
+
+	// modules that weren't read are never invalidated
+	writeFile(t, filepath.Join(dir, "other.wasm"), "version 2")
+	select {
+	case moduleId := <-changed:
+		t.Errorf("Expected nothing else to be invalidated, got %s", moduleId)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
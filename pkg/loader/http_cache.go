@@ -0,0 +1,105 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Objects downloaded by the HTTP loader, kept on disk with their ETag so they can be revalidated.
+//
+// Every object is stored as two files named after the hash of its URL: the body, and a small JSON file with
+// the ETag and the hash of the body. Entries whose body doesn't match are ignored, so a torn write is just a miss
+type diskCache struct {
+	dir string
+}
+
+type cacheMeta struct {
+	URL    string `json:"url"`
+	ETag   string `json:"etag"`
+	SHA256 string `json:"sha256"`
+}
+
+type cacheEntry struct {
+	etag string
+	body []byte
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *diskCache) path(url string) string {
+	return filepath.Join(c.dir, sha256Hex([]byte(url)))
+}
+
+// nil if the cache is disabled or has no valid entry for the URL
+func (c *diskCache) read(url string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+	path := c.path(url)
+
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil || meta.URL != url {
+		return nil
+	}
+
+	body, err := os.ReadFile(path + ".body")
+	if err != nil || sha256Hex(body) != meta.SHA256 {
+		return nil
+	}
+
+	return &cacheEntry{etag: meta.ETag, body: body}
+}
+
+// Errors are ignored, the object is downloaded again next time
+func (c *diskCache) write(url string, etag string, body []byte) {
+	if c == nil || etag == "" {
+		return
+	}
+	path := c.path(url)
+
+	meta, err := json.Marshal(cacheMeta{URL: url, ETag: etag, SHA256: sha256Hex(body)})
+	if err != nil {
+		return
+	}
+	if writeAtomic(path+".body", body) == nil {
+		writeAtomic(path+".json", meta)
+	}
+}
+
+func (c *diskCache) remove(url string) {
+	if c == nil {
+		return
+	}
+	path := c.path(url)
+	os.Remove(path + ".json")
+	os.Remove(path + ".body")
+}
+
+// Write to a temporary file and rename it, so readers never see a partial file
+func writeAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
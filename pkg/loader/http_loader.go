@@ -0,0 +1,286 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type HTTPConfig struct {
+	// URL that module IDs are resolved against: the module "tenant/chat" is fetched from BaseURL/tenant/chat.wasm.
+	// For S3 compatible stores this is the bucket URL, with any prefix
+	BaseURL string
+
+	// Used for every request (defaults to a client with a 30 second timeout).
+	// Set a client with a signing transport for private buckets
+	Client *http.Client
+
+	// Added to every request, such as an Authorization header
+	Header http.Header
+
+	// Keep downloaded modules in this directory and revalidate them with If-None-Match, so unchanged modules
+	// aren't downloaded again. If the server is unavailable, the cached copy is used. Disabled if empty
+	CacheDir string
+
+	// Maximum size of a module (defaults to 16MB)
+	MaxModuleBytes int64
+
+	// Number of retries after a network error, a 5xx or a 429 (defaults to 3, -1 disables retries)
+	MaxRetries int
+
+	// Delay before the first retry, doubled after every attempt (defaults to 200ms)
+	RetryBackoff time.Duration
+
+	// Name of a JSON object under BaseURL mapping module IDs to the hex SHA-256 of their .wasm file,
+	// such as {"tenant/chat": "9f86d0..."}. When set, modules that aren't listed or don't match are refused
+	Manifest string
+}
+
+// Loads modules from a plain HTTP server or an S3 compatible object store
+type HTTPLoader struct {
+	baseURL    string
+	client     *http.Client
+	header     http.Header
+	cache      *diskCache
+	maxBytes   int64
+	maxRetries int
+	backoff    time.Duration
+	manifest   string
+}
+
+// Limit on the size of the manifest
+const maxManifestBytes = 4 << 20
+
+// Limit on the size of a sidecar signature
+const maxSignatureBytes = 4 << 10
+
+// Returned by get when the object doesn't exist, callers turn it into an error for what they were fetching
+var errObjectNotFound = errors.New("Object not found")
+
+func NewHTTPLoader(cfg HTTPConfig) (*HTTPLoader, error) {
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("Invalid base URL %q", cfg.BaseURL)
+	}
+
+	l := &HTTPLoader{
+		baseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		client:     cfg.Client,
+		header:     cfg.Header,
+		maxBytes:   cfg.MaxModuleBytes,
+		maxRetries: cfg.MaxRetries,
+		backoff:    cfg.RetryBackoff,
+		manifest:   cfg.Manifest,
+	}
+	if l.client == nil {
+		l.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if l.maxBytes == 0 {
+		l.maxBytes = 16 << 20
+	}
+	if l.maxRetries == 0 {
+		l.maxRetries = 3
+	}
+	if l.backoff == 0 {
+		l.backoff = 200 * time.Millisecond
+	}
+
+	if cfg.CacheDir != "" {
+		if err := os.MkdirAll(cfg.CacheDir, 0o755); err != nil {
+			return nil, fmt.Errorf("Failed to create cache directory: %w", err)
+		}
+		l.cache = &diskCache{dir: cfg.CacheDir}
+	}
+
+	return l, nil
+}
+
+// LoaderFunction for SandboxStoreCfg
+func (l *HTTPLoader) Load(ctx context.Context, moduleId string) ([]byte, error) {
+	if !validModuleId(moduleId) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidModuleId, moduleId)
+	}
+
+	var digest []byte
+	if l.manifest != "" {
+		digests, err := l.readManifest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		expected, ok := digests[moduleId]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not in the manifest", ErrDigestMismatch, moduleId)
+		}
+		if digest, err = hex.DecodeString(expected); err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("Invalid SHA-256 for module %s in the manifest", moduleId)
+		}
+	}
+
+	target := l.objectURL(moduleId + ".wasm")
+	wasm, err := l.cachedGet(ctx, target, l.maxBytes)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrModuleNotFound, moduleId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load module %s: %w", moduleId, err)
+	}
+
+	if digest != nil {
+		if sum := sha256.Sum256(wasm); !bytes.Equal(sum[:], digest) {
+			// don't keep serving it from the cache when the server is down
+			l.cache.remove(target)
+			return nil, fmt.Errorf("%w: %s has SHA-256 %x, the manifest has %x", ErrDigestMismatch, moduleId, sum, digest)
+		}
+	}
+
+	return wasm, nil
+}
+
+// Sidecar signature of a module (tenant/chat.wasm.sig), see the signing package
+func (l *HTTPLoader) Signature(ctx context.Context, moduleId string) ([]byte, error) {
+	if !validModuleId(moduleId) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidModuleId, moduleId)
+	}
+
+	signature, err := l.cachedGet(ctx, l.objectURL(moduleId+".wasm.sig"), maxSignatureBytes)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSignature, moduleId)
+	}
+	return signature, err
+}
+
+func (l *HTTPLoader) readManifest(ctx context.Context) (map[string]string, error) {
+	data, err := l.cachedGet(ctx, l.objectURL(l.manifest), maxManifestBytes)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, fmt.Errorf("Manifest %s not found", l.manifest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load manifest: %w", err)
+	}
+
+	var digests map[string]string
+	if err := json.Unmarshal(data, &digests); err != nil {
+		return nil, fmt.Errorf("Invalid manifest: %w", err)
+	}
+	return digests, nil
+}
+
+// URL of an object under the base URL, with every path segment escaped
+func (l *HTTPLoader) objectURL(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return l.baseURL + "/" + strings.Join(segments, "/")
+}
+
+// Fetch an object, revalidating the cached copy if there is one
+func (l *HTTPLoader) cachedGet(ctx context.Context, target string, maxBytes int64) ([]byte, error) {
+	cached := l.cache.read(target)
+	etag := ""
+	if cached != nil {
+		etag = cached.etag
+	}
+
+	res, err := l.getWithRetries(ctx, target, etag, maxBytes)
+	switch {
+	case err == nil && res.notModified:
+		return cached.body, nil
+	case err == nil:
+		l.cache.write(target, res.etag, res.body)
+		return res.body, nil
+	case errors.Is(err, ErrUnavailable) && cached != nil:
+		slog.Warn("Using the cached copy of an object", "url", target, "err", err)
+		return cached.body, nil
+	case errors.Is(err, errObjectNotFound):
+		l.cache.remove(target)
+	}
+
+	return nil, err
+}
+
+type response struct {
+	body        []byte
+	etag        string
+	notModified bool
+}
+
+func (l *HTTPLoader) getWithRetries(ctx context.Context, target string, etag string, maxBytes int64) (*response, error) {
+	delay := l.backoff
+	for attempt := 0; ; attempt++ {
+		res, retry, err := l.get(ctx, target, etag, maxBytes)
+		if err == nil || !retry {
+			return res, err
+		}
+		if attempt >= l.maxRetries {
+			return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+
+		// jitter so that stores starting together don't retry together
+		wait := delay + rand.N(delay/2+1)
+		slog.Debug("Retrying module download", "url", target, "attempt", attempt+1, "wait", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// A single attempt. retry is set for errors that may go away, such as network errors and 5xx responses
+func (l *HTTPLoader) get(ctx context.Context, target string, etag string, maxBytes int64) (res *response, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for name, values := range l.header {
+		req.Header[name] = values
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return &response{etag: etag, notModified: true}, false, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, errObjectNotFound
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, true, fmt.Errorf("Server responded with %s", resp.Status)
+	default:
+		return nil, false, fmt.Errorf("Unexpected response %s from %s", resp.Status, target)
+	}
+
+	if resp.ContentLength > maxBytes {
+		return nil, false, fmt.Errorf("%w: %d bytes, the limit is %d", ErrModuleTooLarge, resp.ContentLength, maxBytes)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, false, fmt.Errorf("%w: more than %d bytes", ErrModuleTooLarge, maxBytes)
+	}
+
+	return &response{body: body, etag: resp.Header.Get("ETag")}, false, nil
+}
@@ -0,0 +1,168 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stand-in for a bucket, serving objects with an ETag and counting the responses it sends
+type bucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+
+	// Fail this many requests with a 503 before serving them
+	failures int
+
+	statuses []int
+}
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := http.StatusOK
+	body, ok := b.objects[strings.TrimPrefix(r.URL.Path, "/modules/")]
+	etag := `"` + sha256Hex(body) + `"`
+	switch {
+	case b.failures > 0:
+		b.failures--
+		status = http.StatusServiceUnavailable
+	case !ok:
+		status = http.StatusNotFound
+	case r.Header.Get("If-None-Match") == etag:
+		status = http.StatusNotModified
+	}
+	b.statuses = append(b.statuses, status)
+
+	if status == http.StatusOK {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		w.Write(body)
+	}
+}
+
+func (b *bucket) responses() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	statuses := b.statuses
+	b.statuses = nil
+	return statuses
+}
+
+func newBucket(t *testing.T, objects map[string][]byte) (*bucket, *httptest.Server) {
+	b := &bucket{objects: objects}
+	server := httptest.NewServer(b)
+	t.Cleanup(server.Close)
+	return b, server
+}
+
+func newTestLoader(t *testing.T, server *httptest.Server, cfg HTTPConfig) *HTTPLoader {
+	cfg.BaseURL = server.URL + "/modules/"
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Millisecond
+	}
+	l, err := NewHTTPLoader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to make loader: %v", err)
+	}
+	return l
+}
+
+func TestHTTPCache(t *testing.T) {
+	wasm := []byte("\x00asm module")
+	b, server := newBucket(t, map[string][]byte{"tenant/chat.wasm": wasm})
+	l := newTestLoader(t, server, HTTPConfig{CacheDir: t.TempDir()})
+
+	for i, expected := range []int{http.StatusOK, http.StatusNotModified} {
+		got, err := l.Load(context.Background(), "tenant/chat")
+		if err != nil {
+			t.Fatalf("Load %d failed: %v", i, err)
+		}
+		if !bytes.Equal(got, wasm) {
+			t.Errorf("Load %d returned %q", i, got)
+		}
+		if statuses := b.responses(); len(statuses) != 1 || statuses[0] != expected {
+			t.Errorf("Load %d got responses %v, expected %d", i, statuses, expected)
+		}
+	}
+
+	// the cached copy is used once the retries run out
+	b.failures = 100
+	got, err := l.Load(context.Background(), "tenant/chat")
+	if err != nil || !bytes.Equal(got, wasm) {
+		t.Errorf("Expected the cached module while the server is down, got %q, %v", got, err)
+	}
+	if statuses := b.responses(); len(statuses) != 4 {
+		t.Errorf("Expected 4 attempts, got %v", statuses)
+	}
+}
+
+func TestHTTPRetries(t *testing.T) {
+	b, server := newBucket(t, map[string][]byte{"chat.wasm": []byte("module")})
+	l := newTestLoader(t, server, HTTPConfig{})
+
+	b.failures = 2
+	if _, err := l.Load(context.Background(), "chat"); err != nil {
+		t.Fatalf("Expected the third attempt to succeed: %v", err)
+	}
+
+	b.failures = 4
+	_, err := l.Load(context.Background(), "chat")
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable once the retries run out, got %v", err)
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	wasm := []byte("module")
+	other := sha256.Sum256([]byte("other"))
+	manifest, _ := json.Marshal(map[string]string{
+		"good":  sha256Hex(wasm),
+		"bad":   hex.EncodeToString(other[:]),
+		"large": sha256Hex(wasm),
+	})
+	_, server := newBucket(t, map[string][]byte{
+		"good.wasm":     wasm,
+		"bad.wasm":      wasm,
+		"unlisted.wasm": wasm,
+		"large.wasm":    bytes.Repeat([]byte{0}, 100),
+		"manifest.json": manifest,
+	})
+	l := newTestLoader(t, server, HTTPConfig{Manifest: "manifest.json", MaxModuleBytes: 50})
+
+	for _, test := range []struct {
+		moduleId string
+		err      error
+	}{
+		{"good", nil},
+		{"bad", ErrDigestMismatch},
+		{"unlisted", ErrDigestMismatch},
+		{"large", ErrModuleTooLarge},
+		{"../good", ErrInvalidModuleId},
+	} {
+		_, err := l.Load(context.Background(), test.moduleId)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Loading %s returned %v, expected %v", test.moduleId, err, test.err)
+		}
+	}
+
+	l = newTestLoader(t, server, HTTPConfig{})
+	if _, err := l.Load(context.Background(), "missing"); !errors.Is(err, ErrModuleNotFound) {
+		t.Errorf("Expected ErrModuleNotFound, got %v", err)
+	}
+	if _, err := l.Signature(context.Background(), "good"); !errors.Is(err, ErrNoSignature) {
+		t.Errorf("Expected ErrNoSignature, got %v", err)
+	}
+}
@@ -2,11 +2,35 @@ package loader
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"strings"
 
 	"github.com/tetratelabs/wazero"
 )
 
+// The loaders in this package wrap these errors with the details. The store returns load errors as they are,
+// so callers of ExecuteOnModule can check for them with errors.Is
+
+// Returned when there is no module with the ID
+var ErrModuleNotFound = errors.New("Module not found")
+
+// Returned for IDs that can't be mapped to a file or object, such as ones containing ..
+var ErrInvalidModuleId = errors.New("Invalid module ID")
+
+// Returned when a module is larger than the loader's limit
+var ErrModuleTooLarge = errors.New("Module is too large")
+
+// Returned when a module doesn't match the digest in the manifest, or isn't in the manifest at all
+var ErrDigestMismatch = errors.New("Module does not match its digest")
+
+// Returned by the Signature methods of loaders for modules without a sidecar signature
+var ErrNoSignature = errors.New("Module has no signature")
+
+// Returned when the server holding the modules kept failing after every retry
+var ErrUnavailable = errors.New("Module server is unavailable")
+
 type LoaderFunction func(context.Context, string) ([]byte, error)
 
 var loader LoaderFunction = nil
@@ -16,12 +40,17 @@ func SetLoaderFunction(function LoaderFunction) {
 	loader = function
 }
 
-func LoadCompiled(ctx context.Context, runtime wazero.Runtime, moduleId string) (wazero.CompiledModule, error) {
+// Get the raw bytes of a module with the loader function
+func Load(ctx context.Context, moduleId string) ([]byte, error) {
 	if loader == nil {
 		return nil, fmt.Errorf("Loader function is not defined!")
 	}
 
-	bytes, err := loader(ctx, moduleId)
+	return loader(ctx, moduleId)
+}
+
+func LoadCompiled(ctx context.Context, runtime wazero.Runtime, moduleId string) (wazero.CompiledModule, error) {
+	bytes, err := Load(ctx, moduleId)
 	if err != nil {
 		return nil, err
 	}
@@ -33,3 +62,8 @@ func LoadCompiled(ctx context.Context, runtime wazero.Runtime, moduleId string)
 
 	return compiled, nil
 }
+
+// Module IDs are slash separated paths, which can't leave the directory or bucket they are looked up in
+func validModuleId(moduleId string) bool {
+	return fs.ValidPath(moduleId) && moduleId != "." && !strings.Contains(moduleId, `\`)
+}
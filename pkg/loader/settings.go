@@ -0,0 +1,65 @@
+package loader
+
+import "context"
+
+// Settings that apply to a single module.
+//
+// These are looked up by the store when a module is loaded, so different tenants
+// can opt in to different features. The zero value is the default for every module
+type ModuleSettings struct {
+	// Opt in to WASI (wasi_snapshot_preview1) for this module.
+	//
+	// Modules that import WASI without opting in are refused at load time.
+	// Reactors have _initialize run on every new instance, commands have _start run instead,
+	// but are refused if it exits (e.g. with proc_exit) rather than returning
+	WASI bool `json:"wasi"`
+
+	// Only used when WASI is enabled
+	WASIConfig WASIConfig `json:"wasi_config"`
+
+	// Initialize one instance, snapshot its memory and globals, and create the rest of the pool
+	// (and any replacement instances) from the snapshot instead of running the init code again.
+	//
+	// If the module exports __warmup, it is called before the snapshot is taken
+	Snapshot bool `json:"snapshot"`
+
+	// Reset each instance to the snapshot after every call, so no state carries over between events.
+	// Implies Snapshot
+	Stateless bool `json:"stateless"`
+
+	// Number of instances kept in the module's pool (the store's PoolSize if 0)
+	PoolSize uint8 `json:"pool_size"`
+
+	// Memory limit of each instance, in 64KB pages.
+	// Can only lower the store's MemoryLimitPages, which is used if 0
+	MemoryLimitPages uint32 `json:"memory_limit_pages"`
+
+	// Host functions the module is allowed to import, by their import names such as "broadcast" or "dbSet".
+	// Modules importing anything else are refused at load time. abort is always allowed.
+	//
+	// Every host function is allowed if nil
+	Capabilities []string `json:"capabilities"`
+}
+
+// The WASI sandbox is locked down by default:
+// no filesystem preopens, no environment variables, fake clocks and a seeded random source.
+//
+// stdout and stderr are always routed to the LOG and DEBUG handlers
+type WASIConfig struct {
+	// Arguments visible to the guest through args_get
+	Args []string `json:"args"`
+
+	// Seed for random_get. Every instance of the module reads the same sequence of bytes
+	RandSeed int64 `json:"rand_seed"`
+
+	// Expose the host's wall and monotonic clocks instead of the deterministic fake ones
+	HostClock bool `json:"host_clock"`
+}
+
+// Function to look up the settings of a module by its ID
+type SettingsFunction func(context.Context, string) (*ModuleSettings, error)
+
+// Settings used when no settings function is configured
+func DefaultSettingsFunction(ctx context.Context, moduleId string) (*ModuleSettings, error) {
+	return &ModuleSettings{}, nil
+}
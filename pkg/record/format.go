@@ -0,0 +1,174 @@
+// Records the inputs and host calls of executions, and replays them so that an execution can be
+// reproduced locally with the exact responses it got in production.
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+A recording starts with the 4 byte magic "WSRC" and a version byte, followed by records.
+Each record starts with its kind:
+  - kindInput: the WS event that started an execution
+  - kindCall: a host call, with the response and error the handler returned
+  - kindBatch: the ON_MESSAGE events of a room that a batch execution handled with one guest call
+  - kindHTTP: the HTTP request that started an execution
+  - kindCommit: the DB writes an execution's transaction applied once the guest returned
+
+IDs (execution, instance, connection and room) repeat a lot, so they are interned: the first time an ID is written
+it is encoded as a 0 followed by the string, and every later time as its index + 1 in the order IDs were first written.
+
+Strings are a uvarint length followed by the bytes, other numbers are (u)varints.
+Input records are [execution ID, instance ID, connection ID, room ID, event type, payload, timestamp].
+Call records are [execution ID, instance ID, connection ID, room ID, event type, payload count, payload..., timestamp, response, has error, error].
+Batch records are [execution ID, instance ID, connection ID (always empty), room ID, event count, then connection ID, payload, timestamp per event]
+HTTP records are [execution ID, instance ID, connection ID and room ID (always empty), method, path, query, body, header count, then name, value per header].
+Commit records are [execution ID, instance ID, connection ID and room ID (always empty), write count, then is delete, key, value per write, has error, error].
+*/
+
+const (
+	magic   = "WSRC"
+	version = 1
+
+	kindInput  byte = 1
+	kindCall   byte = 2
+	kindBatch  byte = 3
+	kindHTTP   byte = 4
+	kindCommit byte = 5
+)
+
+// Strings longer than this are treated as corruption
+const maxStringBytes = 64 << 20
+
+var ErrBadRecording = errors.New("Not a recording, or an unsupported version")
+
+type encoder struct {
+	w   *bufio.Writer
+	ids map[string]uint64
+	buf []byte
+}
+
+func newEncoder(w io.Writer) *encoder {
+	return &encoder{w: bufio.NewWriter(w), ids: make(map[string]uint64)}
+}
+
+func (e *encoder) header() error {
+	if _, err := e.w.WriteString(magic); err != nil {
+		return err
+	}
+	return e.w.WriteByte(version)
+}
+
+func (e *encoder) uvarint(n uint64) {
+	e.buf = binary.AppendUvarint(e.buf, n)
+}
+
+func (e *encoder) varint(n int64) {
+	e.buf = binary.AppendVarint(e.buf, n)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) id(s string) {
+	if index, ok := e.ids[s]; ok {
+		e.uvarint(index + 1)
+		return
+	}
+	e.ids[s] = uint64(len(e.ids))
+	e.uvarint(0)
+	e.string(s)
+}
+
+// Write whether there was an error, and its message if there was
+func (e *encoder) errorField(err error) {
+	if err != nil {
+		e.buf = append(e.buf, 1)
+		e.string(err.Error())
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// Write the record built up in buf
+func (e *encoder) flushRecord() error {
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
+
+type decoder struct {
+	r   *bufio.Reader
+	ids []string
+}
+
+func newDecoder(r io.Reader) (*decoder, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return nil, ErrBadRecording
+	}
+	if string(header[:len(magic)]) != magic || header[len(magic)] != version {
+		return nil, ErrBadRecording
+	}
+	return d, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	return binary.ReadUvarint(d.r)
+}
+
+func (d *decoder) varint() (int64, error) {
+	return binary.ReadVarint(d.r)
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > maxStringBytes {
+		return "", fmt.Errorf("String of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Inverse of encoder.errorField
+func (d *decoder) errorField() (hasError bool, message string, err error) {
+	flag, err := d.r.ReadByte()
+	if err != nil || flag != 1 {
+		return false, "", err
+	}
+	message, err = d.string()
+	return true, message, err
+}
+
+func (d *decoder) id() (string, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		s, err := d.string()
+		if err != nil {
+			return "", err
+		}
+		d.ids = append(d.ids, s)
+		return s, nil
+	}
+	if n > uint64(len(d.ids)) {
+		return "", fmt.Errorf("Unknown ID %d", n)
+	}
+	return d.ids[n-1], nil
+}
@@ -0,0 +1,164 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Calls to events without a handler are recorded with the error they got, and replay the same way
+func TestWrapRecordsMissingHandlers(t *testing.T) {
+	var buf bytes.Buffer
+	recorder, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("Failed to start recording: %v", err)
+	}
+
+	handlerMap := wasmevents.NewHandlerMap().AddHandler(wasmevents.GET, func(event *wasmevents.WASMEventInfo) (string, error) {
+		return "value", nil
+	})
+	recorder.Wrap(handlerMap)
+
+	recorder.RecordInput("exec", &wsevents.WSEventInfo{InstanceId: "m", EventType: wsevents.ON_MESSAGE, Payload: "hi"})
+	calls := []*wasmevents.WASMEventInfo{
+		{ExecutionId: "exec", InstanceId: "m", EventType: wasmevents.GET, Payload: []string{"key"}},
+		{ExecutionId: "exec", InstanceId: "m", EventType: wasmevents.DB_GET, Payload: []string{"key"}},
+	}
+	var missingErr error
+	for _, event := range calls {
+		_, missingErr = handlerMap.CallHandler(event)
+	}
+	if missingErr == nil {
+		t.Fatalf("Expected the call without a handler to fail")
+	}
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	rec, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	execution, ok := rec.Execution("exec")
+	if !ok || len(execution.Calls) != 2 {
+		t.Fatalf("Expected an execution with 2 calls, got %+v", execution)
+	}
+	if call := execution.Calls[0]; call.Response != "value" || call.HasError {
+		t.Errorf("Unexpected first call %+v", call)
+	}
+	if call := execution.Calls[1]; !call.HasError || call.Error != missingErr.Error() {
+		t.Errorf("Expected the second call to have failed with %q, got %+v", missingErr, call)
+	}
+
+	replay := execution.Replay()
+	handlers := replay.Handlers()
+	if res, err := handlers.CallHandler(calls[0]); res != "value" || err != nil {
+		t.Errorf("Expected the first call to replay %q, got %q, %v", "value", res, err)
+	}
+	if _, err := handlers.CallHandler(calls[1]); err == nil || err.Error() != missingErr.Error() {
+		t.Errorf("Expected the second call to replay %q, got %v", missingErr, err)
+	}
+	if _, err := handlers.CallHandler(calls[1]); err == nil {
+		t.Errorf("Expected a call past the end of the recording to fail")
+	}
+	if err := replay.Err(); err == nil {
+		t.Errorf("Expected the extra call to make the replay diverge")
+	}
+}
+
+// Replaying one of two executions that ran at the same time only consumes its own calls
+func TestReplayInterleavedExecutions(t *testing.T) {
+	var buf bytes.Buffer
+	recorder, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("Failed to start recording: %v", err)
+	}
+
+	handlerMap := wasmevents.NewHandlerMap().AddHandler(wasmevents.GET, func(event *wasmevents.WASMEventInfo) (string, error) {
+		return event.ExecutionId + ":" + event.Payload[0], nil
+	})
+	recorder.Wrap(handlerMap)
+
+	get := func(executionId string, key string) *wasmevents.WASMEventInfo {
+		return &wasmevents.WASMEventInfo{ExecutionId: executionId, InstanceId: "m", EventType: wasmevents.GET, Payload: []string{key}}
+	}
+	calls := []*wasmevents.WASMEventInfo{get("a", "1"), get("b", "1"), get("b", "2"), get("a", "2"), get("b", "3")}
+	for _, event := range calls {
+		handlerMap.CallHandler(event)
+	}
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	rec, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		execution, _ := rec.Execution(id)
+		replay := execution.Replay()
+		handlers := replay.Handlers()
+
+		// both executions call again in the same interleaved order
+		for _, event := range calls {
+			res, err := handlers.CallHandler(event)
+			switch {
+			case event.ExecutionId != id && err == nil:
+				t.Errorf("Expected the replay of %s to refuse a call from %s", id, event.ExecutionId)
+			case event.ExecutionId == id && (err != nil || res != id+":"+event.Payload[0]):
+				t.Errorf("Expected the replay of %s to answer %s, got %q, %v", id, event.Payload[0], res, err)
+			}
+		}
+		if err := replay.Err(); err != nil {
+			t.Errorf("Expected the replay of %s to match the recording, got %v", id, err)
+		}
+	}
+}
+
+func TestRecordHTTPAndCommit(t *testing.T) {
+	var buf bytes.Buffer
+	recorder, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("Failed to start recording: %v", err)
+	}
+
+	req := &HTTPRequest{
+		InstanceId: "m",
+		Method:     "POST",
+		Path:       "/path",
+		Query:      "q=1",
+		Body:       "body",
+		Header:     http.Header{"B": {"1", "2"}, "A": {"3"}},
+	}
+	writes := []wasmevents.DBWrite{{Key: "k", Value: "v"}, {Delete: true, Key: "d"}}
+	recorder.RecordHTTP("http", req)
+	recorder.RecordCommit("http", "m", writes, nil)
+	recorder.RecordCommit("failed", "m", writes[:1], errors.New("disk full"))
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	rec, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+
+	execution, _ := rec.Execution("http")
+	if !reflect.DeepEqual(execution.HTTP, req) {
+		t.Errorf("Expected request %+v, got %+v", req, execution.HTTP)
+	}
+	if commit := execution.Commit; commit == nil || !slices.Equal(commit.Writes, writes) || commit.HasError {
+		t.Errorf("Expected a commit of %v, got %+v", writes, commit)
+	}
+
+	failed, _ := rec.Execution("failed")
+	if commit := failed.Commit; commit == nil || !commit.HasError || commit.Error != "disk full" {
+		t.Errorf("Expected a failed commit, got %+v", commit)
+	}
+}
@@ -0,0 +1,192 @@
+package record
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Writes executions to a recording. Safe for concurrent use, records of executions that run at the same time
+// are interleaved and told apart by their execution ID
+type Recorder struct {
+	mu  sync.Mutex
+	enc *encoder
+	err error
+}
+
+// Start a recording. Records are buffered, so call Flush once done
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	enc := newEncoder(w)
+	if err := enc.header(); err != nil {
+		return nil, err
+	}
+	return &Recorder{enc: enc}, nil
+}
+
+// Record the event that started an execution
+func (r *Recorder) RecordInput(executionId string, event *wsevents.WSEventInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	e := r.enc
+	e.buf = append(e.buf, kindInput)
+	e.id(executionId)
+	e.id(event.InstanceId)
+	e.id(event.ConnectionId)
+	e.id(event.RoomId)
+	e.uvarint(uint64(event.EventType))
+	e.string(event.Payload)
+	e.varint(event.Timestamp)
+	r.err = e.flushRecord()
+}
+
+// Record the messages of a batch execution, which all have the same module and room
+func (r *Recorder) RecordBatch(executionId string, events []*wsevents.WSEventInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil || len(events) == 0 {
+		return
+	}
+
+	e := r.enc
+	e.buf = append(e.buf, kindBatch)
+	e.id(executionId)
+	e.id(events[0].InstanceId)
+	e.id("")
+	e.id(events[0].RoomId)
+	e.uvarint(uint64(len(events)))
+	for _, event := range events {
+		e.id(event.ConnectionId)
+		e.string(event.Payload)
+		e.varint(event.Timestamp)
+	}
+	r.err = e.flushRecord()
+}
+
+// Record the HTTP request that started an execution (ExecuteHTTP or HTTPHandler)
+func (r *Recorder) RecordHTTP(executionId string, req *HTTPRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	e := r.enc
+	e.buf = append(e.buf, kindHTTP)
+	e.id(executionId)
+	e.id(req.InstanceId)
+	e.id("")
+	e.id("")
+	e.string(req.Method)
+	e.string(req.Path)
+	e.string(req.Query)
+	e.string(req.Body)
+	names := slices.Sorted(maps.Keys(req.Header))
+	pairs := 0
+	for _, values := range req.Header {
+		pairs += len(values)
+	}
+	e.uvarint(uint64(pairs))
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			e.string(name)
+			e.string(value)
+		}
+	}
+	r.err = e.flushRecord()
+}
+
+// Record the DB writes that an execution's transaction passed to the TransactionHandler, and the error it returned
+func (r *Recorder) RecordCommit(executionId string, instanceId string, writes []wasmevents.DBWrite, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	e := r.enc
+	e.buf = append(e.buf, kindCommit)
+	e.id(executionId)
+	e.id(instanceId)
+	e.id("")
+	e.id("")
+	e.uvarint(uint64(len(writes)))
+	for _, write := range writes {
+		if write.Delete {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+		e.string(write.Key)
+		e.string(write.Value)
+	}
+	e.errorField(err)
+	r.err = e.flushRecord()
+}
+
+// Record a host call and what its handler returned
+func (r *Recorder) RecordCall(event *wasmevents.WASMEventInfo, response string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	e := r.enc
+	e.buf = append(e.buf, kindCall)
+	e.id(event.ExecutionId)
+	e.id(event.InstanceId)
+	e.id(event.ConnectionId)
+	e.id(event.RoomId)
+	e.uvarint(uint64(event.EventType))
+	e.uvarint(uint64(len(event.Payload)))
+	for _, field := range event.Payload {
+		e.string(field)
+	}
+	e.varint(event.Timestamp)
+	e.string(response)
+	e.errorField(err)
+	r.err = e.flushRecord()
+}
+
+// Replace every handler of a map with one that records its calls.
+//
+// Events without a handler get one that fails like a missing handler does, so those calls are recorded too
+func (r *Recorder) Wrap(handlerMap *wasmevents.HandlerMap) {
+	missing := wasmevents.NewHandlerMap()
+	for eventType := wasmevents.WASMEventType(0); eventType.Valid(); eventType++ {
+		handler, ok := (*handlerMap)[eventType]
+		if !ok {
+			handler = missing.CallHandler
+		}
+		(*handlerMap)[eventType] = func(event *wasmevents.WASMEventInfo) (string, error) {
+			res, err := handler(event)
+			r.RecordCall(event, res, err)
+			return res, err
+		}
+	}
+}
+
+// Write buffered records. Returns the first error the recording hit, after which nothing more is recorded
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.enc.w.Flush()
+	return r.err
+}
@@ -0,0 +1,468 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// A recorded host call
+type Call struct {
+	Event    wasmevents.WASMEventInfo
+	Response string
+
+	// Position of the call among all the calls of the recording, calls of executions that ran at the same time are interleaved
+	Sequence int
+
+	// Message of the error the handler returned, only set if HasError is
+	Error    string
+	HasError bool
+}
+
+type Execution struct {
+	Id string
+
+	// The event that started the execution, nil for executions that weren't started by a single WS event (HTTP requests, batches)
+	Input *wsevents.WSEventInfo
+
+	// The messages of a batch execution (ExecuteBatch with __onMessageBatch), in order
+	Batch []*wsevents.WSEventInfo
+
+	// The request of an HTTP execution (ExecuteHTTP or HTTPHandler)
+	HTTP *HTTPRequest
+
+	// The DB writes that the execution's transaction applied, nil if it applied none or had no TransactionHandler
+	Commit *Commit
+
+	// Host calls in the order they were made
+	Calls []Call
+}
+
+// A request as the module saw it, see store.ExecuteHTTP
+type HTTPRequest struct {
+	InstanceId string
+	Method     string
+	Path       string
+	Query      string
+	Body       string
+	Header     http.Header
+}
+
+// Writes passed to the TransactionHandler when an execution returned
+type Commit struct {
+	Writes []wasmevents.DBWrite
+
+	// Message of the error the TransactionHandler returned, only set if HasError is
+	Error    string
+	HasError bool
+}
+
+type Recording struct {
+	executions map[string]*Execution
+
+	// Execution IDs in the order they first appear
+	order []string
+
+	// Host calls read so far
+	calls int
+}
+
+// Read a recording. A torn record at the end (from a process that didn't flush before exiting) is ignored
+func Read(r io.Reader) (*Recording, error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := newRecording()
+	for {
+		_, err := d.next(rec)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newRecording() *Recording {
+	return &Recording{executions: make(map[string]*Execution)}
+}
+
+// Read the next record into rec, returning it if it was a host call.
+// Returns io.EOF if there are no more records, or io.ErrUnexpectedEOF if the last one is torn
+func (d *decoder) next(rec *Recording) (*Call, error) {
+	kind, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var call *Call
+	switch kind {
+	case kindInput:
+		err = d.readInput(rec)
+	case kindCall:
+		call, err = d.readCall(rec, rec.calls)
+		rec.calls++
+	case kindBatch:
+		err = d.readBatch(rec)
+	case kindHTTP:
+		err = d.readHTTP(rec)
+	case kindCommit:
+		err = d.readCommit(rec)
+	default:
+		return nil, fmt.Errorf("Unknown record kind %d", kind)
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return call, err
+}
+
+// Reads a recording while it is still being written, so that each record is only decoded once
+type Reader struct {
+	src io.Reader
+	d   *decoder
+	rec *Recording
+}
+
+// The reader has to consume what it reads from r, like a bytes.Buffer does
+func NewReader(r io.Reader) *Reader {
+	return &Reader{src: r, rec: newRecording()}
+}
+
+// Read the records written since the last call and return their host calls, in the order they were made.
+// Flush the Recorder first, a torn record can't be resumed and returns io.ErrUnexpectedEOF
+func (r *Reader) Calls() ([]Call, error) {
+	if r.d == nil {
+		d, err := newDecoder(r.src)
+		if err != nil {
+			return nil, err
+		}
+		r.d = d
+	}
+
+	var calls []Call
+	for {
+		call, err := r.d.next(r.rec)
+		if err == io.EOF {
+			return calls, nil
+		}
+		if err != nil {
+			return calls, err
+		}
+		if call != nil {
+			calls = append(calls, *call)
+		}
+	}
+}
+
+func (rec *Recording) execution(id string) *Execution {
+	e, ok := rec.executions[id]
+	if !ok {
+		e = &Execution{Id: id}
+		rec.executions[id] = e
+		rec.order = append(rec.order, id)
+	}
+	return e
+}
+
+// Read the IDs that start both kinds of record
+func (d *decoder) recordIds() (executionId string, instanceId string, connectionId string, roomId string, err error) {
+	if executionId, err = d.id(); err != nil {
+		return
+	}
+	if instanceId, err = d.id(); err != nil {
+		return
+	}
+	if connectionId, err = d.id(); err != nil {
+		return
+	}
+	roomId, err = d.id()
+	return
+}
+
+func (d *decoder) readInput(rec *Recording) error {
+	executionId, instanceId, connectionId, roomId, err := d.recordIds()
+	if err != nil {
+		return err
+	}
+	eventType, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	payload, err := d.string()
+	if err != nil {
+		return err
+	}
+	timestamp, err := d.varint()
+	if err != nil {
+		return err
+	}
+
+	rec.execution(executionId).Input = &wsevents.WSEventInfo{
+		ConnectionId: connectionId,
+		RoomId:       roomId,
+		InstanceId:   instanceId,
+		EventType:    wsevents.WSEventType(eventType),
+		Payload:      payload,
+		Timestamp:    timestamp,
+	}
+	return nil
+}
+
+func (d *decoder) readBatch(rec *Recording) error {
+	executionId, instanceId, _, roomId, err := d.recordIds()
+	if err != nil {
+		return err
+	}
+	count, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if count > maxStringBytes {
+		return fmt.Errorf("Batch of %d events is too long", count)
+	}
+
+	batch := make([]*wsevents.WSEventInfo, 0, count)
+	for range count {
+		connectionId, err := d.id()
+		if err != nil {
+			return err
+		}
+		payload, err := d.string()
+		if err != nil {
+			return err
+		}
+		timestamp, err := d.varint()
+		if err != nil {
+			return err
+		}
+		batch = append(batch, &wsevents.WSEventInfo{
+			ConnectionId: connectionId,
+			RoomId:       roomId,
+			InstanceId:   instanceId,
+			EventType:    wsevents.ON_MESSAGE,
+			Payload:      payload,
+			Timestamp:    timestamp,
+		})
+	}
+
+	rec.execution(executionId).Batch = batch
+	return nil
+}
+
+func (d *decoder) readHTTP(rec *Recording) error {
+	executionId, instanceId, _, _, err := d.recordIds()
+	if err != nil {
+		return err
+	}
+
+	req := &HTTPRequest{InstanceId: instanceId, Header: make(http.Header)}
+	for _, field := range []*string{&req.Method, &req.Path, &req.Query, &req.Body} {
+		if *field, err = d.string(); err != nil {
+			return err
+		}
+	}
+	count, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if count > maxStringBytes {
+		return fmt.Errorf("Request with %d headers is too long", count)
+	}
+	for range count {
+		name, err := d.string()
+		if err != nil {
+			return err
+		}
+		value, err := d.string()
+		if err != nil {
+			return err
+		}
+		req.Header[name] = append(req.Header[name], value)
+	}
+
+	rec.execution(executionId).HTTP = req
+	return nil
+}
+
+func (d *decoder) readCommit(rec *Recording) error {
+	executionId, _, _, _, err := d.recordIds()
+	if err != nil {
+		return err
+	}
+	count, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if count > maxStringBytes {
+		return fmt.Errorf("Commit of %d writes is too long", count)
+	}
+
+	commit := &Commit{Writes: make([]wasmevents.DBWrite, 0, count)}
+	for range count {
+		var write wasmevents.DBWrite
+		isDelete, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		write.Delete = isDelete == 1
+		if write.Key, err = d.string(); err != nil {
+			return err
+		}
+		if write.Value, err = d.string(); err != nil {
+			return err
+		}
+		commit.Writes = append(commit.Writes, write)
+	}
+	if commit.HasError, commit.Error, err = d.errorField(); err != nil {
+		return err
+	}
+
+	rec.execution(executionId).Commit = commit
+	return nil
+}
+
+func (d *decoder) readCall(rec *Recording, sequence int) (*Call, error) {
+	executionId, instanceId, connectionId, roomId, err := d.recordIds()
+	if err != nil {
+		return nil, err
+	}
+	eventType, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	count, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if count > maxStringBytes {
+		return nil, fmt.Errorf("Payload of %d fields is too long", count)
+	}
+	payload := make([]string, 0, count)
+	for range count {
+		field, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, field)
+	}
+	timestamp, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+
+	call := Call{Sequence: sequence, Event: wasmevents.WASMEventInfo{
+		ExecutionId:  executionId,
+		ConnectionId: connectionId,
+		RoomId:       roomId,
+		InstanceId:   instanceId,
+		EventType:    wasmevents.WASMEventType(eventType),
+		Payload:      payload,
+		Timestamp:    timestamp,
+	}}
+	if call.Response, err = d.string(); err != nil {
+		return nil, err
+	}
+	if call.HasError, call.Error, err = d.errorField(); err != nil {
+		return nil, err
+	}
+
+	e := rec.execution(executionId)
+	e.Calls = append(e.Calls, call)
+	return &call, nil
+}
+
+// Every execution, in the order they first appear in the recording
+func (rec *Recording) Executions() []*Execution {
+	executions := make([]*Execution, len(rec.order))
+	for i, id := range rec.order {
+		executions[i] = rec.executions[id]
+	}
+	return executions
+}
+
+func (rec *Recording) Execution(id string) (*Execution, bool) {
+	e, ok := rec.executions[id]
+	return e, ok
+}
+
+// Feeds the recorded responses of an execution back to a module, in order
+type Replay struct {
+	executionId string
+
+	mu    sync.Mutex
+	calls []Call
+	next  int
+	err   error
+}
+
+func (e *Execution) Replay() *Replay {
+	return &Replay{executionId: e.Id, calls: e.Calls}
+}
+
+// Handlers for every event type that return the recorded responses instead of calling real handlers.
+//
+// Each call must match the next recorded one (same event type and payload). Once a call doesn't,
+// the replay has diverged and every call fails, see Err.
+// Calls made by other executions (such as a timer the replayed execution set) fail without affecting the replay
+func (r *Replay) Handlers() *wasmevents.HandlerMap {
+	handlerMap := wasmevents.NewHandlerMap()
+	for eventType := wasmevents.WASMEventType(0); eventType.Valid(); eventType++ {
+		handlerMap.AddHandler(eventType, r.handle)
+	}
+	return handlerMap
+}
+
+func (r *Replay) handle(event *wasmevents.WASMEventInfo) (string, error) {
+	if event.ExecutionId != r.executionId {
+		return "", fmt.Errorf("Execution %q is not part of the replay of %q", event.ExecutionId, r.executionId)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return "", r.err
+	}
+	if r.next >= len(r.calls) {
+		r.err = fmt.Errorf("Replay diverged: call %d (%s) is past the end of the recording", r.next+1, event.EventType.String())
+		return "", r.err
+	}
+
+	call := r.calls[r.next]
+	if call.Event.EventType != event.EventType || !slices.Equal(call.Event.Payload, event.Payload) {
+		r.err = fmt.Errorf("Replay diverged at call %d: module called %s%q, recording has %s%q",
+			r.next+1, event.EventType.String(), event.Payload, call.Event.EventType.String(), call.Event.Payload)
+		return "", r.err
+	}
+
+	r.next++
+	if call.HasError {
+		return call.Response, errors.New(call.Error)
+	}
+	return call.Response, nil
+}
+
+// Returns an error if the replay diverged, or if some recorded calls were never made
+func (r *Replay) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.next < len(r.calls) {
+		return fmt.Errorf("Replay stopped after %d of %d recorded calls", r.next, len(r.calls))
+	}
+	return nil
+}
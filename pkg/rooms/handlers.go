@@ -0,0 +1,94 @@
+package rooms
+
+import (
+	"errors"
+	"strings"
+
+	handlerutil "github.com/Cloud-RAMP/wasm-sandbox/internal/handler-util"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// Add handlers for every event the registry implements.
+//
+// Returns the map so that it can be chained
+func (r *Registry) Register(handlerMap *wasmevents.HandlerMap) *wasmevents.HandlerMap {
+	return handlerMap.
+		AddHandler(wasmevents.GET_USERS, r.handleGetUsers).
+		AddHandler(wasmevents.BROADCAST, r.handleBroadcast).
+		AddHandler(wasmevents.SEND_MESSAGE, r.handleSendMessage).
+		AddHandler(wasmevents.SERVER_MESSAGE, r.handleServerMessage).
+		AddHandler(wasmevents.CLOSE_CONNECTION, r.handleCloseConnection)
+}
+
+// Returns the connections in the event's room, separated by commas
+func (r *Registry) handleGetUsers(event *wasmevents.WASMEventInfo) (string, error) {
+	return strings.Join(r.Users(event.InstanceId, event.RoomId), ","), nil
+}
+
+// Payload is [message], which is sent to every connection in the event's room
+func (r *Registry) handleBroadcast(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	if r.sender == nil {
+		return "", errors.New("No sender configured")
+	}
+
+	// keep going if one connection fails, so the rest of the room still gets the message
+	var errs []error
+	for _, connectionId := range r.Users(event.InstanceId, event.RoomId) {
+		errs = append(errs, r.sender.Send(Message{
+			InstanceId: event.InstanceId,
+			RoomId:     event.RoomId,
+			From:       event.ConnectionId,
+			To:         connectionId,
+			Data:       event.Payload[0],
+		}))
+	}
+	return "", errors.Join(errs...)
+}
+
+// Payload is [recipient, message]
+func (r *Registry) handleSendMessage(event *wasmevents.WASMEventInfo) (string, error) {
+	return "", r.send(event, event.ConnectionId)
+}
+
+// Payload is [recipient, message]
+func (r *Registry) handleServerMessage(event *wasmevents.WASMEventInfo) (string, error) {
+	return "", r.send(event, "")
+}
+
+func (r *Registry) send(event *wasmevents.WASMEventInfo, from string) error {
+	if err := handlerutil.CheckPayload(event, 2); err != nil {
+		return err
+	}
+	if r.sender == nil {
+		return errors.New("No sender configured")
+	}
+	if !r.InRoom(event.InstanceId, event.RoomId, event.Payload[0]) {
+		return ErrNotInRoom
+	}
+
+	return r.sender.Send(Message{
+		InstanceId: event.InstanceId,
+		RoomId:     event.RoomId,
+		From:       from,
+		To:         event.Payload[0],
+		Data:       event.Payload[1],
+	})
+}
+
+// Payload is [connection]. The connection stays in the registry until its ON_LEAVE is observed
+func (r *Registry) handleCloseConnection(event *wasmevents.WASMEventInfo) (string, error) {
+	if err := handlerutil.CheckPayload(event, 1); err != nil {
+		return "", err
+	}
+	if r.sender == nil {
+		return "", errors.New("No sender configured")
+	}
+	if !r.InRoom(event.InstanceId, event.RoomId, event.Payload[0]) {
+		return "", ErrNotInRoom
+	}
+
+	return "", r.sender.Close(event.Payload[0])
+}
@@ -0,0 +1,245 @@
+// Registry of which connections are in which rooms, kept up to date from ON_JOIN and ON_LEAVE events.
+//
+// It also implements the room-related events (GET_USERS, BROADCAST, SEND_MESSAGE, SERVER_MESSAGE and
+// CLOSE_CONNECTION), delivering messages through a Sender that the WebSocket layer provides
+package rooms
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Returned when a module targets a connection that isn't in its room
+var ErrNotInRoom = errors.New("Connection is not in the room")
+
+// A message for a single connection
+type Message struct {
+	InstanceId string
+	RoomId     string
+
+	// Connection that sent the message, "" for messages sent by the server
+	From string
+
+	To   string
+	Data string
+}
+
+// Delivers messages to connections. Implemented by the WebSocket layer
+type Sender interface {
+	Send(msg Message) error
+
+	// Close a connection. ON_LEAVE should still be sent for it once it is closed
+	Close(connectionId string) error
+}
+
+type Config struct {
+	Sender Sender
+
+	// Called after the first connection joins a room, for example to deliver ON_ROOM_CREATED.
+	// It runs in the goroutine that observed the ON_JOIN, after the registry has been updated
+	OnRoomCreated func(instanceId string, roomId string)
+
+	// Called after the last connection leaves a room, for example to deliver ON_ROOM_EMPTY and call CloseRoom.
+	// For an observed ON_LEAVE this is once the store says the event is done, so the module's __onLeave
+	// always runs first. If a connection joins before then, the room was never empty and this isn't called
+	OnRoomEmpty func(instanceId string, roomId string)
+}
+
+type Registry struct {
+	sender        Sender
+	onRoomCreated func(instanceId string, roomId string)
+	onRoomEmpty   func(instanceId string, roomId string)
+
+	mu    sync.RWMutex
+	rooms map[roomKey]map[string]struct{}
+
+	// Rooms that each connection is in, so that Disconnect doesn't need to visit every room
+	connections map[string]map[roomKey]struct{}
+
+	// Rooms emptied by an observed ON_LEAVE that hasn't finished yet, and the connection that left
+	emptying map[roomKey]string
+}
+
+type roomKey struct {
+	instanceId string
+	roomId     string
+}
+
+func New(cfg Config) *Registry {
+	return &Registry{
+		sender:        cfg.Sender,
+		onRoomCreated: cfg.OnRoomCreated,
+		onRoomEmpty:   cfg.OnRoomEmpty,
+		rooms:         make(map[roomKey]map[string]struct{}),
+		connections:   make(map[string]map[roomKey]struct{}),
+		emptying:      make(map[roomKey]string),
+	}
+}
+
+// Update membership from an event. Only ON_JOIN and ON_LEAVE change anything.
+//
+// Pass the registry as the store's EventObserver to call this for every event
+func (r *Registry) Observe(event *wsevents.WSEventInfo) {
+	switch event.EventType {
+	case wsevents.ON_JOIN:
+		r.Join(event.InstanceId, event.RoomId, event.ConnectionId)
+	case wsevents.ON_LEAVE:
+		// the connection is gone right away, but OnRoomEmpty waits for the event to finish
+		key := roomKey{event.InstanceId, event.RoomId}
+		r.mu.Lock()
+		if r.leaveLocked(key, event.ConnectionId) {
+			r.emptying[key] = event.ConnectionId
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Calls OnRoomEmpty for rooms that an ON_LEAVE emptied, once the event is done.
+//
+// Implements store.FinishedObserver, the store calls this for every event it passed to Observe
+func (r *Registry) Finished(event *wsevents.WSEventInfo, err error) {
+	if event.EventType != wsevents.ON_LEAVE {
+		return
+	}
+	key := roomKey{event.InstanceId, event.RoomId}
+
+	r.mu.Lock()
+	connectionId, emptied := r.emptying[key]
+	emptied = emptied && connectionId == event.ConnectionId
+	if emptied {
+		delete(r.emptying, key)
+	}
+	r.mu.Unlock()
+
+	if emptied && r.onRoomEmpty != nil {
+		r.onRoomEmpty(event.InstanceId, event.RoomId)
+	}
+}
+
+// Add a connection to a room. Returns true if this created the room
+func (r *Registry) Join(instanceId string, roomId string, connectionId string) bool {
+	key := roomKey{instanceId, roomId}
+
+	r.mu.Lock()
+	members, ok := r.rooms[key]
+	if !ok {
+		members = make(map[string]struct{})
+		r.rooms[key] = members
+	}
+	members[connectionId] = struct{}{}
+
+	if r.connections[connectionId] == nil {
+		r.connections[connectionId] = make(map[roomKey]struct{})
+	}
+	r.connections[connectionId][key] = struct{}{}
+
+	// the room was only emptied by a leave that hasn't finished, so it's still the same room
+	created := !ok
+	if _, emptying := r.emptying[key]; emptying {
+		delete(r.emptying, key)
+		created = false
+	}
+	r.mu.Unlock()
+
+	if created && r.onRoomCreated != nil {
+		r.onRoomCreated(instanceId, roomId)
+	}
+	return created
+}
+
+// Remove a connection from a room. Returns true if the room is now empty
+func (r *Registry) Leave(instanceId string, roomId string, connectionId string) bool {
+	key := roomKey{instanceId, roomId}
+
+	r.mu.Lock()
+	emptied := r.leaveLocked(key, connectionId)
+	r.mu.Unlock()
+
+	if emptied && r.onRoomEmpty != nil {
+		r.onRoomEmpty(instanceId, roomId)
+	}
+	return emptied
+}
+
+// Remove a connection from every room it is in, for connections that dropped without ON_LEAVE
+func (r *Registry) Disconnect(connectionId string) {
+	var emptied []roomKey
+
+	r.mu.Lock()
+	for key := range r.connections[connectionId] {
+		if r.leaveLocked(key, connectionId) {
+			emptied = append(emptied, key)
+		}
+	}
+	r.mu.Unlock()
+
+	if r.onRoomEmpty != nil {
+		for _, key := range emptied {
+			r.onRoomEmpty(key.instanceId, key.roomId)
+		}
+	}
+}
+
+// Returns true if the room was emptied, the lock must be held
+func (r *Registry) leaveLocked(key roomKey, connectionId string) bool {
+	members, ok := r.rooms[key]
+	if !ok {
+		return false
+	}
+	if _, ok := members[connectionId]; !ok {
+		return false
+	}
+
+	delete(members, connectionId)
+	delete(r.connections[connectionId], key)
+	if len(r.connections[connectionId]) == 0 {
+		delete(r.connections, connectionId)
+	}
+
+	if len(members) > 0 {
+		return false
+	}
+	delete(r.rooms, key)
+	return true
+}
+
+// Connections in a room, in sorted order
+func (r *Registry) Users(instanceId string, roomId string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	members := r.rooms[roomKey{instanceId, roomId}]
+	users := make([]string, 0, len(members))
+	for connectionId := range members {
+		users = append(users, connectionId)
+	}
+	slices.Sort(users)
+	return users
+}
+
+// Rooms of a module that have at least one connection, in sorted order
+func (r *Registry) Rooms(instanceId string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rooms := make([]string, 0)
+	for key := range r.rooms {
+		if key.instanceId == instanceId {
+			rooms = append(rooms, key.roomId)
+		}
+	}
+	slices.Sort(rooms)
+	return rooms
+}
+
+// Returns true if a connection is in a room
+func (r *Registry) InRoom(instanceId string, roomId string, connectionId string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.rooms[roomKey{instanceId, roomId}][connectionId]
+	return ok
+}
@@ -0,0 +1,208 @@
+package rooms
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmtest"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Callbacks in the order they were called
+type callbackLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *callbackLog) add(call string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, call)
+}
+
+func (l *callbackLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	calls := l.calls
+	l.calls = nil
+	return calls
+}
+
+func newTestRegistry(log *callbackLog) *Registry {
+	return New(Config{
+		OnRoomCreated: func(instanceId string, roomId string) { log.add("created " + instanceId + "/" + roomId) },
+		OnRoomEmpty:   func(instanceId string, roomId string) { log.add("empty " + instanceId + "/" + roomId) },
+	})
+}
+
+func event(eventType wsevents.WSEventType, connectionId string, roomId string) *wsevents.WSEventInfo {
+	return &wsevents.WSEventInfo{InstanceId: "m", RoomId: roomId, ConnectionId: connectionId, EventType: eventType}
+}
+
+func expectCalls(t *testing.T, log *callbackLog, expected ...string) {
+	t.Helper()
+	if calls := log.take(); !slices.Equal(calls, expected) {
+		t.Errorf("Expected callbacks %q, got %q", expected, calls)
+	}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	log := &callbackLog{}
+	r := newTestRegistry(log)
+
+	if !r.Join("m", "lobby", "a") || r.Join("m", "lobby", "b") {
+		t.Errorf("Expected only the first join to create the room")
+	}
+	r.Join("m", "other", "a")
+	r.Join("other", "lobby", "c")
+	expectCalls(t, log, "created m/lobby", "created m/other", "created other/lobby")
+
+	if users := r.Users("m", "lobby"); !slices.Equal(users, []string{"a", "b"}) {
+		t.Errorf("Expected a and b in the lobby, got %v", users)
+	}
+	if rooms := r.Rooms("m"); !slices.Equal(rooms, []string{"lobby", "other"}) {
+		t.Errorf("Expected m to have two rooms, got %v", rooms)
+	}
+	if !r.InRoom("m", "lobby", "b") || r.InRoom("m", "other", "b") {
+		t.Errorf("Expected b to only be in the lobby")
+	}
+
+	// leaving twice, or a room the connection isn't in, changes nothing
+	if r.Leave("m", "lobby", "b") || r.Leave("m", "lobby", "b") || r.Leave("m", "other", "b") {
+		t.Errorf("Expected the lobby to still have a connection")
+	}
+	expectCalls(t, log)
+
+	// a is in two rooms, and both are emptied when it drops
+	r.Disconnect("a")
+	expectCalls(t, log, "empty m/lobby", "empty m/other")
+	if rooms := r.Rooms("m"); len(rooms) != 0 {
+		t.Errorf("Expected m to have no rooms, got %v", rooms)
+	}
+	if users := r.Users("other", "lobby"); !slices.Equal(users, []string{"c"}) {
+		t.Errorf("Expected other modules' rooms to be left alone, got %v", users)
+	}
+
+	if !r.Leave("other", "lobby", "c") {
+		t.Errorf("Expected the last leave to empty the room")
+	}
+	expectCalls(t, log, "empty other/lobby")
+}
+
+// Rooms emptied by an observed ON_LEAVE are only reported once the store says it's done
+func TestObservedLeaveWaitsForFinished(t *testing.T) {
+	log := &callbackLog{}
+	r := newTestRegistry(log)
+
+	r.Observe(event(wsevents.ON_JOIN, "a", "lobby"))
+	r.Observe(event(wsevents.ON_JOIN, "b", "lobby"))
+	r.Observe(event(wsevents.ON_MESSAGE, "c", "lobby"))
+	expectCalls(t, log, "created m/lobby")
+
+	leaveA := event(wsevents.ON_LEAVE, "a", "lobby")
+	leaveB := event(wsevents.ON_LEAVE, "b", "lobby")
+	r.Observe(leaveA)
+	r.Observe(leaveB)
+	if users := r.Users("m", "lobby"); len(users) != 0 {
+		t.Errorf("Expected the connections to leave right away, got %v", users)
+	}
+	expectCalls(t, log)
+
+	// only the leave that emptied the room reports it
+	r.Finished(leaveA, nil)
+	expectCalls(t, log)
+	r.Finished(leaveB, nil)
+	expectCalls(t, log, "empty m/lobby")
+
+	// a join before the leave is done means the room never became empty
+	r.Observe(event(wsevents.ON_JOIN, "a", "lobby"))
+	r.Observe(leaveA)
+	r.Observe(event(wsevents.ON_JOIN, "b", "lobby"))
+	r.Finished(leaveA, nil)
+	expectCalls(t, log, "created m/lobby")
+	if users := r.Users("m", "lobby"); !slices.Equal(users, []string{"b"}) {
+		t.Errorf("Expected b in the lobby, got %v", users)
+	}
+
+	// failed leaves still empty the room
+	r.Observe(leaveB)
+	r.Finished(leaveB, context.DeadlineExceeded)
+	expectCalls(t, log, "empty m/lobby")
+}
+
+// Logs "leave" from __onLeave
+const leaveModule = `(module
+	(import "env" "log" (func $log (param i32 i32) (result i32)))
+	(memory (export "memory") 1)
+	(data (i32.const 0) "leave")
+	(func (export "__new") (param i32 i32) (result i32) (i32.const 1024))
+	(func (export "__onJoin") (param i32 i32))
+	(func (export "__onLeave") (param i32 i32)
+		(drop (call $log (i32.const 0) (i32.const 5)))))`
+
+// With the registry as a store's observer, OnRoomEmpty comes after the last __onLeave has run
+func TestRoomEmptyAfterLeaveRuns(t *testing.T) {
+	wasm := wasmtest.Must(t, leaveModule)
+
+	for _, ordered := range []bool{false, true} {
+		log := &callbackLog{}
+		r := newTestRegistry(log)
+		handlerMap := wasmevents.NewHandlerMap().AddHandler(wasmevents.LOG, func(event *wasmevents.WASMEventInfo) (string, error) {
+			log.add(event.Payload[0] + " " + event.ConnectionId)
+			return "", nil
+		})
+
+		sandbox, err := store.NewSandboxStore(context.Background(), store.SandboxStoreCfg{
+			HandlerMap:      handlerMap,
+			EventObserver:   r,
+			OrderedDispatch: ordered,
+			LoaderFunction: func(ctx context.Context, moduleId string) ([]byte, error) {
+				return wasm, nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		defer sandbox.Close(context.Background())
+
+		for _, e := range []*wsevents.WSEventInfo{
+			event(wsevents.ON_JOIN, "a", "lobby"),
+			event(wsevents.ON_JOIN, "b", "lobby"),
+			event(wsevents.ON_LEAVE, "a", "lobby"),
+			event(wsevents.ON_LEAVE, "b", "lobby"),
+		} {
+			if err := sandbox.ExecuteOnModule(context.Background(), e); err != nil {
+				t.Fatalf("Failed to run %s: %v", e.EventType.String(), err)
+			}
+		}
+		expectCalls(t, log, "created m/lobby", "leave a", "leave b", "empty m/lobby")
+
+		// the same goes for submitted events
+		sandbox.ExecuteOnModule(context.Background(), event(wsevents.ON_JOIN, "a", "lobby"))
+		log.take()
+		if result := <-sandbox.Submit(context.Background(), event(wsevents.ON_LEAVE, "a", "lobby")); result.Err != nil {
+			t.Fatalf("Failed to run submitted ON_LEAVE: %v", result.Err)
+		}
+		waitForCalls(t, log, "leave a", "empty m/lobby")
+	}
+}
+
+// Submitted events with OrderedDispatch report that they are done from another goroutine
+func waitForCalls(t *testing.T, log *callbackLog, expected ...string) {
+	t.Helper()
+	for range 1000 {
+		log.mu.Lock()
+		n := len(log.calls)
+		log.mu.Unlock()
+		if n >= len(expected) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expectCalls(t, log, expected...)
+}
@@ -0,0 +1,112 @@
+package sandboxtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+)
+
+// Fake clock for the store's timers. Time only moves when Advance is called, which fires the timers
+// that are due in the calling goroutine, so their events have run by the time it returns
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+
+	// Breaks ties between timers due at the same time, in the order they were set
+	seq uint64
+}
+
+type fakeTimer struct {
+	clock *Clock
+	when  time.Time
+	seq   uint64
+	f     func()
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now, timers: make(map[*fakeTimer]struct{})}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) AfterFunc(d time.Duration, f func()) store.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timer := &fakeTimer{clock: c, f: f}
+	c.startLocked(timer, d)
+	return timer
+}
+
+func (c *Clock) startLocked(timer *fakeTimer, d time.Duration) {
+	c.seq++
+	timer.when = c.now.Add(d)
+	timer.seq = c.seq
+	c.timers[timer] = struct{}{}
+}
+
+// Move time forward, firing every timer that becomes due in order. Each timer sees Now as the time it was due.
+// Timers set while advancing fire too if they are due before the end
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+
+	for {
+		var next *fakeTimer
+		for timer := range c.timers {
+			if timer.when.After(end) {
+				continue
+			}
+			if next == nil || timer.when.Before(next.when) || (timer.when.Equal(next.when) && timer.seq < next.seq) {
+				next = timer
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		delete(c.timers, next)
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+
+		// the timer's event runs the module, which may set or clear timers
+		c.mu.Unlock()
+		next.f()
+		c.mu.Lock()
+	}
+
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Number of timers that haven't fired or been stopped
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	_, active := t.clock.timers[t]
+	t.clock.startLocked(t, d)
+	return active
+}
@@ -0,0 +1,31 @@
+package sandboxtest
+
+import wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+
+// Helpers to build WS events. The harness fills in the instance ID and timestamp when they're left empty
+
+func Event(eventType wsevents.WSEventType, connectionId string, roomId string, payload string) *wsevents.WSEventInfo {
+	return &wsevents.WSEventInfo{
+		ConnectionId: connectionId,
+		RoomId:       roomId,
+		Payload:      payload,
+		EventType:    eventType,
+	}
+}
+
+func Join(connectionId string, roomId string) *wsevents.WSEventInfo {
+	return Event(wsevents.ON_JOIN, connectionId, roomId, "")
+}
+
+func Leave(connectionId string, roomId string) *wsevents.WSEventInfo {
+	return Event(wsevents.ON_LEAVE, connectionId, roomId, "")
+}
+
+func Message(connectionId string, roomId string, payload string) *wsevents.WSEventInfo {
+	return Event(wsevents.ON_MESSAGE, connectionId, roomId, payload)
+}
+
+// Room lifecycle events (ON_ROOM_CREATED, ON_ROOM_EMPTY, ON_TICK) don't come from a connection
+func RoomEvent(eventType wsevents.WSEventType, roomId string, payload string) *wsevents.WSEventInfo {
+	return Event(eventType, "", roomId, payload)
+}
@@ -0,0 +1,464 @@
+// Harness for unit testing modules from Go.
+//
+// A Harness runs a module in an in-memory sandbox store with working KV, DB and room handlers, a fake clock
+// for timers, and records every host call the module makes so tests can assert on them:
+//
+//	h := sandboxtest.New(t, sandboxtest.Config{ModulePath: "build/release.wasm"})
+//	h.Send(sandboxtest.Join("alice", "lobby"))
+//	h.Send(sandboxtest.Message("alice", "lobby", "hi"))
+//	h.AssertCalled(wasmevents.BROADCAST, "alice: hi")
+//
+// Traps, aborts and errors returned by the module fail the test, unless the event was run with TrySend
+package sandboxtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/handlers/db"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/handlers/kv"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/rooms"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+type Config struct {
+	// The compiled module to test
+	Module []byte
+
+	// Read the module from this file if Module isn't set
+	ModulePath string
+
+	// Instance ID given to events that don't set one (defaults to "test")
+	InstanceId string
+
+	// Let the module import WASI, which modules built with Go or Rust need
+	WASI bool
+
+	// Memory limit of each instance, in 64KB pages (defaults to 100)
+	MemoryLimitPages uint32
+
+	// Execution time limit of each event (defaults to 5 seconds)
+	MaxExecutionTime time.Duration
+
+	// Initial time of the fake clock (defaults to 2025-01-01 UTC)
+	Start time.Time
+
+	// Enables GET_STATE / SET_STATE, kept in memory
+	State bool
+}
+
+// A recorded host call, see record.Call
+type Call = record.Call
+
+// Execution IDs of events run by the harness, timers get theirs from the store
+const executionPrefix = "sandboxtest-"
+
+type Harness struct {
+	tb         testing.TB
+	instanceId string
+
+	// Real stores behind the module's handlers, tests can seed them or check them directly
+	Clock   *Clock
+	Sandbox *store.SandboxStore
+	Rooms   *rooms.Registry
+	KV      *kv.Store
+	DB      *db.Store
+
+	// Every host call is recorded here and read back when asserting
+	recorder  *record.Recorder
+	recording lockedBuffer
+	reader    *record.Reader
+	calls     []Call
+
+	mu         sync.Mutex
+	real       wasmevents.HandlerMap
+	fakes      map[wasmevents.WASMEventType]wasmevents.HandlerFunction
+	messages   []rooms.Message
+	closed     []string
+	aborts     []abort
+	executions int
+
+	// Calls and messages before these indexes were cleared by Reset
+	callsOffset    int
+	messagesOffset int
+}
+
+// Start a harness for the module. Everything is closed when the test ends
+func New(tb testing.TB, cfg Config) *Harness {
+	tb.Helper()
+
+	wasm := cfg.Module
+	if wasm == nil {
+		var err error
+		if wasm, err = os.ReadFile(cfg.ModulePath); err != nil {
+			tb.Fatalf("Failed to read module: %v", err)
+		}
+	}
+
+	start := cfg.Start
+	if start.IsZero() {
+		start = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	h := &Harness{
+		tb:         tb,
+		instanceId: cfg.InstanceId,
+		Clock:      NewClock(start),
+		fakes:      make(map[wasmevents.WASMEventType]wasmevents.HandlerFunction),
+	}
+	if h.instanceId == "" {
+		h.instanceId = "test"
+	}
+
+	h.KV = kv.New(kv.Config{Now: h.Clock.Now})
+	tb.Cleanup(h.KV.Close)
+
+	var err error
+	if h.DB, err = db.Open(db.Config{Dir: tb.TempDir()}); err != nil {
+		tb.Fatalf("Failed to open DB: %v", err)
+	}
+	tb.Cleanup(func() { h.DB.Close() })
+
+	h.Rooms = rooms.New(rooms.Config{Sender: (*harnessSender)(h)})
+
+	h.real = *h.Rooms.Register(h.DB.Register(h.KV.Register(wasmevents.NewHandlerMap()))).
+		AddHandler(wasmevents.FETCH, h.handleFetch).
+		AddHandler(wasmevents.LOG, h.handleLog).
+		AddHandler(wasmevents.DEBUG, h.handleLog).
+		AddHandler(wasmevents.ABORT, h.handleAbort)
+
+	// Route every event through the harness so that fakes can be added at any time
+	handlerMap := wasmevents.NewHandlerMap()
+	for eventType := wasmevents.WASMEventType(0); eventType.Valid(); eventType++ {
+		handlerMap.AddHandler(eventType, h.handle)
+	}
+
+	if h.recorder, err = record.NewRecorder(&h.recording); err != nil {
+		tb.Fatalf("Failed to start recording: %v", err)
+	}
+	h.reader = record.NewReader(&h.recording)
+
+	storeCfg := store.SandboxStoreCfg{
+		MemoryLimitPages:   defaultValue(cfg.MemoryLimitPages, 100),
+		MaxExecutionTime:   defaultValue(cfg.MaxExecutionTime, 5*time.Second),
+		CloseOnContextDone: true,
+		HandlerMap:         handlerMap,
+		TransactionHandler: h.DB,
+		EventObserver:      h.Rooms,
+		Recorder:           h.recorder,
+		Clock:              h.Clock,
+		OnTimerError:       h.onTimerError,
+		// every instance ID loads the module under test
+		LoaderFunction: func(ctx context.Context, moduleId string) ([]byte, error) {
+			return wasm, nil
+		},
+	}
+	if cfg.WASI {
+		storeCfg.SettingsFunction = func(ctx context.Context, moduleId string) (*loader.ModuleSettings, error) {
+			return &loader.ModuleSettings{WASI: true}, nil
+		}
+	}
+	if cfg.State {
+		storeCfg.StateBackend = store.NewMemoryStateBackend()
+	}
+
+	if h.Sandbox, err = store.NewSandboxStore(context.Background(), storeCfg); err != nil {
+		tb.Fatalf("Failed to create sandbox store: %v", err)
+	}
+	tb.Cleanup(func() { h.Sandbox.Close(context.Background()) })
+
+	return h
+}
+
+func defaultValue[T comparable](value T, defaultValue T) T {
+	var zero T
+	if value == zero {
+		return defaultValue
+	}
+	return value
+}
+
+// Handle an event type with fn instead of the default handler.
+//
+// Timers (SET_TIMEOUT, SET_INTERVAL, CLEAR_TIMER), state (GET_STATE, SET_STATE) and transactions (TX_BEGIN, TX_COMMIT, TX_ROLLBACK)
+// are run by the store and can't be faked. Neither can DB_SET and DB_DEL, which the store buffers until the guest returns
+func (h *Harness) Handle(eventType wasmevents.WASMEventType, fn wasmevents.HandlerFunction) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fakes[eventType] = fn
+}
+
+func (h *Harness) handle(event *wasmevents.WASMEventInfo) (string, error) {
+	h.mu.Lock()
+	handler, ok := h.fakes[event.EventType]
+	if !ok {
+		handler, ok = h.real[event.EventType]
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("No handler present for %s event", event.EventType.String())
+	}
+	return handler(event)
+}
+
+// Fails unless faked with Handle
+func (h *Harness) handleFetch(event *wasmevents.WASMEventInfo) (string, error) {
+	return "", fmt.Errorf("No fake handler for %s, see Harness.Handle", event.EventType.String())
+}
+
+func (h *Harness) handleLog(event *wasmevents.WASMEventInfo) (string, error) {
+	h.tb.Logf("%s: %s", event.EventType.String(), strings.Join(event.Payload, " "))
+	return "", nil
+}
+
+// An abort that hasn't been reported with its execution's error yet
+type abort struct {
+	event   wasmevents.WASMEventInfo
+	message string
+}
+
+// The module traps right after aborting, so the abort is reported with the execution's error
+func (h *Harness) handleAbort(event *wasmevents.WASMEventInfo) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.aborts = append(h.aborts, abort{event: *event, message: strings.Join(event.Payload, " ")})
+	return "", nil
+}
+
+// Remove and return the latest abort that matches
+func (h *Harness) takeAbort(match func(event *wasmevents.WASMEventInfo) bool) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.aborts) - 1; i >= 0; i-- {
+		if match(&h.aborts[i].event) {
+			message := h.aborts[i].message
+			h.aborts = slices.Delete(h.aborts, i, i+1)
+			return message, true
+		}
+	}
+	return "", false
+}
+
+func (h *Harness) executionError(executionId string, err error) error {
+	message, ok := h.takeAbort(func(event *wasmevents.WASMEventInfo) bool {
+		return event.ExecutionId == executionId
+	})
+	if ok {
+		return fmt.Errorf("Module aborted: %s (%v)", message, err)
+	}
+	return err
+}
+
+// Timers fire from Advance, so this runs in the test's goroutine
+func (h *Harness) onTimerError(event *wsevents.WSEventInfo, err error) {
+	// timer executions get IDs from the store rather than the harness, so match the abort by where it ran instead
+	message, ok := h.takeAbort(func(abort *wasmevents.WASMEventInfo) bool {
+		return !strings.HasPrefix(abort.ExecutionId, executionPrefix) &&
+			abort.InstanceId == event.InstanceId &&
+			abort.RoomId == event.RoomId &&
+			abort.ConnectionId == event.ConnectionId
+	})
+	if ok {
+		err = fmt.Errorf("Module aborted: %s (%v)", message, err)
+	}
+
+	h.tb.Errorf("%s for room %q failed: %v", event.EventType.String(), event.RoomId, err)
+}
+
+// Run an event, failing the test if the module traps, aborts or returns an error
+func (h *Harness) Send(event *wsevents.WSEventInfo) {
+	h.tb.Helper()
+	if err := h.TrySend(event); err != nil {
+		h.tb.Errorf("%s from %q failed: %v", event.EventType.String(), event.ConnectionId, err)
+	}
+}
+
+// Run an event and return its error instead of failing the test.
+// The instance ID and timestamp are filled in if they are empty
+func (h *Harness) TrySend(event *wsevents.WSEventInfo) error {
+	if event.InstanceId == "" {
+		event.InstanceId = h.instanceId
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = h.Clock.Now().UnixMilli()
+	}
+
+	h.mu.Lock()
+	h.executions++
+	executionId := fmt.Sprintf("%s%d", executionPrefix, h.executions)
+	h.mu.Unlock()
+
+	err := h.Sandbox.ExecuteOnModule(store.WithExecutionId(context.Background(), executionId), event)
+	if err != nil {
+		return h.executionError(executionId, err)
+	}
+	return nil
+}
+
+// Move the fake clock forward, running the events of every timer that becomes due
+func (h *Harness) Advance(d time.Duration) {
+	h.tb.Helper()
+	h.Clock.Advance(d)
+}
+
+// Every host call made since the harness started or was last Reset, in order
+func (h *Harness) Calls() []Call {
+	h.tb.Helper()
+
+	// only the records written since the last read are decoded
+	if err := h.recorder.Flush(); err != nil {
+		h.tb.Fatalf("Failed to flush recording: %v", err)
+	}
+	calls, err := h.reader.Calls()
+	if err != nil {
+		h.tb.Fatalf("Failed to read recording: %v", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, calls...)
+	return slices.Clone(h.calls[h.callsOffset:])
+}
+
+// Host calls of one event type
+func (h *Harness) CallsTo(eventType wasmevents.WASMEventType) []Call {
+	h.tb.Helper()
+
+	var calls []Call
+	for _, call := range h.Calls() {
+		if call.Event.EventType == eventType {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Fail the test unless the module made a call of this type with exactly this payload
+func (h *Harness) AssertCalled(eventType wasmevents.WASMEventType, payload ...string) {
+	h.tb.Helper()
+
+	calls := h.CallsTo(eventType)
+	for _, call := range calls {
+		if slices.Equal(call.Event.Payload, payload) {
+			return
+		}
+	}
+
+	if len(calls) == 0 {
+		h.tb.Errorf("Expected %s%q to be called, but %s was never called", eventType.String(), payload, eventType.String())
+		return
+	}
+	got := make([]string, len(calls))
+	for i, call := range calls {
+		got[i] = fmt.Sprintf("%q", call.Event.Payload)
+	}
+	h.tb.Errorf("Expected %s%q to be called, got calls with %s", eventType.String(), payload, strings.Join(got, ", "))
+}
+
+// Fail the test if the module made any call of this type
+func (h *Harness) AssertNotCalled(eventType wasmevents.WASMEventType) {
+	h.tb.Helper()
+
+	if calls := h.CallsTo(eventType); len(calls) > 0 {
+		h.tb.Errorf("Expected %s not to be called, got %d calls, the first with %q", eventType.String(), len(calls), calls[0].Event.Payload)
+	}
+}
+
+// Messages delivered to connections through BROADCAST, SEND_MESSAGE and SERVER_MESSAGE since the last Reset
+func (h *Harness) Messages() []rooms.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.messages[h.messagesOffset:])
+}
+
+// Fail the test unless a connection was sent this message
+func (h *Harness) AssertSent(to string, data string) {
+	h.tb.Helper()
+
+	messages := h.Messages()
+	for _, msg := range messages {
+		if msg.To == to && msg.Data == data {
+			return
+		}
+	}
+
+	got := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		if msg.To == to {
+			got = append(got, fmt.Sprintf("%q", msg.Data))
+		}
+	}
+	if len(got) == 0 {
+		h.tb.Errorf("Expected %q to be sent %q, but it wasn't sent anything", to, data)
+		return
+	}
+	h.tb.Errorf("Expected %q to be sent %q, got %s", to, data, strings.Join(got, ", "))
+}
+
+// Connections closed through CLOSE_CONNECTION
+func (h *Harness) Closed() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.closed)
+}
+
+// Forget the calls and messages so far, for example between the cases of a table driven test.
+// Module memory, KV, DB and room membership are kept
+func (h *Harness) Reset() {
+	h.tb.Helper()
+	h.Calls()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.callsOffset = len(h.calls)
+	h.messagesOffset = len(h.messages)
+	h.closed = nil
+}
+
+// Collects messages instead of sending them
+type harnessSender Harness
+
+func (s *harnessSender) Send(msg rooms.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *harnessSender) Close(connectionId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = append(s.closed, connectionId)
+	return nil
+}
+
+// The recording is written while executions run and read while asserting
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Reading consumes the recording, the harness keeps the decoded calls
+func (b *lockedBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Read(p)
+}
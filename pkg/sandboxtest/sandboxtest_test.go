@@ -0,0 +1,164 @@
+package sandboxtest_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmtest"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/sandboxtest"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// A tiny module, so the tests don't need an AssemblyScript build:
+//   - __onMessage broadcasts "hello"
+//   - __onJoin sets a 1 second timeout with payload "ping"
+//   - __onTimer broadcasts "tick"
+//   - __onLeave aborts with "bye"
+const testModule = `(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(import "env" "setTimeout" (func $setTimeout (param i32 i32 i32) (result i32)))
+	(import "env" "abort" (func $abort (param i32 i32 i32 i32)))
+
+	(memory (export "memory") 1)
+	(global $heap (mut i32) (i32.const 1024))
+
+	(data (i32.const 0) "hello")
+	(data (i32.const 16) "ping")
+	(data (i32.const 32) "tick")
+	;; AssemblyScript string, length in characters at +4 and UTF-16 data at +8
+	(data (i32.const 48) "\00\00\00\00\03\00\00\00b\00y\00e\00")
+
+	;; bumps the heap pointer
+	(func (export "__new") (param $size i32) (param $id i32) (result i32)
+		global.get $heap
+		(global.set $heap (i32.add (global.get $heap) (local.get $size))))
+
+	(func (export "__onMessage") (param i32 i32)
+		(drop (call $broadcast (i32.const 0) (i32.const 5))))
+
+	(func (export "__onJoin") (param i32 i32)
+		(drop (call $setTimeout (i32.const 1000) (i32.const 16) (i32.const 4))))
+
+	(func (export "__onTimer") (param i32 i32)
+		(drop (call $broadcast (i32.const 32) (i32.const 4))))
+
+	(func (export "__onLeave") (param i32 i32)
+		(call $abort (i32.const 48) (i32.const 0) (i32.const 0) (i32.const 0))
+		unreachable))`
+
+func TestMessage(t *testing.T) {
+	h := sandboxtest.New(t, sandboxtest.Config{Module: wasmtest.Must(t, testModule)})
+
+	h.Send(sandboxtest.Join("alice", "lobby"))
+	h.Send(sandboxtest.Join("bob", "lobby"))
+	h.Reset()
+
+	h.Send(sandboxtest.Message("alice", "lobby", "hi"))
+	h.AssertCalled(wasmevents.BROADCAST, "hello")
+	h.AssertNotCalled(wasmevents.SET_TIMEOUT)
+	h.AssertSent("alice", "hello")
+	h.AssertSent("bob", "hello")
+
+	if calls := h.Calls(); len(calls) != 1 {
+		t.Errorf("Expected 1 call after Reset, got %d", len(calls))
+	}
+}
+
+func TestTimers(t *testing.T) {
+	h := sandboxtest.New(t, sandboxtest.Config{Module: wasmtest.Must(t, testModule)})
+
+	h.Send(sandboxtest.Join("alice", "lobby"))
+	h.AssertCalled(wasmevents.SET_TIMEOUT, "1000", "ping")
+	if pending := h.Clock.Pending(); pending != 1 {
+		t.Fatalf("Expected 1 pending timer, got %d", pending)
+	}
+
+	h.Advance(999 * time.Millisecond)
+	h.AssertNotCalled(wasmevents.BROADCAST)
+
+	h.Advance(time.Millisecond)
+	h.AssertCalled(wasmevents.BROADCAST, "tick")
+	h.AssertSent("alice", "tick")
+	if pending := h.Clock.Pending(); pending != 0 {
+		t.Errorf("Expected no pending timers, got %d", pending)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	h := sandboxtest.New(t, sandboxtest.Config{Module: wasmtest.Must(t, testModule)})
+
+	err := h.TrySend(sandboxtest.Leave("alice", "lobby"))
+	if err == nil || !strings.Contains(err.Error(), "bye") {
+		t.Fatalf("Expected the abort to be returned, got %v", err)
+	}
+	h.AssertCalled(wasmevents.ABORT, "bye")
+}
+
+func TestFake(t *testing.T) {
+	h := sandboxtest.New(t, sandboxtest.Config{Module: wasmtest.Must(t, testModule)})
+
+	broadcasts := 0
+	h.Handle(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+		broadcasts++
+		return "", nil
+	})
+
+	h.Send(sandboxtest.Message("alice", "lobby", "hi"))
+	if broadcasts != 1 {
+		t.Errorf("Expected the fake to be called once, got %d", broadcasts)
+	}
+	if messages := h.Messages(); len(messages) != 0 {
+		t.Errorf("Expected no messages with the fake, got %v", messages)
+	}
+}
+
+// Module that broadcasts its own name on every message
+func namedModule(name string) string {
+	return fmt.Sprintf(`(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(memory (export "memory") 1)
+	(data (i32.const 0) %q)
+	(func (export "__new") (param i32 i32) (result i32) (i32.const 1024))
+	(func (export "__onMessage") (param i32 i32)
+		(drop (call $broadcast (i32.const 0) (i32.const %d)))))`, name, len(name))
+}
+
+// Each harness loads its own module, even when they are created and used at the same time
+func TestHarnessesAreIsolated(t *testing.T) {
+	names := []string{"first", "second"}
+	harnesses := make([]*sandboxtest.Harness, len(names))
+	for i, name := range names {
+		harnesses[i] = sandboxtest.New(t, sandboxtest.Config{Module: wasmtest.Must(t, namedModule(name))})
+	}
+
+	var wg sync.WaitGroup
+	for _, h := range harnesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				if err := h.TrySend(sandboxtest.Message("alice", "lobby", "hi")); err != nil {
+					t.Errorf("Send failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, h := range harnesses {
+		broadcasts := h.CallsTo(wasmevents.BROADCAST)
+		if len(broadcasts) != 20 {
+			t.Errorf("Expected harness %s to broadcast 20 times, got %d", names[i], len(broadcasts))
+		}
+		for _, call := range broadcasts {
+			if call.Event.Payload[0] != names[i] {
+				t.Errorf("Expected harness %s to only run its own module, got a broadcast of %q", names[i], call.Event.Payload[0])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package signing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+)
+
+type Config struct {
+	// Modules have to be signed by one of these keys
+	TrustedKeys []ed25519.PublicKey
+
+	// Optional, looks up the sidecar signature of modules that don't have one embedded,
+	// such as FSLoader.Signature or HTTPLoader.Signature. It returns loader.ErrNoSignature (or an error
+	// wrapping fs.ErrNotExist) for modules without one, which are refused with ErrUnsigned
+	Signature func(ctx context.Context, moduleId string) ([]byte, error)
+
+	// Called with the outcome of every check. Rejections are logged as warnings if not specified
+	Audit func(event AuditEvent)
+}
+
+// Record of a module's signature being checked
+type AuditEvent struct {
+	Time     time.Time
+	ModuleId string
+
+	// Of the module as it was loaded
+	SHA256 string
+
+	// KeyId of the key that signed it, empty if it isn't signed
+	KeyId string
+
+	// Whether the signature was embedded or in a sidecar
+	Detached bool
+
+	Accepted bool
+
+	// Why the module was rejected
+	Err error
+}
+
+// Wrap a loader function so that it only returns modules signed by a trusted key.
+// Signatures are checked before the store compiles anything
+func VerifyingLoader(next loader.LoaderFunction, cfg Config) loader.LoaderFunction {
+	audit := cfg.Audit
+	if audit == nil {
+		audit = logAudit
+	}
+
+	return func(ctx context.Context, moduleId string) ([]byte, error) {
+		wasm, err := next(ctx, moduleId)
+		if err != nil {
+			return nil, err
+		}
+
+		event := AuditEvent{Time: time.Now(), ModuleId: moduleId, SHA256: sha256Hex(wasm)}
+		signer, err := verifyModule(ctx, cfg, moduleId, wasm, &event)
+		if signer != nil {
+			event.KeyId = KeyId(signer)
+		}
+		event.Accepted = err == nil
+		event.Err = err
+		audit(event)
+
+		if err != nil {
+			return nil, fmt.Errorf("Refused module %s: %w", moduleId, err)
+		}
+		return wasm, nil
+	}
+}
+
+func verifyModule(ctx context.Context, cfg Config, moduleId string, wasm []byte, event *AuditEvent) (ed25519.PublicKey, error) {
+	unsigned, signature, err := Embedded(wasm)
+	if err != nil {
+		return nil, err
+	}
+	if signature != nil {
+		return Verify(unsigned, signature, cfg.TrustedKeys)
+	}
+
+	if cfg.Signature == nil {
+		return nil, ErrUnsigned
+	}
+	signature, err = cfg.Signature(ctx, moduleId)
+	if errors.Is(err, loader.ErrNoSignature) || errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrUnsigned
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	event.Detached = true
+	return Verify(wasm, signature, cfg.TrustedKeys)
+}
+
+func logAudit(event AuditEvent) {
+	if event.Accepted {
+		slog.Debug("Module signature accepted", "moduleId", event.ModuleId, "sha256", event.SHA256, "keyId", event.KeyId)
+		return
+	}
+	slog.Warn("Module rejected", "moduleId", event.ModuleId, "sha256", event.SHA256, "keyId", event.KeyId, "err", event.Err)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
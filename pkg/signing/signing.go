@@ -0,0 +1,149 @@
+// Signs modules with ed25519, and checks their signatures before they are compiled.
+//
+// A signature is either embedded in the module as a custom section named SectionName, which has to be the last
+// section and covers everything before it, or kept next to the module in a sidecar file (module.wasm.sig)
+// that covers the whole file. Both hold the same 97 bytes: a version byte, the signer's public key and the signature
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmbin"
+)
+
+// Name of the custom section holding an embedded signature
+const SectionName = "wasm-sandbox.signature"
+
+// Extension of sidecar signature files, added after .wasm
+const SidecarExtension = ".sig"
+
+const version = 1
+
+const signatureSize = 1 + ed25519.PublicKeySize + ed25519.SignatureSize
+
+// Signed along with the module, so these signatures can't be passed off as signatures of anything else
+const domain = "wasm-sandbox module signature v1\x00"
+
+// Returned for modules without an embedded or sidecar signature
+var ErrUnsigned = errors.New("Module is not signed")
+
+// Returned for modules signed by a key that isn't trusted
+var ErrUntrustedKey = errors.New("Module is signed by an untrusted key")
+
+// Returned when the signature doesn't match the module, meaning the module or signature was changed after signing
+var ErrBadSignature = errors.New("Module signature is invalid")
+
+// Short identifier of a public key, used in logs and audit events
+func KeyId(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func sign(message []byte, key ed25519.PrivateKey) []byte {
+	sig := ed25519.Sign(key, append([]byte(domain), message...))
+	out := append([]byte{version}, key.Public().(ed25519.PublicKey)...)
+	return append(out, sig...)
+}
+
+// Embed a signature in a module, replacing the one it already has
+func Sign(wasm []byte, key ed25519.PrivateKey) ([]byte, error) {
+	unsigned, _, _, err := wasmbin.TrailingCustomSection(wasm, SectionName)
+	if err != nil {
+		return nil, err
+	}
+	return wasmbin.AppendCustomSection(unsigned, SectionName, sign(unsigned, key)), nil
+}
+
+// Signature of the whole file, to be stored in a sidecar
+func SignDetached(wasm []byte, key ed25519.PrivateKey) []byte {
+	return sign(wasm, key)
+}
+
+// The module without its embedded signature, and the signature if it has one
+func Embedded(wasm []byte) (unsigned []byte, signature []byte, err error) {
+	unsigned, signature, _, err = wasmbin.TrailingCustomSection(wasm, SectionName)
+	return unsigned, signature, err
+}
+
+// Check a signature of message against the trusted keys, and return the key that signed it
+func Verify(message []byte, signature []byte, trusted []ed25519.PublicKey) (ed25519.PublicKey, error) {
+	if signature == nil {
+		return nil, ErrUnsigned
+	}
+	if len(signature) != signatureSize || signature[0] != version {
+		return nil, fmt.Errorf("%w: unsupported format", ErrBadSignature)
+	}
+
+	signer := ed25519.PublicKey(signature[1 : 1+ed25519.PublicKeySize])
+	isTrusted := false
+	for _, key := range trusted {
+		if key.Equal(signer) {
+			isTrusted = true
+			break
+		}
+	}
+	if !isTrusted {
+		return signer, fmt.Errorf("%w: %s", ErrUntrustedKey, KeyId(signer))
+	}
+
+	if !ed25519.Verify(signer, append([]byte(domain), message...), signature[1+ed25519.PublicKeySize:]) {
+		return signer, ErrBadSignature
+	}
+	return signer, nil
+}
+
+// PEM encoded PKCS #8, the format openssl uses for ed25519 keys
+func MarshalPrivateKey(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// PEM encoded PKIX, the format openssl uses for ed25519 keys
+func MarshalPublicKey(key ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("Not a PEM encoded private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("Not an ed25519 private key")
+	}
+	return edKey, nil
+}
+
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("Not a PEM encoded public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("Not an ed25519 public key")
+	}
+	return edKey, nil
+}
@@ -0,0 +1,107 @@
+package signing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmbin"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+)
+
+// Module with nothing but a single page of exported memory
+var module = []byte{
+	0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00,
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	0x07, 0x0a, 0x01, 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+}
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return public, private
+}
+
+func TestVerifyingLoader(t *testing.T) {
+	public, private := newKey(t)
+	_, untrusted := newKey(t)
+
+	signed, err := Sign(module, private)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	resigned, err := Sign(signed, private)
+	if err != nil || len(resigned) != len(signed) {
+		t.Fatalf("Signing again should replace the signature, got %d bytes instead of %d: %v", len(resigned), len(signed), err)
+	}
+	untrustedSigned, _ := Sign(module, untrusted)
+	tampered := append([]byte{}, signed...)
+	tampered[12] = 2
+	// the signature only counts as the last section, anything after it would be unsigned
+	notLast := wasmbin.AppendCustomSection(signed, "name", nil)
+
+	detached := SignDetached(module, private)
+	badVersion := append([]byte{2}, detached[1:]...)
+
+	modules := map[string][]byte{
+		"signed":     signed,
+		"detached":   module,
+		"tampered":   tampered,
+		"untrusted":  untrustedSigned,
+		"unsigned":   module,
+		"notLast":    notLast,
+		"short":      module,
+		"badVersion": module,
+		"lookupErr":  module,
+	}
+	sidecars := map[string][]byte{
+		"detached":   detached,
+		"short":      detached[:len(detached)-1],
+		"badVersion": badVersion,
+	}
+
+	var audited []AuditEvent
+	load := VerifyingLoader(func(ctx context.Context, moduleId string) ([]byte, error) {
+		return modules[moduleId], nil
+	}, Config{
+		TrustedKeys: []ed25519.PublicKey{public},
+		Signature: func(ctx context.Context, moduleId string) ([]byte, error) {
+			if sig, ok := sidecars[moduleId]; ok {
+				return sig, nil
+			}
+			if moduleId == "lookupErr" {
+				return nil, loader.ErrUnavailable
+			}
+			return nil, loader.ErrNoSignature
+		},
+		Audit: func(event AuditEvent) { audited = append(audited, event) },
+	})
+
+	for _, test := range []struct {
+		moduleId string
+		err      error
+	}{
+		{"signed", nil},
+		{"detached", nil},
+		{"tampered", ErrBadSignature},
+		{"untrusted", ErrUntrustedKey},
+		{"unsigned", ErrUnsigned},
+		{"notLast", ErrUnsigned},
+		{"short", ErrBadSignature},
+		{"badVersion", ErrBadSignature},
+		// failing to look up the sidecar isn't the same as not having one
+		{"lookupErr", loader.ErrUnavailable},
+	} {
+		audited = nil
+		_, err := load(context.Background(), test.moduleId)
+		if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+			t.Errorf("Loading %s returned %v, expected %v", test.moduleId, err, test.err)
+		}
+		if len(audited) != 1 || audited[0].Accepted != (test.err == nil) || audited[0].ModuleId != test.moduleId {
+			t.Errorf("Unexpected audit events for %s: %+v", test.moduleId, audited)
+		}
+	}
+}
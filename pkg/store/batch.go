@@ -0,0 +1,173 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Name of the export that modules define to handle many messages in one call
+const batchExport = "__onMessageBatch"
+
+// Run many ON_MESSAGE events for one module, with a single guest call per room.
+//
+// The events of each room are encoded as one flat array of [connectionId, roomId, timestamp, payload] per event
+// and passed to __onMessageBatch. The module returns an array with one string per event, empty on
+// success and an error message otherwise. Each room's call is an execution of its own: it holds the room's
+// state, waits behind the room's other events with OrderedDispatch, and has its own transaction and timeout.
+// If the module doesn't export __onMessageBatch, every event is run on its own through ExecuteOnModule instead.
+//
+// The returned slice has one error (or nil) per event, in the same order
+func (s *SandboxStore) ExecuteBatch(ctx context.Context, moduleId string, events []*wsevents.WSEventInfo) []error {
+	errs := make([]error, len(events))
+
+	// only messages for this module can go in the batch
+	batch := make([]*wsevents.WSEventInfo, 0, len(events))
+	indexes := make([]int, 0, len(events))
+	for i, event := range events {
+		switch {
+		case event.InstanceId != moduleId:
+			errs[i] = fmt.Errorf("Event is for module %s, not %s", event.InstanceId, moduleId)
+		case event.EventType != wsevents.ON_MESSAGE:
+			errs[i] = fmt.Errorf("Only ON_MESSAGE events can be batched")
+		default:
+			batch = append(batch, event)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(batch) == 0 {
+		return errs
+	}
+
+	hasBatchExport, err := s.exportsFunction(moduleId, batchExport)
+	if err != nil {
+		for _, index := range indexes {
+			errs[index] = err
+		}
+		return errs
+	}
+
+	if !hasBatchExport {
+		// each event is an execution of its own, so it can't take the batch's execution ID
+		eventCtx := WithExecutionId(ctx, "")
+		for i, event := range batch {
+			errs[indexes[i]] = s.ExecuteOnModule(eventCtx, event)
+		}
+		return errs
+	}
+
+	// group the events by room, keeping their order within each room
+	var rooms []string
+	byRoom := make(map[string][]int)
+	for i, event := range batch {
+		if _, ok := byRoom[event.RoomId]; !ok {
+			rooms = append(rooms, event.RoomId)
+		}
+		byRoom[event.RoomId] = append(byRoom[event.RoomId], i)
+	}
+
+	for _, roomId := range rooms {
+		roomEvents := make([]*wsevents.WSEventInfo, 0, len(byRoom[roomId]))
+		for _, i := range byRoom[roomId] {
+			roomEvents = append(roomEvents, batch[i])
+		}
+
+		roomErrs := s.executeRoomBatch(ctx, moduleId, roomId, roomEvents)
+		for j, i := range byRoom[roomId] {
+			errs[indexes[i]] = roomErrs[j]
+		}
+	}
+
+	return errs
+}
+
+// Pass the messages of a single room to __onMessageBatch
+func (s *SandboxStore) executeRoomBatch(ctx context.Context, moduleId string, roomId string, events []*wsevents.WSEventInfo) []error {
+	for _, event := range events {
+		s.observe(event)
+	}
+
+	batchErrs := make([]error, len(events))
+	finished := func(err error) {
+		for i, event := range events {
+			s.observeFinished(event, cmp.Or(err, batchErrs[i]))
+		}
+	}
+	err := s.runInOrder(ctx, events[0], func(ctx context.Context, _ *wsevents.WSEventInfo) error {
+		return s.withInstance(ctx, moduleId, "", roomId, func(ctx context.Context, instance api.Module) error {
+			if s.recorder != nil {
+				s.recorder.RecordBatch(ExecutionId(ctx), events)
+			}
+
+			onMessageBatch := instance.ExportedFunction(batchExport)
+			if onMessageBatch == nil {
+				return fmt.Errorf("Module does not export %s", batchExport)
+			}
+			return s.callInTransaction(ctx, moduleId, func(ctx context.Context) error {
+				return callBatchExport(ctx, instance, onMessageBatch, events, batchErrs)
+			})
+		})
+	}, finished)
+
+	// batchErrs may still be written to if ctx was cancelled while the batch was running
+	if err != nil {
+		errs := make([]error, len(events))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	return batchErrs
+}
+
+// Check if a module exports a function, loading the module if needed
+func (s *SandboxStore) exportsFunction(moduleId string, name string) (bool, error) {
+	active, err := s.loadModule(moduleId)
+	if err != nil {
+		return false, err
+	}
+	defer active.wg.Done()
+
+	_, ok := active.compiled.ExportedFunctions()[name]
+	return ok, nil
+}
+
+func callBatchExport(ctx context.Context, instance api.Module, onMessageBatch api.Function, batch []*wsevents.WSEventInfo, batchErrs []error) error {
+	ptr, memLen, err := asmscript.WriteWSEventBatch(&asmscript.ModuleContext{
+		Module: instance,
+		Ctx:    ctx,
+	}, batch)
+	if err != nil {
+		return err
+	}
+
+	results, err := onMessageBatch.Call(ctx, ptr, memLen)
+	if err != nil {
+		return err
+	}
+
+	// a null pointer means every event succeeded
+	if len(results) == 0 || results[0] == 0 {
+		return nil
+	}
+
+	statuses, err := asmscript.ReadArray(instance.Memory(), uint32(results[0]))
+	if err != nil {
+		return err
+	}
+	if len(statuses) != len(batch) {
+		return fmt.Errorf("%s returned %d results for %d events", batchExport, len(statuses), len(batch))
+	}
+
+	for i, status := range statuses {
+		if status != "" {
+			batchErrs[i] = errors.New(status)
+		}
+	}
+	return nil
+}
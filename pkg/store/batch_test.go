@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Broadcasts the array it was called with, and fails the second event of every call.
+// Calls that don't have exactly two events get the wrong number of results
+var batchModule = `(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(data (i32.const 60) "` + watArray("", "bad") + `")
+	(func (export "__onMessageBatch") (param $ptr i32) (param $len i32) (result i32)
+		(drop (call $broadcast (local.get $ptr) (local.get $len)))
+		(i32.const 64)))`
+
+// Broadcasts the event it was called with, and traps on events that are longer than 40 bytes
+const unbatchedModule = `(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(func (export "__onMessage") (param $ptr i32) (param $len i32)
+		(drop (call $broadcast (local.get $ptr) (local.get $len)))
+		(i32.gt_u (local.get $len) (i32.const 40))
+		if
+			unreachable
+		end))`
+
+// Store that records the payload of every call, as the [connectionId, roomId, timestamp, payload] fields it was passed
+func newBatchStore(t *testing.T, wat string) (*SandboxStore, func() [][]string) {
+	var mu sync.Mutex
+	var calls [][]string
+
+	s := newTestStore(t, SandboxStoreCfg{
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, decodeTestArray(t, event.Payload[0]))
+			return "", nil
+		}),
+	}, wat)
+
+	return s, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(calls)
+	}
+}
+
+func decodeTestArray(t *testing.T, encoded string) []string {
+	buf := []byte(encoded)
+	if len(buf) < 6 || buf[0] != '+' {
+		t.Errorf("Invalid array %q", encoded)
+		return nil
+	}
+
+	var fields []string
+	offset := 6
+	for range binary.LittleEndian.Uint32(buf[2:]) {
+		size := int(binary.LittleEndian.Uint32(buf[offset:]))
+		fields = append(fields, string(buf[offset+4:offset+4+size]))
+		offset += 4 + size
+	}
+	return fields
+}
+
+func message(instanceId string, roomId string, payload string) *wsevents.WSEventInfo {
+	return &wsevents.WSEventInfo{
+		InstanceId:   instanceId,
+		ConnectionId: "c",
+		RoomId:       roomId,
+		EventType:    wsevents.ON_MESSAGE,
+		Timestamp:    1,
+		Payload:      payload,
+	}
+}
+
+// Only the payloads of a call's events
+func payloads(fields []string) []string {
+	var payloads []string
+	for i := 3; i < len(fields); i += 4 {
+		payloads = append(payloads, fields[i])
+	}
+	return payloads
+}
+
+func TestExecuteBatchGroupsByRoom(t *testing.T) {
+	s, calls := newBatchStore(t, batchModule)
+
+	leave := message("m", "a", "leave")
+	leave.EventType = wsevents.ON_LEAVE
+	errs := s.ExecuteBatch(context.Background(), "m", []*wsevents.WSEventInfo{
+		message("m", "a", "a1"),
+		message("m", "b", "b1"),
+		message("other", "a", "other"),
+		message("m", "a", "a2"),
+		leave,
+		message("m", "c", "c1"),
+		message("m", "b", "b2"),
+	})
+
+	// one call per room, in the order each room was first seen, with the room's events in order
+	expected := [][]string{{"a1", "a2"}, {"b1", "b2"}, {"c1"}}
+	got := calls()
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d calls, got %v", len(expected), got)
+	}
+	for i, call := range got {
+		if !slices.Equal(payloads(call), expected[i]) || call[1] != expected[i][0][:1] {
+			t.Errorf("Expected call %d to have %v, got %v", i, expected[i], call)
+		}
+	}
+
+	// every event gets its own result, no matter how the others went
+	for i, expected := range []string{"", "", "not m", "bad", "ON_MESSAGE", "1 events", "bad"} {
+		switch {
+		case expected == "" && errs[i] != nil:
+			t.Errorf("Expected event %d to succeed, got %v", i, errs[i])
+		case expected != "" && (errs[i] == nil || !strings.Contains(errs[i].Error(), expected)):
+			t.Errorf("Expected event %d to fail with %q, got %v", i, expected, errs[i])
+		}
+	}
+}
+
+// Without __onMessageBatch each event runs on its own, and a trap only fails its own event
+func TestExecuteBatchFallback(t *testing.T) {
+	s, calls := newBatchStore(t, unbatchedModule)
+
+	errs := s.ExecuteBatch(context.Background(), "m", []*wsevents.WSEventInfo{
+		message("m", "a", "1"),
+		message("m", "b", "this payload makes the event too long"),
+		message("m", "a", "2"),
+	})
+
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("Expected only the second event to fail, got %v", errs)
+	}
+
+	var got []string
+	for _, call := range calls() {
+		got = append(got, payloads(call)...)
+	}
+	if expected := []string{"1", "this payload makes the event too long", "2"}; !slices.Equal(got, expected) {
+		t.Errorf("Expected each event to run in order, got %q", got)
+	}
+}
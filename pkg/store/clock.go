@@ -0,0 +1,28 @@
+package store
+
+import "time"
+
+// Source of time for module timers and room ticks. Tests can pass a fake one to fire timers
+// without waiting, see sandboxtest.Clock
+type Clock interface {
+	Now() time.Time
+
+	// Call f in its own goroutine once d has passed, like time.AfterFunc
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A timer started by Clock.AfterFunc, *time.Timer implements it
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Returned (as a Result) when an event is shed because a queue is full
+var ErrOverloaded = errors.New("Sandbox is overloaded")
+
+// Returned (as a Result) for events submitted after the store was closed
+var ErrStoreClosed = errors.New("Sandbox store is closed")
+
+// The outcome of an event passed to Submit
+type Result struct {
+	Event *wsevents.WSEventInfo
+	Err   error
+}
+
+// Snapshot of the dispatcher's queues, see SandboxStore.QueueStats
+type QueueStats struct {
+	// Events submitted but not yet running
+	Queued int64
+
+	// Events currently running on an instance
+	Running int64
+
+	// Events rejected with ErrOverloaded since the store was created
+	Rejected uint64
+
+	// Events submitted but not yet finished (queued + running), per module
+	PerModule map[string]int64
+}
+
+// Runs events passed to Submit on a fixed number of worker goroutines.
+//
+// Workers are only started on the first Submit, so stores that only use ExecuteOnModule don't pay for them
+type dispatcher struct {
+	jobs    chan *dispatchJob
+	workers int
+
+	// Held by whatever is executing a submitted event, so at most `workers` run at once.
+	// In ordered mode the room's goroutine takes a slot instead of a worker
+	slots chan struct{}
+
+	maxPerModule int64
+	pending      map[string]int64
+
+	// Set once stop is called, guarded by pendingMu so no event is admitted after it
+	closed    bool
+	pendingMu sync.Mutex
+
+	// Events that were admitted but aren't queued yet, stop waits for them before draining the queue
+	admitting sync.WaitGroup
+
+	running  atomic.Int64
+	rejected atomic.Uint64
+
+	startOnce sync.Once
+	quit      chan struct{}
+
+	execute func(context.Context, *wsevents.WSEventInfo) error
+
+	// Called with each event right before it runs, so shed events are never observed,
+	// and once it has run
+	observe         func(*wsevents.WSEventInfo)
+	observeFinished func(*wsevents.WSEventInfo, error)
+}
+
+type dispatchJob struct {
+	ctx    context.Context
+	event  *wsevents.WSEventInfo
+	result chan Result
+}
+
+func newDispatcher(workers int, queueSize int, maxPerModule int, execute func(context.Context, *wsevents.WSEventInfo) error, observe func(*wsevents.WSEventInfo), observeFinished func(*wsevents.WSEventInfo, error)) *dispatcher {
+	return &dispatcher{
+		jobs:            make(chan *dispatchJob, queueSize),
+		workers:         workers,
+		slots:           make(chan struct{}, workers),
+		maxPerModule:    int64(maxPerModule),
+		pending:         make(map[string]int64),
+		quit:            make(chan struct{}),
+		execute:         execute,
+		observe:         observe,
+		observeFinished: observeFinished,
+	}
+}
+
+// Reserve room for an event of the given module, or shed it.
+//
+// Once admitted, the caller has to queue the event and then call d.admitting.Done()
+func (d *dispatcher) admit(moduleId string) error {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	if d.closed {
+		return ErrStoreClosed
+	}
+	if d.pending[moduleId] >= d.maxPerModule {
+		d.rejected.Add(1)
+		return ErrOverloaded
+	}
+	d.pending[moduleId]++
+	d.admitting.Add(1)
+	return nil
+}
+
+func (d *dispatcher) release(moduleId string) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	d.pending[moduleId]--
+	if d.pending[moduleId] <= 0 {
+		delete(d.pending, moduleId)
+	}
+}
+
+// Execute an event while holding a slot
+func (d *dispatcher) run(ctx context.Context, event *wsevents.WSEventInfo) error {
+	select {
+	case d.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-d.slots }()
+
+	// ordered events can still be picked up from their room's queue while the store is closing
+	select {
+	case <-d.quit:
+		return ErrStoreClosed
+	default:
+	}
+
+	d.running.Add(1)
+	defer d.running.Add(-1)
+
+	if d.observe != nil {
+		d.observe(event)
+	}
+	err := d.execute(ctx, event)
+	if d.observeFinished != nil {
+		d.observeFinished(event, err)
+	}
+	return err
+}
+
+func (d *dispatcher) start() {
+	d.startOnce.Do(func() {
+		for range d.workers {
+			go d.work()
+		}
+	})
+}
+
+func (d *dispatcher) work() {
+	for {
+		select {
+		case <-d.quit:
+			return
+		case job := <-d.jobs:
+			var err error
+			if err = job.ctx.Err(); err == nil {
+				err = d.run(job.ctx, job.event)
+			}
+			d.finish(job.event, job.result, err)
+		}
+	}
+}
+
+func (d *dispatcher) finish(event *wsevents.WSEventInfo, result chan Result, err error) {
+	d.release(event.InstanceId)
+	result <- Result{Event: event, Err: err}
+	close(result)
+}
+
+// Stop the workers, and fail anything that is still queued
+func (d *dispatcher) stop() {
+	d.pendingMu.Lock()
+	if d.closed {
+		d.pendingMu.Unlock()
+		return
+	}
+	d.closed = true
+	d.pendingMu.Unlock()
+
+	// nothing can be admitted anymore, so once these are queued the drain below sees every job
+	d.admitting.Wait()
+	close(d.quit)
+
+	for {
+		select {
+		case job := <-d.jobs:
+			d.finish(job.event, job.result, ErrStoreClosed)
+		default:
+			return
+		}
+	}
+}
+
+func (d *dispatcher) stats() QueueStats {
+	d.pendingMu.Lock()
+	perModule := make(map[string]int64, len(d.pending))
+	total := int64(0)
+	for id, n := range d.pending {
+		perModule[id] = n
+		total += n
+	}
+	d.pendingMu.Unlock()
+
+	running := d.running.Load()
+	return QueueStats{
+		Queued:    max(total-running, 0),
+		Running:   running,
+		Rejected:  d.rejected.Load(),
+		PerModule: perModule,
+	}
+}
+
+// Queue an event to run asynchronously, without the caller providing a goroutine.
+//
+// The returned channel receives exactly one Result and is then closed. If the module already has
+// MaxQueuedPerModule events pending, or the queue is full, the result is ErrOverloaded right away,
+// and events that are still queued when the store is closed get ErrStoreClosed.
+// With OrderedDispatch enabled, events for the same room still run in the order they were submitted.
+//
+// The EventObserver sees events once they start running, so events that are shed are never observed
+func (s *SandboxStore) Submit(ctx context.Context, event *wsevents.WSEventInfo) <-chan Result {
+	result := make(chan Result, 1)
+	d := s.dispatcher
+
+	fail := func(err error) <-chan Result {
+		result <- Result{Event: event, Err: err}
+		close(result)
+		return result
+	}
+
+	if !event.EventType.Valid() {
+		return fail(errors.New("Invalid WS event type"))
+	}
+	if err := d.admit(event.InstanceId); err != nil {
+		return fail(err)
+	}
+	defer d.admitting.Done()
+
+	// ordered events are queued per room, which keeps them in order,
+	// and take a dispatcher slot when it's their turn to run
+	if s.ordered != nil {
+		err := s.ordered.enqueueWith(ctx, event, d.run, func(err error) {
+			d.finish(event, result, err)
+		})
+		if err != nil {
+			if !errors.Is(err, ErrStoreClosed) {
+				d.rejected.Add(1)
+			}
+			d.release(event.InstanceId)
+			return fail(err)
+		}
+		return result
+	}
+
+	d.start()
+	select {
+	case d.jobs <- &dispatchJob{ctx: ctx, event: event, result: result}:
+		return result
+	default:
+		d.rejected.Add(1)
+		d.release(event.InstanceId)
+		return fail(ErrOverloaded)
+	}
+}
+
+// Current depth of the Submit queues
+func (s *SandboxStore) QueueStats() QueueStats {
+	return s.dispatcher.stats()
+}
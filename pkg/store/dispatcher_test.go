@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Remembers every event it observed
+type testObserver struct {
+	mu     sync.Mutex
+	events map[*wsevents.WSEventInfo]bool
+}
+
+func (o *testObserver) Observe(event *wsevents.WSEventInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.events == nil {
+		o.events = make(map[*wsevents.WSEventInfo]bool)
+	}
+	o.events[event] = true
+}
+
+func (o *testObserver) observed(event *wsevents.WSEventInfo) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.events[event]
+}
+
+// Handler map whose BROADCAST signals started, then waits for release
+func blockingHandlers(started chan<- struct{}, release <-chan struct{}) *wasmevents.HandlerMap {
+	return wasmevents.NewHandlerMap().AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+		started <- struct{}{}
+		<-release
+		return "", nil
+	})
+}
+
+func receive(t *testing.T, result <-chan Result) Result {
+	t.Helper()
+	select {
+	case res := <-result:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No result after 5 seconds")
+		return Result{}
+	}
+}
+
+func TestSubmitShedsPerModule(t *testing.T) {
+	started := make(chan struct{}, 8)
+	release := make(chan struct{})
+	observer := &testObserver{}
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:           2,
+		DispatchWorkers:    1,
+		MaxQueuedPerModule: 2,
+		HandlerMap:         blockingHandlers(started, release),
+		EventObserver:      observer,
+	}, orderedModule)
+
+	event := func(instanceId string) *wsevents.WSEventInfo {
+		return &wsevents.WSEventInfo{InstanceId: instanceId, EventType: wsevents.ON_MESSAGE}
+	}
+
+	running, queued, shed := event("m"), event("m"), event("m")
+	results := []<-chan Result{s.Submit(context.Background(), running)}
+	<-started
+	results = append(results, s.Submit(context.Background(), queued))
+
+	if res := receive(t, s.Submit(context.Background(), shed)); !errors.Is(res.Err, ErrOverloaded) {
+		t.Errorf("Expected the third event to be shed with ErrOverloaded, got %v", res.Err)
+	}
+	// the limit is per module
+	other := s.Submit(context.Background(), event("other"))
+
+	stats := s.QueueStats()
+	if stats.Running != 1 || stats.Queued != 2 || stats.Rejected != 1 || stats.PerModule["m"] != 2 || stats.PerModule["other"] != 1 {
+		t.Errorf("Unexpected queue stats %+v", stats)
+	}
+
+	close(release)
+	for _, result := range append(results, other) {
+		if res := receive(t, result); res.Err != nil {
+			t.Errorf("Expected admitted events to run, got %v", res.Err)
+		}
+	}
+
+	if !observer.observed(running) || !observer.observed(queued) {
+		t.Errorf("Expected the events that ran to be observed")
+	}
+	if observer.observed(shed) {
+		t.Errorf("Expected the shed event not to be observed")
+	}
+	if stats := s.QueueStats(); stats.Running != 0 || stats.Queued != 0 || len(stats.PerModule) != 0 {
+		t.Errorf("Expected empty queues once everything ran, got %+v", stats)
+	}
+}
+
+func TestSubmitShedsWhenQueueIsFull(t *testing.T) {
+	started := make(chan struct{}, 8)
+	release := make(chan struct{})
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:          2,
+		DispatchWorkers:   1,
+		DispatchQueueSize: 1,
+		HandlerMap:        blockingHandlers(started, release),
+	}, orderedModule)
+
+	event := &wsevents.WSEventInfo{InstanceId: "m", EventType: wsevents.ON_MESSAGE}
+	results := []<-chan Result{s.Submit(context.Background(), event)}
+	<-started
+	results = append(results, s.Submit(context.Background(), event))
+
+	if res := receive(t, s.Submit(context.Background(), event)); !errors.Is(res.Err, ErrOverloaded) {
+		t.Errorf("Expected ErrOverloaded with a full queue, got %v", res.Err)
+	}
+
+	close(release)
+	for _, result := range results {
+		if res := receive(t, result); res.Err != nil {
+			t.Errorf("Expected queued events to run, got %v", res.Err)
+		}
+	}
+}
+
+// Every submitted event gets exactly one result, even while the store closes,
+// and events that never ran are never observed
+func TestSubmitDuringClose(t *testing.T) {
+	observer := &testObserver{}
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:           2,
+		DispatchWorkers:    2,
+		MaxQueuedPerModule: 1000,
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+			return "", nil
+		}),
+		EventObserver: observer,
+	}, orderedModule)
+
+	// each submitter keeps going until the store is closed
+	const submitters = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var results []Result
+	for range submitters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				result := s.Submit(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", EventType: wsevents.ON_MESSAGE})
+				var res Result
+				select {
+				case res = <-result:
+				case <-time.After(5 * time.Second):
+					t.Errorf("No result after 5 seconds")
+					return
+				}
+				if _, open := <-result; open {
+					t.Errorf("Expected the result channel to be closed after one result")
+				}
+
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+				if errors.Is(res.Err, ErrStoreClosed) {
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	s.Close(context.Background())
+	wg.Wait()
+
+	closed := 0
+	for _, res := range results {
+		if errors.Is(res.Err, ErrStoreClosed) {
+			closed++
+			if observer.observed(res.Event) {
+				t.Errorf("An event rejected with ErrStoreClosed was observed")
+			}
+		}
+	}
+	if closed != submitters {
+		t.Errorf("Expected every submitter to get ErrStoreClosed, %d of %d did", closed, submitters)
+	}
+}
+
+// Events still waiting in their room's queue fail with ErrStoreClosed once Close is called, instead of running
+func TestCloseFailsQueuedOrderedEvents(t *testing.T) {
+	started := make(chan struct{}, 8)
+	release := make(chan struct{})
+	observer := &testObserver{}
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:        2,
+		OrderedDispatch: true,
+		HandlerMap:      blockingHandlers(started, release),
+		EventObserver:   observer,
+	}, orderedModule)
+	// runs before the store's cleanup, so a failed test doesn't leave Close waiting
+	releaseRunning := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(releaseRunning)
+
+	event := func() *wsevents.WSEventInfo {
+		return &wsevents.WSEventInfo{InstanceId: "m", RoomId: "a", EventType: wsevents.ON_MESSAGE}
+	}
+	running := s.Submit(context.Background(), event())
+	<-started
+
+	var queued []<-chan Result
+	for range 3 {
+		queued = append(queued, s.Submit(context.Background(), event()))
+	}
+	executed := make(chan error, 1)
+	executedEvent := event()
+	go func() { executed <- s.ExecuteOnModule(context.Background(), executedEvent) }()
+	// ExecuteOnModule observes its event right before queueing it, and it fails the same way if Close comes first
+	for deadline := time.Now().Add(5 * time.Second); !observer.observed(executedEvent); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("ExecuteOnModule never queued its event")
+		}
+	}
+
+	// Close waits for the running event, the queued ones fail right away
+	closed := make(chan struct{})
+	go func() {
+		s.Close(context.Background())
+		close(closed)
+	}()
+	for _, result := range queued {
+		res := receive(t, result)
+		if !errors.Is(res.Err, ErrStoreClosed) {
+			t.Errorf("Expected a queued event to fail with ErrStoreClosed, got %v", res.Err)
+		}
+		if observer.observed(res.Event) {
+			t.Errorf("Expected a queued event not to be observed")
+		}
+	}
+	select {
+	case err := <-executed:
+		if !errors.Is(err, ErrStoreClosed) {
+			t.Errorf("Expected ExecuteOnModule to fail with ErrStoreClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ExecuteOnModule didn't return")
+	}
+
+	if res := s.Submit(context.Background(), event()); !errors.Is(receive(t, res).Err, ErrStoreClosed) {
+		t.Errorf("Expected events submitted after Close to fail with ErrStoreClosed")
+	}
+
+	releaseRunning()
+	if res := receive(t, running); res.Err != nil {
+		t.Errorf("Expected the running event to finish, got %v", res.Err)
+	}
+	<-closed
+	select {
+	case <-started:
+		t.Errorf("Expected only one event to run")
+	default:
+	}
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Name of the export that modules define to handle HTTP requests
+const httpExport = "__onHttpRequest"
+
+// Returned when the module does not export __onHttpRequest
+var ErrNoHTTPHandler = errors.New("Module does not handle HTTP requests")
+
+// Returned when the request body is larger than MaxHTTPBodyBytes
+var ErrBodyTooLarge = errors.New("Request body is too large")
+
+// The response that a module produced for an HTTP request
+type HTTPResponse struct {
+	StatusCode int
+
+	// Hop-by-hop and framing headers (Content-Length, Connection, ...) are dropped,
+	// since the server writing the response is in charge of those
+	Header http.Header
+	Body   []byte
+}
+
+// Headers that modules can't set, see isHopHeader
+var hopHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Keep-Alive",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Function that picks the module which should handle an HTTP request.
+//
+// It returns the module ID, and the path that the module should see
+type ModuleResolver func(*http.Request) (moduleId string, path string, err error)
+
+// Call a module's __onHttpRequest export with the given request.
+//
+// The request is passed in as an array of [method, path, query, body, name1, value1, name2, value2, ...],
+// with one name / value pair per header value. The module returns a pointer to an array of
+// [status, body, name1, value1, ...] in the same format
+func (s *SandboxStore) ExecuteHTTP(ctx context.Context, moduleId string, req *http.Request) (*HTTPResponse, error) {
+	return s.executeHTTP(ctx, moduleId, req.URL.Path, req)
+}
+
+func (s *SandboxStore) executeHTTP(ctx context.Context, moduleId string, path string, req *http.Request) (*HTTPResponse, error) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, s.maxHTTPBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > s.maxHTTPBodyBytes {
+		return nil, ErrBodyTooLarge
+	}
+
+	// headers are sorted so that the module sees the same request when it's replayed
+	fields := []string{req.Method, path, req.URL.RawQuery, string(body)}
+	for _, name := range slices.Sorted(maps.Keys(req.Header)) {
+		for _, value := range req.Header[name] {
+			fields = append(fields, name, value)
+		}
+	}
+
+	var resp *HTTPResponse
+	err = s.withInstance(ctx, moduleId, "", "", func(ctx context.Context, instance api.Module) error {
+		onHttpRequest := instance.ExportedFunction(httpExport)
+		if onHttpRequest == nil {
+			return ErrNoHTTPHandler
+		}
+
+		if s.recorder != nil {
+			s.recorder.RecordHTTP(ExecutionId(ctx), &record.HTTPRequest{
+				InstanceId: moduleId,
+				Method:     req.Method,
+				Path:       path,
+				Query:      req.URL.RawQuery,
+				Body:       string(body),
+				Header:     req.Header,
+			})
+		}
+
+		return s.callInTransaction(ctx, moduleId, func(ctx context.Context) error {
+			ptr, memLen, err := asmscript.WriteArray(&asmscript.ModuleContext{
+				Module: instance,
+				Ctx:    ctx,
+			}, fields)
+			if err != nil {
+				return err
+			}
+
+			results, err := onHttpRequest.Call(ctx, ptr, memLen)
+			if err != nil {
+				return err
+			}
+			if len(results) == 0 || results[0] == 0 {
+				return fmt.Errorf("%s returned no response", httpExport)
+			}
+
+			respFields, err := asmscript.ReadArray(instance.Memory(), uint32(results[0]))
+			if err != nil {
+				return err
+			}
+
+			resp, err = decodeHTTPResponse(respFields)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func decodeHTTPResponse(fields []string) (*HTTPResponse, error) {
+	if len(fields) < 2 || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("Invalid HTTP response from module")
+	}
+
+	status, err := strconv.Atoi(fields[0])
+	if err != nil || status < 200 || status > 599 {
+		return nil, fmt.Errorf("Invalid HTTP status %q from module", fields[0])
+	}
+
+	resp := &HTTPResponse{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       []byte(fields[1]),
+	}
+
+	// headers listed in Connection are hop-by-hop too
+	var connectionHeaders []string
+	for i := 2; i < len(fields); i += 2 {
+		if http.CanonicalHeaderKey(fields[i]) == "Connection" {
+			for _, name := range strings.Split(fields[i+1], ",") {
+				connectionHeaders = append(connectionHeaders, http.CanonicalHeaderKey(strings.TrimSpace(name)))
+			}
+		}
+	}
+
+	for i := 2; i < len(fields); i += 2 {
+		name := http.CanonicalHeaderKey(fields[i])
+		if isHopHeader(name) || slices.Contains(connectionHeaders, name) {
+			continue
+		}
+		resp.Header.Add(name, fields[i+1])
+	}
+
+	return resp, nil
+}
+
+// Returns true for canonical header names that only apply to a single connection, or describe how the body is framed
+func isHopHeader(name string) bool {
+	return slices.Contains(hopHeaders, name) || strings.HasPrefix(name, "Proxy-")
+}
+
+// Route requests of the form /{moduleId}/rest/of/path to the module, which sees /rest/of/path.
+//
+// Module IDs containing slashes must be path escaped
+func PathPrefixResolver(req *http.Request) (string, string, error) {
+	trimmed := strings.TrimPrefix(req.URL.EscapedPath(), "/")
+	escapedId, rest, _ := strings.Cut(trimmed, "/")
+
+	moduleId, err := url.PathUnescape(escapedId)
+	if err != nil || moduleId == "" {
+		return "", "", fmt.Errorf("No module ID in path")
+	}
+
+	path, err := url.PathUnescape("/" + rest)
+	if err != nil {
+		return "", "", err
+	}
+
+	return moduleId, path, nil
+}
+
+// Create an http.Handler which forwards requests to modules.
+//
+// If resolve is nil, PathPrefixResolver is used
+func (s *SandboxStore) HTTPHandler(resolve ModuleResolver) http.Handler {
+	if resolve == nil {
+		resolve = PathPrefixResolver
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		moduleId, path, err := resolve(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		resp, err := s.executeHTTP(req.Context(), moduleId, path, req)
+		if errors.Is(err, ErrNoHTTPHandler) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if errors.Is(err, ErrBodyTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			slog.Error("Module failed to handle HTTP request", "moduleId", moduleId, "err", err)
+			http.Error(w, "Module failed to handle request", http.StatusInternalServerError)
+			return
+		}
+
+		for name, values := range resp.Header {
+			for _, value := range values {
+				w.Header().Add(name, value)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		w.Write(resp.Body)
+	})
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
+)
+
+// WAT string holding an array that the module returns, prefixed with its length like an AssemblyScript ArrayBuffer
+func watArray(fields ...string) string {
+	encoded := []byte{'+', 0}
+	encoded = binary.LittleEndian.AppendUint32(encoded, uint32(len(fields)))
+	for _, field := range fields {
+		encoded = binary.LittleEndian.AppendUint32(encoded, uint32(len(field)))
+		encoded = append(encoded, field...)
+	}
+
+	var b strings.Builder
+	for _, c := range binary.LittleEndian.AppendUint32(nil, uint32(len(encoded))) {
+		fmt.Fprintf(&b, "\\%02x", c)
+	}
+	for _, c := range encoded {
+		fmt.Fprintf(&b, "\\%02x", c)
+	}
+	return b.String()
+}
+
+// Responds to every request with a fixed response, stored at 64
+func httpModule(response ...string) string {
+	return `(module
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(data (i32.const 60) "` + watArray(response...) + `")
+	(func (export "__onHttpRequest") (param i32 i32) (result i32)
+		(i32.const 64)))`
+}
+
+const trappingHTTPModule = `(module
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(func (export "__onHttpRequest") (param i32 i32) (result i32)
+		unreachable))`
+
+var testResponse = []string{
+	"201", "created",
+	"X-Custom", "a",
+	"x-custom", "b",
+	"Content-Type", "text/plain",
+	"Content-Length", "999",
+	"Transfer-Encoding", "chunked",
+	"Connection", "close, X-Hop",
+	"X-Hop", "dropped",
+	"Keep-Alive", "timeout=5",
+	"Proxy-Authenticate", "Basic",
+	"TE", "trailers",
+	"Trailer", "X-Checksum",
+	"Upgrade", "websocket",
+}
+
+func TestExecuteHTTP(t *testing.T) {
+	s := newTestStore(t, SandboxStoreCfg{}, httpModule(testResponse...))
+
+	req := httptest.NewRequest(http.MethodPost, "/path?q=1", strings.NewReader("body"))
+	resp, err := s.ExecuteHTTP(context.Background(), "m", req)
+	if err != nil {
+		t.Fatalf("ExecuteHTTP failed: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated || string(resp.Body) != "created" {
+		t.Errorf("Expected 201 created, got %d %q", resp.StatusCode, resp.Body)
+	}
+	if values := resp.Header.Values("X-Custom"); !slices.Equal(values, []string{"a", "b"}) {
+		t.Errorf("Expected both X-Custom values, got %v", values)
+	}
+	if len(resp.Header) != 2 || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected only X-Custom and Content-Type, got %v", resp.Header)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	s := newTestStore(t, SandboxStoreCfg{}, httpModule(testResponse...))
+	server := httptest.NewServer(s.HTTPHandler(nil))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/m/path")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated || string(body) != "created" {
+		t.Errorf("Expected 201 created, got %d %q", resp.StatusCode, body)
+	}
+	if values := resp.Header.Values("X-Custom"); !slices.Equal(values, []string{"a", "b"}) {
+		t.Errorf("Expected both X-Custom values, got %v", values)
+	}
+
+	// the server frames the body itself, and keeps the connection open
+	if resp.ContentLength != int64(len("created")) || len(resp.TransferEncoding) != 0 || resp.Close {
+		t.Errorf("Expected the module's framing headers to be ignored, got length %d, encoding %v and close %v", resp.ContentLength, resp.TransferEncoding, resp.Close)
+	}
+	for _, name := range []string{"X-Hop", "Keep-Alive", "Proxy-Authenticate", "Te", "Trailer", "Upgrade"} {
+		if value := resp.Header.Get(name); value != "" {
+			t.Errorf("Expected %s to be dropped, got %q", name, value)
+		}
+	}
+}
+
+func TestHTTPModuleTraps(t *testing.T) {
+	s := newTestStore(t, SandboxStoreCfg{}, trappingHTTPModule)
+
+	if _, err := s.ExecuteHTTP(context.Background(), "m", httptest.NewRequest(http.MethodGet, "/", nil)); err == nil {
+		t.Errorf("Expected ExecuteHTTP to fail")
+	}
+
+	rec := httptest.NewRecorder()
+	s.HTTPHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/m/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "unreachable") {
+		t.Errorf("Expected the trap not to be shown to the client, got %q", body)
+	}
+}
+
+func TestExecuteHTTPIsRecorded(t *testing.T) {
+	var buf bytes.Buffer
+	recorder, err := record.NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("Failed to start recording: %v", err)
+	}
+	s := newTestStore(t, SandboxStoreCfg{Recorder: recorder}, httpModule("200", "ok"))
+
+	req := httptest.NewRequest(http.MethodPost, "/m/path?q=1", strings.NewReader("body"))
+	req.Header.Set("X-Custom", "a")
+	rec := httptest.NewRecorder()
+	s.HTTPHandler(nil).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	recording, err := record.Read(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	executions := recording.Executions()
+	if len(executions) != 1 || executions[0].HTTP == nil {
+		t.Fatalf("Expected an HTTP execution, got %+v", executions)
+	}
+
+	// the module's path, not the one the handler was called with
+	expected := &record.HTTPRequest{
+		InstanceId: "m",
+		Method:     http.MethodPost,
+		Path:       "/path",
+		Query:      "q=1",
+		Body:       "body",
+		Header:     http.Header{"X-Custom": {"a"}},
+	}
+	if !reflect.DeepEqual(executions[0].HTTP, expected) {
+		t.Errorf("Expected request %+v, got %+v", expected, executions[0].HTTP)
+	}
+}
@@ -2,9 +2,14 @@ package store
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"time"
 
+	builder "github.com/Cloud-RAMP/wasm-sandbox/internal/host-builder"
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmbin"
 	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
 )
@@ -40,29 +45,85 @@ func (s *SandboxStore) loadModule(moduleId string) (*ActiveModule, error) {
 		}
 	}()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.loadTimeout)
 	defer cancel()
 
-	// Compile the module once
-	compiled, err := loader.LoadCompiled(ctx, s.runtime, moduleId)
+	settings, err := s.settingsFunction(ctx, moduleId)
 	if err != nil {
 		return nil, err
 	}
 
-	// Instantiate a pool of instances from the compiled module
-	instances := make(chan api.Module, s.poolSize)
-	for range s.poolSize {
-		inst, err := s.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName(""))
+	wasm, err := s.loaderFunction(ctx, moduleId)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.MemoryLimitPages != 0 {
+		wasm, err = wasmbin.LimitMemory(wasm, settings.MemoryLimitPages)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to limit the memory of module %s: %w", moduleId, err)
+		}
+	}
+
+	// Snapshots need every mutable global exported so they can be restored
+	var prepared *wasmbin.Prepared
+	if settings.Snapshot || settings.Stateless {
+		prepared, err = wasmbin.PrepareSnapshot(wasm)
 		if err != nil {
+			return nil, fmt.Errorf("Failed to prepare module %s for snapshots: %w", moduleId, err)
+		}
+		wasm = prepared.Wasm
+	}
+
+	// Compile the module once
+	compiled, err := s.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	// WASI is opt-in, so refuse modules that need it without having enabled it
+	if builder.ImportsWASI(compiled) {
+		if !settings.WASI {
+			compiled.Close(ctx)
+			return nil, fmt.Errorf("Module %s imports WASI but WASI is not enabled for it", moduleId)
+		}
+		if err := s.ensureWASI(ctx); err != nil {
+			compiled.Close(ctx)
 			return nil, err
 		}
-		instances <- inst
 	}
 
+	if err := checkCapabilities(moduleId, compiled, settings); err != nil {
+		compiled.Close(ctx)
+		return nil, err
+	}
+
+	poolSize := defaultValue(settings.PoolSize, 0, s.poolSize)
 	mod := &ActiveModule{
 		compiled:   compiled,
-		instances:  instances,
+		instances:  make(chan api.Module, poolSize),
 		instanceId: moduleId,
+		settings:   settings,
+		poolSize:   poolSize,
+	}
+
+	// The first instance is initialized normally, the rest are restored from its snapshot
+	if prepared != nil {
+		inst, err := s.snapshotModule(ctx, mod, prepared)
+		if err != nil {
+			compiled.Close(ctx)
+			return nil, err
+		}
+		mod.instances <- inst
+	}
+
+	// Instantiate a pool of instances from the compiled module
+	for len(mod.instances) < int(poolSize) {
+		inst, err := s.newInstance(ctx, mod)
+		if err != nil {
+			return nil, err
+		}
+		mod.instances <- inst
 	}
 	mod.lastUsed.Store(time.Now().UnixNano())
 
@@ -76,3 +137,17 @@ func (s *SandboxStore) loadModule(moduleId string) (*ActiveModule, error) {
 
 	return mod, nil
 }
+
+// Refuse modules that import host functions outside of their capabilities
+func checkCapabilities(moduleId string, compiled wazero.CompiledModule, settings *loader.ModuleSettings) error {
+	if settings.Capabilities == nil {
+		return nil
+	}
+
+	for _, name := range builder.ImportedHostFunctions(compiled) {
+		if name != wasmevents.ABORT.String() && !slices.Contains(settings.Capabilities, name) {
+			return fmt.Errorf("Module %s imports %s, which is not in its capabilities", moduleId, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Returned when a room already has OrderedQueueSize events waiting to run.
+// This is a form of ErrOverloaded
+var ErrQueueFull = fmt.Errorf("Room event queue is full: %w", ErrOverloaded)
+
+// Serializes events per (instance, room) so that they run in the order they were dispatched.
+//
+// Each key with pending events has a single goroutine working through its queue,
+// so different rooms still run in parallel
+type orderedDispatcher struct {
+	mu        sync.Mutex
+	queues    map[orderedKey]*orderedQueue
+	queueSize int
+
+	// Set by stop, after which nothing can be queued
+	closed bool
+}
+
+type orderedKey struct {
+	instanceId string
+	roomId     string
+}
+
+type orderedQueue struct {
+	jobs []*orderedJob
+}
+
+type orderedJob struct {
+	ctx   context.Context
+	event *wsevents.WSEventInfo
+
+	// How to run the event, and what to do with the result
+	run  func(context.Context, *wsevents.WSEventInfo) error
+	done func(error)
+}
+
+func newOrderedDispatcher(queueSize int) *orderedDispatcher {
+	return &orderedDispatcher{
+		queues:    make(map[orderedKey]*orderedQueue),
+		queueSize: queueSize,
+	}
+}
+
+// Add an event to the back of its room's queue. The event is executed with run, and done is called with the result
+func (d *orderedDispatcher) enqueueWith(ctx context.Context, event *wsevents.WSEventInfo, run func(context.Context, *wsevents.WSEventInfo) error, done func(error)) error {
+	key := orderedKey{instanceId: event.InstanceId, roomId: event.RoomId}
+	job := &orderedJob{
+		ctx:   ctx,
+		event: event,
+		run:   run,
+		done:  done,
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrStoreClosed
+	}
+	queue, running := d.queues[key]
+	if !running {
+		queue = &orderedQueue{}
+		d.queues[key] = queue
+	}
+
+	if len(queue.jobs) >= d.queueSize {
+		return ErrQueueFull
+	}
+	queue.jobs = append(queue.jobs, job)
+
+	// the queue is removed from the map once it is drained, so a queue
+	// that was already in the map always has a goroutine working on it
+	if !running {
+		go d.run(key, queue)
+	}
+
+	return nil
+}
+
+func (d *orderedDispatcher) run(key orderedKey, queue *orderedQueue) {
+	for {
+		d.mu.Lock()
+		if len(queue.jobs) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		job := queue.jobs[0]
+		queue.jobs[0] = nil
+		queue.jobs = queue.jobs[1:]
+		d.mu.Unlock()
+
+		// don't bother running events that the caller has given up on
+		if err := job.ctx.Err(); err != nil {
+			job.done(err)
+			continue
+		}
+
+		job.done(job.run(job.ctx, job.event))
+	}
+}
+
+// Fail every event that is still queued with ErrStoreClosed, and refuse new ones.
+// Events that are already running are left to finish
+func (d *orderedDispatcher) stop() {
+	d.mu.Lock()
+	d.closed = true
+	var pending []*orderedJob
+	for _, queue := range d.queues {
+		pending = append(pending, queue.jobs...)
+		// the queue's goroutine sees it's empty once the running event is done, and exits
+		queue.jobs = nil
+	}
+	d.mu.Unlock()
+
+	for _, job := range pending {
+		job.done(ErrStoreClosed)
+	}
+}
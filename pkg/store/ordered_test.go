@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Broadcasts nothing with every message, so the handler sees which event ran
+const orderedModule = `(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(func (export "__onMessage") (param i32 i32)
+		(global.set $heap (i32.const 4096))
+		(drop (call $broadcast (i32.const 0) (i32.const 0)))))`
+
+func TestOrderedDispatchKeepsRoomOrder(t *testing.T) {
+	var mu sync.Mutex
+	ran := make(map[string][]int)
+
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:         4,
+		OrderedDispatch:  true,
+		OrderedQueueSize: 256,
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+			seq, _ := strconv.Atoi(event.ConnectionId)
+			// give events of the same room a chance to overtake each other
+			time.Sleep(time.Duration(seq%3) * 100 * time.Microsecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			ran[event.RoomId] = append(ran[event.RoomId], seq)
+			return "", nil
+		}),
+	}, orderedModule)
+
+	const events = 50
+	rooms := []string{"a", "b", "c", "d"}
+	var results []<-chan Result
+	for i := range events {
+		for _, room := range rooms {
+			results = append(results, s.Submit(context.Background(), &wsevents.WSEventInfo{
+				InstanceId:   "m",
+				RoomId:       room,
+				ConnectionId: strconv.Itoa(i),
+				EventType:    wsevents.ON_MESSAGE,
+			}))
+		}
+	}
+	for _, result := range results {
+		if res := <-result; res.Err != nil {
+			t.Fatalf("Event failed: %v", res.Err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, room := range rooms {
+		if len(ran[room]) != events {
+			t.Fatalf("Expected %d events in room %s, got %d", events, room, len(ran[room]))
+		}
+		for i, seq := range ran[room] {
+			if seq != i {
+				t.Fatalf("Room %s ran event %d as number %d: %v", room, seq, i, ran[room])
+			}
+		}
+	}
+}
+
+func TestOrderedDispatchRunsRoomsInParallel(t *testing.T) {
+	bStarted := make(chan struct{})
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:        2,
+		OrderedDispatch: true,
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+			if event.RoomId == "b" {
+				close(bStarted)
+				return "", nil
+			}
+			// room a only finishes once room b ran next to it
+			select {
+			case <-bStarted:
+				return "", nil
+			case <-time.After(5 * time.Second):
+				return "", fmt.Errorf("Room b never ran")
+			}
+		}),
+	}, orderedModule)
+
+	a := s.Submit(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", RoomId: "a", EventType: wsevents.ON_MESSAGE})
+	b := s.Submit(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", RoomId: "b", EventType: wsevents.ON_MESSAGE})
+	for _, result := range []<-chan Result{a, b} {
+		if res := <-result; res.Err != nil {
+			t.Fatalf("Event in room %s failed: %v", res.Event.RoomId, res.Err)
+		}
+	}
+}
+
+func TestOrderedDispatchQueueFull(t *testing.T) {
+	started := make(chan struct{}, 8)
+	release := make(chan struct{})
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:         2,
+		OrderedDispatch:  true,
+		OrderedQueueSize: 2,
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+			if event.RoomId == "a" {
+				started <- struct{}{}
+				<-release
+			}
+			return "", nil
+		}),
+	}, orderedModule)
+
+	event := func(room string) *wsevents.WSEventInfo {
+		return &wsevents.WSEventInfo{InstanceId: "m", RoomId: room, EventType: wsevents.ON_MESSAGE}
+	}
+
+	// the first event is running, so it no longer counts against the queue
+	results := []<-chan Result{s.Submit(context.Background(), event("a"))}
+	<-started
+	results = append(results, s.Submit(context.Background(), event("a")), s.Submit(context.Background(), event("a")))
+
+	err := s.ExecuteOnModule(context.Background(), event("a"))
+	if !errors.Is(err, ErrQueueFull) || !errors.Is(err, ErrOverloaded) {
+		t.Errorf("Expected ErrQueueFull for the third queued event, got %v", err)
+	}
+
+	// other rooms have their own queue
+	if err := s.ExecuteOnModule(context.Background(), event("b")); err != nil {
+		t.Errorf("Expected room b to run, got %v", err)
+	}
+
+	close(release)
+	for _, result := range results {
+		if res := <-result; res.Err != nil {
+			t.Errorf("Queued event failed: %v", res.Err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmbin"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Name of the export that modules can define to warm up before their snapshot is taken
+const warmupExport = "__warmup"
+
+// The memory and mutable globals of an instance, captured once it was initialized.
+//
+// Tables and dropped data segments are not captured, which is fine for modules that
+// don't change them after initialization (AssemblyScript modules don't)
+type snapshot struct {
+	memory  []byte
+	globals []snapshotGlobal
+}
+
+type snapshotGlobal struct {
+	name  string
+	value uint64
+}
+
+// Capture the state of an instance. Globals are the exports added by wasmbin.PrepareSnapshot
+func takeSnapshot(instance api.Module, globals []string) (*snapshot, error) {
+	mem := instance.Memory()
+	if mem == nil {
+		return nil, fmt.Errorf("Module has no memory to snapshot")
+	}
+
+	data, ok := mem.Read(0, mem.Size())
+	if !ok {
+		return nil, fmt.Errorf("Failed to read memory for snapshot")
+	}
+
+	snap := &snapshot{memory: append([]byte(nil), data...)}
+	for _, name := range globals {
+		global := instance.ExportedGlobal(name)
+		if global == nil {
+			return nil, fmt.Errorf("Global %s is not exported", name)
+		}
+		snap.globals = append(snap.globals, snapshotGlobal{name: name, value: global.Get()})
+	}
+
+	return snap, nil
+}
+
+// Overwrite an instance's memory and globals with the snapshot
+func (snap *snapshot) restore(instance api.Module) error {
+	mem := instance.Memory()
+	if mem == nil {
+		return fmt.Errorf("Module has no memory to restore")
+	}
+
+	if size := uint32(len(snap.memory)); mem.Size() < size {
+		if _, ok := mem.Grow((size - mem.Size()) / 65536); !ok {
+			return fmt.Errorf("Failed to grow memory to %d bytes", size)
+		}
+	}
+
+	// memory can't shrink, so anything the instance grew past the snapshot is zeroed instead
+	data, ok := mem.Read(0, mem.Size())
+	if !ok {
+		return fmt.Errorf("Failed to read memory for restore")
+	}
+	copy(data, snap.memory)
+	clear(data[len(snap.memory):])
+
+	for _, g := range snap.globals {
+		global, ok := instance.ExportedGlobal(g.name).(api.MutableGlobal)
+		if !ok {
+			return fmt.Errorf("Global %s is not mutable", g.name)
+		}
+		global.Set(g.value)
+	}
+
+	return nil
+}
+
+// Initialize the first instance of a module and snapshot it.
+//
+// The instance is returned so it can go in the pool
+func (s *SandboxStore) snapshotModule(ctx context.Context, mod *ActiveModule, prepared *wasmbin.Prepared) (api.Module, error) {
+	// the start section was turned into an export, so it has to be called explicitly and first
+	startFunctions := []string{wasmbin.StartExport, "_start"}
+	if mod.settings.WASI {
+		startFunctions = append(startFunctions[:1], wasiStartFunctions(mod.compiled)...)
+	}
+
+	config, flush := s.instanceConfig(mod)
+	instance, err := s.runtime.InstantiateModule(ctx, mod.compiled, config.WithStartFunctions(startFunctions...))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWASIInstance(mod, instance); err != nil {
+		return nil, err
+	}
+	mod.trackOutput(instance, flush)
+
+	if warmup := instance.ExportedFunction(warmupExport); warmup != nil {
+		if _, err := warmup.Call(eventContext(ctx, mod.instanceId, "", "")); err != nil {
+			mod.untrackOutput(instance)
+			instance.Close(ctx)
+			return nil, fmt.Errorf("%s failed: %w", warmupExport, err)
+		}
+	}
+
+	mod.snapshot, err = takeSnapshot(instance, prepared.Globals)
+	if err != nil {
+		mod.untrackOutput(instance)
+		instance.Close(ctx)
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// Create an instance of a module, from its snapshot if it has one
+func (s *SandboxStore) newInstance(ctx context.Context, mod *ActiveModule) (api.Module, error) {
+	config, flush := s.instanceConfig(mod)
+	if mod.snapshot == nil {
+		instance, err := s.runtime.InstantiateModule(ctx, mod.compiled, config)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkWASIInstance(mod, instance); err != nil {
+			return nil, err
+		}
+		mod.trackOutput(instance, flush)
+		return instance, nil
+	}
+
+	instance, err := s.runtime.InstantiateModule(ctx, mod.compiled, config.WithStartFunctions())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mod.snapshot.restore(instance); err != nil {
+		instance.Close(ctx)
+		return nil, err
+	}
+
+	mod.trackOutput(instance, flush)
+	return instance, nil
+}
+
+// Get an instance ready to go back into the pool.
+//
+// Closed instances (for example after a timeout with CloseOnContextDone) are replaced,
+// and instances of stateless modules are reset to the snapshot
+func (s *SandboxStore) recycleInstance(mod *ActiveModule, instance api.Module) api.Module {
+	ctx := context.Background()
+
+	if !instance.IsClosed() {
+		if !mod.settings.Stateless {
+			return instance
+		}
+
+		err := mod.snapshot.restore(instance)
+		if err == nil {
+			return instance
+		}
+		slog.Error("Failed to reset instance", "instanceId", mod.instanceId, "err", err)
+		instance.Close(ctx)
+	}
+	mod.untrackOutput(instance)
+
+	fresh, err := s.newInstance(ctx, mod)
+	if err != nil {
+		// keep the pool full, calls on the closed instance fail until the module is reloaded
+		slog.Error("Failed to replace instance", "instanceId", mod.instanceId, "err", err)
+		return instance
+	}
+
+	return fresh
+}
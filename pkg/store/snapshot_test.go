@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmbin"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// The start function sets the counter to 5 and __warmup adds 10. Every join grows memory,
+// scribbles over it and broadcasts the incremented counter
+const snapshotModule = `(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(memory (export "memory") 1 8)
+` + testAllocator + `
+	(global $counter (mut i32) (i32.const 0))
+
+	(func $init
+		(global.set $counter (i32.const 5))
+		(i32.store (i32.const 64) (i32.const 0x74696e69)))
+	(start $init)
+
+	(func (export "__warmup")
+		(global.set $counter (i32.add (global.get $counter) (i32.const 10)))
+		(i32.store (i32.const 80) (i32.const 0x6d726177)))
+
+	(func (export "__onJoin") (param i32 i32)
+		(global.set $counter (i32.add (global.get $counter) (i32.const 1)))
+		(drop (memory.grow (i32.const 1)))
+		(i32.store (i32.const 65536) (i32.const -1))
+		(i32.store (i32.const 64) (i32.const 0))
+		(i32.store (i32.const 32) (global.get $counter))
+		(drop (call $broadcast (i32.const 32) (i32.const 4)))))`
+
+// Store running snapshotModule with the given settings, returns the counters it broadcast
+func newSnapshotStore(t *testing.T, settings loader.ModuleSettings) (*SandboxStore, func() []uint32) {
+	var mu sync.Mutex
+	var counters []uint32
+
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize: 2,
+		SettingsFunction: func(ctx context.Context, moduleId string) (*loader.ModuleSettings, error) {
+			return &settings, nil
+		},
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			counters = append(counters, binary.LittleEndian.Uint32([]byte(event.Payload[0])))
+			return "", nil
+		}),
+	}, snapshotModule)
+
+	return s, func() []uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(counters)
+	}
+}
+
+func join(t *testing.T, s *SandboxStore, times int) {
+	t.Helper()
+	for range times {
+		if err := s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", EventType: wsevents.ON_JOIN}); err != nil {
+			t.Fatalf("Join failed: %v", err)
+		}
+	}
+}
+
+func TestSnapshotRunsInitOnce(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		settings loader.ModuleSettings
+		expected []uint32
+	}{
+		// nothing runs __warmup without snapshots
+		{"none", loader.ModuleSettings{PoolSize: 1}, []uint32{6, 7, 8}},
+		{"snapshot", loader.ModuleSettings{PoolSize: 1, Snapshot: true}, []uint32{16, 17, 18}},
+		{"stateless", loader.ModuleSettings{Stateless: true}, []uint32{16, 16, 16}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, counters := newSnapshotStore(t, tc.settings)
+			join(t, s, 3)
+			if got := counters(); !slices.Equal(got, tc.expected) {
+				t.Errorf("Expected counters %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+// An instance restored from the snapshot has the same memory and globals as one that
+// just ran the module's init code, no matter what it did in between
+func TestSnapshotRestoreMatchesFreshInstance(t *testing.T) {
+	s, _ := newSnapshotStore(t, loader.ModuleSettings{Stateless: true})
+	join(t, s, 4)
+
+	s.mu.Lock()
+	mod := s.activeModules["m"]
+	s.mu.Unlock()
+
+	ctx := context.Background()
+	config, _ := s.instanceConfig(mod)
+	fresh, err := s.runtime.InstantiateModule(ctx, mod.compiled, config.WithStartFunctions(wasmbin.StartExport))
+	if err != nil {
+		t.Fatalf("Failed to instantiate: %v", err)
+	}
+	defer fresh.Close(ctx)
+	if _, err := fresh.ExportedFunction(warmupExport).Call(ctx); err != nil {
+		t.Fatalf("Failed to warm up: %v", err)
+	}
+	freshMemory, _ := fresh.Memory().Read(0, fresh.Memory().Size())
+
+	// every pool instance, the one the snapshot was taken from and the ones restored from it
+	instances := make([]api.Module, mod.poolSize)
+	for i := range instances {
+		instances[i] = <-mod.instances
+	}
+	defer func() {
+		for _, instance := range instances {
+			mod.instances <- instance
+		}
+	}()
+
+	for i, instance := range instances {
+		memory, _ := instance.Memory().Read(0, instance.Memory().Size())
+		if !slices.Equal(memory[:len(freshMemory)], freshMemory) {
+			t.Errorf("Instance %d: memory differs from a fresh instance", i)
+		}
+		if slices.ContainsFunc(memory[len(freshMemory):], func(b byte) bool { return b != 0 }) {
+			t.Errorf("Instance %d: memory grown after the snapshot wasn't cleared", i)
+		}
+
+		for _, global := range mod.snapshot.globals {
+			got := instance.ExportedGlobal(global.name).Get()
+			expected := fresh.ExportedGlobal(global.name).Get()
+			if got != expected {
+				t.Errorf("Instance %d: global %s is %d, a fresh instance has %d", i, global.name, got, expected)
+			}
+		}
+	}
+}
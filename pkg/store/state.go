@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// Persists the state blobs of modules, keyed by module and room.
+//
+// Implementations must be safe for concurrent use. The store never calls Save
+// concurrently for the same key
+type StateBackend interface {
+	// Returns nil (and no error) if the key has no state yet
+	Load(ctx context.Context, instanceId string, roomId string) ([]byte, error)
+	Save(ctx context.Context, instanceId string, roomId string, state []byte) error
+	Delete(ctx context.Context, instanceId string, roomId string) error
+}
+
+// StateBackend that keeps everything in memory. State survives module eviction, but not restarts
+type MemoryStateBackend struct {
+	mu     sync.RWMutex
+	states map[stateKey][]byte
+}
+
+func NewMemoryStateBackend() *MemoryStateBackend {
+	return &MemoryStateBackend{states: make(map[stateKey][]byte)}
+}
+
+func (b *MemoryStateBackend) Load(ctx context.Context, instanceId string, roomId string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.states[stateKey{instanceId, roomId}], nil
+}
+
+func (b *MemoryStateBackend) Save(ctx context.Context, instanceId string, roomId string, state []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.states[stateKey{instanceId, roomId}] = state
+	return nil
+}
+
+func (b *MemoryStateBackend) Delete(ctx context.Context, instanceId string, roomId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.states, stateKey{instanceId, roomId})
+	return nil
+}
+
+type stateKey struct {
+	instanceId string
+	roomId     string
+}
+
+// Caches state blobs in front of the backend, and makes sure only one execution
+// uses the state of a (module, room) at a time.
+//
+// Since every pool instance reads the same blob through GET_STATE, state is consistent
+// no matter which instance an event lands on
+type stateManager struct {
+	backend  StateBackend
+	maxBytes int
+
+	mu      sync.Mutex
+	entries map[stateKey]*stateEntry
+}
+
+type stateEntry struct {
+	// Number of executions holding or waiting for lock, guarded by stateManager.mu
+	refs    int
+	evicted bool
+
+	// ID of the execution holding lock, guarded by stateManager.mu.
+	// Host calls are only allowed to use the state from that execution
+	owner string
+
+	// Holds a value for the duration of an execution, a channel so that waiting can be given up on.
+	// Everything below is only touched while holding it
+	lock chan struct{}
+
+	loaded bool
+	saved  []byte
+	data   []byte
+	dirty  bool
+}
+
+func newStateManager(backend StateBackend, maxBytes int) *stateManager {
+	return &stateManager{
+		backend:  backend,
+		maxBytes: maxBytes,
+		entries:  make(map[stateKey]*stateEntry),
+	}
+}
+
+// Lock the state of a (module, room) for an execution. Returns ctx's error if it is done before the lock is free
+func (m *stateManager) acquire(ctx context.Context, instanceId string, roomId string, executionId string) (*stateEntry, error) {
+	key := stateKey{instanceId, roomId}
+
+	m.mu.Lock()
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = &stateEntry{lock: make(chan struct{}, 1)}
+		m.entries[key] = entry
+	}
+	entry.refs++
+	m.mu.Unlock()
+
+	select {
+	case entry.lock <- struct{}{}:
+	case <-ctx.Done():
+		m.unref(key, entry)
+		return nil, ctx.Err()
+	}
+
+	m.mu.Lock()
+	entry.owner = executionId
+	m.mu.Unlock()
+
+	return entry, nil
+}
+
+// Load the state of a locked entry from the backend, unless it is already cached
+func (m *stateManager) load(ctx context.Context, key stateKey, entry *stateEntry) error {
+	if entry.loaded {
+		return nil
+	}
+
+	data, err := m.backend.Load(ctx, key.instanceId, key.roomId)
+	if err != nil {
+		return fmt.Errorf("Failed to load module state: %w", err)
+	}
+	entry.saved = data
+	entry.data = data
+	entry.loaded = true
+	return nil
+}
+
+// Save the state if the execution succeeded and changed it, or roll it back otherwise, then unlock it.
+// Returns an error if saving failed
+func (m *stateManager) release(ctx context.Context, key stateKey, entry *stateEntry, execErr error) error {
+	var err error
+	if entry.dirty {
+		if execErr == nil {
+			err = m.backend.Save(ctx, key.instanceId, key.roomId, entry.data)
+		}
+		if execErr != nil || err != nil {
+			// discard the changes, and reload next time in case the backend has partially saved them
+			entry.data = entry.saved
+			entry.loaded = err == nil
+		} else {
+			entry.saved = entry.data
+		}
+		entry.dirty = false
+	}
+
+	m.mu.Lock()
+	entry.owner = ""
+	m.mu.Unlock()
+	<-entry.lock
+
+	m.unref(key, entry)
+
+	if err != nil {
+		return fmt.Errorf("Failed to save module state: %w", err)
+	}
+	return nil
+}
+
+// Stop holding or waiting for an entry, dropping it if it was evicted in the meantime
+func (m *stateManager) unref(key stateKey, entry *stateEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.refs--
+	if entry.refs == 0 && entry.evicted {
+		delete(m.entries, key)
+	}
+}
+
+// Drop cached entries, the backend still has their state.
+// Entries that are in use are dropped once their last execution releases them
+func (m *stateManager) dropWhere(filter func(stateKey) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, entry := range m.entries {
+		if !filter(key) {
+			continue
+		}
+		if entry.refs == 0 {
+			delete(m.entries, key)
+		} else {
+			entry.evicted = true
+		}
+	}
+}
+
+func (m *stateManager) dropModule(instanceId string) {
+	m.dropWhere(func(key stateKey) bool { return key.instanceId == instanceId })
+}
+
+func (m *stateManager) dropRoom(instanceId string, roomId string) {
+	m.dropWhere(func(key stateKey) bool { return key.instanceId == instanceId && key.roomId == roomId })
+}
+
+// Find the entry that the execution making the host call holds
+func (m *stateManager) activeEntry(event *wasmevents.WASMEventInfo) (*stateEntry, error) {
+	m.mu.Lock()
+	entry, ok := m.entries[stateKey{event.InstanceId, event.RoomId}]
+	owned := ok && event.ExecutionId != "" && entry.owner == event.ExecutionId
+	m.mu.Unlock()
+
+	if !owned {
+		return nil, fmt.Errorf("Module state is not loaded for room %s", event.RoomId)
+	}
+	return entry, nil
+}
+
+// Handler for GET_STATE events. Returns the state blob of the current room, or "" if there is none
+func (s *SandboxStore) getStateHandler(event *wasmevents.WASMEventInfo) (string, error) {
+	if s.state == nil {
+		return "", fmt.Errorf("Module state is not enabled")
+	}
+
+	entry, err := s.state.activeEntry(event)
+	if err != nil {
+		return "", err
+	}
+	return string(entry.data), nil
+}
+
+// Handler for SET_STATE events. Payload is [new state]
+func (s *SandboxStore) setStateHandler(event *wasmevents.WASMEventInfo) (string, error) {
+	if s.state == nil {
+		return "", fmt.Errorf("Module state is not enabled")
+	}
+	if len(event.Payload) != 1 {
+		return "", fmt.Errorf("Invalid payload for %s event", event.EventType.String())
+	}
+	if len(event.Payload[0]) > s.state.maxBytes {
+		return "", fmt.Errorf("Module state is larger than %d bytes", s.state.maxBytes)
+	}
+
+	entry, err := s.state.activeEntry(event)
+	if err != nil {
+		return "", err
+	}
+	entry.data = []byte(event.Payload[0])
+	entry.dirty = true
+	return "", nil
+}
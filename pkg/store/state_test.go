@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Every message adds an "x" to the room's state. GET_STATE returns "+\0" and then the state in UTF-16,
+// so the state has (size - 2) / 2 characters. It logs in between, so tests can make executions overlap
+const stateModule = `(module
+	(import "env" "log" (func $log (param i32 i32) (result i32)))
+	(import "env" "getState" (func $getState (result i32)))
+	(import "env" "setState" (func $setState (param i32 i32) (result i32)))
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(func (export "__onMessage") (param i32 i32) (local $n i32) (local $i i32)
+		(global.set $heap (i32.const 4096))
+		(drop (call $getState))
+		(local.set $n (i32.add (i32.shr_u (i32.sub (global.get $lastSize) (i32.const 2)) (i32.const 1)) (i32.const 1)))
+		(drop (call $log (i32.const 0) (i32.const 0)))
+
+		block
+			loop
+				(br_if 1 (i32.ge_u (local.get $i) (local.get $n)))
+				(i32.store8 offset=1024 (local.get $i) (i32.const 0x78))
+				(local.set $i (i32.add (local.get $i) (i32.const 1)))
+				br 0
+			end
+		end
+		(drop (call $setState (i32.const 1024) (local.get $n)))))`
+
+// Executions of a room take turns with its state, so no update is lost
+// even though they run on different pool instances at the same time
+func TestStateIsConsistentAcrossInstances(t *testing.T) {
+	backend := NewMemoryStateBackend()
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:     4,
+		StateBackend: backend,
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.LOG, func(event *wasmevents.WASMEventInfo) (string, error) {
+			time.Sleep(100 * time.Microsecond)
+			return "", nil
+		}),
+	}, stateModule)
+
+	const workers = 8
+	const perWorker = 20
+	rooms := []string{"a", "b"}
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				err := s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{
+					InstanceId: "m",
+					RoomId:     rooms[i%len(rooms)],
+					EventType:  wsevents.ON_MESSAGE,
+				})
+				if err != nil {
+					t.Errorf("Event failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, room := range rooms {
+		state, err := backend.Load(context.Background(), "m", room)
+		if err != nil {
+			t.Fatalf("Failed to load state: %v", err)
+		}
+		expected := strings.Repeat("x", workers/len(rooms)*perWorker)
+		if string(state) != expected {
+			t.Errorf("Expected room %s to have %d updates, got %d", room, len(expected), len(state))
+		}
+	}
+}
+
+// Records the state saved for every key
+type recordingBackend struct {
+	*MemoryStateBackend
+	mu    sync.Mutex
+	saves map[string]int
+}
+
+func (b *recordingBackend) Save(ctx context.Context, instanceId string, roomId string, state []byte) error {
+	b.mu.Lock()
+	b.saves[fmt.Sprintf("%s/%s", instanceId, roomId)]++
+	b.mu.Unlock()
+	return b.MemoryStateBackend.Save(ctx, instanceId, roomId, state)
+}
+
+func TestStateIsLoadedFromBackend(t *testing.T) {
+	backend := &recordingBackend{MemoryStateBackend: NewMemoryStateBackend(), saves: make(map[string]int)}
+	backend.MemoryStateBackend.Save(context.Background(), "m", "lobby", []byte("xxx"))
+
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:     2,
+		StateBackend: backend,
+		HandlerMap:   wasmevents.NewHandlerMap(),
+	}, stateModule)
+
+	err := s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", RoomId: "lobby", EventType: wsevents.ON_MESSAGE})
+	if err != nil {
+		t.Fatalf("Event failed: %v", err)
+	}
+
+	state, _ := backend.Load(context.Background(), "m", "lobby")
+	if string(state) != "xxxx" {
+		t.Errorf("Expected the stored state to be extended to %q, got %q", "xxxx", state)
+	}
+	if saves := backend.saves["m/lobby"]; saves != 1 {
+		t.Errorf("Expected the state to be saved once, got %d", saves)
+	}
+}
+
+// Remembers whether Load was given a deadline
+type deadlineBackend struct {
+	*MemoryStateBackend
+	hadDeadline chan bool
+}
+
+func (b *deadlineBackend) Load(ctx context.Context, instanceId string, roomId string) ([]byte, error) {
+	_, ok := ctx.Deadline()
+	b.hadDeadline <- ok
+	return b.MemoryStateBackend.Load(ctx, instanceId, roomId)
+}
+
+// An execution waiting for a room's state gives up once its context is done,
+// and the state is loaded within the execution's time limit
+func TestStateWaitFollowsContext(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := &deadlineBackend{MemoryStateBackend: NewMemoryStateBackend(), hadDeadline: make(chan bool, 2)}
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:     2,
+		StateBackend: backend,
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.LOG, func(event *wasmevents.WASMEventInfo) (string, error) {
+			select {
+			case started <- struct{}{}:
+				<-release
+			default:
+			}
+			return "", nil
+		}),
+	}, stateModule)
+	event := &wsevents.WSEventInfo{InstanceId: "m", RoomId: "lobby", EventType: wsevents.ON_MESSAGE}
+
+	holder := make(chan error, 1)
+	go func() { holder <- s.ExecuteOnModule(context.Background(), event) }()
+	<-started
+	if !<-backend.hadDeadline {
+		t.Errorf("Expected the state to be loaded with a deadline")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.ExecuteOnModule(ctx, event); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the waiting execution to give up, got %v", err)
+	}
+
+	close(release)
+	if err := <-holder; err != nil {
+		t.Fatalf("Holder failed: %v", err)
+	}
+	// the execution that gave up didn't keep the state locked
+	if err := s.ExecuteOnModule(context.Background(), event); err != nil {
+		t.Errorf("Expected the next execution to run, got %v", err)
+	}
+	state, _ := backend.MemoryStateBackend.Load(context.Background(), "m", "lobby")
+	if string(state) != "xx" {
+		t.Errorf("Expected two updates, got %q", state)
+	}
+}
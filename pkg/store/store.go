@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
@@ -10,12 +11,17 @@ import (
 
 	"github.com/Cloud-RAMP/wasm-sandbox/internal/asmscript"
 	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
 	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
 	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
 )
 
+// Returned when a module returns from an event with a transaction it didn't commit or roll back.
+// None of the event's DB writes are applied
+var ErrTransactionOpen = errors.New("Transaction was left open when the module returned")
+
 type SandboxStore struct {
 	// The runtime is where modules are executed, but thier states are kept separete
 	runtime wazero.Runtime
@@ -44,6 +50,50 @@ type SandboxStore struct {
 	// This is done to prevent concurrent fetches of the same module
 	loadingModules   map[string]chan struct{}
 	loadingModulesMu sync.Mutex
+
+	// Gets the bytes of a module, and per-module settings, when a module is loaded.
+	// Each store has its own, so stores in one process can load from different places
+	loaderFunction   loader.LoaderFunction
+	settingsFunction loader.SettingsFunction
+	loadTimeout      time.Duration
+
+	// Limit on request bodies passed to __onHttpRequest
+	maxHTTPBodyBytes int64
+
+	// Only set when OrderedDispatch is enabled
+	ordered *orderedDispatcher
+
+	// Worker pool for events passed to Submit
+	dispatcher *dispatcher
+
+	// Only set when a StateBackend is configured
+	state *stateManager
+
+	// Optional, sees every event before it runs
+	observer EventObserver
+
+	// Only set when WriteBehindInterval is configured
+	writes *writeBehind
+
+	// Only set when a TransactionHandler is configured
+	transactions *transactions
+
+	// Timers registered by modules through SET_TIMEOUT / SET_INTERVAL
+	timers *timerScheduler
+
+	// Optional, called instead of logging when a timer's event fails
+	onTimerError func(event *wsevents.WSEventInfo, err error)
+
+	// Only set when a Recorder is configured
+	recorder *record.Recorder
+
+	// Execution IDs are this random prefix followed by a counter, so they are unique across restarts
+	executionPrefix string
+	executions      atomic.Uint64
+
+	// The WASI host module, only instantiated once some module opts in to WASI
+	wasiModule api.Closer
+	wasiMu     sync.Mutex
 }
 
 type ActiveModule struct {
@@ -61,6 +111,18 @@ type ActiveModule struct {
 	// The module's ID
 	instanceId string
 
+	// Settings the module was loaded with
+	settings *loader.ModuleSettings
+
+	// Number of instances in the pool, from the settings or the store's default
+	poolSize uint8
+
+	// Only set for modules with Snapshot or Stateless enabled
+	snapshot *snapshot
+
+	// Flush function of each WASI instance, see instanceConfig
+	output sync.Map
+
 	wg sync.WaitGroup
 }
 
@@ -75,17 +137,213 @@ type SandboxStoreCfg struct {
 	Ctx                context.Context
 	LoaderFunction     loader.LoaderFunction
 	PoolSize           uint8
+
+	// Optional, used to look up per-module settings such as WASI.
+	// Every module uses the default settings if not specified
+	SettingsFunction loader.SettingsFunction
+
+	// Time allowed for loading a module, including the loader and settings functions and instantiating its pool
+	// (defaults to 5 seconds). Raise it for loaders that download modules and retry
+	LoadTimeout time.Duration
+
+	// Maximum number of pending timers a single module can have (defaults to 100)
+	MaxTimersPerModule uint16
+
+	// Clock used for timers and room ticks (defaults to the system clock)
+	Clock Clock
+
+	// Called when the event of a fired timer or tick fails. Errors are logged if not specified
+	OnTimerError func(event *wsevents.WSEventInfo, err error)
+
+	// Maximum size of an HTTP request body passed to a module (defaults to 1MB)
+	MaxHTTPBodyBytes int64
+
+	// Run events for the same (instance, room) one at a time, in the order they were dispatched.
+	// Different rooms still run in parallel
+	OrderedDispatch bool
+
+	// Maximum number of events waiting to run per room in ordered mode (defaults to 64)
+	OrderedQueueSize uint16
+
+	// Number of workers running events passed to Submit, which caps how many run at once (defaults to 16)
+	DispatchWorkers uint16
+
+	// Maximum number of submitted events waiting for a worker (defaults to 1024)
+	DispatchQueueSize uint32
+
+	// Maximum number of submitted events pending per module before ErrOverloaded (defaults to 256)
+	MaxQueuedPerModule uint32
+
+	// Enables module state (GET_STATE / SET_STATE) and persists it.
+	// Executions that share a module and room run one at a time while this is set
+	StateBackend StateBackend
+
+	// Maximum size of a state blob (defaults to 64KB)
+	MaxStateBytes uint32
+
+	// Enables DB transactions (TX_BEGIN / TX_COMMIT / TX_ROLLBACK).
+	// Committed writes are passed to it once the guest returns, and dropped if it traps or times out
+	TransactionHandler wasmevents.TransactionHandler
+
+	// Buffer SET and DEL events, and pass them to the handlers in the background on this interval.
+	// Repeated writes to a key are coalesced, and GET still sees the module's own writes. Disabled if 0
+	WriteBehindInterval time.Duration
+
+	// Flush a module's buffered writes early once it has this many keys buffered (defaults to 1000)
+	WriteBehindMaxKeys uint32
+
+	// Optional, called with every event passed to ExecuteOnModule or Submit before it runs.
+	// If it also implements FinishedObserver, it is told once each of those events is done.
+	// rooms.Registry implements both to track room membership
+	EventObserver EventObserver
+
+	// Record every event and host call to replay them later, see the record package
+	Recorder *record.Recorder
+
+	// Replace every handler, including the store's own (timers, state), with these.
+	// Used with record.Replay to reproduce a recorded execution
+	ReplayHandlers *wasmevents.HandlerMap
+
+	// Optional, called with the final handler map, including the store's own handlers (timers, state, transactions),
+	// before any module runs. Lets tools see every host call, like the Recorder does
+	WrapHandlers func(*wasmevents.HandlerMap)
+}
+
+// Sees events as they are passed to the store, see SandboxStoreCfg.EventObserver
+type EventObserver interface {
+	Observe(event *wsevents.WSEventInfo)
+}
+
+// Optionally implemented by an EventObserver that needs to know when the events it observed are done.
+//
+// Finished is called exactly once for every observed event, after its export has returned or with the error
+// that kept it from running. It is never called from a room's OrderedDispatch queue, so it can run more events
+type FinishedObserver interface {
+	Finished(event *wsevents.WSEventInfo, err error)
+}
+
+// Deliver a room lifecycle event (ON_ROOM_CREATED, ON_ROOM_EMPTY or ON_TICK) to a module
+func (s *SandboxStore) ExecuteRoomEvent(ctx context.Context, instanceId string, roomId string, eventType wsevents.WSEventType, payload string) error {
+	if !eventType.IsRoomEvent() {
+		return fmt.Errorf("%s is not a room event", eventType.String())
+	}
+
+	return s.ExecuteOnModule(ctx, &wsevents.WSEventInfo{
+		InstanceId: instanceId,
+		RoomId:     roomId,
+		Payload:    payload,
+		EventType:  eventType,
+		Timestamp:  time.Now().UnixMilli(),
+	})
 }
 
 // Execute a function on a given module
 //
-// The event will be handled by whatever custom event handler the user has set up
+// The event will be handled by whatever custom event handler the user has set up.
+// With OrderedDispatch enabled, events for the same room run one at a time in the order
+// this was called, and ErrQueueFull is returned if the room has too many events waiting
 func (s *SandboxStore) ExecuteOnModule(ctx context.Context, wsEvent *wsevents.WSEventInfo) error {
 	if !wsEvent.EventType.Valid() {
 		return fmt.Errorf("Invalid WS event type")
 	}
 
-	active, err := s.loadModule(wsEvent.InstanceId)
+	s.observe(wsEvent)
+	return s.runInOrder(ctx, wsEvent, s.executeOnModule, func(err error) {
+		s.observeFinished(wsEvent, err)
+	})
+}
+
+// Pass an event to the EventObserver, if there is one
+func (s *SandboxStore) observe(wsEvent *wsevents.WSEventInfo) {
+	if s.observer != nil {
+		s.observer.Observe(wsEvent)
+	}
+}
+
+// Tell the EventObserver that an event it observed is done, if it wants to know
+func (s *SandboxStore) observeFinished(wsEvent *wsevents.WSEventInfo, err error) {
+	if observer, ok := s.observer.(FinishedObserver); ok {
+		observer.Finished(wsEvent, err)
+	}
+}
+
+// Submitted events with OrderedDispatch finish in their room's queue, so the observer is told from another goroutine
+func (s *SandboxStore) observeSubmitFinished(wsEvent *wsevents.WSEventInfo, err error) {
+	if _, ok := s.observer.(FinishedObserver); !ok {
+		return
+	}
+	if s.ordered != nil {
+		go s.observeFinished(wsEvent, err)
+		return
+	}
+	s.observeFinished(wsEvent, err)
+}
+
+// Run an event with run, behind the other events of its room if OrderedDispatch is enabled.
+//
+// finished is called once the event is done, even if ctx is done first and the error has already been returned.
+// It never runs in the room's queue, so it can wait for more events of the room
+func (s *SandboxStore) runInOrder(ctx context.Context, wsEvent *wsevents.WSEventInfo, run func(context.Context, *wsevents.WSEventInfo) error, finished func(error)) error {
+	if s.ordered == nil {
+		err := run(ctx, wsEvent)
+		finished(err)
+		return err
+	}
+
+	done := make(chan error, 1)
+	if err := s.ordered.enqueueWith(ctx, wsEvent, run, func(err error) { done <- err }); err != nil {
+		finished(err)
+		return err
+	}
+
+	select {
+	case err := <-done:
+		finished(err)
+		return err
+	case <-ctx.Done():
+		// the event might still be running
+		go func() { finished(<-done) }()
+		return ctx.Err()
+	}
+}
+
+func (s *SandboxStore) executeOnModule(ctx context.Context, wsEvent *wsevents.WSEventInfo) error {
+	return s.withInstance(ctx, wsEvent.InstanceId, wsEvent.ConnectionId, wsEvent.RoomId, func(ctx context.Context, instance api.Module) error {
+		if s.recorder != nil {
+			s.recorder.RecordInput(ExecutionId(ctx), wsEvent)
+		}
+		return s.callInTransaction(ctx, wsEvent.InstanceId, func(ctx context.Context) error {
+			return callEventExport(ctx, instance, wsEvent)
+		})
+	})
+}
+
+// Call the export matching the event's type on an instance that has already been taken from the pool
+func callEventExport(ctx context.Context, instance api.Module, wsEvent *wsevents.WSEventInfo) error {
+	onMessage := instance.ExportedFunction(wsEvent.EventType.String())
+	if onMessage == nil {
+		return fmt.Errorf("Module does not export %s", wsEvent.EventType.String())
+	}
+
+	// Write the information of the event in module memory so they can read it
+	ptr, memLen, err := asmscript.WriteWSEvent(&asmscript.ModuleContext{
+		Module: instance,
+		Ctx:    ctx,
+	}, wsEvent)
+	if err != nil {
+		return err
+	}
+
+	_, err = onMessage.Call(ctx, ptr, memLen)
+	return err
+}
+
+// Run fn with an instance of the given module, loading the module if needed.
+//
+// The instance is taken from the module's pool for the duration of fn, and ctx carries the
+// values that host functions need to build events, as well as the execution timeout
+func (s *SandboxStore) withInstance(ctx context.Context, instanceId string, connectionId string, roomId string, fn func(context.Context, api.Module) error) (err error) {
+	active, err := s.loadModule(instanceId)
 	if err != nil {
 		return err
 	}
@@ -95,42 +353,78 @@ func (s *SandboxStore) ExecuteOnModule(ctx context.Context, wsEvent *wsevents.WS
 
 	defer active.wg.Done()
 
+	if ExecutionId(ctx) == "" {
+		ctx = WithExecutionId(ctx, fmt.Sprintf("%s-%d", s.executionPrefix, s.executions.Add(1)))
+	}
+
+	// Lock the room's state before taking an instance, so waiting executions don't hold one.
+	// Only host calls made with this execution's ID can use it
+	var state *stateEntry
+	key := stateKey{instanceId, roomId}
+	if s.state != nil {
+		if state, err = s.state.acquire(ctx, instanceId, roomId, ExecutionId(ctx)); err != nil {
+			return err
+		}
+		defer func() {
+			if saveErr := s.state.release(ctx, key, state, err); saveErr != nil && err == nil {
+				err = saveErr
+			}
+		}()
+	}
+
 	// Grab an instance from the pool — blocks if all instances are in use
 	instance := <-active.instances
 	defer func() {
 		if r := recover(); r != nil {
 			slog.Error("Panic in ExecuteOnModule", "recover", r)
 		}
-		active.instances <- instance // always return the instance
+		active.flushOutput(instance)
+		active.instances <- s.recycleInstance(active, instance) // always return the instance
 	}()
 
 	// Update last used
 	active.lastUsed.Store(time.Now().UnixNano())
 
 	// Create inner context with instanceId key / value
-	ctx = context.WithValue(ctx, "instanceId", wsEvent.InstanceId)
-	ctx = context.WithValue(ctx, "connectionId", wsEvent.ConnectionId)
-	ctx = context.WithValue(ctx, "roomId", wsEvent.RoomId)
+	ctx = eventContext(ctx, instanceId, connectionId, roomId)
 
 	// Add timeout (defaults to 5 seconds)
 	ctx, cancel := context.WithTimeout(ctx, s.maxExecutionTime)
 	defer cancel()
 
-	// Write the information of the event in module memory so they can read it
-	ptr, memLen, err := asmscript.WriteWSEvent(&asmscript.ModuleContext{
-		Module: instance,
-		Ctx:    ctx,
-	}, wsEvent)
-	if err != nil {
-		return err
+	// loading the state counts against the execution's time
+	if state != nil {
+		if err := s.state.load(ctx, key, state); err != nil {
+			return err
+		}
 	}
 
-	onMessage := instance.ExportedFunction(wsEvent.EventType.String())
-	_, err = onMessage.Call(ctx, ptr, memLen)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	return fn(ctx, instance)
+}
+
+// Make a single export call with its own transaction, see transactions.call
+func (s *SandboxStore) callInTransaction(ctx context.Context, instanceId string, call func(context.Context) error) error {
+	if s.transactions == nil {
+		return call(ctx)
 	}
+	return s.transactions.call(ctx, instanceId, call)
+}
+
+// Run the execution started with ctx under the given ID instead of a generated one, such as to match a recording
+func WithExecutionId(ctx context.Context, executionId string) context.Context {
+	return context.WithValue(ctx, "executionId", executionId)
+}
+
+// The ID of the execution running with ctx, empty outside of one
+func ExecutionId(ctx context.Context) string {
+	executionId, _ := ctx.Value("executionId").(string)
+	return executionId
+}
 
-	return nil
+// Add the values that host functions read to build their events
+func eventContext(ctx context.Context, instanceId string, connectionId string, roomId string) context.Context {
+	ctx = context.WithValue(ctx, "instanceId", instanceId)
+	ctx = context.WithValue(ctx, "connectionId", connectionId)
+	ctx = context.WithValue(ctx, "roomId", roomId)
+	return ctx
 }
@@ -12,19 +12,33 @@ func (s *SandboxStore) Close(ctx context.Context) error {
 		ctx = context.Background()
 	}
 
+	s.timers.cancelAll()
+	s.dispatcher.stop()
+	if s.ordered != nil {
+		s.ordered.stop()
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// remove all active modules from the map and close them
 	for id, active := range s.activeModules {
 		delete(s.activeModules, id)
-		active.Close(s.poolSize)
+		active.Close(active.poolSize)
+	}
+
+	// every execution has finished, so nothing can be buffered after this
+	if s.writes != nil {
+		s.writes.stop()
 	}
 
-	// close the host module as well
+	// close the host modules as well
 	if s.hostModule != nil {
 		s.hostModule.Close(ctx)
 	}
+	if s.wasiModule != nil {
+		s.wasiModule.Close(ctx)
+	}
 
 	return s.runtime.Close(ctx)
 }
@@ -41,6 +55,7 @@ func (mod *ActiveModule) Close(poolSize uint8) {
 			// allow 5 seconds for moduel to close
 			closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
+			mod.untrackOutput(inst)
 			inst.Close(closeCtx)
 		}
 	}
@@ -62,11 +77,53 @@ func (s *SandboxStore) evictLRU() {
 		}
 	}
 
-	// remove module from the map so no new requests can be sent to it
-	mod := s.activeModules[lru]
-	delete(s.activeModules, lru)
+	s.removeModule(lru, s.activeModules[lru])
+}
+
+// Remove a module from the map so no new requests can be sent to it, and close it once its executions finish.
+//
+// s.mu must be held
+func (s *SandboxStore) removeModule(id string, mod *ActiveModule) {
+	delete(s.activeModules, id)
+	s.timers.cancelModule(id)
+	if s.state != nil {
+		s.state.dropModule(id)
+	}
+
+	go s.closeModule(id, mod)
+}
 
-	go mod.Close(s.poolSize)
+// Drop a loaded module, so that the next event for it loads it again with the loader and settings functions.
+// Used when the module or its settings change.
+//
+// Executions that are running finish on the old module. Like eviction, this cancels the module's timers.
+// Returns false if the module wasn't loaded
+func (s *SandboxStore) Invalidate(moduleId string) bool {
+	// a load that is in progress may have read the old module, so wait for it and drop what it loaded
+	s.loadingModulesMu.Lock()
+	signal, loading := s.loadingModules[moduleId]
+	s.loadingModulesMu.Unlock()
+	if loading {
+		<-signal
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mod, ok := s.activeModules[moduleId]
+	if !ok {
+		return false
+	}
+	s.removeModule(moduleId, mod)
+	return true
+}
+
+// Close a module that was removed from the map, then flush the writes it buffered
+func (s *SandboxStore) closeModule(id string, mod *ActiveModule) {
+	mod.Close(mod.poolSize)
+	if s.writes != nil {
+		s.writes.dropModule(id)
+	}
 }
 
 func (s *SandboxStore) cleanupIdleModules() {
@@ -77,8 +134,7 @@ func (s *SandboxStore) cleanupIdleModules() {
 		t := time.Unix(0, active.lastUsed.Load())
 		if time.Since(t) > s.maxIdleTime {
 			slog.Info("Removing idle store", "storeId", id)
-			delete(s.activeModules, id)
-			go active.Close(s.poolSize)
+			s.removeModule(id, active)
 		}
 	}
 }
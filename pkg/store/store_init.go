@@ -2,11 +2,14 @@ package store
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
+	"maps"
 	"time"
 
 	builder "github.com/Cloud-RAMP/wasm-sandbox/internal/host-builder"
 	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
 	"github.com/tetratelabs/wazero"
 )
 
@@ -28,31 +31,103 @@ func NewSandboxStore(ctx context.Context, cfg SandboxStoreCfg) (*SandboxStore, e
 			WithMemoryLimitPages(memPages).
 			WithCloseOnContextDone(cfg.CloseOnContextDone))
 
-	// Build host module once
-	hostModule, err := builder.BuildHostModule(ctx, runtime, cfg.HandlerMap)
-	if err != nil {
-		runtime.Close(ctx)
-		return nil, err
-	}
-
-	// Set loader function, use error if not specified
+	// Loader function is required, use error if not specified
 	if cfg.LoaderFunction == nil {
+		runtime.Close(ctx)
 		return nil, fmt.Errorf("No module loader function specified!")
 	}
-	loader.SetLoaderFunction(cfg.LoaderFunction)
 
 	store := &SandboxStore{
 		runtime:          runtime,
-		hostModule:       hostModule,
 		moduleConfig:     wazero.NewModuleConfig(),
 		activeModules:    make(map[string]*ActiveModule),
 		loadingModules:   make(map[string]chan struct{}),
 		maxActiveModules: defaultValue(cfg.MaxActiveModules, 0, 25),
 		maxExecutionTime: defaultValue(cfg.MaxExecutionTime, 0, 5*time.Second),
 		poolSize:         defaultValue(cfg.PoolSize, 0, 5),
+		handlerMap:       make(wasmevents.HandlerMap),
+		loaderFunction:   cfg.LoaderFunction,
+		settingsFunction: cfg.SettingsFunction,
+		loadTimeout:      defaultValue(cfg.LoadTimeout, 0, 5*time.Second),
+		maxHTTPBodyBytes: defaultValue(cfg.MaxHTTPBodyBytes, 0, 1<<20),
+		observer:         cfg.EventObserver,
+		recorder:         cfg.Recorder,
+		onTimerError:     cfg.OnTimerError,
+		executionPrefix:  rand.Text()[:8],
+	}
+
+	if cfg.HandlerMap != nil {
+		maps.Copy(store.handlerMap, *cfg.HandlerMap)
+	}
+
+	if store.settingsFunction == nil {
+		store.settingsFunction = loader.DefaultSettingsFunction
+	}
+
+	if cfg.OrderedDispatch {
+		store.ordered = newOrderedDispatcher(int(defaultValue(cfg.OrderedQueueSize, 0, 64)))
+	}
+
+	store.dispatcher = newDispatcher(
+		int(defaultValue(cfg.DispatchWorkers, 0, 16)),
+		int(defaultValue(cfg.DispatchQueueSize, 0, 1024)),
+		int(defaultValue(cfg.MaxQueuedPerModule, 0, 256)),
+		store.executeOnModule,
+		store.observe,
+		store.observeSubmitFinished,
+	)
+
+	clock := cfg.Clock
+	if clock == nil {
+		clock = systemClock{}
 	}
+	store.timers = newTimerScheduler(int(defaultValue(cfg.MaxTimersPerModule, 0, 100)), clock, store.fireTimer)
 
-	loader.SetLoaderFunction(cfg.LoaderFunction)
+	if cfg.StateBackend != nil {
+		store.state = newStateManager(cfg.StateBackend, int(defaultValue(cfg.MaxStateBytes, 0, 64*1024)))
+	}
+
+	if cfg.WriteBehindInterval > 0 {
+		store.writes = newWriteBehind(maps.Clone(store.handlerMap), cfg.WriteBehindInterval, int(defaultValue(cfg.WriteBehindMaxKeys, 0, 1000)))
+		store.writes.wrap(&store.handlerMap)
+	}
+
+	if cfg.TransactionHandler != nil {
+		store.transactions = newTransactions(cfg.TransactionHandler, maps.Clone(store.handlerMap), cfg.Recorder)
+		store.transactions.wrap(&store.handlerMap)
+	}
+
+	// Events that are handled by the store itself rather than the user's handlers
+	store.handlerMap.
+		AddHandler(wasmevents.SET_TIMEOUT, store.setTimerHandler(false)).
+		AddHandler(wasmevents.SET_INTERVAL, store.setTimerHandler(true)).
+		AddHandler(wasmevents.CLEAR_TIMER, store.clearTimerHandler).
+		AddHandler(wasmevents.GET_STATE, store.getStateHandler).
+		AddHandler(wasmevents.SET_STATE, store.setStateHandler).
+		AddHandler(wasmevents.TX_BEGIN, store.transactionHandler).
+		AddHandler(wasmevents.TX_COMMIT, store.transactionHandler).
+		AddHandler(wasmevents.TX_ROLLBACK, store.transactionHandler)
+
+	if cfg.ReplayHandlers != nil {
+		store.handlerMap = maps.Clone(*cfg.ReplayHandlers)
+	}
+	if cfg.WrapHandlers != nil {
+		cfg.WrapHandlers(&store.handlerMap)
+	}
+	if store.recorder != nil {
+		store.recorder.Wrap(&store.handlerMap)
+	}
+
+	// Build host module once
+	hostModule, err := builder.BuildHostModule(ctx, runtime, &store.handlerMap)
+	if err != nil {
+		if store.writes != nil {
+			store.writes.stop()
+		}
+		runtime.Close(ctx)
+		return nil, err
+	}
+	store.hostModule = hostModule
 
 	// auto-clean up modules if cleanup interval and max idle time are defined
 	if cfg.CleanupInterval != 0 && cfg.MaxIdleTime != 0 {
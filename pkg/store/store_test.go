@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmtest"
+)
+
+// A bump allocator that starts over at 4096 with every event, and remembers the size of the last allocation
+// so the guest knows how long the strings written by the host are
+const testAllocator = `
+	(global $heap (mut i32) (i32.const 4096))
+	(global $lastSize (mut i32) (i32.const 0))
+
+	(func (export "__new") (param $size i32) (param $id i32) (result i32)
+		(global.set $lastSize (local.get $size))
+		global.get $heap
+		(global.set $heap (i32.add (global.get $heap) (local.get $size))))
+`
+
+// Start a store that loads the module written in wat for every instance ID. It's closed when the test ends
+func newTestStore(t *testing.T, cfg SandboxStoreCfg, wat string) *SandboxStore {
+	t.Helper()
+
+	wasm := wasmtest.Must(t, wat)
+	cfg.LoaderFunction = func(ctx context.Context, moduleId string) ([]byte, error) {
+		return wasm, nil
+	}
+	cfg.MemoryLimitPages = defaultValue(cfg.MemoryLimitPages, 0, 10)
+
+	s, err := NewSandboxStore(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to make sandbox store: %v", err)
+	}
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s
+}
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Intervals shorter than this are rounded up so a module can't spin the scheduler
+const minTimerInterval = 10 * time.Millisecond
+
+// Keeps track of the timers that modules have registered, as well as room ticks.
+//
+// When a timer fires, an ON_TIMER (or ON_TICK) event is run through ExecuteOnModule like any other event
+type timerScheduler struct {
+	mu        sync.Mutex
+	timers    map[string]*scheduledTimer
+	perModule map[string]int
+	nextId    uint64
+
+	maxPerModule int
+	clock        Clock
+
+	// Called (in the clock's goroutine) every time a timer fires
+	fire func(*wsevents.WSEventInfo)
+}
+
+type scheduledTimer struct {
+	id           string
+	instanceId   string
+	roomId       string
+	connectionId string
+	payload      string
+
+	// Zero for timeouts
+	interval time.Duration
+
+	// ON_TIMER for timers set by the module, ON_TICK for room ticks set by the embedder
+	eventType wsevents.WSEventType
+	ticks     uint64
+
+	timer Timer
+}
+
+// Ticks are keyed by room so that each room has at most one
+func tickId(instanceId string, roomId string) string {
+	return "tick:" + instanceId + ":" + roomId
+}
+
+func newTimerScheduler(maxPerModule int, clock Clock, fire func(*wsevents.WSEventInfo)) *timerScheduler {
+	return &timerScheduler{
+		timers:       make(map[string]*scheduledTimer),
+		perModule:    make(map[string]int),
+		maxPerModule: maxPerModule,
+		clock:        clock,
+		fire:         fire,
+	}
+}
+
+// Register a new timer, returns its ID
+func (t *timerScheduler) schedule(event *wasmevents.WASMEventInfo, delay time.Duration, repeat bool) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.perModule[event.InstanceId] >= t.maxPerModule {
+		return "", fmt.Errorf("Module %s has reached the limit of %d timers", event.InstanceId, t.maxPerModule)
+	}
+
+	t.nextId++
+	timer := &scheduledTimer{
+		id:           strconv.FormatUint(t.nextId, 10),
+		instanceId:   event.InstanceId,
+		roomId:       event.RoomId,
+		connectionId: event.ConnectionId,
+		payload:      event.Payload[1],
+		eventType:    wsevents.ON_TIMER,
+	}
+	if repeat {
+		timer.interval = max(delay, minTimerInterval)
+		delay = timer.interval
+	}
+
+	t.addLocked(timer, delay)
+	t.perModule[timer.instanceId]++
+
+	return timer.id, nil
+}
+
+// Start firing ON_TICK for a room. Replaces the room's previous ticks, if any
+func (t *timerScheduler) scheduleTicks(instanceId string, roomId string, interval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := tickId(instanceId, roomId)
+	if old, ok := t.timers[id]; ok {
+		old.timer.Stop()
+		t.removeLocked(old)
+	}
+
+	timer := &scheduledTimer{
+		id:         id,
+		instanceId: instanceId,
+		roomId:     roomId,
+		interval:   max(interval, minTimerInterval),
+		eventType:  wsevents.ON_TICK,
+	}
+	t.addLocked(timer, timer.interval)
+}
+
+func (t *timerScheduler) addLocked(timer *scheduledTimer, delay time.Duration) {
+	timer.timer = t.clock.AfterFunc(delay, func() { t.onFire(timer) })
+	t.timers[timer.id] = timer
+}
+
+func (t *timerScheduler) onFire(timer *scheduledTimer) {
+	t.mu.Lock()
+	if _, ok := t.timers[timer.id]; !ok {
+		// cancelled while the timer was firing
+		t.mu.Unlock()
+		return
+	}
+
+	if timer.interval == 0 {
+		t.removeLocked(timer)
+	}
+
+	// ticks carry a sequence number so the module can tell if any were missed
+	payload := timer.payload
+	if timer.eventType == wsevents.ON_TICK {
+		timer.ticks++
+		payload = strconv.FormatUint(timer.ticks, 10)
+	}
+	t.mu.Unlock()
+
+	t.fire(&wsevents.WSEventInfo{
+		ConnectionId: timer.connectionId,
+		InstanceId:   timer.instanceId,
+		RoomId:       timer.roomId,
+		Payload:      payload,
+		EventType:    timer.eventType,
+		Timestamp:    t.clock.Now().UnixMilli(),
+	})
+
+	// intervals are only re-armed once the event has run, so a slow module skips ticks
+	// instead of piling up executions
+	if timer.interval > 0 {
+		t.mu.Lock()
+		if t.timers[timer.id] == timer {
+			timer.timer.Reset(timer.interval)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Cancel a single timer. Modules may only cancel their own timers
+func (t *timerScheduler) cancel(instanceId string, timerId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timer, ok := t.timers[timerId]
+	if !ok || timer.instanceId != instanceId || timer.eventType != wsevents.ON_TIMER {
+		return fmt.Errorf("No timer with ID %s", timerId)
+	}
+
+	timer.timer.Stop()
+	t.removeLocked(timer)
+	return nil
+}
+
+// Cancel every timer matching the filter
+func (t *timerScheduler) cancelWhere(filter func(*scheduledTimer) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, timer := range t.timers {
+		if filter(timer) {
+			timer.timer.Stop()
+			t.removeLocked(timer)
+		}
+	}
+}
+
+func (t *timerScheduler) cancelModule(instanceId string) {
+	t.cancelWhere(func(timer *scheduledTimer) bool {
+		return timer.instanceId == instanceId
+	})
+}
+
+func (t *timerScheduler) cancelRoom(instanceId string, roomId string) {
+	t.cancelWhere(func(timer *scheduledTimer) bool {
+		return timer.instanceId == instanceId && timer.roomId == roomId
+	})
+}
+
+func (t *timerScheduler) cancelAll() {
+	t.cancelWhere(func(*scheduledTimer) bool { return true })
+}
+
+func (t *timerScheduler) removeLocked(timer *scheduledTimer) {
+	delete(t.timers, timer.id)
+
+	// ticks don't count towards the module's limit
+	if timer.eventType != wsevents.ON_TIMER {
+		return
+	}
+	t.perModule[timer.instanceId]--
+	if t.perModule[timer.instanceId] <= 0 {
+		delete(t.perModule, timer.instanceId)
+	}
+}
+
+// Handler for SET_TIMEOUT and SET_INTERVAL events.
+//
+// Payload is [delay in milliseconds, payload passed back to __onTimer]
+func (s *SandboxStore) setTimerHandler(repeat bool) wasmevents.HandlerFunction {
+	return func(event *wasmevents.WASMEventInfo) (string, error) {
+		if len(event.Payload) != 2 {
+			return "", fmt.Errorf("Invalid payload for %s event", event.EventType.String())
+		}
+
+		delayMs, err := strconv.ParseUint(event.Payload[0], 10, 32)
+		if err != nil {
+			return "", err
+		}
+
+		return s.timers.schedule(event, time.Duration(delayMs)*time.Millisecond, repeat)
+	}
+}
+
+// Handler for CLEAR_TIMER events. Payload is [timer ID]
+func (s *SandboxStore) clearTimerHandler(event *wasmevents.WASMEventInfo) (string, error) {
+	if len(event.Payload) != 1 {
+		return "", fmt.Errorf("Invalid payload for %s event", event.EventType.String())
+	}
+
+	return "", s.timers.cancel(event.InstanceId, event.Payload[0])
+}
+
+// Deliver a fired timer to its module. The clock already runs this in its own goroutine
+func (s *SandboxStore) fireTimer(event *wsevents.WSEventInfo) {
+	err := s.ExecuteOnModule(context.Background(), event)
+	if err == nil {
+		return
+	}
+
+	if s.onTimerError != nil {
+		s.onTimerError(event, err)
+		return
+	}
+	slog.Error("Failed to run timer", "instanceId", event.InstanceId, "roomId", event.RoomId, "err", err)
+}
+
+// Cancel everything tied to a room, such as its timers and ticks, and drop its cached state.
+// State that was saved to the StateBackend is kept.
+//
+// The embedding application should call this once a room has closed
+func (s *SandboxStore) CloseRoom(instanceId string, roomId string) {
+	s.timers.cancelRoom(instanceId, roomId)
+	if s.state != nil {
+		s.state.dropRoom(instanceId, roomId)
+	}
+}
+
+// Deliver ON_TICK to a room every interval, until StopTicks or CloseRoom is called.
+//
+// Calling this again for the same room changes its interval
+func (s *SandboxStore) StartTicks(instanceId string, roomId string, interval time.Duration) {
+	s.timers.scheduleTicks(instanceId, roomId, interval)
+}
+
+func (s *SandboxStore) StopTicks(instanceId string, roomId string) {
+	s.timers.cancelWhere(func(timer *scheduledTimer) bool {
+		return timer.id == tickId(instanceId, roomId)
+	})
+}
@@ -0,0 +1,255 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Clock that only moves when advance is called, which fires the timers that are due in the calling goroutine
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+
+	// Breaks ties between timers due at the same time, in the order they were set
+	seq uint64
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	when  time.Time
+	seq   uint64
+	f     func()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.UnixMilli(1000), timers: make(map[*fakeTimer]struct{})}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timer := &fakeTimer{clock: c, f: f}
+	c.startLocked(timer, d)
+	return timer
+}
+
+func (c *fakeClock) startLocked(timer *fakeTimer, d time.Duration) {
+	c.seq++
+	timer.when = c.now.Add(d)
+	timer.seq = c.seq
+	c.timers[timer] = struct{}{}
+}
+
+// Move time forward, firing the timers that become due one at a time, in order
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+
+	for {
+		var next *fakeTimer
+		for timer := range c.timers {
+			if timer.when.After(end) {
+				continue
+			}
+			if next == nil || timer.when.Before(next.when) || (timer.when.Equal(next.when) && timer.seq < next.seq) {
+				next = timer
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		delete(c.timers, next)
+		c.now = next.when
+		c.mu.Unlock()
+		next.f()
+		c.mu.Lock()
+	}
+
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Number of timers that haven't fired or been stopped
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	_, active := t.clock.timers[t]
+	t.clock.startLocked(t, d)
+	return active
+}
+
+// __onTick and __onTimer broadcast the event they were called with, __onJoin sets a 100ms interval with payload "p"
+const timersModule = `(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(import "env" "setInterval" (func $setInterval (param i32 i32 i32) (result i32)))
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(data (i32.const 0) "p")
+	(func (export "__onTick") (param $ptr i32) (param $len i32)
+		(drop (call $broadcast (local.get $ptr) (local.get $len))))
+	(func (export "__onTimer") (param $ptr i32) (param $len i32)
+		(drop (call $broadcast (local.get $ptr) (local.get $len))))
+	(func (export "__onJoin") (param i32 i32)
+		(global.set $heap (i32.const 4096))
+		(drop (call $setInterval (i32.const 100) (i32.const 0) (i32.const 1)))))`
+
+// Store on a fake clock that records the fields of every event the module broadcasts,
+// along with the number of timers that were pending while the event ran
+func newTimersStore(t *testing.T) (*SandboxStore, *fakeClock, func() []string) {
+	clock := newFakeClock()
+	var mu sync.Mutex
+	var events []string
+
+	s := newTestStore(t, SandboxStoreCfg{
+		Clock: clock,
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, fmt.Sprintf("%q pending=%d", decodeTestArray(t, event.Payload[0]), clock.pending()))
+			return "", nil
+		}),
+		OnTimerError: func(event *wsevents.WSEventInfo, err error) {
+			t.Errorf("%s for room %s failed: %v", event.EventType.String(), event.RoomId, err)
+		},
+	}, timersModule)
+
+	return s, clock, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered := slices.Clone(events)
+		events = nil
+		return delivered
+	}
+}
+
+func expectEvents(t *testing.T, events []string, want ...string) {
+	t.Helper()
+	if !slices.Equal(events, want) {
+		t.Errorf("Expected events %q, got %q", want, events)
+	}
+}
+
+// Ticks carry the room, the time they were due and their sequence number, which starts over when the interval changes
+func TestTicks(t *testing.T) {
+	s, clock, events := newTimersStore(t)
+
+	s.StartTicks("m", "a", 100*time.Millisecond)
+	clock.advance(350 * time.Millisecond)
+	expectEvents(t, events(),
+		`["a" "1100" "1"] pending=0`,
+		`["a" "1200" "2"] pending=0`,
+		`["a" "1300" "3"] pending=0`,
+	)
+
+	// the new interval counts from now, not from the last tick
+	s.StartTicks("m", "a", 30*time.Millisecond)
+	clock.advance(70 * time.Millisecond)
+	expectEvents(t, events(),
+		`["a" "1380" "1"] pending=0`,
+		`["a" "1410" "2"] pending=0`,
+	)
+	if n := clock.pending(); n != 1 {
+		t.Errorf("Expected the old interval to be stopped, got %d timers", n)
+	}
+
+	// intervals are rounded up to the minimum
+	s.StartTicks("m", "a", time.Millisecond)
+	clock.advance(25 * time.Millisecond)
+	expectEvents(t, events(),
+		`["a" "1430" "1"] pending=0`,
+		`["a" "1440" "2"] pending=0`,
+	)
+}
+
+func TestTicksAreCancelled(t *testing.T) {
+	for name, cancel := range map[string]func(s *SandboxStore){
+		"StopTicks": func(s *SandboxStore) { s.StopTicks("m", "a") },
+		"CloseRoom": func(s *SandboxStore) { s.CloseRoom("m", "a") },
+		"StartTicks": func(s *SandboxStore) {
+			// replacing the ticks of a room cancels the old ones, then StopTicks cancels the new ones
+			s.StartTicks("m", "a", time.Hour)
+			s.StopTicks("m", "a")
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, clock, events := newTimersStore(t)
+
+			s.StartTicks("m", "a", 100*time.Millisecond)
+			s.StartTicks("m", "b", 100*time.Millisecond)
+			clock.advance(100 * time.Millisecond)
+			expectEvents(t, events(),
+				`["a" "1100" "1"] pending=1`,
+				`["b" "1100" "1"] pending=1`,
+			)
+
+			cancel(s)
+			clock.advance(200 * time.Millisecond)
+			expectEvents(t, events(),
+				`["b" "1200" "2"] pending=0`,
+				`["b" "1300" "3"] pending=0`,
+			)
+			if n := clock.pending(); n != 1 {
+				t.Errorf("Expected only the ticks of room b to be pending, got %d timers", n)
+			}
+		})
+	}
+}
+
+// An interval is only re-armed once its event has finished, so none are pending while it runs
+func TestIntervalIsRearmedAfterEvent(t *testing.T) {
+	s, clock, events := newTimersStore(t)
+
+	join := message("m", "a", "")
+	join.EventType = wsevents.ON_JOIN
+	if err := s.ExecuteOnModule(context.Background(), join); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.advance(250 * time.Millisecond)
+	expectEvents(t, events(),
+		`["c" "a" "1100" "p"] pending=0`,
+		`["c" "a" "1200" "p"] pending=0`,
+	)
+	if n := clock.pending(); n != 1 {
+		t.Errorf("Expected the interval to be re-armed, got %d timers", n)
+	}
+
+	s.CloseRoom("m", "a")
+	clock.advance(time.Second)
+	expectEvents(t, events())
+	if n := clock.pending(); n != 0 {
+		t.Errorf("Expected CloseRoom to cancel the interval, got %d timers", n)
+	}
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+var (
+	errTransactionOpen = errors.New("A transaction is already open")
+	errNoTransaction   = errors.New("No transaction is open")
+	errTransactionsOff = errors.New("Transactions are not enabled")
+)
+
+/*
+The DB writes of one execution, buffered until the guest returns.
+
+Every DB_SET and DB_DEL of the execution is buffered, whether it's made inside a transaction or not,
+so they are all applied in the order the guest made them, or not at all if it fails.
+TX_ROLLBACK discards the writes made since TX_BEGIN. DB_GET sees the buffered writes, DB_LIST and DB_SCAN do not.
+
+Host calls of an execution are made one at a time, so a transaction needs no locking of its own
+*/
+type transaction struct {
+	writes []wasmevents.DBWrite
+
+	// Index of the first write of the open transaction, -1 if none is open
+	begin int
+}
+
+// Buffers the DB writes of each running execution, keyed by execution ID.
+// Only used when a TransactionHandler is configured
+type transactions struct {
+	handler wasmevents.TransactionHandler
+
+	// The user's handlers, which reads of keys without a buffered write go to
+	handlers wasmevents.HandlerMap
+
+	// Optional, sees every commit
+	recorder *record.Recorder
+
+	mu  sync.Mutex
+	txs map[string]*transaction
+}
+
+func newTransactions(handler wasmevents.TransactionHandler, handlers wasmevents.HandlerMap, recorder *record.Recorder) *transactions {
+	return &transactions{
+		handler:  handler,
+		handlers: handlers,
+		recorder: recorder,
+		txs:      make(map[string]*transaction),
+	}
+}
+
+// Replace the DB write and read handlers of a map with ones that go through the execution's transaction
+func (t *transactions) wrap(handlerMap *wasmevents.HandlerMap) {
+	handlerMap.
+		AddHandler(wasmevents.DB_SET, t.handleWrite).
+		AddHandler(wasmevents.DB_DEL, t.handleWrite).
+		AddHandler(wasmevents.DB_GET, t.handleGet)
+}
+
+// Make a single export call with its own transaction, and apply the DB writes it buffered once it returns.
+//
+// Nothing is applied if the call fails, or returns with a transaction still open
+func (t *transactions) call(ctx context.Context, instanceId string, call func(context.Context) error) error {
+	executionId := ExecutionId(ctx)
+	tx := &transaction{begin: -1}
+
+	t.mu.Lock()
+	t.txs[executionId] = tx
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.txs, executionId)
+		t.mu.Unlock()
+	}()
+
+	if err := call(ctx); err != nil {
+		return err
+	}
+
+	if tx.begin >= 0 {
+		return ErrTransactionOpen
+	}
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	err := t.handler.CommitTransaction(instanceId, tx.writes)
+	if t.recorder != nil {
+		t.recorder.RecordCommit(executionId, instanceId, tx.writes, err)
+	}
+	return err
+}
+
+// Find the transaction of the execution making a host call.
+// Returns nil for host calls made outside of an execution, such as by a snapshot warmup
+func (t *transactions) active(event *wasmevents.WASMEventInfo) *transaction {
+	if t == nil || event.ExecutionId == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.txs[event.ExecutionId]
+}
+
+// Payload is [key, value] for DB_SET and [key] for DB_DEL
+func (t *transactions) handleWrite(event *wasmevents.WASMEventInfo) (string, error) {
+	tx := t.active(event)
+	if tx == nil {
+		return t.handlers.CallHandler(event)
+	}
+
+	switch {
+	case event.EventType == wasmevents.DB_SET && len(event.Payload) == 2:
+		tx.writes = append(tx.writes, wasmevents.DBWrite{Key: event.Payload[0], Value: event.Payload[1]})
+	case event.EventType == wasmevents.DB_DEL && len(event.Payload) == 1:
+		tx.writes = append(tx.writes, wasmevents.DBWrite{Delete: true, Key: event.Payload[0]})
+	default:
+		return "", fmt.Errorf("Invalid payload for %s event", event.EventType.String())
+	}
+	return "", nil
+}
+
+// Answer DB_GET from the latest buffered write to the key, deleted keys read as ""
+func (t *transactions) handleGet(event *wasmevents.WASMEventInfo) (string, error) {
+	if tx := t.active(event); tx != nil && len(event.Payload) == 1 {
+		for i := len(tx.writes) - 1; i >= 0; i-- {
+			if tx.writes[i].Key == event.Payload[0] {
+				return tx.writes[i].Value, nil
+			}
+		}
+	}
+	return t.handlers.CallHandler(event)
+}
+
+// Handler for TX_BEGIN, TX_COMMIT and TX_ROLLBACK events
+func (s *SandboxStore) transactionHandler(event *wasmevents.WASMEventInfo) (string, error) {
+	tx := s.transactions.active(event)
+	if tx == nil {
+		return "", errTransactionsOff
+	}
+
+	if event.EventType == wasmevents.TX_BEGIN {
+		if tx.begin >= 0 {
+			return "", errTransactionOpen
+		}
+		tx.begin = len(tx.writes)
+		return "", nil
+	}
+
+	if tx.begin < 0 {
+		return "", errNoTransaction
+	}
+	if event.EventType == wasmevents.TX_ROLLBACK {
+		tx.writes = tx.writes[:tx.begin]
+	}
+	tx.begin = -1
+	return "", nil
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/record"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Every export sets "a" before TX_BEGIN and "c" after TX_COMMIT, with "b" in between.
+// __onMessage then traps, and __onLeave rolls back a transaction that sets "x" before any of that
+const transactionModule = `(module
+	(import "env" "dbSet" (func $set (param i32 i32 i32 i32) (result i32)))
+	(import "env" "txBegin" (func $begin (result i32)))
+	(import "env" "txCommit" (func $commit (result i32)))
+	(import "env" "txRollback" (func $rollback (result i32)))
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(data (i32.const 0) "abcx123")
+
+	(func $writes
+		(drop (call $set (i32.const 0) (i32.const 1) (i32.const 4) (i32.const 1)))
+		(drop (call $begin))
+		(drop (call $set (i32.const 1) (i32.const 1) (i32.const 5) (i32.const 1)))
+		(drop (call $commit))
+		(drop (call $set (i32.const 2) (i32.const 1) (i32.const 6) (i32.const 1))))
+
+	(func (export "__onJoin") (param i32 i32)
+		(call $writes))
+
+	(func (export "__onMessage") (param i32 i32)
+		(call $writes)
+		unreachable)
+
+	(func (export "__onLeave") (param i32 i32)
+		(drop (call $begin))
+		(drop (call $set (i32.const 3) (i32.const 1) (i32.const 4) (i32.const 1)))
+		(drop (call $rollback))
+		(call $writes)))`
+
+// TransactionHandler that keeps every commit
+type commitLog struct {
+	mu      sync.Mutex
+	commits [][]wasmevents.DBWrite
+}
+
+func (c *commitLog) CommitTransaction(instanceId string, writes []wasmevents.DBWrite) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commits = append(c.commits, slices.Clone(writes))
+	return nil
+}
+
+func (c *commitLog) take() [][]wasmevents.DBWrite {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	commits := c.commits
+	c.commits = nil
+	return commits
+}
+
+var transactionWrites = []wasmevents.DBWrite{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "c", Value: "3"}}
+
+func TestTransactions(t *testing.T) {
+	commits := &commitLog{}
+	s := newTestStore(t, SandboxStoreCfg{
+		TransactionHandler: commits,
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.DB_SET, func(event *wasmevents.WASMEventInfo) (string, error) {
+			t.Errorf("Expected %v to be buffered, but it went straight to the handler", event.Payload)
+			return "", nil
+		}),
+	}, transactionModule)
+
+	run := func(eventType wsevents.WSEventType) error {
+		return s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", EventType: eventType})
+	}
+
+	// writes before TX_BEGIN and after TX_COMMIT are applied in order along with the transaction's
+	if err := run(wsevents.ON_JOIN); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if got := commits.take(); len(got) != 1 || !slices.Equal(got[0], transactionWrites) {
+		t.Errorf("Expected a single commit of %v, got %v", transactionWrites, got)
+	}
+
+	// none of them are once the guest traps, even the ones outside of the transaction
+	if err := run(wsevents.ON_MESSAGE); err == nil {
+		t.Fatalf("Expected the message to trap")
+	}
+	if got := commits.take(); len(got) != 0 {
+		t.Errorf("Expected nothing to be committed after a trap, got %v", got)
+	}
+
+	if err := run(wsevents.ON_LEAVE); err != nil {
+		t.Fatalf("Leave failed: %v", err)
+	}
+	if got := commits.take(); len(got) != 1 || !slices.Equal(got[0], transactionWrites) {
+		t.Errorf("Expected the rolled back write to be left out of %v, got %v", transactionWrites, got)
+	}
+}
+
+// Buffered writes and transaction calls go through the handler map like any other host call, so they're recorded
+func TestTransactionsAreRecorded(t *testing.T) {
+	var buf bytes.Buffer
+	recorder, err := record.NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("Failed to start recording: %v", err)
+	}
+
+	s := newTestStore(t, SandboxStoreCfg{TransactionHandler: &commitLog{}, Recorder: recorder}, transactionModule)
+	if err := s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", EventType: wsevents.ON_JOIN}); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	rec, err := record.Read(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	executions := rec.Executions()
+	if len(executions) != 1 {
+		t.Fatalf("Expected one execution, got %d", len(executions))
+	}
+
+	var calls []wasmevents.WASMEventType
+	for _, call := range executions[0].Calls {
+		calls = append(calls, call.Event.EventType)
+	}
+	expected := []wasmevents.WASMEventType{wasmevents.DB_SET, wasmevents.TX_BEGIN, wasmevents.DB_SET, wasmevents.TX_COMMIT, wasmevents.DB_SET}
+	if !slices.Equal(calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, calls)
+	}
+
+	// and so is what they applied
+	if commit := executions[0].Commit; commit == nil || !slices.Equal(commit.Writes, transactionWrites) || commit.HasError {
+		t.Errorf("Expected a commit of %v to be recorded, got %+v", transactionWrites, commit)
+	}
+}
+
+func TestTransactionsOff(t *testing.T) {
+	var mu sync.Mutex
+	var sets []string
+	s := newTestStore(t, SandboxStoreCfg{
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.DB_SET, func(event *wasmevents.WASMEventInfo) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			sets = append(sets, event.Payload[0])
+			return "", nil
+		}),
+	}, transactionModule)
+
+	// without a TransactionHandler writes go straight to the handler, even if the guest traps afterwards
+	if err := s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", EventType: wsevents.ON_MESSAGE}); err == nil {
+		t.Fatalf("Expected the message to trap")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(sets, []string{"a", "b", "c"}) {
+		t.Errorf("Expected every write to be applied right away, got %v", sets)
+	}
+}
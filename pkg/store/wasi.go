@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	builder "github.com/Cloud-RAMP/wasm-sandbox/internal/host-builder"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Instantiate WASI in the runtime the first time a module opts in to it
+func (s *SandboxStore) ensureWASI(ctx context.Context) error {
+	s.wasiMu.Lock()
+	defer s.wasiMu.Unlock()
+
+	if s.wasiModule != nil {
+		return nil
+	}
+
+	wasi, err := builder.BuildWASIModule(ctx, s.runtime)
+	if err != nil {
+		return err
+	}
+	s.wasiModule = wasi
+	return nil
+}
+
+// Build the config used to instantiate each instance of a module.
+//
+// flush sends any output the instance wrote without a trailing newline, it is nil for modules without WASI
+func (s *SandboxStore) instanceConfig(mod *ActiveModule) (config wazero.ModuleConfig, flush func()) {
+	config = s.moduleConfig.WithName("")
+	if !mod.settings.WASI {
+		return config, nil
+	}
+
+	wasiCfg := mod.settings.WASIConfig
+
+	stdout := builder.NewHandlerWriter(&s.handlerMap, wasmevents.LOG, mod.instanceId)
+	stderr := builder.NewHandlerWriter(&s.handlerMap, wasmevents.DEBUG, mod.instanceId)
+	config = config.
+		WithStartFunctions(wasiStartFunctions(mod.compiled)...).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithRandSource(rand.New(rand.NewSource(wasiCfg.RandSeed)))
+
+	if len(wasiCfg.Args) > 0 {
+		config = config.WithArgs(wasiCfg.Args...)
+	}
+
+	if wasiCfg.HostClock {
+		config = config.WithSysWalltime().WithSysNanotime()
+	}
+
+	return config, func() {
+		stdout.Flush()
+		stderr.Flush()
+	}
+}
+
+// WASI "reactor" modules export _initialize, which sets up their runtime and returns.
+// "Command" modules (the default for TinyGo and Rust) export _start instead, which runs main.
+// That is fine as long as it returns, but a _start that calls proc_exit closes the instance, see checkWASIInstance
+func wasiStartFunctions(compiled wazero.CompiledModule) []string {
+	exports := compiled.ExportedFunctions()
+	if _, ok := exports["_initialize"]; ok {
+		return []string{"_initialize"}
+	}
+	if _, ok := exports["_start"]; ok {
+		return []string{"_start"}
+	}
+	return nil
+}
+
+// Refuse instances that exited while starting, which can't run any events
+func checkWASIInstance(mod *ActiveModule, instance api.Module) error {
+	if !mod.settings.WASI || !instance.IsClosed() {
+		return nil
+	}
+	return fmt.Errorf("Module %s exited from _start. Build it as a WASI reactor (exporting _initialize), or return from main without exiting", mod.instanceId)
+}
+
+// Send the output an instance wrote without a trailing newline, once it finished an event
+func (mod *ActiveModule) flushOutput(instance api.Module) {
+	if flush, ok := mod.output.Load(instance); ok {
+		flush.(func())()
+	}
+}
+
+// Keep the flush function of a new instance
+func (mod *ActiveModule) trackOutput(instance api.Module, flush func()) {
+	if flush != nil {
+		mod.output.Store(instance, flush)
+	}
+}
+
+// Flush and forget an instance that is being closed
+func (mod *ActiveModule) untrackOutput(instance api.Module) {
+	mod.flushOutput(instance)
+	mod.output.Delete(instance)
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/loader"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// Prints "init\n" from its start functions, and "one\ntwo\nthr" (without a trailing newline) on every join
+const wasiModule = `(module
+	(import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(data (i32.const 0) "init\n")
+	(data (i32.const 16) "one\ntwo\nthr")
+
+	(func $print (param $ptr i32) (param $len i32)
+		(i32.store (i32.const 256) (local.get $ptr))
+		(i32.store (i32.const 260) (local.get $len))
+		(drop (call $fd_write (i32.const 1) (i32.const 256) (i32.const 1) (i32.const 264))))
+
+	%s
+
+	(func (export "__onJoin") (param i32 i32)
+		(call $print (i32.const 16) (i32.const 11))))`
+
+// Store running wasiModule with the given start functions, returns the lines it logged
+func newWASIStore(t *testing.T, start string) (*SandboxStore, func() []string) {
+	var mu sync.Mutex
+	var lines []string
+
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize: 1,
+		SettingsFunction: func(ctx context.Context, moduleId string) (*loader.ModuleSettings, error) {
+			return &loader.ModuleSettings{WASI: true}, nil
+		},
+		HandlerMap: wasmevents.NewHandlerMap().AddHandler(wasmevents.LOG, func(event *wasmevents.WASMEventInfo) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, event.Payload[0])
+			return "", nil
+		}),
+	}, strings.Replace(wasiModule, "%s", start, 1))
+
+	return s, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := lines
+		lines = nil
+		return taken
+	}
+}
+
+func TestWASIStdoutIsLogged(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		start string
+	}{
+		{"reactor", `(func (export "_initialize") (call $print (i32.const 0) (i32.const 5)))`},
+		{"command", `(func (export "_start") (call $print (i32.const 0) (i32.const 5)))`},
+		// reactors that still export _start only run _initialize
+		{"both", `(func (export "_initialize") (call $print (i32.const 0) (i32.const 5)))
+			(func (export "_start") unreachable)`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, lines := newWASIStore(t, tc.start)
+
+			// whole lines are logged as they're written, and the rest once the event is done
+			join(t, s, 2)
+			expected := []string{"init", "one", "two", "thr", "one", "two", "thr"}
+			if got := lines(); !slices.Equal(got, expected) {
+				t.Errorf("Expected lines %q, got %q", expected, got)
+			}
+		})
+	}
+}
+
+// Commands that exit from _start close their instance before any event can run on it
+func TestWASICommandExitIsRefused(t *testing.T) {
+	s, _ := newWASIStore(t, `(func (export "_start") (call $proc_exit (i32.const 0)))`)
+
+	err := s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{InstanceId: "m", EventType: wsevents.ON_JOIN})
+	if err == nil || !strings.Contains(err.Error(), "_initialize") {
+		t.Errorf("Expected an error about building a reactor, got %v", err)
+	}
+}
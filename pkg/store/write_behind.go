@@ -0,0 +1,259 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+)
+
+// KV events that read or modify keys in ways the buffer can't answer on its own.
+// A module's buffered writes are flushed before any of these run, so they never see stale values
+var flushBeforeEvents = []wasmevents.WASMEventType{
+	wasmevents.SET_EX,
+	wasmevents.EXPIRE,
+	wasmevents.INCR_BY,
+	wasmevents.COMPARE_AND_SWAP,
+	wasmevents.SET_IF_ABSENT,
+	wasmevents.LIST_PUSH,
+	wasmevents.LIST_POP,
+	wasmevents.LIST_RANGE,
+	wasmevents.HASH_GET,
+	wasmevents.HASH_SET,
+	wasmevents.HASH_GET_ALL,
+	wasmevents.SCAN,
+}
+
+/*
+Buffers SET and DEL events per module, so that guests don't wait on the KV handlers.
+
+Only the latest write to each key is kept, and it is passed to the original handler when the buffer is flushed:
+  - every interval, in the background
+  - once a module has maxKeys keys buffered
+  - after a module is evicted or removed for being idle
+  - when the store is closed
+
+GET events for a key with a buffered write are answered from the buffer
+*/
+type writeBehind struct {
+	// The user's handlers, which buffered writes are passed to
+	handlers wasmevents.HandlerMap
+	maxKeys  int
+
+	mu      sync.Mutex
+	modules map[string]*writeBuffer
+
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type writeBuffer struct {
+	mu sync.Mutex
+
+	// Latest SET or DEL event for each key
+	writes map[string]*wasmevents.WASMEventInfo
+
+	// Keys in the order they were first written, so flushes are deterministic
+	order []string
+
+	// Set once the buffer is removed from the map, writers that raced with that need to get a new one
+	dropped bool
+
+	// Writes that a flush is passing to the handlers, GET still answers from these until it's done
+	flushing map[string]*wasmevents.WASMEventInfo
+
+	// Held while a flush calls the handlers (without mu), so flushes of a module apply writes in order
+	flushMu sync.Mutex
+}
+
+func newWriteBehind(handlers wasmevents.HandlerMap, interval time.Duration, maxKeys int) *writeBehind {
+	wb := &writeBehind{
+		handlers: handlers,
+		maxKeys:  maxKeys,
+		modules:  make(map[string]*writeBuffer),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go wb.loop(interval)
+	return wb
+}
+
+// Replace the KV handlers of a map with ones that go through the buffer
+func (wb *writeBehind) wrap(handlerMap *wasmevents.HandlerMap) {
+	handlerMap.
+		AddHandler(wasmevents.SET, wb.handleWrite).
+		AddHandler(wasmevents.DEL, wb.handleWrite).
+		AddHandler(wasmevents.GET, wb.handleGet)
+
+	for _, eventType := range flushBeforeEvents {
+		if handler, ok := wb.handlers[eventType]; ok {
+			handlerMap.AddHandler(eventType, func(event *wasmevents.WASMEventInfo) (string, error) {
+				wb.flushModule(event.InstanceId)
+				return handler(event)
+			})
+		}
+	}
+}
+
+// Get a module's buffer, creating it if needed. Only writes create buffers, so modules that only read don't keep one
+func (wb *writeBehind) buffer(instanceId string) *writeBuffer {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	buf, ok := wb.modules[instanceId]
+	if !ok {
+		buf = &writeBuffer{writes: make(map[string]*wasmevents.WASMEventInfo)}
+		wb.modules[instanceId] = buf
+	}
+	return buf
+}
+
+// Get a module's buffer, nil if it has none
+func (wb *writeBehind) lookup(instanceId string) *writeBuffer {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return wb.modules[instanceId]
+}
+
+// Payload is [key, value] for SET and [key] for DEL
+func (wb *writeBehind) handleWrite(event *wasmevents.WASMEventInfo) (string, error) {
+	if len(event.Payload) == 0 {
+		return "", fmt.Errorf("Invalid payload for %s event", event.EventType.String())
+	}
+	key := event.Payload[0]
+
+	buf := wb.buffer(event.InstanceId)
+	buf.mu.Lock()
+	for buf.dropped {
+		buf.mu.Unlock()
+		buf = wb.buffer(event.InstanceId)
+		buf.mu.Lock()
+	}
+
+	if _, ok := buf.writes[key]; !ok {
+		buf.order = append(buf.order, key)
+	}
+	buf.writes[key] = event
+	full := len(buf.writes) >= wb.maxKeys
+	buf.mu.Unlock()
+
+	if full {
+		wb.flush(buf)
+	}
+	return "", nil
+}
+
+func (wb *writeBehind) handleGet(event *wasmevents.WASMEventInfo) (string, error) {
+	if buf := wb.lookup(event.InstanceId); buf != nil && len(event.Payload) == 1 {
+		buf.mu.Lock()
+		write, ok := buf.writes[event.Payload[0]]
+		if !ok {
+			write, ok = buf.flushing[event.Payload[0]]
+		}
+		buf.mu.Unlock()
+
+		if ok {
+			if write.EventType == wasmevents.DEL {
+				return "", nil
+			}
+			return write.Payload[1], nil
+		}
+	}
+	return wb.handlers.CallHandler(event)
+}
+
+// Pass a buffer's writes to the handlers. The writes are swapped out under the buffer's lock,
+// so guests keep buffering while the handlers run.
+//
+// Writes that fail are logged and dropped, since the guest that made them has already returned
+func (wb *writeBehind) flush(buf *writeBuffer) {
+	buf.flushMu.Lock()
+	defer buf.flushMu.Unlock()
+
+	buf.mu.Lock()
+	writes, order := buf.writes, buf.order
+	if len(writes) == 0 {
+		buf.mu.Unlock()
+		return
+	}
+	buf.writes = make(map[string]*wasmevents.WASMEventInfo)
+	buf.order = nil
+	buf.flushing = writes
+	buf.mu.Unlock()
+
+	for _, key := range order {
+		event := writes[key]
+		if _, err := wb.handlers.CallHandler(event); err != nil {
+			slog.Error("Failed to flush write", "instanceId", event.InstanceId, "event", event.EventType.String(), "key", key, "err", err)
+		}
+	}
+
+	buf.mu.Lock()
+	buf.flushing = nil
+	buf.mu.Unlock()
+}
+
+func (wb *writeBehind) flushModule(instanceId string) {
+	if buf := wb.lookup(instanceId); buf != nil {
+		wb.flush(buf)
+	}
+}
+
+func (wb *writeBehind) flushAll() {
+	wb.mu.Lock()
+	ids := make([]string, 0, len(wb.modules))
+	for id := range wb.modules {
+		ids = append(ids, id)
+	}
+	wb.mu.Unlock()
+
+	for _, id := range ids {
+		wb.flushModule(id)
+	}
+}
+
+// Flush a module that is being removed, and forget its buffer
+func (wb *writeBehind) dropModule(instanceId string) {
+	wb.flushModule(instanceId)
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if buf, ok := wb.modules[instanceId]; ok {
+		buf.mu.Lock()
+		defer buf.mu.Unlock()
+
+		// keep buffers that were written to again since the flush, by a newer load of the module
+		if len(buf.writes) == 0 && buf.flushing == nil {
+			buf.dropped = true
+			delete(wb.modules, instanceId)
+		}
+	}
+}
+
+func (wb *writeBehind) loop(interval time.Duration) {
+	defer close(wb.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wb.quit:
+			return
+		case <-ticker.C:
+			wb.flushAll()
+		}
+	}
+}
+
+// Stop the background flushes and flush everything that is left. Only the first call does anything
+func (wb *writeBehind) stop() {
+	wb.stopOnce.Do(func() {
+		close(wb.quit)
+		<-wb.done
+		wb.flushAll()
+	})
+}
@@ -0,0 +1,307 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+)
+
+// The letters count per instance, so tests use a pool of one.
+// Messages set "k" to the next letter, joins broadcast what GET returns for "k",
+// and leaves set the next letter as a key to "v"
+const writeBehindModule = `(module
+	(import "env" "set" (func $set (param i32 i32 i32 i32) (result i32)))
+	(import "env" "get" (func $get (param i32 i32) (result i32)))
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+	(memory (export "memory") 1)
+` + testAllocator + `
+	(global $counter (mut i32) (i32.const 0))
+	(data (i32.const 0) "k")
+	(data (i32.const 16) "v")
+
+	;; writes the next letter at 32
+	(func $nextLetter
+		(i32.store8 (i32.const 32) (i32.add (i32.const 0x61) (i32.and (global.get $counter) (i32.const 15))))
+		(global.set $counter (i32.add (global.get $counter) (i32.const 1))))
+
+	(func (export "__onMessage") (param i32 i32)
+		(global.set $heap (i32.const 4096))
+		call $nextLetter
+		(drop (call $set (i32.const 0) (i32.const 1) (i32.const 32) (i32.const 1))))
+
+	;; GET returns "+\0" and then the value in UTF-16
+	(func (export "__onJoin") (param i32 i32) (local $ptr i32)
+		(global.set $heap (i32.const 4096))
+		(local.set $ptr (call $get (i32.const 0) (i32.const 1)))
+		(drop (call $broadcast (i32.add (local.get $ptr) (i32.const 2)) (i32.sub (global.get $lastSize) (i32.const 2)))))
+
+	(func (export "__onLeave") (param i32 i32)
+		(global.set $heap (i32.const 4096))
+		call $nextLetter
+		(drop (call $set (i32.const 32) (i32.const 1) (i32.const 16) (i32.const 1)))))`
+
+// KV handlers backed by a map, that remember every SET they were passed
+type testKV struct {
+	mu         sync.Mutex
+	data       map[string]string
+	sets       []string
+	gets       int
+	broadcasts map[string][]string
+
+	// Slows SET down so that executions overlap with flushes
+	setDelay time.Duration
+}
+
+func newTestKV() *testKV {
+	return &testKV{data: make(map[string]string), broadcasts: make(map[string][]string)}
+}
+
+func (kv *testKV) handlers() *wasmevents.HandlerMap {
+	return wasmevents.NewHandlerMap().
+		AddHandler(wasmevents.SET, func(event *wasmevents.WASMEventInfo) (string, error) {
+			time.Sleep(kv.setDelay)
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
+			key := event.InstanceId + "/" + event.Payload[0]
+			kv.data[key] = event.Payload[1]
+			kv.sets = append(kv.sets, key+"="+event.Payload[1])
+			return "", nil
+		}).
+		AddHandler(wasmevents.GET, func(event *wasmevents.WASMEventInfo) (string, error) {
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
+			kv.gets++
+			return kv.data[event.InstanceId+"/"+event.Payload[0]], nil
+		}).
+		AddHandler(wasmevents.BROADCAST, func(event *wasmevents.WASMEventInfo) (string, error) {
+			// every other byte, since the guest broadcasts UTF-16
+			var value []byte
+			for i := 0; i < len(event.Payload[0]); i += 2 {
+				value = append(value, event.Payload[0][i])
+			}
+
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
+			kv.broadcasts[event.InstanceId] = append(kv.broadcasts[event.InstanceId], string(value))
+			return "", nil
+		})
+}
+
+func (kv *testKV) setCalls() []string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return slices.Clone(kv.sets)
+}
+
+func send(t *testing.T, s *SandboxStore, instanceId string, eventType wsevents.WSEventType) {
+	t.Helper()
+	if err := s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{InstanceId: instanceId, EventType: eventType}); err != nil {
+		t.Fatalf("%s failed: %v", eventType.String(), err)
+	}
+}
+
+func TestWriteBehindCoalescesUntilClose(t *testing.T) {
+	kv := newTestKV()
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:            1,
+		WriteBehindInterval: time.Hour,
+		HandlerMap:          kv.handlers(),
+	}, writeBehindModule)
+
+	for range 5 {
+		send(t, s, "m", wsevents.ON_MESSAGE)
+	}
+	if sets := kv.setCalls(); len(sets) != 0 {
+		t.Errorf("Expected writes to be buffered, got %v", sets)
+	}
+
+	// the module reads its own write from the buffer, the handler is never asked
+	send(t, s, "m", wsevents.ON_JOIN)
+	if got := kv.broadcasts["m"]; !slices.Equal(got, []string{"e"}) {
+		t.Errorf("Expected GET to return the buffered %q, got %v", "e", got)
+	}
+	if kv.gets != 0 {
+		t.Errorf("Expected GET not to reach the handler, it was called %d times", kv.gets)
+	}
+
+	s.Close(context.Background())
+	if sets := kv.setCalls(); !slices.Equal(sets, []string{"m/k=e"}) {
+		t.Errorf("Expected a single coalesced SET on Close, got %v", sets)
+	}
+}
+
+// Modules that only read go straight to the handler, and don't get a buffer
+func TestWriteBehindReadsWithoutBuffer(t *testing.T) {
+	kv := newTestKV()
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:            1,
+		WriteBehindInterval: time.Hour,
+		HandlerMap:          kv.handlers(),
+	}, writeBehindModule)
+
+	kv.data["m/k"] = "x"
+	send(t, s, "m", wsevents.ON_JOIN)
+	if got := kv.broadcasts["m"]; !slices.Equal(got, []string{"x"}) {
+		t.Errorf("Expected GET to return the handler's %q, got %v", "x", got)
+	}
+	if buf := s.writes.lookup("m"); buf != nil {
+		t.Errorf("Expected no buffer for a module that only reads")
+	}
+
+	send(t, s, "m", wsevents.ON_MESSAGE)
+	if buf := s.writes.lookup("m"); buf == nil {
+		t.Errorf("Expected a buffer once the module writes")
+	}
+}
+
+func TestWriteBehindFlushesWhenFull(t *testing.T) {
+	kv := newTestKV()
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:            1,
+		WriteBehindInterval: time.Hour,
+		WriteBehindMaxKeys:  3,
+		HandlerMap:          kv.handlers(),
+	}, writeBehindModule)
+
+	send(t, s, "m", wsevents.ON_LEAVE)
+	send(t, s, "m", wsevents.ON_LEAVE)
+	if sets := kv.setCalls(); len(sets) != 0 {
+		t.Errorf("Expected writes to be buffered below the limit, got %v", sets)
+	}
+
+	send(t, s, "m", wsevents.ON_LEAVE)
+	if sets := kv.setCalls(); !slices.Equal(sets, []string{"m/a=v", "m/b=v", "m/c=v"}) {
+		t.Errorf("Expected the buffer to be flushed in order once full, got %v", sets)
+	}
+}
+
+func TestWriteBehindFlushesOnEviction(t *testing.T) {
+	kv := newTestKV()
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:            1,
+		MaxActiveModules:    1,
+		WriteBehindInterval: time.Hour,
+		HandlerMap:          kv.handlers(),
+	}, writeBehindModule)
+
+	send(t, s, "first", wsevents.ON_MESSAGE)
+	send(t, s, "second", wsevents.ON_MESSAGE)
+
+	// evicted modules are closed in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Contains(kv.setCalls(), "first/k=a") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the evicted module's write to be flushed, got %v", kv.setCalls())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if slices.Contains(kv.setCalls(), "second/k=a") {
+		t.Errorf("Expected the loaded module's write to stay buffered")
+	}
+}
+
+func TestWriteBehindReadsWritesBeingFlushed(t *testing.T) {
+	kv := newTestKV()
+	handlers := kv.handlers()
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	set := (*handlers)[wasmevents.SET]
+	handlers.AddHandler(wasmevents.SET, func(event *wasmevents.WASMEventInfo) (string, error) {
+		select {
+		case started <- struct{}{}:
+			<-release
+		default:
+		}
+		return set(event)
+	})
+
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:            1,
+		WriteBehindInterval: time.Hour,
+		HandlerMap:          handlers,
+	}, writeBehindModule)
+
+	send(t, s, "m", wsevents.ON_MESSAGE)
+	flushed := make(chan struct{})
+	go func() {
+		s.writes.flushModule("m")
+		close(flushed)
+	}()
+	<-started
+
+	// "a" is with the handler but not stored yet, then "b" is buffered behind it
+	send(t, s, "m", wsevents.ON_JOIN)
+	send(t, s, "m", wsevents.ON_MESSAGE)
+	send(t, s, "m", wsevents.ON_JOIN)
+	close(release)
+	<-flushed
+
+	kv.mu.Lock()
+	got := slices.Clone(kv.broadcasts["m"])
+	kv.mu.Unlock()
+	if !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("Expected reads of %v while flushing, got %v", []string{"a", "b"}, got)
+	}
+
+	s.Close(context.Background())
+	if sets := kv.setCalls(); !slices.Equal(sets, []string{"m/k=a", "m/k=b"}) {
+		t.Errorf("Expected both writes to be flushed in order, got %v", sets)
+	}
+}
+
+// Modules always read their own latest write, while background flushes pass writes to slow handlers
+func TestWriteBehindReadsOwnWritesDuringFlushes(t *testing.T) {
+	kv := newTestKV()
+	kv.setDelay = 200 * time.Microsecond
+	s := newTestStore(t, SandboxStoreCfg{
+		PoolSize:            1,
+		WriteBehindInterval: time.Millisecond,
+		HandlerMap:          kv.handlers(),
+	}, writeBehindModule)
+
+	const modules = 4
+	const rounds = 40
+	var wg sync.WaitGroup
+	for i := range modules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instanceId := fmt.Sprintf("m%d", i)
+			for range rounds {
+				for _, eventType := range []wsevents.WSEventType{wsevents.ON_MESSAGE, wsevents.ON_JOIN} {
+					err := s.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{InstanceId: instanceId, EventType: eventType})
+					if err != nil {
+						t.Errorf("%s failed: %v", eventType.String(), err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	s.Close(context.Background())
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	for i := range modules {
+		instanceId := fmt.Sprintf("m%d", i)
+		got := kv.broadcasts[instanceId]
+		if len(got) != rounds {
+			t.Fatalf("Expected %d reads from %s, got %d", rounds, instanceId, len(got))
+		}
+		for round, value := range got {
+			if expected := string(rune('a' + round%16)); value != expected {
+				t.Fatalf("Round %d of %s read %q instead of its own write %q", round, instanceId, value, expected)
+			}
+		}
+		if value := kv.data[instanceId+"/k"]; value != string(rune('a'+(rounds-1)%16)) {
+			t.Errorf("Expected the last write of %s to be flushed, got %q", instanceId, value)
+		}
+	}
+}
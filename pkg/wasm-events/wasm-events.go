@@ -55,6 +55,91 @@ const (
 
 	// Send an HTTP request to a given URL with a request type and body
 	FETCH
+
+	// Call __onTimer once after a delay.
+	// Handled by the store's timer scheduler, returns the timer ID
+	SET_TIMEOUT
+
+	// Call __onTimer repeatedly on an interval.
+	// Handled by the store's timer scheduler, returns the timer ID
+	SET_INTERVAL
+
+	// Cancel a timer created with SET_TIMEOUT or SET_INTERVAL
+	CLEAR_TIMER
+
+	// Get the state blob of the current module and room.
+	// Handled by the store when a StateBackend is configured
+	GET_STATE
+
+	// Replace the state blob of the current module and room.
+	// It is saved to the StateBackend once the call returns successfully
+	SET_STATE
+
+	// Set a key in the in-memory KV store which expires after a TTL.
+	// Payload is [key, value, ttl in milliseconds]
+	SET_EX
+
+	// Set the TTL of an existing key in the in-memory KV store.
+	// Payload is [key, ttl in milliseconds]
+	EXPIRE
+
+	// Atomically add to the integer stored at a key (missing keys start at 0).
+	// Payload is [key, delta], returns the new value
+	INCR_BY
+
+	// Atomically replace a key's value if it currently holds the expected value.
+	// Payload is [key, expected, new], returns "true" if the value was swapped
+	COMPARE_AND_SWAP
+
+	// Set a key only if it doesn't exist.
+	// Payload is [key, value], returns "true" if the value was set
+	SET_IF_ABSENT
+
+	// Append a value to the end of a list.
+	// Payload is [key, value], returns the new length of the list
+	LIST_PUSH
+
+	// Remove and return the first value of a list.
+	// Payload is [key], fails if the list is empty
+	LIST_POP
+
+	// Get a range of a list. Negative indexes count from the end of the list.
+	// Payload is [key, start, stop] (both inclusive), returns a JSON array of strings
+	LIST_RANGE
+
+	// Get a field of a hash.
+	// Payload is [key, field], returns "" if the field doesn't exist
+	HASH_GET
+
+	// Set a field of a hash.
+	// Payload is [key, field, value]
+	HASH_SET
+
+	// Get every field of a hash.
+	// Payload is [key], returns a JSON object of fields to values
+	HASH_GET_ALL
+
+	// List the keys in persistent storage that start with a prefix.
+	// Payload is [prefix], returns a JSON array of keys in sorted order
+	DB_LIST
+
+	// Get a page of keys and values from the in-memory KV store that start with a prefix.
+	// Payload is [prefix, cursor, limit], returns a JSON array of [next cursor, key1, value1, ...].
+	// The cursor is "" for the first page, and the next cursor is "" once there are no more keys
+	SCAN
+
+	// Same as SCAN, for persistent storage
+	DB_SCAN
+
+	// Start a transaction, whose DB_SET and DB_DEL writes can be rolled back.
+	// Handled by the store when a TransactionHandler is configured
+	TX_BEGIN
+
+	// Close the open transaction. Its writes are applied atomically once the guest returns successfully
+	TX_COMMIT
+
+	// Discard the writes of the open transaction
+	TX_ROLLBACK
 )
 
 type WASMEventInfo struct {
@@ -68,6 +153,9 @@ type WASMEventInfo struct {
 	// The unique ID of the application that this event is being sent to
 	InstanceId string `json:"instance_id"`
 
+	// Unique ID of the module execution that made this call, shared by every call it makes
+	ExecutionId string `json:"execution_id"`
+
 	EventType WASMEventType `jsonL:"event_type"`
 	Payload   []string      `json:"payload"`
 
@@ -91,12 +179,52 @@ var eventStrings = [...]string{
 	"serverMessage",
 	"closeConnection",
 	"fetch",
+	"setTimeout",
+	"setInterval",
+	"clearTimer",
+	"getState",
+	"setState",
+	"setEx",
+	"expire",
+	"incrBy",
+	"compareAndSwap",
+	"setIfAbsent",
+	"listPush",
+	"listPop",
+	"listRange",
+	"hashGet",
+	"hashSet",
+	"hashGetAll",
+	"dbList",
+	"scan",
+	"dbScan",
+	"txBegin",
+	"txCommit",
+	"txRollback",
 }
 
 func (e WASMEventType) String() string {
 	return eventStrings[e]
 }
 
+func (e WASMEventType) Valid() bool {
+	return e >= 0 && e < WASMEventType(len(eventStrings))
+}
+
+// A single write to persistent storage, made inside a transaction
+type DBWrite struct {
+	Delete bool
+	Key    string
+	Value  string
+}
+
+// Implemented by persistent storage that can apply several writes at once.
+//
+// Either every write is applied or none are
+type TransactionHandler interface {
+	CommitTransaction(instanceId string, writes []DBWrite) error
+}
+
 type HandlerFunction func(*WASMEventInfo) (string, error)
 type HandlerMap map[WASMEventType]HandlerFunction
 
@@ -7,6 +7,20 @@ const (
 	ON_JOIN
 	ON_LEAVE
 	ON_ERROR
+
+	// Fired by the store when a timer set by the module expires
+	ON_TIMER
+
+	// Room lifecycle events. These are not tied to a connection, see IsRoomEvent
+
+	// The first user has joined a room
+	ON_ROOM_CREATED
+
+	// The last user has left a room
+	ON_ROOM_EMPTY
+
+	// Periodic tick for a room, see SandboxStore.StartTicks
+	ON_TICK
 )
 
 // These are the function names that will be defined within our AssemblyScript SDK
@@ -15,6 +29,10 @@ var exportedWSEvents = [...]string{
 	"__onJoin",
 	"__onLeave",
 	"__onError",
+	"__onTimer",
+	"__onRoomCreated",
+	"__onRoomEmpty",
+	"__onTick",
 }
 
 func (e WSEventType) String() string {
@@ -27,6 +45,12 @@ func (e WSEventType) Valid() bool {
 	return e >= 0 && e < WSEventType(len(exportedWSEvents))
 }
 
+// Room events are about a room as a whole, so they don't have a connection ID
+// and are encoded differently when passed to the module
+func (e WSEventType) IsRoomEvent() bool {
+	return e == ON_ROOM_CREATED || e == ON_ROOM_EMPTY || e == ON_TICK
+}
+
 // This event defines info that will be sent INTO the WASM sandbox for the user to use in their code
 type WSEventInfo struct {
 	// The unique ID of the connection sending this message
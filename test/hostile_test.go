@@ -0,0 +1,307 @@
+package test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	builder "github.com/Cloud-RAMP/wasm-sandbox/internal/host-builder"
+	"github.com/Cloud-RAMP/wasm-sandbox/internal/wasmtest"
+	"github.com/Cloud-RAMP/wasm-sandbox/pkg/store"
+	wasmevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/wasm-events"
+	wsevents "github.com/Cloud-RAMP/wasm-sandbox/pkg/ws-events"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Written into the memory of another instance, hostile calls must never be able to see it
+const secret = "SECRET-SECRET-42"
+
+// Offset of the payload in an encoded WS event with an empty connection, room and a timestamp of 0
+const payloadOffset = 23
+
+// Guest helpers to fill a range of memory with a byte, and to check that it still holds it
+const guardFuncs = `
+	(func $fill (param $from i32) (param $to i32) (param $byte i32)
+		block
+			loop
+				(br_if 1 (i32.ge_u (local.get $from) (local.get $to)))
+				(i32.store8 (local.get $from) (local.get $byte))
+				(local.set $from (i32.add (local.get $from) (i32.const 1)))
+				br 0
+			end
+		end)
+
+	(func $changed (param $from i32) (param $to i32) (param $byte i32) (result i32)
+		block
+			loop
+				(br_if 1 (i32.ge_u (local.get $from) (local.get $to)))
+				(i32.ne (i32.load8_u (local.get $from)) (local.get $byte))
+				if
+					i32.const 1
+					return
+				end
+				(local.set $from (i32.add (local.get $from) (i32.const 1)))
+				br 0
+			end
+		end
+		i32.const 0)
+`
+
+// Build a guest that imports every host function, and calls each of them with values taken from the
+// first 16 bytes of the event payload, rotated so that every value reaches every parameter.
+//
+// __onMessage uses a working allocator, and traps if a host write landed anywhere but in what __new
+// returned. __onJoin makes __new return the first value instead, so that host writes into the guest
+// go to adversarial addresses as well
+func hostileModule(tb testing.TB) []byte {
+	tb.Helper()
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+	hostModule, err := builder.BuildHostModule(ctx, runtime, wasmevents.NewHandlerMap())
+	if err != nil {
+		tb.Fatalf("Failed to build host module: %v", err)
+	}
+
+	definitions := hostModule.ExportedFunctionDefinitions()
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	valueTypes := func(kind string, types []api.ValueType) string {
+		if len(types) == 0 {
+			return ""
+		}
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = api.ValueTypeName(t)
+		}
+		return fmt.Sprintf(" (%s %s)", kind, strings.Join(names, " "))
+	}
+
+	var wat strings.Builder
+	wat.WriteString("(module\n")
+	for i, name := range names {
+		def := definitions[name]
+		fmt.Fprintf(&wat, "\t(import \"env\" %q (func $host%d%s%s))\n",
+			name, i, valueTypes("param", def.ParamTypes()), valueTypes("result", def.ResultTypes()))
+	}
+
+	wat.WriteString(`
+	(memory (export "memory") 1)
+	(global $heap (mut i32) (i32.const 1024))
+	;; what __new returns when it's not 0
+	(global $override (mut i32) (i32.const 0))
+
+	(func (export "__new") (param $size i32) (param $id i32) (result i32)
+		global.get $override
+		if (result i32)
+			global.get $override
+		else
+			global.get $heap
+			(global.set $heap (i32.add (global.get $heap) (local.get $size)))
+		end)
+`)
+	wat.WriteString(guardFuncs)
+
+	event := func(export string, overrideNew bool) {
+		fmt.Fprintf(&wat, "\n\t(func (export %q) (param $ptr i32) (param $len i32)\n", export)
+		wat.WriteString("\t\t(local $v0 i32) (local $v1 i32) (local $v2 i32) (local $v3 i32)\n")
+		for i := range 4 {
+			fmt.Fprintf(&wat, "\t\t(local.set $v%d (i32.load offset=%d (local.get $ptr)))\n", i, payloadOffset+i*4)
+		}
+		wat.WriteString("\t\t(global.set $heap (i32.const 1024))\n")
+		if overrideNew {
+			wat.WriteString("\t\t(global.set $override (local.get $v0))\n")
+		} else {
+			// a guard below the heap, and nothing written above it
+			wat.WriteString("\t\t(call $fill (i32.const 512) (i32.const 1024) (i32.const 0x5a))\n")
+			wat.WriteString("\t\t(call $fill (i32.const 1024) (i32.const 65536) (i32.const 0))\n")
+		}
+
+		for i, name := range names {
+			def := definitions[name]
+			for rotation := range 4 {
+				wat.WriteString("\t\t")
+				for j, param := range def.ParamTypes() {
+					fmt.Fprintf(&wat, "local.get $v%d ", (j+rotation)%4)
+					if param == api.ValueTypeI64 {
+						wat.WriteString("i64.extend_i32_u ")
+					}
+				}
+				fmt.Fprintf(&wat, "call $host%d", i)
+				for range def.ResultTypes() {
+					wat.WriteString(" drop")
+				}
+				wat.WriteString("\n")
+			}
+		}
+
+		if overrideNew {
+			wat.WriteString("\t\t(global.set $override (i32.const 0))")
+		} else {
+			wat.WriteString("\t\t(call $changed (i32.const 512) (i32.const 1024) (i32.const 0x5a))\n")
+			wat.WriteString("\t\tif unreachable end\n")
+			wat.WriteString("\t\t(call $changed (global.get $heap) (i32.const 65536) (i32.const 0))\n")
+			wat.WriteString("\t\tif unreachable end")
+		}
+		wat.WriteString(")\n")
+	}
+	event(wsevents.ON_MESSAGE.String(), false)
+	event(wsevents.ON_JOIN.String(), true)
+	wat.WriteString(")")
+
+	return wasmtest.Must(tb, wat.String())
+}
+
+// Keeps the secret in its memory, and traps if anything but its heap changes.
+// Otherwise it broadcasts the secret, so that tests can check it's intact
+func victimModule(tb testing.TB) []byte {
+	tb.Helper()
+	return wasmtest.Must(tb, `(module
+	(import "env" "broadcast" (func $broadcast (param i32 i32) (result i32)))
+
+	(memory (export "memory") 1)
+	(global $heap (mut i32) (i32.const 32768))
+	(data (i32.const 1024) "`+secret+`")
+`+guardFuncs+`
+	(func (export "__new") (param $size i32) (param $id i32) (result i32)
+		global.get $heap
+		(global.set $heap (i32.add (global.get $heap) (local.get $size))))
+
+	(func (export "__onMessage") (param i32 i32)
+		(global.set $heap (i32.const 32768))
+		(call $changed (i32.const 0) (i32.const 1024) (i32.const 0))
+		if unreachable end
+		(call $changed (i32.const `+strconv.Itoa(1024+len(secret))+`) (i32.const 32768) (i32.const 0))
+		if unreachable end
+		(drop (call $broadcast (i32.const 1024) (i32.const `+strconv.Itoa(len(secret))+`)))))`)
+}
+
+// What the handlers saw from the hostile instance
+type hostileCalls struct {
+	calls atomic.Int64
+
+	// Set if any host call carried the secret
+	leaked atomic.Bool
+
+	// What the victim last broadcast
+	victim atomic.Value
+}
+
+// Store running the hostile module, with a victim instance that has the secret in its memory
+func setupHostileStore(tb testing.TB) (*store.SandboxStore, *hostileCalls) {
+	tb.Helper()
+	module := hostileModule(tb)
+	victim := victimModule(tb)
+	seen := &hostileCalls{}
+
+	handler := func(event *wasmevents.WASMEventInfo) (string, error) {
+		if event.InstanceId != "hostile" {
+			if event.EventType == wasmevents.BROADCAST {
+				seen.victim.Store(strings.Join(event.Payload, ""))
+			}
+			return "ok", nil
+		}
+		seen.calls.Add(1)
+		if strings.Contains(strings.Join(event.Payload, ""), secret) {
+			seen.leaked.Store(true)
+		}
+		return "ok", nil
+	}
+	handlerMap := wasmevents.NewHandlerMap()
+	for eventType := wasmevents.WASMEventType(0); eventType.Valid(); eventType++ {
+		handlerMap.AddHandler(eventType, handler)
+	}
+
+	sandbox, err := store.NewSandboxStore(context.Background(), store.SandboxStoreCfg{
+		MemoryLimitPages:   10,
+		CloseOnContextDone: true,
+		PoolSize:           1,
+		HandlerMap:         handlerMap,
+		// timers set by the hostile module fire into a module without __onTimer
+		OnTimerError: func(event *wsevents.WSEventInfo, err error) {},
+		LoaderFunction: func(ctx context.Context, moduleId string) ([]byte, error) {
+			if moduleId == "victim" {
+				return victim, nil
+			}
+			return module, nil
+		},
+	})
+	if err != nil {
+		tb.Fatalf("Failed to make sandbox store: %v", err)
+	}
+	tb.Cleanup(func() { sandbox.Close(context.Background()) })
+
+	if err := checkVictim(sandbox, seen); err != nil {
+		tb.Fatal(err)
+	}
+	return sandbox, seen
+}
+
+// Run the victim, which fails if its memory was written to by anything but its own allocations
+func checkVictim(sandbox *store.SandboxStore, seen *hostileCalls) error {
+	seen.victim.Store("")
+	err := sandbox.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{
+		InstanceId: "victim",
+		EventType:  wsevents.ON_MESSAGE,
+	})
+	if err != nil {
+		return fmt.Errorf("The victim's memory was changed: %v", err)
+	}
+	if got := seen.victim.Load(); got != secret {
+		return fmt.Errorf("The victim's secret was changed to %q", got)
+	}
+	return nil
+}
+
+// Calls every host function with adversarial pointers and lengths. The host must return errors
+// rather than trap or panic, and must only ever read and write the calling instance's memory
+func FuzzHostFunctions(f *testing.F) {
+	sandbox, seen := setupHostileStore(f)
+
+	adversarial := []uint32{0, 1, 3, 1023, 1024, 65532, 65535, 65536, 0x7fffffff, 0x80000000, 0xfffffff8, 0xfffffffc, 0xffffffff}
+	for _, a := range adversarial {
+		f.Add(a, uint32(4), uint32(0xffffffff), uint32(1024))
+		f.Add(uint32(1024), a, a, uint32(16))
+	}
+
+	f.Fuzz(func(t *testing.T, a uint32, b uint32, c uint32, d uint32) {
+		payload := binary.LittleEndian.AppendUint32(nil, a)
+		payload = binary.LittleEndian.AppendUint32(payload, b)
+		payload = binary.LittleEndian.AppendUint32(payload, c)
+		payload = binary.LittleEndian.AppendUint32(payload, d)
+
+		for _, eventType := range []wsevents.WSEventType{wsevents.ON_MESSAGE, wsevents.ON_JOIN} {
+			err := sandbox.ExecuteOnModule(context.Background(), &wsevents.WSEventInfo{
+				InstanceId: "hostile",
+				EventType:  eventType,
+				Payload:    string(payload),
+			})
+			// __onMessage traps if a host write missed its allocation
+			if err != nil {
+				t.Errorf("%s with %d, %d, %d, %d failed: %v", eventType.String(), a, b, c, d, err)
+			}
+		}
+
+		if err := checkVictim(sandbox, seen); err != nil {
+			t.Fatal(err)
+		}
+
+		if seen.leaked.Load() {
+			t.Fatalf("A host call of the hostile instance saw the memory of another instance")
+		}
+		if seen.calls.Load() == 0 {
+			t.Fatalf("The hostile module never reached a handler")
+		}
+	})
+}